@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"github.com/layertwo/helm-convert/pkg/helm"
+	"github.com/spf13/cobra"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+// chartOptions are the options shared by the commands loading a chart: where
+// to find it and the values supplied by the user
+type chartOptions struct {
+	chart         string
+	repoURL       string
+	valueFiles    helm.ValueFiles
+	values        []string
+	stringValues  []string
+	fileValues    []string
+	jsonValues    []string
+	literalValues []string
+	version       string
+	depUp         bool
+
+	username string
+	password string
+	certFile string
+	keyFile  string
+	caFile   string
+
+	verify  bool
+	keyring string
+}
+
+// addChartFlags add the flags of the chart options to a command
+func (o *chartOptions) addChartFlags(c *cobra.Command) {
+	f := c.Flags()
+	f.VarP(&o.valueFiles, "values", "f", "specify values in a YAML file or a URL(can specify multiple)")
+	f.StringArrayVar(&o.values, "set", []string{}, "set values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	f.StringArrayVar(&o.fileValues, "set-file", []string{}, "set values from respective files specified via the command line (can specify multiple or separate values with commas: key1=path1,key2=path2)")
+	f.StringArrayVar(&o.stringValues, "set-string", []string{}, "set STRING values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	f.StringArrayVar(&o.jsonValues, "set-json", []string{}, "set JSON values on the command line (can specify multiple or separate values with commas: key1=jsonval1,key2=jsonval2)")
+	f.StringArrayVar(&o.literalValues, "set-literal", []string{}, "set a literal STRING value on the command line")
+	f.BoolVar(&o.verify, "verify", false, "verify the package against its signature")
+	f.StringVar(&o.version, "version", "", "specific version of a chart. Without this, the latest version is fetched")
+	f.StringVar(&o.keyring, "keyring", defaultKeyring(), "keyring containing public keys")
+	f.StringVar(&o.repoURL, "repo", "", "chart repository url where to locate the requested chart")
+	f.StringVar(&o.certFile, "cert-file", "", "identify HTTPS client using this SSL certificate file")
+	f.StringVar(&o.keyFile, "key-file", "", "identify HTTPS client using this SSL key file")
+	f.StringVar(&o.caFile, "ca-file", "", "verify certificates of HTTPS-enabled servers using this CA bundle")
+	f.BoolVar(&o.depUp, "dep-up", false, "run helm dependency update before loading the chart")
+	f.StringVar(&o.username, "username", "", "chart repository username")
+	f.StringVar(&o.password, "password", "", "chart repository password")
+}
+
+// loadChart load the requested chart
+func (o *chartOptions) loadChart(h *helm.Helm) (*chart.Chart, error) {
+	return h.LoadChart(&helm.LoadChartConfig{
+		RepoURL:  o.repoURL,
+		Username: o.username,
+		Password: o.password,
+		Chart:    o.chart,
+		Version:  o.version,
+		DepUp:    o.depUp,
+		Verify:   o.verify,
+		Keyring:  o.keyring,
+		CertFile: o.certFile,
+		KeyFile:  o.keyFile,
+		CaFile:   o.caFile,
+	})
+}
@@ -14,51 +14,55 @@ import (
 	"github.com/golang/glog"
 	"github.com/layertwo/helm-convert/pkg/generators"
 	"github.com/layertwo/helm-convert/pkg/helm"
+	"github.com/layertwo/helm-convert/pkg/sops"
 	"github.com/layertwo/helm-convert/pkg/transformers"
 	"github.com/layertwo/helm-convert/pkg/types"
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc/status"
 	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
-	helm_env "k8s.io/helm/pkg/helm/environment"
-	"k8s.io/helm/pkg/helm/helmpath"
 	"k8s.io/helm/pkg/hooks"
+	ktypes "sigs.k8s.io/kustomize/api/types"
 	"sigs.k8s.io/kustomize/k8sdeps/kunstruct"
 	"sigs.k8s.io/kustomize/pkg/resource"
-	ktypes "sigs.k8s.io/kustomize/pkg/types"
 )
 
-var (
-	whitespaceRegex = regexp.MustCompile(`^\s*$`)
-	settings        helm_env.EnvSettings
-)
+var whitespaceRegex = regexp.MustCompile(`^\s*$`)
 
 type convertCmd struct {
-	home helmpath.Home
+	chartOptions
 
-	chart            string
-	repoURL          string
 	destination      string
 	name             string
 	namespace        string
-	fileValues       []string
-	valueFiles       helm.ValueFiles
-	values           []string
-	stringValues     []string
 	skipTransformers []string
-	version          string
-	depUp            bool
+	patchPaths       []string
+	kubeVersion      string
+	removedAPIPolicy string
+	keepDefaultPaths []string
+	keepEmptyPaths   []string
+	legacyVars       bool
+	extractEnv       bool
+	valueMap         bool
+	valueComments    bool
+	releaseFields    bool
+	unusedValues     bool
+	strictValues     bool
+	gateway          string
+	pruneProtection  string
+	nonDeterministic string
 	forceGen         bool
 	comments         bool
+	secretOutput     string
+	sopsLayout       string
+	sopsAge          []string
+	sopsConfig       string
 
-	username string
-	password string
-	certFile string
-	keyFile  string
-	caFile   string
+	externalSecretStore       string
+	externalSecretStoreKind   string
+	externalSecretKeyTemplate string
 
-	verify      bool
 	verifyLater bool
-	keyring     string
+	showValues  string
 
 	out io.Writer
 }
@@ -79,6 +83,46 @@ const convertExample = `
 
   # convert the stable/mongodb chart and override values using --set flag:
   helm convert --set persistence.enabled=true stable/mongodb
+
+  # set a list with --set-json and a string containing commas with --set-literal
+  helm convert --set-json 'tolerations=[{"key":"dedicated","operator":"Exists"}]' \
+    --set-literal 'extraFlags=--a=1,--b=2' stable/mongodb
+
+  # convert the stable/mongodb chart and encrypt secrets with SOPS for KSOPS
+  helm convert --secret-output sops --sops-layout ksops --sops-age age1... stable/mongodb
+
+  # convert the stable/mongodb chart and also move the replica count to patches
+  helm convert --patch-paths spec.replicas,spec.template.spec.containers[].resources stable/mongodb
+
+  # convert the stable/mongodb chart and use vars for references to other resources
+  helm convert --legacy-vars stable/mongodb
+
+  # convert the stable/mongodb chart and move the container environment variables to generators
+  helm convert --extract-env stable/mongodb
+
+  # convert the stable/mongodb chart and replace ingresses by routes of the infra/public Gateway
+  helm convert --gateway infra/public stable/mongodb
+
+  # convert the stable/mongodb chart and let the user supply the secrets generated by the chart
+  helm convert --non-deterministic-policy secret stable/mongodb
+
+  # convert the stable/mongodb chart and substitute the fields derived from the release name with vars
+  helm convert --release-fields stable/mongodb
+
+  # convert the stable/mongodb chart and report the values without effect
+  helm convert --unused-values -f values.yaml stable/mongodb
+
+  # convert the stable/mongodb chart and map its values to the fields they control
+  helm convert --value-map --value-comments stable/mongodb
+
+  # convert the stable/mongodb chart and replace secrets by ExternalSecret resources
+  helm convert --secret-output external-secret --external-secret-store vault stable/mongodb
+
+  # print the values of the stable/mongodb chart with a given values.yaml file and their source
+  helm convert --show-values -f values.yaml stable/mongodb
+
+  # print the values and their source as JSON
+  helm convert --show-values=json --set persistence.enabled=true stable/mongodb
 `
 
 // NewConvertCommand constructs a new convert command
@@ -97,8 +141,6 @@ func NewConvertCommand() *cobra.Command {
 			flag.CommandLine.Parse([]string{})
 		},
 		RunE: func(c *cobra.Command, args []string) error {
-			settings.Home = k.home
-
 			for i := 0; i < len(args); i++ {
 				k.chart = args[i]
 				if err := k.run(); err != nil {
@@ -110,29 +152,41 @@ func NewConvertCommand() *cobra.Command {
 		},
 	}
 
+	k.addChartFlags(c)
+
 	f := c.Flags()
 	f.StringVar(&k.name, "name", "", "release name")
-	f.VarP(&k.valueFiles, "values", "f", "specify values in a YAML file or a URL(can specify multiple)")
-	f.StringVar((*string)(&k.home), "home", helm_env.DefaultHelmHome, "location of your Helm config. Overrides $HELM_HOME")
-	f.StringArrayVar(&k.values, "set", []string{}, "set values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
-	f.StringArrayVar(&k.fileValues, "set-file", []string{}, "set values from respective files specified via the command line (can specify multiple or separate values with commas: key1=path1,key2=path2)")
-	f.StringArrayVar(&k.stringValues, "set-string", []string{}, "set STRING values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
 	f.StringSliceVar(&k.skipTransformers, "skip-transformers", []string{}, "set a list of transformers that are skipped during the conversion process (can specify multiple or separate values with commas: secret,configmap)")
-	f.BoolVar(&k.verify, "verify", false, "verify the package against its signature")
+	f.StringSliceVar(&k.patchPaths, "patch-paths", transformers.DefaultPatchPaths, "field paths moved from the resources to patch files, [] iterate over list elements (can specify multiple or separate values with commas: spec.replicas,spec.template.spec.containers[].resources)")
+	f.StringVar(&k.kubeVersion, "kube-version", transformers.DefaultKubeVersion, "Kubernetes version used to render the chart, resources are migrated to the API versions it serves")
+	f.StringVar(&k.removedAPIPolicy, "removed-api-policy", string(transformers.RemovedAPIPolicyFail), "what to do with resources whose API version is not served by --kube-version and can't be migrated: fail or drop")
+	f.StringSliceVar(&k.keepDefaultPaths, "keep-default-paths", []string{}, "field paths kept even when equal to their server-side default, [] iterate over list elements (can specify multiple or separate values with commas: spec.strategy.type,spec.template.spec.containers[].imagePullPolicy)")
+	f.StringSliceVar(&k.keepEmptyPaths, "keep-empty-paths", transformers.DefaultEmptyKeepPaths, "field paths kept even when empty, matched against the end of the path, [] match list elements and * any key (can specify multiple or separate values with commas: volumes[].emptyDir,securityContext)")
+	f.StringVar(&k.gateway, "gateway", "", "convert Ingress resources to HTTPRoute resources attached to this Gateway: [namespace/]name")
+	f.StringVar(&k.pruneProtection, "prune-protection", string(transformers.PruneProtectionNone), "prune protection replacing the helm.sh/resource-policy: keep annotation: none, the annotation is left untouched, argocd, flux or kapp")
+	f.StringVar(&k.nonDeterministic, "non-deterministic-policy", string(transformers.NonDeterministicPolicyKeep), "what to do with values generated on every render of the chart (ie: randAlphaNum, uuidv4, now): keep, placeholder or secret")
+	f.BoolVar(&k.valueMap, "value-map", false, "render the chart once per value to map each value to the fields it controls, the map is written to "+generators.DefaultValueMapFilename)
+	f.BoolVar(&k.valueComments, "value-comments", false, "comment the fields of the patch files with the values controlling them, implies --value-map")
+	f.BoolVar(&k.releaseFields, "release-fields", false, "render the chart with a sentinel release name and namespace to substitute the fields derived from them with vars")
+	f.BoolVar(&k.unusedValues, "unused-values", false, "render the chart once per value supplied by the user to report the values without effect on the manifests or not defined by the chart")
+	f.BoolVar(&k.strictValues, "strict-values", false, "fail the conversion if a value supplied by the user has no effect on the manifests or isn't defined by the default values of the chart, implies --unused-values")
+	f.BoolVar(&k.legacyVars, "legacy-vars", false, "use vars instead of replacements to propagate name changes to fields referencing other resources")
+	f.BoolVar(&k.extractEnv, "extract-env", false, "move the literal environment variables of containers to a configMapGenerator, or a secretGenerator for credentials and generated or random looking values, referenced with envFrom")
 	f.BoolVar(&k.verifyLater, "prov", false, "fetch the provenance file, but don't perform verification")
 	f.StringVar(&k.namespace, "namespace", "default", "global namespace to use for the manifests")
-	f.StringVar(&k.version, "version", "", "specific version of a chart. Without this, the latest version is fetched")
-	f.StringVar(&k.keyring, "keyring", defaultKeyring(), "keyring containing public keys")
 	f.StringVarP(&k.destination, "destination", "d", "", "location to write the chart. If this and tardir are specified, tardir is appended to this")
-	f.StringVar(&k.repoURL, "repo", "", "chart repository url where to locate the requested chart")
-	f.StringVar(&k.certFile, "cert-file", "", "identify HTTPS client using this SSL certificate file")
-	f.StringVar(&k.keyFile, "key-file", "", "identify HTTPS client using this SSL key file")
-	f.StringVar(&k.caFile, "ca-file", "", "verify certificates of HTTPS-enabled servers using this CA bundle")
-	f.BoolVar(&k.depUp, "dep-up", false, "run helm dependency update before installing the chart")
 	f.BoolVar(&k.forceGen, "force", false, "convert chart even if the destination directory already exists")
-	f.StringVar(&k.username, "username", "", "chart repository username")
-	f.StringVar(&k.password, "password", "", "chart repository password")
 	f.BoolVar(&k.comments, "comments", true, "add default comments to kustomization.yaml file")
+	f.StringVar(&k.secretOutput, "secret-output", string(transformers.SecretOutputPlain), "how secrets are written: plain, sops, placeholder or external-secret")
+	f.StringVar(&k.sopsLayout, "sops-layout", transformers.SOPSLayoutFlux, "kustomization layout for SOPS encrypted secrets: flux or ksops")
+	f.StringSliceVar(&k.sopsAge, "sops-age", []string{}, "age recipients used to encrypt secrets, default to the creation rules of .sops.yaml (can specify multiple or separate values with commas: age1...,age1...)")
+	f.StringVar(&k.sopsConfig, "sops-config", "", "path to the .sops.yaml configuration file, default to the one found by sops")
+	f.StringVar(&k.externalSecretStore, "external-secret-store", "", "name of the secret store referenced by the generated ExternalSecret resources")
+	f.StringVar(&k.externalSecretStoreKind, "external-secret-store-kind", transformers.DefaultExternalSecretStoreKind, "kind of the secret store: SecretStore or ClusterSecretStore")
+	f.StringVar(&k.externalSecretKeyTemplate, "external-secret-key-template", transformers.DefaultExternalSecretKeyTemplate, "Go template of the remote key of each secret key, .Name, .Namespace, .Type and .Key are available")
+
+	f.StringVar(&k.showValues, "show-values", "", "print the values used to render the chart and their source instead of converting it: yaml, the source of each value is written as a comment, or json")
+	f.Lookup("show-values").NoOptDefVal = "yaml"
 
 	// log to stderr by default
 	// lint:ignore
@@ -145,28 +199,28 @@ func NewConvertCommand() *cobra.Command {
 }
 
 func (k *convertCmd) run() error {
-	h := helm.NewHelm(settings, k.out)
+	if k.showValues != "" && k.showValues != "yaml" && k.showValues != "json" {
+		return fmt.Errorf("unknown values format '%s', expected yaml or json", k.showValues)
+	}
+	if err := k.validateSecretOptions(); err != nil {
+		return err
+	}
+	if err := k.validateTransformerOptions(); err != nil {
+		return err
+	}
 
-	glog.V(8).Infof("Using settings %#v", settings)
+	h := helm.NewHelm(k.out)
 
 	// load chart
-	chartRequested, err := h.LoadChart(&helm.LoadChartConfig{
-		RepoURL:  k.repoURL,
-		Username: k.username,
-		Password: k.password,
-		Chart:    k.chart,
-		Version:  k.version,
-		DepUp:    k.depUp,
-		Verify:   k.verify,
-		Keyring:  k.keyring,
-		CertFile: k.certFile,
-		KeyFile:  k.keyFile,
-		CaFile:   k.caFile,
-	})
+	chartRequested, err := k.loadChart(h)
 	if err != nil {
 		return prettyError(err)
 	}
 
+	if k.showValues != "" {
+		return printValues(k.out, h, chartRequested, k.chartOptions, k.showValues)
+	}
+
 	// use chart name if destination isn't defined via flags
 	if k.destination == "" {
 		k.destination = chartRequested.Metadata.Name
@@ -178,7 +232,7 @@ func (k *convertCmd) run() error {
 	}
 
 	// render charts with given values
-	renderedManifests, err := h.RenderChart(&helm.RenderChartConfig{
+	rendered, err := h.RenderChart(&helm.RenderChartConfig{
 		ChartRequested: chartRequested,
 		Name:           k.name,
 		Namespace:      k.namespace,
@@ -186,14 +240,39 @@ func (k *convertCmd) run() error {
 		Values:         k.values,
 		StringValues:   k.stringValues,
 		FileValues:     k.fileValues,
+		JSONValues:     k.jsonValues,
+		LiteralValues:  k.literalValues,
+		KubeVersion:    k.kubeVersion,
+
+		DetectNonDeterministic: true,
+		DetectReleaseFields:    k.releaseFields,
+		DetectValueFields:      k.valueMap || k.valueComments,
+		DetectUnusedValues:     k.unusedValues || k.strictValues,
 	})
 	if err != nil {
 		return prettyError(err)
 	}
 
+	if k.strictValues && (len(rendered.UnusedValues) > 0 || len(rendered.UnknownValues) > 0) {
+		var problems []string
+		if len(rendered.UnusedValues) > 0 {
+			problems = append(problems, fmt.Sprintf("values without effect: %s",
+				strings.Join(rendered.UnusedValues, ", ")))
+		}
+		if len(rendered.UnknownValues) > 0 {
+			problems = append(problems, fmt.Sprintf("values not defined by the chart: %s",
+				strings.Join(rendered.UnknownValues, ", ")))
+		}
+		return fmt.Errorf("invalid values (--strict-values): %s", strings.Join(problems, "; "))
+	}
+
 	// convert Yaml to resource
 	resources := types.NewResources()
-	for _, m := range renderedManifests {
+	resources.NonDeterministic = rendered.NonDeterministic
+	resources.ReleaseFields = rendered.ReleaseFields
+	resources.ValueMap = rendered.ValueMap
+	reportValues(&resources.Report, rendered)
+	for _, m := range rendered.Manifests {
 		data := m.Content
 		b := filepath.Base(m.Name)
 		if b == "NOTES.txt" {
@@ -218,18 +297,36 @@ func (k *convertCmd) run() error {
 	config := &ktypes.Kustomization{}
 
 	defaultTransfomers := []transformers.Transformer{
+		transformers.NewAPIVersionTransformer(k.kubeVersion, transformers.RemovedAPIPolicy(k.removedAPIPolicy)),
+		transformers.NewGatewayTransformer(k.gatewayOptions()),
 		transformers.NewLabelsTransformer([]string{"chart", "release", "heritage"}),
 		transformers.NewAnnotationsTransformer([]string{
 			hooks.HookAnno,
 			hooks.HookWeightAnno,
 			hooks.HookDeleteAnno,
 		}),
+		transformers.NewResourcePolicyTransformer(transformers.PruneProtection(k.pruneProtection)),
+		transformers.NewDefaultsTransformer(k.keepDefaultPaths),
 		transformers.NewImageTransformer(),
+		transformers.NewEnvTransformer(k.extractEnv),
+		transformers.NewCertManagerTransformer(k.namespace),
+		transformers.NewNonDeterministicTransformer(transformers.NonDeterministicPolicy(k.nonDeterministic)),
+		transformers.NewReplacementsTransformer(k.legacyVars),
+		transformers.NewReleaseFieldsTransformer(k.name, k.namespace),
 		transformers.NewConfigMapTransformer(),
-		transformers.NewSecretTransformer(),
+		transformers.NewSecretTransformer(transformers.SecretOptions{
+			Output:                    transformers.SecretOutput(k.secretOutput),
+			SOPSLayout:                k.sopsLayout,
+			ExternalSecretStore:       k.externalSecretStore,
+			ExternalSecretStoreKind:   k.externalSecretStoreKind,
+			ExternalSecretKeyTemplate: k.externalSecretKeyTemplate,
+		}),
+		transformers.NewGeneratorOptionsTransformer(),
+		transformers.NewChecksumTransformer(),
+		transformers.NewPatchTransformer(k.patchPaths, k.valueComments),
 		transformers.NewNamePrefixTransformer(),
 		transformers.NewResourcesTransformer(),
-		transformers.NewEmptyTransformer(),
+		transformers.NewEmptyTransformer(k.keepEmptyPaths),
 	}
 
 	// load transformers
@@ -257,7 +354,12 @@ func (k *convertCmd) run() error {
 	}
 
 	// write to disk
-	generator := generators.NewGenerator(k.forceGen)
+	var encrypter generators.Encrypter
+	if transformers.SecretOutput(k.secretOutput) == transformers.SecretOutputSOPS {
+		encrypter = sops.NewEncrypter(k.sopsAge, k.sopsConfig)
+	}
+
+	generator := generators.NewGenerator(k.forceGen, encrypter)
 	err = generator.Render(k.destination, config, chartRequested.Metadata, resources, k.comments)
 	if err != nil {
 		return err
@@ -266,6 +368,70 @@ func (k *convertCmd) run() error {
 	return nil
 }
 
+// validateSecretOptions check the secret output flags
+func (k *convertCmd) validateSecretOptions() error {
+	switch transformers.SecretOutput(k.secretOutput) {
+	case transformers.SecretOutputPlain, transformers.SecretOutputSOPS, transformers.SecretOutputPlaceholder:
+	case transformers.SecretOutputExternalSecret:
+		if k.externalSecretStore == "" {
+			return fmt.Errorf("--external-secret-store is required with the external-secret output")
+		}
+	default:
+		return fmt.Errorf("unknown secret output '%s', expected plain, sops, placeholder or external-secret",
+			k.secretOutput)
+	}
+
+	switch k.sopsLayout {
+	case transformers.SOPSLayoutFlux, transformers.SOPSLayoutKSOPS:
+	default:
+		return fmt.Errorf("unknown sops layout '%s', expected flux or ksops", k.sopsLayout)
+	}
+
+	return nil
+}
+
+// validateTransformerOptions check the flags selecting the behaviour of a
+// transformer, before the chart is loaded and rendered
+func (k *convertCmd) validateTransformerOptions() error {
+	switch transformers.RemovedAPIPolicy(k.removedAPIPolicy) {
+	case transformers.RemovedAPIPolicyFail, transformers.RemovedAPIPolicyDrop:
+	default:
+		return fmt.Errorf("unknown removed API policy '%s', expected fail or drop", k.removedAPIPolicy)
+	}
+
+	switch transformers.PruneProtection(k.pruneProtection) {
+	case transformers.PruneProtectionNone, transformers.PruneProtectionArgoCD, transformers.PruneProtectionFlux,
+		transformers.PruneProtectionKapp:
+	default:
+		return fmt.Errorf("unknown prune protection '%s', expected none, argocd, flux or kapp", k.pruneProtection)
+	}
+
+	switch transformers.NonDeterministicPolicy(k.nonDeterministic) {
+	case transformers.NonDeterministicPolicyKeep, transformers.NonDeterministicPolicyPlaceholder,
+		transformers.NonDeterministicPolicySecret:
+	default:
+		return fmt.Errorf("unknown non-deterministic policy '%s', expected keep, placeholder or secret",
+			k.nonDeterministic)
+	}
+
+	if k.gateway != "" {
+		parts := strings.Split(k.gateway, "/")
+		if len(parts) > 2 || parts[len(parts)-1] == "" || (len(parts) == 2 && parts[0] == "") {
+			return fmt.Errorf("invalid gateway '%s', expected [namespace/]name", k.gateway)
+		}
+	}
+
+	return nil
+}
+
+// gatewayOptions parse the --gateway flag
+func (k *convertCmd) gatewayOptions() transformers.GatewayOptions {
+	if i := strings.Index(k.gateway, "/"); i >= 0 {
+		return transformers.GatewayOptions{Namespace: k.gateway[:i], Name: k.gateway[i+1:]}
+	}
+	return transformers.GatewayOptions{Name: k.gateway}
+}
+
 func newResources(in []byte) ([]*resource.Resource, error) {
 	decoder := k8syaml.NewYAMLOrJSONDecoder(bytes.NewReader(in), 1024)
 	rf := resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
@@ -302,6 +468,28 @@ func isEmptyYamlError(err error) bool {
 	return strings.Contains(err.Error(), "is missing in 'null'")
 }
 
+// reportValues add the values supplied by the user which have no effect on
+// the manifests or aren't defined by the chart to the report, they are
+// usually misspelled
+func reportValues(report *types.Report, rendered *helm.RenderedChart) {
+	for _, key := range rendered.UnusedValues {
+		glog.Warningf("The value '%s' has no effect on the manifests", key)
+		report.Add(types.ReportEntry{
+			Transformer: "values",
+			Path:        key,
+			Message:     "supplied by the user but has no effect on the manifests",
+		})
+	}
+	for _, key := range rendered.UnknownValues {
+		glog.Warningf("The value '%s' is not defined by the default values of the chart", key)
+		report.Add(types.ReportEntry{
+			Transformer: "values",
+			Path:        key,
+			Message:     "supplied by the user but not defined by the default values of the chart",
+		})
+	}
+}
+
 func prettyError(err error) error {
 	if err == nil {
 		return nil
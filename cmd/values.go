@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/layertwo/helm-convert/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// printValues write the coalesced values of the chart to out, as YAML with the
+// source of each value in a comment or as JSON with a map of the sources
+func printValues(out io.Writer, h *helm.Helm, c *chart.Chart, o chartOptions, format string) error {
+	values, err := h.CoalescedVals(c, o.valueFiles, o.values, o.stringValues, o.fileValues,
+		o.jsonValues, o.literalValues, o.certFile, o.keyFile, o.caFile)
+	if err != nil {
+		return prettyError(err)
+	}
+
+	var output []byte
+	if format == "json" {
+		output, err = json.MarshalIndent(struct {
+			Values  map[string]interface{}      `json:"values"`
+			Sources map[string]helm.ValueSource `json:"sources"`
+		}{values.Values, values.Sources}, "", "  ")
+		output = append(output, '\n')
+	} else {
+		output, err = commentedValues(values)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = out.Write(output)
+	return err
+}
+
+// commentedValues return the values as YAML, the source of each value is
+// written as a comment at the end of its line
+func commentedValues(values *helm.CoalescedValues) ([]byte, error) {
+	root, err := kyaml.FromMap(values.Values)
+	if err != nil {
+		return nil, err
+	}
+
+	var walk func(node *kyaml.Node, path string)
+	walk = func(node *kyaml.Node, path string) {
+		switch node.Kind {
+		case kyaml.MappingNode:
+			if len(node.Content) > 0 {
+				for i := 0; i+1 < len(node.Content); i += 2 {
+					key := node.Content[i].Value
+					if path != "" {
+						key = path + "." + key
+					}
+					walk(node.Content[i+1], key)
+				}
+				return
+			}
+		case kyaml.SequenceNode:
+			if len(node.Content) > 0 {
+				for i, item := range node.Content {
+					walk(item, path+"["+strconv.Itoa(i)+"]")
+				}
+				return
+			}
+		}
+		if source, found := values.Sources[path]; found {
+			node.LineComment = source.String()
+		}
+	}
+	walk(root.YNode(), "")
+
+	output, err := root.String()
+	return []byte(output), err
+}
@@ -5,7 +5,7 @@ set -e
 current_version=$(sed -n -e 's/version:[ "]*\([^"]*\).*/\1/p' $(dirname $0)/plugin.yaml)
 HELM_CONVERT_VERSION=${HELM_CONVERT_VERSION:-$current_version}
 
-dir=${HELM_PLUGIN_DIR:-"$(helm home)/plugins/helm-convert"}
+dir=${HELM_PLUGIN_DIR:-"$(helm env HELM_PLUGINS)/helm-convert"}
 os=$(uname -s | tr '[:upper:]' '[:lower:]')
 release_file="helm-convert_${os}_${HELM_CONVERT_VERSION}.tar.gz"
 url="https://github.com/ContainerSolutions/helm-convert/releases/download/v${HELM_CONVERT_VERSION}/${release_file}"
@@ -14,6 +14,8 @@ var commentsMapping = map[string]string{
 		"# one ConfigMap resource (it's a generator of n maps).",
 	"secretGenerator": "# Each entry in this list results in the creation of\n" +
 		"# one Secret resource (it's a generator of n secrets).",
+	"generators": "# Each entry in this list should resolve to\n" +
+		"# a generator configuration file (ie: KSOPS).",
 	"generatorOptions": "# generatorOptions modify behavior of all ConfigMap\n" +
 		"# and Secret generators",
 	"patches": "# Each entry in this list should resolve to\n" +
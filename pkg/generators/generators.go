@@ -6,12 +6,13 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/layertwo/helm-convert/pkg/types"
 	"github.com/layertwo/helm-convert/pkg/utils"
 	"k8s.io/helm/pkg/proto/hapi/chart"
-	ktypes "sigs.k8s.io/kustomize/pkg/types"
+	ktypes "sigs.k8s.io/kustomize/api/types"
 )
 
 const (
@@ -20,16 +21,32 @@ const (
 
 	// DefaultKustomizationFilename is the name of the kustomization config file
 	DefaultKustomizationFilename = "kustomization.yaml"
+
+	// DefaultReportFilename is the name of the conversion report file, it is
+	// only written if a transformer recorded something
+	DefaultReportFilename = "conversion-report.yaml"
+
+	// DefaultValueMapFilename is the name of the file mapping the values of
+	// the chart to the rendered fields they control, it is only written if
+	// the value analysis is enabled
+	DefaultValueMapFilename = "values-map.yaml"
 )
 
+// Encrypter encrypt the content of a file before it is written to disk
+type Encrypter interface {
+	Encrypt(filePath string, data []byte) ([]byte, error)
+}
+
 // Generator type
 type Generator struct {
-	force bool
+	force     bool
+	encrypter Encrypter
 }
 
-// NewGenerator contructs a new generator
-func NewGenerator(force bool) *Generator {
-	return &Generator{force}
+// NewGenerator contructs a new generator, if an encrypter is given secret
+// files are encrypted before being written to disk
+func NewGenerator(force bool, encrypter Encrypter) *Generator {
+	return &Generator{force, encrypter}
 }
 
 // Render to disk the kustomization.yaml, Kube-descriptor.yaml and associated resources
@@ -63,7 +80,15 @@ func (g *Generator) Render(destination string, config *ktypes.Kustomization,
 			return err
 		}
 
-		err = writeYamlFile(path.Join(destination, filename), res)
+		filePath, err := destinationPath(destination, filename)
+		if err != nil {
+			return err
+		}
+
+		namespace, _ := res.GetFieldValue("metadata.namespace")
+		comments := resources.Comments[types.ResourceKey(res.GetKind(), namespace, res.GetName())]
+
+		err = writeCommentedYamlFile(filePath, comments, res)
 		if err != nil {
 			return err
 		}
@@ -71,9 +96,20 @@ func (g *Generator) Render(destination string, config *ktypes.Kustomization,
 
 	// render all config and env files
 	for filename, data := range resources.SourceFiles {
-		// TODO: prevent overwriting of file, filename can be similar from one
-		// resource to another
-		err = writeFile(path.Join(destination, filename), []byte(data), 0644)
+		filePath, err := destinationPath(destination, filename)
+		if err != nil {
+			return err
+		}
+		content := []byte(data)
+
+		if _, ok := resources.SecretFiles[filename]; ok && g.encrypter != nil {
+			content, err = g.encrypter.Encrypt(filePath, content)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = writeFile(filePath, content, 0644)
 		if err != nil {
 			return err
 		}
@@ -97,5 +133,32 @@ func (g *Generator) Render(destination string, config *ktypes.Kustomization,
 		return err
 	}
 
+	// render conversion-report.yaml
+	if len(resources.Report.Entries) > 0 {
+		resources.Report.Sort()
+		err = writeYamlFile(path.Join(destination, DefaultReportFilename), resources.Report)
+		if err != nil {
+			return err
+		}
+	}
+
+	// render values-map.yaml
+	if len(resources.ValueMap) > 0 {
+		err = writeYamlFile(path.Join(destination, DefaultValueMapFilename), resources.ValueMap)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+// destinationPath return the path of a file in the destination directory, an
+// error is returned if the filename would escape the destination
+func destinationPath(destination, filename string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(filename)) {
+		return "", fmt.Errorf("refusing to write '%s' outside of the destination directory '%s'",
+			filename, destination)
+	}
+	return path.Join(destination, filename), nil
+}
@@ -0,0 +1,66 @@
+package generators
+
+import (
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/layertwo/helm-convert/pkg/sops"
+	"github.com/layertwo/helm-convert/pkg/types"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+)
+
+func TestRenderEncryptSecretFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the sops stub is a shell script")
+	}
+
+	// the sops stub record the --filename-override argument of each call and
+	// prefix stdin with ENC:
+	binDir := t.TempDir()
+	argsFile := path.Join(binDir, "filenames")
+	stub := "#!/bin/sh\nwhile [ $# -gt 0 ]; do\n" +
+		"  if [ \"$1\" = --filename-override ]; then echo \"$2\" >> " + argsFile + "; fi\n" +
+		"  shift\ndone\nprintf 'ENC:'; cat\n"
+	if err := os.WriteFile(path.Join(binDir, sops.DefaultBinary), []byte(stub), 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	destination := path.Join(t.TempDir(), "out")
+	resources := types.NewResources()
+	resources.SourceFiles["files/secret/db/db.env"] = "PASSWORD=secret\n"
+	resources.SourceFiles["files/configmap/app/app.env"] = "MODE=production\n"
+	resources.SecretFiles["files/secret/db/db.env"] = struct{}{}
+
+	err := NewGenerator(true, sops.NewEncrypter([]string{"age1a"}, "")).Render(destination,
+		&ktypes.Kustomization{}, &chart.Metadata{Name: "app"}, resources, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for filename, expected := range map[string]string{
+		"files/secret/db/db.env":      "ENC:PASSWORD=secret\n",
+		"files/configmap/app/app.env": "MODE=production\n",
+	} {
+		content, err := os.ReadFile(path.Join(destination, filename))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(content) != expected {
+			t.Errorf("expected '%s' to contain '%s', got '%s'", filename, expected, content)
+		}
+	}
+
+	filenames, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := path.Join(destination, "files/secret/db/db.env")
+	if got := strings.TrimSpace(string(filenames)); got != expected {
+		t.Errorf("expected sops to be called once with the filename '%s', got '%s'", expected, got)
+	}
+}
@@ -3,6 +3,7 @@ package generators
 import (
 	"bufio"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 
@@ -23,6 +24,22 @@ func writeYamlFile(filePath string, data interface{}) error {
 	return writeFile(filePath, output, 0644)
 }
 
+// writeCommentedYamlFile write a given interface into yaml preceded by
+// comments, one per line
+func writeCommentedYamlFile(filePath string, comments []string, data interface{}) error {
+	output, err := yaml.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	var header []byte
+	for _, comment := range comments {
+		header = append(header, "# "+comment+"\n"...)
+	}
+
+	return writeFile(filePath, append(header, output...), 0644)
+}
+
 // writeAndFormatKustomizationConfig adds line break and comments
 func writeAndFormatKustomizationConfig(filePath string, comments bool) error {
 	glog.V(4).Infof("Formatting %s", filePath)
@@ -64,11 +81,17 @@ func writeAndFormatKustomizationConfig(filePath string, comments bool) error {
 	return writeFile(filePath, []byte(strings.Join(output, "\n")), 0644)
 }
 
-// writeFile writes data to a file named by filename.
+// writeFile writes data to a file named by filename, creating the parent
+// directories if needed.
 func writeFile(filePath string, data []byte, perm os.FileMode) error {
 	glog.V(4).Infof("Writing %s", filePath)
 
-	err := os.WriteFile(filePath, data, perm)
+	err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(filePath, data, perm)
 	if err != nil {
 		return err
 	}
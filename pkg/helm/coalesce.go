@@ -0,0 +1,299 @@
+package helm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+// The Helm 2 libraries coalesce values differently than Helm 3: nested null
+// values are kept instead of deleting the default value and the globals of a
+// subchart are written back to the globals of its parent, they leak to the
+// other subcharts. The functions below follow the Helm 3 semantics:
+//
+//   - a null value supplied by the user delete the default value, at any depth
+//   - the values of a chart override the values of its subcharts, found under
+//     the name of the subchart
+//   - the globals of a chart are copied to its subcharts and override their
+//     own globals, the globals of a subchart are not visible to its parent
+//   - the values imported from a subchart with import-values override the
+//     default values of the parent, the values supplied by the user override
+//     both
+
+// coalesceChartValues return the values supplied by the user merged with the
+// default values of a chart and its subcharts, the values are left untouched
+func coalesceChartValues(c *chart.Chart, values map[string]interface{}) (map[string]interface{}, error) {
+	dest, _ := copyValues(values).(map[string]interface{})
+	if dest == nil {
+		dest = make(map[string]interface{})
+	}
+	return coalesceChart(c, dest, "")
+}
+
+// coalesceChart merge the default values of a chart and its subcharts in
+// dest, prefix is the path of the chart used in the warnings
+func coalesceChart(c *chart.Chart, dest map[string]interface{}, prefix string) (map[string]interface{}, error) {
+	prefix = joinValuePath(prefix, c.Metadata.Name)
+
+	defaults, err := chartDefaults(c)
+	if err != nil {
+		return dest, err
+	}
+
+	for key, value := range defaults {
+		current, found := dest[key]
+		switch {
+		case !found:
+			dest[key] = value
+		case current == nil:
+			// a null value delete the default value
+			delete(dest, key)
+		case istable(current):
+			if src, ok := value.(map[string]interface{}); ok {
+				coalesceTables(current.(map[string]interface{}), src, joinValuePath(prefix, key), false)
+			} else if value != nil {
+				glog.Warningf("Skipped the default value of %s, the value is not a table",
+					joinValuePath(prefix, key))
+			}
+		}
+	}
+
+	for _, dependency := range c.Dependencies {
+		name := dependency.Metadata.Name
+		current, found := dest[name]
+		if !found || current == nil {
+			current = make(map[string]interface{})
+			dest[name] = current
+		}
+		sub, ok := current.(map[string]interface{})
+		if !ok {
+			return dest, fmt.Errorf("type mismatch on %s: %T", joinValuePath(prefix, name), current)
+		}
+
+		coalesceGlobals(sub, dest, joinValuePath(prefix, name))
+		if dest[name], err = coalesceChart(dependency, sub, prefix); err != nil {
+			return dest, err
+		}
+	}
+
+	return dest, nil
+}
+
+// coalesceTables merge src in dst, the values of dst win. If merge is false,
+// the null values of dst delete the value of src.
+func coalesceTables(dst, src map[string]interface{}, prefix string, merge bool) map[string]interface{} {
+	for key, value := range src {
+		current, found := dst[key]
+		switch {
+		case found && current == nil && !merge:
+			delete(dst, key)
+		case !found:
+			dst[key] = copyValues(value)
+		case istable(value):
+			if istable(current) {
+				coalesceTables(current.(map[string]interface{}), value.(map[string]interface{}),
+					joinValuePath(prefix, key), merge)
+			} else if current != nil {
+				glog.Warningf("Cannot overwrite the table %s with the non table value %v",
+					joinValuePath(prefix, key), current)
+			}
+		case istable(current) && value != nil:
+			glog.Warningf("The value of %s is a table, ignoring the non table value %v",
+				joinValuePath(prefix, key), value)
+		}
+	}
+	return dst
+}
+
+// coalesceGlobals copy the globals of a chart to the values of a subchart,
+// the globals of the chart override the ones of the subchart
+func coalesceGlobals(dest, src map[string]interface{}, prefix string) {
+	destGlobals := make(map[string]interface{})
+	if current, found := dest[chartutil.GlobalKey]; found && current != nil {
+		globals, ok := current.(map[string]interface{})
+		if !ok {
+			glog.Warningf("Skipping the globals of %s, %s is not a table", prefix, chartutil.GlobalKey)
+			return
+		}
+		destGlobals = globals
+	}
+
+	srcGlobals := make(map[string]interface{})
+	if current, found := src[chartutil.GlobalKey]; found && current != nil {
+		globals, ok := current.(map[string]interface{})
+		if !ok {
+			glog.Warningf("Skipping the globals of %s, the parent %s is not a table", prefix, chartutil.GlobalKey)
+			return
+		}
+		srcGlobals = globals
+	}
+
+	for key, value := range srcGlobals {
+		current, found := destGlobals[key]
+		switch {
+		case istable(value):
+			copied := copyValues(value).(map[string]interface{})
+			if !found || current == nil {
+				destGlobals[key] = copied
+			} else if currentTable, ok := current.(map[string]interface{}); ok {
+				// the parent globals win, nulls are removed when the values of
+				// the subchart are coalesced
+				destGlobals[key] = coalesceTables(copied, currentTable, joinValuePath(prefix, key), true)
+			} else {
+				glog.Warningf("Cannot merge the global table %s onto a non table value", key)
+			}
+		case found && istable(current):
+			glog.Warningf("The global %s of %s is a table, ignoring the value of the parent", key, prefix)
+		default:
+			destGlobals[key] = copyValues(value)
+		}
+	}
+	dest[chartutil.GlobalKey] = destGlobals
+}
+
+// importChartValues copy the values exported by the subcharts of a chart, and
+// of their subcharts, to the default values of the chart according to the
+// import-values of its requirements. The imported values override the default
+// values of the chart.
+func importChartValues(c *chart.Chart) error {
+	for _, dependency := range c.Dependencies {
+		if err := importChartValues(dependency); err != nil {
+			return err
+		}
+	}
+
+	requirements, err := chartutil.LoadRequirements(c)
+	if err == chartutil.ErrRequirementsNotFound {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("cannot load requirements: %v", err)
+	}
+
+	var coalesced chartutil.Values
+	imported := make(map[string]interface{})
+	for _, requirement := range requirements.Dependencies {
+		if len(requirement.ImportValues) == 0 {
+			continue
+		}
+
+		name := ""
+		for _, dependency := range c.Dependencies {
+			switch dependency.Metadata.Name {
+			case requirement.Name, requirement.Alias:
+				name = dependency.Metadata.Name
+			}
+		}
+		if name == "" {
+			// disabled subchart
+			continue
+		}
+
+		if coalesced == nil {
+			values, err := coalesceChartValues(c, nil)
+			if err != nil {
+				return err
+			}
+			coalesced = values
+		}
+
+		for _, importValue := range requirement.ImportValues {
+			var child, parent string
+			switch typedV := importValue.(type) {
+			case map[string]interface{}:
+				child, _ = typedV["child"].(string)
+				parent, _ = typedV["parent"].(string)
+			case string:
+				child, parent = "exports."+typedV, "."
+			default:
+				continue
+			}
+
+			table, err := coalesced.Table(name + "." + child)
+			if err != nil {
+				glog.Warningf("Cannot import the values %s.%s to %s: %v", name, child, c.Metadata.Name, err)
+				continue
+			}
+			coalesceTables(imported, valuesAtPath(parent, copyValues(table.AsMap()).(map[string]interface{})),
+				c.Metadata.Name, true)
+		}
+	}
+
+	if len(imported) == 0 {
+		return nil
+	}
+
+	defaults, err := chartDefaults(c)
+	if err != nil {
+		return err
+	}
+	raw, err := yaml.Marshal(coalesceTables(imported, defaults, c.Metadata.Name, true))
+	if err != nil {
+		return err
+	}
+	c.Values = &chart.Config{Raw: string(raw)}
+	return nil
+}
+
+// chartDefaults return the default values of a chart, not its subcharts
+func chartDefaults(c *chart.Chart) (map[string]interface{}, error) {
+	if c.Values == nil || c.Values.Raw == "" {
+		return map[string]interface{}{}, nil
+	}
+	values, err := chartutil.ReadValues([]byte(c.Values.Raw))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the default values of chart '%s': %v", c.Metadata.Name, err)
+	}
+	return map[string]interface{}(values), nil
+}
+
+// valuesAtPath return the values nested at the given dotted path, . being the
+// root
+func valuesAtPath(path string, values map[string]interface{}) map[string]interface{} {
+	if path == "." || path == "" {
+		return values
+	}
+	fields := strings.Split(path, ".")
+	for i := len(fields) - 1; i >= 0; i-- {
+		values = map[string]interface{}{fields[i]: values}
+	}
+	return values
+}
+
+// copyValues return a deep copy of values
+func copyValues(value interface{}) interface{} {
+	switch typedV := value.(type) {
+	case chartutil.Values:
+		return copyValues(map[string]interface{}(typedV))
+	case map[string]interface{}:
+		output := make(map[string]interface{}, len(typedV))
+		for k, v := range typedV {
+			output[k] = copyValues(v)
+		}
+		return output
+	case []interface{}:
+		output := make([]interface{}, len(typedV))
+		for i, v := range typedV {
+			output[i] = copyValues(v)
+		}
+		return output
+	}
+	return value
+}
+
+// istable return true if a value is a table
+func istable(value interface{}) bool {
+	_, ok := value.(map[string]interface{})
+	return ok
+}
+
+// joinValuePath append a key to the path of a value
+func joinValuePath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
@@ -0,0 +1,295 @@
+package helm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/kylelemons/godebug/pretty"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+// coalesceTestChart return a chart with two subcharts, redis and worker
+func coalesceTestChart() *chart.Chart {
+	return &chart.Chart{
+		Metadata: &chart.Metadata{Name: "app", Version: "0.1.0"},
+		Values: &chart.Config{Raw: `image:
+  tag: "1.0"
+  pullPolicy: IfNotPresent
+tolerations:
+- key: dedicated
+global:
+  env: prod
+  labels:
+    team: web
+redis:
+  port: 6380
+`},
+		Dependencies: []*chart.Chart{
+			{
+				Metadata: &chart.Metadata{Name: "redis", Version: "0.1.0"},
+				Values: &chart.Config{Raw: `port: 6379
+persistence:
+  size: 8Gi
+global:
+  env: dev
+  region: eu
+  labels:
+    tier: cache
+`},
+			},
+			{
+				Metadata: &chart.Metadata{Name: "worker", Version: "0.1.0"},
+				Values:   &chart.Config{Raw: "global:\n  queue: jobs\n"},
+			},
+		},
+	}
+}
+
+func TestCoalesceChartValues(t *testing.T) {
+	defaults := `image:
+  tag: "1.0"
+  pullPolicy: IfNotPresent
+tolerations:
+- key: dedicated
+global:
+  env: prod
+  labels:
+    team: web
+redis:
+  port: 6380
+  persistence:
+    size: 8Gi
+  global:
+    env: prod
+    region: eu
+    labels:
+      team: web
+      tier: cache
+worker:
+  global:
+    env: prod
+    queue: jobs
+    labels:
+      team: web
+`
+
+	// the leaves changed from the defaults, nil for the deleted leaves
+	testCases := []struct {
+		name     string
+		values   string
+		expected map[string]interface{}
+	}{
+		{
+			name:     "the globals of a subchart don't leak to its parent and siblings",
+			values:   "",
+			expected: map[string]interface{}{},
+		},
+		{
+			name:     "null deletes a top-level value",
+			values:   "tolerations: null\n",
+			expected: map[string]interface{}{"tolerations[0].key": nil},
+		},
+		{
+			name:     "null deletes a nested value",
+			values:   "image:\n  pullPolicy: null\n",
+			expected: map[string]interface{}{"image.pullPolicy": nil},
+		},
+		{
+			name:     "null deletes a table",
+			values:   "image: null\n",
+			expected: map[string]interface{}{"image.pullPolicy": nil, "image.tag": nil},
+		},
+		{
+			name:   "null deletes a default value of a subchart",
+			values: "redis:\n  persistence:\n    size: null\n",
+			expected: map[string]interface{}{
+				"redis.persistence":      map[string]interface{}{},
+				"redis.persistence.size": nil,
+			},
+		},
+		{
+			name:   "the globals of the parent override the globals of the subcharts",
+			values: "global:\n  region: us\n",
+			expected: map[string]interface{}{
+				"global.region":        "us",
+				"redis.global.region":  "us",
+				"worker.global.region": "us",
+			},
+		},
+		{
+			name:   "the values of the parent override the values of a subchart",
+			values: "redis:\n  port: 7000\n  persistence:\n    size: 1Gi\n",
+			expected: map[string]interface{}{
+				"redis.port":             float64(7000),
+				"redis.persistence.size": "1Gi",
+			},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			values, err := chartutil.ReadValues([]byte(test.values))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			input := copyValues(values)
+
+			output, err := coalesceChartValues(coalesceTestChart(), values)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var defaultValues map[string]interface{}
+			if err := yaml.Unmarshal([]byte(defaults), &defaultValues); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			expected := leafValues(defaultValues)
+			for key, value := range test.expected {
+				if value == nil {
+					delete(expected, key)
+				} else {
+					expected[key] = value
+				}
+			}
+
+			if diff := pretty.Compare(leafValues(output), expected); diff != "" {
+				t.Errorf("diff: (-got +want)\n%s", diff)
+			}
+			if diff := pretty.Compare(values, input); diff != "" {
+				t.Errorf("the values were modified: (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+// leafValues return the leaves of values indexed by their path
+func leafValues(values map[string]interface{}) map[string]interface{} {
+	output := make(map[string]interface{})
+	for _, leaf := range valueLeaves(values, nil) {
+		output[leaf.String()] = leaf.value
+	}
+	return output
+}
+
+func TestCoalesceValuesNullFlags(t *testing.T) {
+	valuesFile := filepath.Join(t.TempDir(), "values.yaml")
+	if err := os.WriteFile(valuesFile, []byte("tolerations: null\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rawVals, err := NewHelm(nil).Vals(ValueFiles{valuesFile},
+		[]string{"image.pullPolicy=null,redis.persistence=null"}, nil, nil, nil, nil, "", "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	values, err := coalesceValues(coalesceTestChart(), rawVals)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, p := range [][]string{{"tolerations"}, {"image", "pullPolicy"}, {"redis", "persistence"}} {
+		if value, found := lookupValue(values, p); found {
+			t.Errorf("expected %v to be deleted, got %v", p, value)
+		}
+	}
+	if value, _ := lookupValue(values, []string{"image", "tag"}); value != "1.0" {
+		t.Errorf("expected image.tag to be kept, got %v", value)
+	}
+}
+
+func TestImportChartValues(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"Chart.yaml": "name: app\nversion: 0.1.0\n",
+		"values.yaml": `redisPort: 1
+storage:
+  class: fast
+  size: 1Gi
+`,
+		"requirements.yaml": `dependencies:
+- name: redis
+  version: 0.1.0
+  alias: cache
+  import-values:
+  - data
+  - child: persistence
+    parent: storage
+`,
+		"charts/redis/Chart.yaml": "name: redis\nversion: 0.1.0\n",
+		"charts/redis/values.yaml": `exports:
+  data:
+    redisPort: 6379
+persistence:
+  size: 8Gi
+  accessMode: ReadWriteOnce
+`,
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	c, err := chartutil.Load(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		values   string
+		expected map[string]interface{}
+	}{
+		{
+			name:   "the imported values override the default values",
+			values: "",
+			expected: map[string]interface{}{
+				"redisPort":          float64(6379),
+				"storage.class":      "fast",
+				"storage.size":       "8Gi",
+				"storage.accessMode": "ReadWriteOnce",
+			},
+		},
+		{
+			name:   "the user values override the imported values",
+			values: "redisPort: 7000\nstorage:\n  size: 20Gi\n  accessMode: null\n",
+			expected: map[string]interface{}{
+				"redisPort":     float64(7000),
+				"storage.class": "fast",
+				"storage.size":  "20Gi",
+			},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			values, err := coalesceValues(c, []byte(test.values))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			output := make(map[string]interface{})
+			for key, value := range leafValues(values) {
+				if key == "redisPort" || strings.HasPrefix(key, "storage.") {
+					output[key] = value
+				}
+			}
+			if diff := pretty.Compare(output, test.expected); diff != "" {
+				t.Errorf("diff: (-got +want)\n%s", diff)
+			}
+		})
+	}
+
+	// the chart is left untouched
+	if c.Values.Raw != files["values.yaml"] {
+		t.Errorf("the default values of the chart were modified: %s", c.Values.Raw)
+	}
+}
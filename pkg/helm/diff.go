@@ -0,0 +1,225 @@
+package helm
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/layertwo/helm-convert/pkg/types"
+	"k8s.io/helm/pkg/manifest"
+	"k8s.io/helm/pkg/releaseutil"
+)
+
+const (
+	// SentinelReleaseName is the release name used to find the fields derived
+	// from the release name, it must be a valid resource name unlikely to be
+	// found in a chart
+	SentinelReleaseName = "hcsentinelrelease"
+
+	// SentinelReleaseNamespace is the namespace used to find the fields
+	// derived from the release namespace
+	SentinelReleaseNamespace = "hcsentinelnamespace"
+)
+
+// sentinelMarkers replace the sentinels by the markers of a release field
+var sentinelMarkers = strings.NewReplacer(
+	SentinelReleaseName, types.ReleaseNameMarker,
+	SentinelReleaseNamespace, types.ReleaseNamespaceMarker,
+)
+
+// renderedResource is a resource rendered by a template, it is identified by
+// the template and its position in the template output, so that resources
+// whose name changes between two renders can be compared
+type renderedResource struct {
+	key string
+	obj map[string]interface{}
+}
+
+// parseManifests return the resources of rendered manifests, lists are
+// expanded like the convert command does
+func parseManifests(manifests []manifest.Manifest) (map[string]*renderedResource, error) {
+	resources := make(map[string]*renderedResource)
+
+	for _, m := range manifests {
+		if filepath.Base(m.Name) == "NOTES.txt" {
+			continue
+		}
+
+		documents := releaseutil.SplitManifests(m.Content)
+		for i := 0; i < len(documents); i++ {
+			var obj map[string]interface{}
+			if err := yaml.Unmarshal([]byte(documents[fmt.Sprintf("manifest-%d", i)]), &obj); err != nil {
+				return nil, fmt.Errorf("%s: %v", m.Name, err)
+			}
+			if len(obj) == 0 {
+				continue
+			}
+
+			key := fmt.Sprintf("%s#%d", m.Name, i)
+			if items, ok := obj["items"].([]interface{}); ok {
+				for j, item := range items {
+					if typedItem, ok := item.(map[string]interface{}); ok {
+						itemKey := fmt.Sprintf("%s[%d]", key, j)
+						resources[itemKey] = &renderedResource{itemKey, typedItem}
+					}
+				}
+				continue
+			}
+			resources[key] = &renderedResource{key, obj}
+		}
+	}
+
+	return resources, nil
+}
+
+// diffManifests return the fields whose value differ between two renders of a
+// chart, the resources are identified by their kind, name and namespace in
+// the first render
+func diffManifests(a, b []manifest.Manifest) ([]types.ResourceField, error) {
+	var fields []types.ResourceField
+	err := diffResources(a, b, func(field types.ResourceField, _, _ interface{}) {
+		fields = append(fields, field)
+	})
+	return fields, err
+}
+
+// diffResources call changed with each field whose value differ between two
+// renders of a chart and both values, the resources are identified by their
+// kind, name and namespace in the first render
+func diffResources(a, b []manifest.Manifest, changed func(types.ResourceField, interface{}, interface{})) error {
+	resourcesA, err := parseManifests(a)
+	if err != nil {
+		return err
+	}
+	resourcesB, err := parseManifests(b)
+	if err != nil {
+		return err
+	}
+
+	diffParsedResources(resourcesA, resourcesB, changed)
+	return nil
+}
+
+// diffParsedResources is diffResources for parsed manifests, so that a render
+// compared many times is only parsed once. A resource found in a single render
+// is a change of the whole resource, with an empty path and a nil value on the
+// missing side.
+func diffParsedResources(resourcesA, resourcesB map[string]*renderedResource,
+	changed func(types.ResourceField, interface{}, interface{})) {
+
+	keys := make([]string, 0, len(resourcesA))
+	for key := range resourcesA {
+		keys = append(keys, key)
+	}
+	for key := range resourcesB {
+		if _, found := resourcesA[key]; !found {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		resA, resB := resourcesA[key], resourcesB[key]
+
+		// the identity is taken from the first render when found
+		res := resA
+		if res == nil {
+			res = resB
+		}
+		kind, _ := res.obj["kind"].(string)
+		metadata, _ := res.obj["metadata"].(map[string]interface{})
+		name, _ := metadata["name"].(string)
+		namespace, _ := metadata["namespace"].(string)
+		field := func(path []string) types.ResourceField {
+			return types.ResourceField{Kind: kind, Name: name, Namespace: namespace, Path: path}
+		}
+
+		switch {
+		case resB == nil:
+			changed(field(nil), resA.obj, nil)
+		case resA == nil:
+			changed(field(nil), nil, resB.obj)
+		default:
+			diffValues(resA.obj, resB.obj, nil, func(path []string, valueA, valueB interface{}) {
+				changed(field(path), valueA, valueB)
+			})
+		}
+	}
+}
+
+// releaseFields return the string fields of a render which differ in a render
+// with the sentinel release name and namespace and hold one of them. The
+// identity of the resource and the field paths are taken from the first render.
+// The name and namespace of the resources are left to kustomize.
+func releaseFields(manifests, sentinelManifests []manifest.Manifest) ([]types.ReleaseField, error) {
+	var fields []types.ReleaseField
+	err := diffResources(manifests, sentinelManifests, func(field types.ResourceField, a, b interface{}) {
+		value, ok := a.(string)
+		if !ok {
+			return
+		}
+		sentinelValue, ok := b.(string)
+		if !ok || !strings.Contains(sentinelValue, SentinelReleaseName) &&
+			!strings.Contains(sentinelValue, SentinelReleaseNamespace) {
+			return
+		}
+		if len(field.Path) == 2 && field.Path[0] == "metadata" &&
+			(field.Path[1] == "name" || field.Path[1] == "namespace") {
+			return
+		}
+
+		fields = append(fields, types.ReleaseField{
+			ResourceField: field,
+			Value:         value,
+			Template:      sentinelMarkers.Replace(sentinelValue),
+		})
+	})
+	return fields, err
+}
+
+// diffValues call changed with the path and both values of each value which
+// differ, a map key or list element missing on one side is a difference of the
+// map or list
+func diffValues(a, b interface{}, path []string, changed func([]string, interface{}, interface{})) {
+	child := func(field string) []string {
+		return append(path[:len(path):len(path)], field)
+	}
+
+	switch typedA := a.(type) {
+	case map[string]interface{}:
+		typedB, ok := b.(map[string]interface{})
+		if !ok || len(typedA) != len(typedB) {
+			changed(path, a, b)
+			return
+		}
+		keys := make([]string, 0, len(typedA))
+		for key := range typedA {
+			if _, found := typedB[key]; !found {
+				changed(path, a, b)
+				return
+			}
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			diffValues(typedA[key], typedB[key], child(key), changed)
+		}
+	case []interface{}:
+		typedB, ok := b.([]interface{})
+		if !ok || len(typedA) != len(typedB) {
+			changed(path, a, b)
+			return
+		}
+		for i := range typedA {
+			diffValues(typedA[i], typedB[i], child("["+strconv.Itoa(i)+"]"), changed)
+		}
+	default:
+		if !reflect.DeepEqual(a, b) {
+			changed(path, a, b)
+		}
+	}
+}
@@ -12,10 +12,9 @@ import (
 
 	"github.com/ghodss/yaml"
 	"github.com/golang/glog"
+	"github.com/layertwo/helm-convert/pkg/types"
 	"k8s.io/helm/pkg/chartutil"
 	"k8s.io/helm/pkg/downloader"
-	"k8s.io/helm/pkg/getter"
-	helm_env "k8s.io/helm/pkg/helm/environment"
 	"k8s.io/helm/pkg/manifest"
 	"k8s.io/helm/pkg/proto/hapi/chart"
 	"k8s.io/helm/pkg/renderutil"
@@ -27,8 +26,9 @@ var defaultKubeVersion = fmt.Sprintf("%s.%s", chartutil.DefaultKubeVersion.Major
 
 // Helm type
 type Helm struct {
-	settings helm_env.EnvSettings
-	out      io.Writer
+	helm3 helm3Config
+	out   io.Writer
+	cache *renderCache
 }
 
 // LoadChartConfig define the configuration to load a chart
@@ -55,13 +55,72 @@ type RenderChartConfig struct {
 	Values         []string
 	StringValues   []string
 	FileValues     []string
+	JSONValues     []string
+	LiteralValues  []string
+
+	// KubeVersion is the Kubernetes version exposed to the templates, ie:
+	// 1.29, the default version of Helm is used if empty
+	KubeVersion string
+
+	// DetectNonDeterministic render the chart a second time and record the
+	// fields whose value differ between both renders
+	DetectNonDeterministic bool
+
+	// DetectReleaseFields render the chart a second time with sentinel
+	// release name and namespace, and record the fields derived from them
+	DetectReleaseFields bool
+
+	// DetectValueFields render the chart once per value with the value
+	// perturbed, and record the fields controlled by each value
+	DetectValueFields bool
+
+	// DetectUnusedValues record the values supplied by the user which have
+	// no effect on the rendered manifests, the chart is rendered once per
+	// value with the value perturbed. It also record the values which are
+	// not defined by the default values of the chart.
+	DetectUnusedValues bool
+
+	// Concurrency is the number of renders run concurrently by the value
+	// analysis, the number of CPUs is used if zero
+	Concurrency int
 }
 
-// NewHelm constructs helm
-func NewHelm(settings helm_env.EnvSettings, out io.Writer) *Helm {
+// RenderedChart is the result of the rendering of a chart
+type RenderedChart struct {
+	Manifests []manifest.Manifest
+
+	// NonDeterministic contains the fields generated on every render (ie:
+	// randAlphaNum, uuidv4, now, genPrivateKey), only set when
+	// RenderChartConfig.DetectNonDeterministic is enabled
+	NonDeterministic []types.ResourceField
+
+	// ReleaseFields contains the string fields derived from the release name
+	// or namespace, only set when RenderChartConfig.DetectReleaseFields is
+	// enabled
+	ReleaseFields []types.ReleaseField
+
+	// ValueMap contains the fields controlled by each value of the chart,
+	// only set when RenderChartConfig.DetectValueFields is enabled
+	ValueMap types.ValueMap
+
+	// UnusedValues contains the values supplied by the user which have no
+	// effect on the rendered manifests, only set when
+	// RenderChartConfig.DetectUnusedValues is enabled
+	UnusedValues []string
+
+	// UnknownValues contains the values supplied by the user which are not
+	// defined by the default values of the chart, only set when
+	// RenderChartConfig.DetectUnusedValues is enabled
+	UnknownValues []string
+}
+
+// NewHelm constructs helm, the repositories are read from the paths of the
+// Helm 3 environment
+func NewHelm(out io.Writer) *Helm {
 	return &Helm{
-		settings,
+		newHelm3Config(os.Getenv),
 		out,
+		newRenderCache(),
 	}
 }
 
@@ -95,15 +154,21 @@ func (h *Helm) LoadChart(c *LoadChartConfig) (*chart.Chart, error) {
 	if req, err := chartutil.LoadRequirements(chartRequested); err == nil {
 		if err := renderutil.CheckDependencies(chartRequested, req); err != nil {
 			if c.DepUp {
+				home, cleanup, err := h.helm3.dependencyHome()
+				if err != nil {
+					return nil, err
+				}
 				man := &downloader.Manager{
 					Out:        h.out,
 					ChartPath:  chartPath,
-					HelmHome:   h.settings.Home,
+					HelmHome:   home,
 					Keyring:    c.Keyring,
 					SkipUpdate: false,
-					Getters:    getter.All(h.settings),
+					Getters:    getters(),
 				}
-				if err := man.Update(); err != nil {
+				err = man.Update()
+				cleanup()
+				if err != nil {
 					return nil, err
 				}
 
@@ -124,32 +189,99 @@ func (h *Helm) LoadChart(c *LoadChartConfig) (*chart.Chart, error) {
 }
 
 // RenderChart manifest
-func (h *Helm) RenderChart(c *RenderChartConfig) ([]manifest.Manifest, error) {
+func (h *Helm) RenderChart(c *RenderChartConfig) (*RenderedChart, error) {
+	kubeVersion := c.KubeVersion
+	if kubeVersion == "" {
+		kubeVersion = defaultKubeVersion
+	}
+
 	renderOpts := renderutil.Options{
 		ReleaseOptions: chartutil.ReleaseOptions{
 			Name:      c.Name,
 			Namespace: c.Namespace,
 		},
-		KubeVersion: defaultKubeVersion,
+		KubeVersion: strings.TrimPrefix(kubeVersion, "v"),
 	}
 	glog.V(8).Infof("Rendering chart with options: %#v\n", renderOpts)
 
 	// get combined values and create config
-	rawVals, err := h.Vals(c.ValueFiles, c.Values, c.StringValues, c.FileValues, "", "", "")
+	rawVals, err := h.Vals(c.ValueFiles, c.Values, c.StringValues, c.FileValues, c.JSONValues, c.LiteralValues,
+		"", "", "")
 	if err != nil {
 		return nil, err
 	}
 
-	config := &chart.Config{Raw: string(rawVals), Values: map[string]*chart.Value{}}
-	glog.V(10).Infof("Chart config: %#v", config)
+	values, err := coalesceValues(c.ChartRequested, rawVals)
+	if err != nil {
+		return nil, err
+	}
+	glog.V(10).Infof("Chart values: %#v", values)
 	glog.V(10).Info("Chart requested", c.ChartRequested)
 
-	renderedTemplates, err := renderutil.Render(c.ChartRequested, config, renderOpts)
+	manifests, err := h.renderValues(c.ChartRequested, values, renderOpts)
 	if err != nil {
 		return nil, err
 	}
+	rendered := &RenderedChart{Manifests: manifests}
+
+	// the value analysis ignore the non-deterministic fields
+	var nonDeterministic []types.ResourceField
+	if c.DetectNonDeterministic || c.DetectValueFields || c.DetectUnusedValues {
+		// the second render isn't cached
+		manifests, err := h.render(c.ChartRequested, values, renderOpts)
+		if err != nil {
+			return nil, err
+		}
+
+		nonDeterministic, err = diffManifests(rendered.Manifests, manifests)
+		if err != nil {
+			return nil, fmt.Errorf("cannot compare the renders of the chart: %v", err)
+		}
+		glog.V(8).Infof("Found %d non-deterministic fields", len(nonDeterministic))
+	}
+	if c.DetectNonDeterministic {
+		rendered.NonDeterministic = nonDeterministic
+	}
+
+	if c.DetectReleaseFields {
+		sentinelOpts := renderOpts
+		sentinelOpts.ReleaseOptions.Name = SentinelReleaseName
+		sentinelOpts.ReleaseOptions.Namespace = SentinelReleaseNamespace
+		manifests, err := h.renderValues(c.ChartRequested, values, sentinelOpts)
+		if err != nil {
+			return nil, fmt.Errorf("cannot render the chart with a sentinel release: %v", err)
+		}
+
+		rendered.ReleaseFields, err = releaseFields(rendered.Manifests, manifests)
+		if err != nil {
+			return nil, fmt.Errorf("cannot compare the renders of the chart: %v", err)
+		}
+		glog.V(8).Infof("Found %d fields derived from the release name or namespace", len(rendered.ReleaseFields))
+	}
+
+	if c.DetectValueFields {
+		rendered.ValueMap, err = h.valueMap(c.ChartRequested, rawVals, renderOpts, c.Concurrency, nonDeterministic)
+		if err != nil {
+			return nil, fmt.Errorf("cannot map the values to the rendered fields: %v", err)
+		}
+		glog.V(8).Infof("Mapped %d values to the rendered fields", len(rendered.ValueMap))
+	}
 
-	return manifest.SplitManifests(renderedTemplates), nil
+	if c.DetectUnusedValues {
+		rendered.UnusedValues, err = h.unusedValues(c.ChartRequested, rawVals, renderOpts, c.Concurrency,
+			nonDeterministic, rendered.ValueMap)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find the unused values: %v", err)
+		}
+		rendered.UnknownValues, err = unknownValues(c.ChartRequested, rawVals)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find the unknown values: %v", err)
+		}
+		glog.V(8).Infof("Found %d unused and %d unknown values", len(rendered.UnusedValues),
+			len(rendered.UnknownValues))
+	}
+
+	return rendered, nil
 }
 
 // LocateChartPath looks for a chart directory in known places, and returns either the full path or an error.
@@ -159,10 +291,13 @@ func (h *Helm) RenderChart(c *RenderChartConfig) ([]manifest.Manifest, error) {
 // Order of resolution:
 // - current working directory
 // - if path is absolute or begins with '.', error out here
-// - chart repos in $HELM_HOME
+// - chart repos in the Helm 3 repositories.yaml ($HELM_REPOSITORY_CONFIG)
+// - chart repo given by repoURL
 // - URL
 //
-// If 'verify' is true, this will attempt to also verify the chart.
+// The downloaded charts are written to the Helm 3 repository cache
+// ($HELM_REPOSITORY_CACHE). If 'verify' is true, this will attempt to also
+// verify the chart.
 func (h *Helm) LocateChartPath(repoURL, username, password, name, version string, verify bool, keyring,
 	certFile, keyFile, caFile string) (string, error) {
 	name = strings.TrimSpace(name)
@@ -187,61 +322,54 @@ func (h *Helm) LocateChartPath(repoURL, username, password, name, version string
 		return name, fmt.Errorf("path %q not found", name)
 	}
 
-	crepo := filepath.Join(h.settings.Home.Repository(), name)
-
-	if _, err := os.Stat(crepo); err == nil {
-		return filepath.Abs(crepo)
+	flags := httpOptions{
+		username: username,
+		password: password,
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
 	}
 
-	dl := downloader.ChartDownloader{
-		HelmHome: h.settings.Home,
-		Out:      h.out,
-		Keyring:  keyring,
-		Getters:  getter.All(h.settings),
-		Username: username,
-		Password: password,
-	}
-
-	if verify {
-		dl.Verify = downloader.VerifyAlways
-	}
-
-	if repoURL != "" {
+	if repoURL == "" {
+		filename, found, err := h.locateRepositoryChart(name, version, verify, keyring, flags)
+		if found || err != nil {
+			return filename, err
+		}
+	} else {
 		chartURL, err := repo.FindChartInAuthRepoURL(repoURL, username, password, name, version,
-			certFile, keyFile, caFile, getter.All(h.settings))
+			certFile, keyFile, caFile, getters())
 		if err != nil {
 			return "", err
 		}
 		name = chartURL
 	}
 
-	if _, err := os.Stat(h.settings.Home.Archive()); os.IsNotExist(err) {
-		err := os.MkdirAll(h.settings.Home.Archive(), 0744)
-		if err != nil {
-			return "unable to create " + h.settings.Home.Archive(), err
-		}
+	chartURL, err := url.Parse(name)
+	if err != nil || !chartURL.IsAbs() {
+		return "", fmt.Errorf("chart %q not found, the repository isn't in %s", name, h.helm3.repositoryConfig)
 	}
-
-	glog.V(8).Infof("Downloading chart '%s' version '%s' with parameters: %#v\n", name, version, dl)
-	filename, _, err := dl.DownloadTo(name, version, h.settings.Home.Archive())
-
-	if err != nil {
-		return filename, err
+	if chartURL.Scheme != "http" && chartURL.Scheme != "https" {
+		return "", fmt.Errorf("cannot download the chart %q, the %s scheme isn't supported", name, chartURL.Scheme)
 	}
-
-	lname, err := filepath.Abs(filename)
-	if err != nil {
-		return filename, err
-	}
-	glog.V(4).Infof("Fetched %s to %s\n", name, filename)
-
-	return lname, nil
+	return h.downloadChart(name, chartURL, verify, keyring, flags)
 }
 
 // Vals merges values from files specified via -f/--values and
-// directly via --set or --set-string or --set-file, marshaling them to YAML
-func (h *Helm) Vals(valueFiles ValueFiles, values []string, stringValues []string, fileValues []string, CertFile, KeyFile, CAFile string) ([]byte, error) {
+// directly via --set-json, --set, --set-string, --set-file or --set-literal,
+// in this order like Helm 3, marshaling them to YAML
+func (h *Helm) Vals(valueFiles ValueFiles, values []string, stringValues []string, fileValues []string,
+	jsonValues []string, literalValues []string, CertFile, KeyFile, CAFile string) ([]byte, error) {
+	rawVals, _, err := h.vals(valueFiles, values, stringValues, fileValues, jsonValues, literalValues,
+		CertFile, KeyFile, CAFile)
+	return rawVals, err
+}
+
+// vals is Vals, it also return the layers merged in order so that the source
+// of each value can be found
+func (h *Helm) vals(valueFiles ValueFiles, values []string, stringValues []string, fileValues []string,
+	jsonValues []string, literalValues []string, CertFile, KeyFile, CAFile string) ([]byte, []*valueLayer, error) {
 	base := map[string]interface{}{}
+	var layers []*valueLayer
 
 	// User specified a values files via -f/--values
 	for _, filePath := range valueFiles {
@@ -256,28 +384,43 @@ func (h *Helm) Vals(valueFiles ValueFiles, values []string, stringValues []strin
 		}
 
 		if err != nil {
-			return []byte{}, err
+			return []byte{}, nil, err
 		}
 
 		if err := yaml.Unmarshal(bytes, &currentMap); err != nil {
-			return []byte{}, fmt.Errorf("failed to parse %s: %s", filePath, err)
+			return []byte{}, nil, fmt.Errorf("failed to parse %s: %s", filePath, err)
 		}
 		// Merge with the previous map
 		base = mergeValues(base, currentMap)
+		layers = append(layers, &valueLayer{source: ValueSource{File: filePath}, data: bytes})
+	}
+
+	// User specified a value via --set-json
+	for _, value := range jsonValues {
+		if err := parseJSONInto(value, base); err != nil {
+			return []byte{}, nil, fmt.Errorf("failed parsing --set-json data: %s", err)
+		}
+		layer := map[string]interface{}{}
+		parseJSONInto(value, layer)
+		layers = append(layers, &valueLayer{source: ValueSource{Flag: "--set-json " + value}, values: layer})
 	}
 
 	// User specified a value via --set
 	for _, value := range values {
 		if err := strvals.ParseInto(value, base); err != nil {
-			return []byte{}, fmt.Errorf("failed parsing --set data: %s", err)
+			return []byte{}, nil, fmt.Errorf("failed parsing --set data: %s", err)
 		}
+		layer, _ := strvals.Parse(value)
+		layers = append(layers, &valueLayer{source: ValueSource{Flag: "--set " + value}, values: layer})
 	}
 
 	// User specified a value via --set-string
 	for _, value := range stringValues {
 		if err := strvals.ParseIntoString(value, base); err != nil {
-			return []byte{}, fmt.Errorf("failed parsing --set-string data: %s", err)
+			return []byte{}, nil, fmt.Errorf("failed parsing --set-string data: %s", err)
 		}
+		layer, _ := strvals.ParseString(value)
+		layers = append(layers, &valueLayer{source: ValueSource{Flag: "--set-string " + value}, values: layer})
 	}
 
 	// User specified a value via --set-file
@@ -287,17 +430,30 @@ func (h *Helm) Vals(valueFiles ValueFiles, values []string, stringValues []strin
 			return string(bytes), err
 		}
 		if err := strvals.ParseIntoFile(value, base, reader); err != nil {
-			return []byte{}, fmt.Errorf("failed parsing --set-file data: %s", err)
+			return []byte{}, nil, fmt.Errorf("failed parsing --set-file data: %s", err)
+		}
+		layer, _ := strvals.ParseFile(value, func([]rune) (interface{}, error) { return "", nil })
+		layers = append(layers, &valueLayer{source: ValueSource{Flag: "--set-file " + value}, values: layer})
+	}
+
+	// User specified a value via --set-literal
+	for _, value := range literalValues {
+		if err := parseLiteralInto(value, base); err != nil {
+			return []byte{}, nil, fmt.Errorf("failed parsing --set-literal data: %s", err)
 		}
+		layer := map[string]interface{}{}
+		parseLiteralInto(value, layer)
+		layers = append(layers, &valueLayer{source: ValueSource{Flag: "--set-literal " + value}, values: layer})
 	}
 
-	return yaml.Marshal(base)
+	rawVals, err := yaml.Marshal(base)
+	return rawVals, layers, err
 }
 
 // readFile load a file from the local directory or a remote file with a url.
 func (h *Helm) readFile(filePath, CertFile, KeyFile, CAFile string) ([]byte, error) {
 	u, _ := url.Parse(filePath)
-	p := getter.All(h.settings)
+	p := getters()
 
 	// FIXME: maybe someone handle other protocols like ftp.
 	getterConstructor, err := p.ByScheme(u.Scheme)
@@ -0,0 +1,378 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/layertwo/helm-convert/pkg/types"
+	"k8s.io/helm/pkg/manifest"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+// testChart return a chart made of the given templates
+func testChart(templates map[string]string) *chart.Chart {
+	c := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "test", Version: "0.1.0"},
+		Values:   &chart.Config{Raw: "password: \"\"\n"},
+	}
+	for name, data := range templates {
+		c.Templates = append(c.Templates, &chart.Template{Name: name, Data: []byte(data)})
+	}
+	return c
+}
+
+func TestRenderChartNonDeterministic(t *testing.T) {
+	c := testChart(map[string]string{
+		"templates/secret.yaml": `apiVersion: v1
+kind: Secret
+metadata:
+  name: {{ .Release.Name }}-db
+  namespace: {{ .Release.Namespace }}
+data:
+  password: {{ .Values.password | default (randAlphaNum 16) | b64enc }}
+  username: {{ "admin" | b64enc }}
+`,
+		"templates/deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Release.Name }}-web
+spec:
+  template:
+    metadata:
+      annotations:
+        rollme: {{ uuidv4 | quote }}
+    spec:
+      containers:
+      - name: web
+        image: web:1.0
+        env:
+        - name: TOKEN
+          value: {{ randAlphaNum 32 | quote }}
+`,
+	})
+
+	for _, test := range []struct {
+		name     string
+		values   []string
+		expected []types.ResourceField
+	}{
+		{
+			name: "it should report the fields generated on every render",
+			expected: []types.ResourceField{
+				{Kind: "Deployment", Name: "rel-web", Path: []string{"spec", "template", "metadata", "annotations", "rollme"}},
+				{Kind: "Deployment", Name: "rel-web", Path: []string{"spec", "template", "spec", "containers", "[0]", "env", "[0]", "value"}},
+				{Kind: "Secret", Name: "rel-db", Namespace: "prod", Path: []string{"data", "password"}},
+			},
+		},
+		{
+			name:   "it should not report the values supplied by the user",
+			values: []string{"password=s3cr3t"},
+			expected: []types.ResourceField{
+				{Kind: "Deployment", Name: "rel-web", Path: []string{"spec", "template", "metadata", "annotations", "rollme"}},
+				{Kind: "Deployment", Name: "rel-web", Path: []string{"spec", "template", "spec", "containers", "[0]", "env", "[0]", "value"}},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			rendered, err := NewHelm(nil).RenderChart(&RenderChartConfig{
+				ChartRequested:         c,
+				Name:                   "rel",
+				Namespace:              "prod",
+				Values:                 test.values,
+				DetectNonDeterministic: true,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := pretty.Compare(rendered.NonDeterministic, test.expected); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+		})
+	}
+}
+
+func TestDiffManifests(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		a, b     []manifest.Manifest
+		expected []types.ResourceField
+	}{
+		{
+			name: "it should pair the documents of a template by position",
+			a: []manifest.Manifest{
+				{Name: "templates/cm.yaml", Content: "kind: ConfigMap\nmetadata:\n  name: a\ndata:\n  id: x1\n" +
+					"---\nkind: ConfigMap\nmetadata:\n  name: b\ndata:\n  id: same\n"},
+				{Name: "templates/NOTES.txt", Content: "random: y1"},
+			},
+			b: []manifest.Manifest{
+				{Name: "templates/cm.yaml", Content: "kind: ConfigMap\nmetadata:\n  name: a\ndata:\n  id: x2\n" +
+					"---\nkind: ConfigMap\nmetadata:\n  name: b\ndata:\n  id: same\n"},
+				{Name: "templates/NOTES.txt", Content: "random: y2"},
+			},
+			expected: []types.ResourceField{
+				{Kind: "ConfigMap", Name: "a", Path: []string{"data", "id"}},
+			},
+		},
+		{
+			name: "it should report lists and maps whose structure change",
+			a: []manifest.Manifest{
+				{Name: "templates/cm.yaml", Content: "kind: ConfigMap\nmetadata:\n  name: a\ndata:\n  k1: v\nports:\n- 1\n"},
+			},
+			b: []manifest.Manifest{
+				{Name: "templates/cm.yaml", Content: "kind: ConfigMap\nmetadata:\n  name: a\ndata:\n  k2: v\nports:\n- 1\n- 2\n"},
+			},
+			expected: []types.ResourceField{
+				{Kind: "ConfigMap", Name: "a", Path: []string{"data"}},
+				{Kind: "ConfigMap", Name: "a", Path: []string{"ports"}},
+			},
+		},
+		{
+			name: "it should report the resources found in a single render",
+			a: []manifest.Manifest{
+				{Name: "templates/cm.yaml", Content: "kind: ConfigMap\nmetadata:\n  name: a\n"},
+			},
+			b: []manifest.Manifest{
+				{Name: "templates/ingress.yaml", Content: "kind: Ingress\nmetadata:\n  name: b\n"},
+			},
+			expected: []types.ResourceField{
+				{Kind: "ConfigMap", Name: "a"},
+				{Kind: "Ingress", Name: "b"},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			fields, err := diffManifests(test.a, test.b)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := pretty.Compare(fields, test.expected); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+		})
+	}
+}
+
+func TestRenderChartReleaseFields(t *testing.T) {
+	c := testChart(map[string]string{
+		"templates/deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Release.Name }}-web
+  namespace: {{ .Release.Namespace }}
+  labels:
+    release: {{ .Release.Name }}
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:1.0
+        args:
+        - -Dapp.namespace={{ .Release.Namespace }}
+        - --id={{ .Release.Name | trunc 2 }}
+        - --static=rel
+`,
+	})
+
+	rendered, err := NewHelm(nil).RenderChart(&RenderChartConfig{
+		ChartRequested:      c,
+		Name:                "rel",
+		Namespace:           "prod",
+		DetectReleaseFields: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	resource := types.ResourceField{Kind: "Deployment", Name: "rel-web", Namespace: "prod"}
+	field := func(value, template string, path ...string) types.ReleaseField {
+		f := types.ReleaseField{ResourceField: resource, Value: value, Template: template}
+		f.Path = path
+		return f
+	}
+	expected := []types.ReleaseField{
+		field("rel", "{{ .Release.Name }}", "metadata", "labels", "release"),
+		field("-Dapp.namespace=prod", "-Dapp.namespace={{ .Release.Namespace }}",
+			"spec", "template", "spec", "containers", "[0]", "args", "[0]"),
+	}
+
+	if diff := pretty.Compare(rendered.ReleaseFields, expected); diff != "" {
+		t.Errorf("diff: (-got +want)\n%s", diff)
+	}
+}
+
+func TestRenderChartValueMap(t *testing.T) {
+	c := testChart(map[string]string{
+		"templates/deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Release.Name }}-web
+spec:
+  replicas: {{ .Values.replicaCount }}
+  template:
+    metadata:
+      annotations:
+        rollme: {{ uuidv4 | quote }}
+    spec:
+      containers:
+      - name: web
+        image: "{{ .Values.image.repository }}:{{ .Values.image.tag }}"
+        {{- with .Values.tolerations }}
+      tolerations:
+{{ toYaml . | indent 6 }}
+        {{- end }}
+`,
+		"templates/ingress.yaml": `{{- if .Values.ingress.enabled }}
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: {{ .Release.Name }}-web
+{{- end }}
+`,
+	})
+	c.Values = &chart.Config{Raw: `replicaCount: 2
+image:
+  repository: web
+  tag: "1.0"
+tolerations: []
+ingress:
+  enabled: false
+global:
+  registry: docker.io
+unused: true
+`}
+	cache := testChart(map[string]string{
+		"templates/deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Release.Name }}-cache
+spec:
+  template:
+    spec:
+      containers:
+      - name: cache
+        image: "{{ .Values.global.registry }}/cache:1.0"
+`,
+	})
+	cache.Metadata = &chart.Metadata{Name: "cache", Version: "0.1.0"}
+	cache.Values = nil
+	c.Dependencies = []*chart.Chart{cache}
+
+	h := NewHelm(nil)
+	rendered, err := h.RenderChart(&RenderChartConfig{
+		ChartRequested:    c,
+		Name:              "rel",
+		Namespace:         "prod",
+		Values:            []string{"image.tag=2.0"},
+		DetectValueFields: true,
+		Concurrency:       2,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	field := func(path ...string) types.ResourceField {
+		return types.ResourceField{Kind: "Deployment", Name: "rel-web", Path: path}
+	}
+	image := field("spec", "template", "spec", "containers", "[0]", "image")
+	expected := types.ValueMap{
+		"global.registry": {{Kind: "Deployment", Name: "rel-cache",
+			Path: []string{"spec", "template", "spec", "containers", "[0]", "image"}}},
+		"image.repository": {image},
+		"image.tag":        {image},
+		"ingress.enabled":  {{Kind: "Ingress", Name: "rel-web"}},
+		"replicaCount":     {field("spec", "replicas")},
+		"tolerations":      {field("spec", "template", "spec")},
+	}
+
+	if diff := pretty.Compare(rendered.ValueMap, expected); diff != "" {
+		t.Errorf("diff: (-got +want)\n%s", diff)
+	}
+	if rendered.NonDeterministic != nil {
+		t.Errorf("expected the non-deterministic fields to be left unset, got %v", rendered.NonDeterministic)
+	}
+	if len(h.cache.renders) != 1 {
+		t.Errorf("expected only the baseline render to be cached, got %d renders", len(h.cache.renders))
+	}
+}
+
+func TestRenderChartUnusedValues(t *testing.T) {
+	c := testChart(map[string]string{
+		"templates/deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Release.Name }}-web
+spec:
+  replicas: {{ .Values.replicaCount }}
+  template:
+    spec:
+      containers:
+      - name: web
+        image: "{{ .Values.image.repository | default "web" }}:{{ .Values.image.tag }}"
+        {{- with .Values.nodeSelector }}
+      nodeSelector:
+{{ toYaml . | indent 8 }}
+        {{- end }}
+`,
+		"templates/ingress.yaml": `{{- if .Values.ingress.enabled }}
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: {{ .Release.Name }}-web
+{{- end }}
+`,
+	})
+	c.Values = &chart.Config{Raw: `replicaCount: 2
+image:
+  repository: web
+  tag: "1.0"
+nodeSelector: {}
+ingress:
+  enabled: false
+global:
+  registry: docker.io
+debug: false
+`}
+	cache := testChart(map[string]string{
+		"templates/deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Release.Name }}-cache
+spec:
+  template:
+    spec:
+      containers:
+      - name: cache
+        image: "{{ .Values.global.registry }}/cache:1.0"
+`,
+	})
+	cache.Metadata = &chart.Metadata{Name: "cache", Version: "0.1.0"}
+	c.Dependencies = []*chart.Chart{cache}
+
+	rendered, err := NewHelm(nil).RenderChart(&RenderChartConfig{
+		ChartRequested: c,
+		Name:           "rel",
+		Namespace:      "prod",
+		Values: []string{
+			"replicaCout=3",
+			"image.tag=2.0,image.pullPolicy=Always,image.repository=null",
+			"nodeSelector.disktype=ssd",
+			"ingress.enabled=true",
+			"global.registry=quay.io",
+			"debug=true",
+		},
+		DetectUnusedValues: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if diff := pretty.Compare(rendered.UnusedValues, []string{"debug", "image.pullPolicy", "replicaCout"}); diff != "" {
+		t.Errorf("unused values, diff: (-got +want)\n%s", diff)
+	}
+	if diff := pretty.Compare(rendered.UnknownValues, []string{"image.pullPolicy", "replicaCout"}); diff != "" {
+		t.Errorf("unknown values, diff: (-got +want)\n%s", diff)
+	}
+}
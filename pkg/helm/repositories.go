@@ -0,0 +1,347 @@
+package helm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"k8s.io/helm/pkg/downloader"
+	"k8s.io/helm/pkg/getter"
+	"k8s.io/helm/pkg/helm/helmpath"
+	"k8s.io/helm/pkg/repo"
+	"k8s.io/helm/pkg/tlsutil"
+)
+
+// helm3Config is the client configuration of Helm 3, the paths are passed to
+// the plugins with the HELM_* environment variables
+type helm3Config struct {
+	// repositoryConfig is the repositories.yaml file
+	repositoryConfig string
+
+	// repositoryCache contains the repository indexes, ie: stable-index.yaml,
+	// and the downloaded charts
+	repositoryCache string
+}
+
+// newHelm3Config return the Helm 3 configuration from the environment, the
+// defaults of Helm 3 are used for the unset variables
+func newHelm3Config(getenv func(string) string) helm3Config {
+	configHome := helm3Home(getenv, "HELM_CONFIG_HOME", "XDG_CONFIG_HOME", "config")
+	cacheHome := helm3Home(getenv, "HELM_CACHE_HOME", "XDG_CACHE_HOME", "cache")
+
+	config := helm3Config{
+		repositoryConfig: getenv("HELM_REPOSITORY_CONFIG"),
+		repositoryCache:  getenv("HELM_REPOSITORY_CACHE"),
+	}
+	if config.repositoryConfig == "" {
+		config.repositoryConfig = filepath.Join(configHome, "repositories.yaml")
+	}
+	if config.repositoryCache == "" {
+		config.repositoryCache = filepath.Join(cacheHome, "repository")
+	}
+	return config
+}
+
+// helm3Home return a Helm 3 home directory: the given variable, the XDG base
+// directory or the default directory of the platform, kind is config or cache
+func helm3Home(getenv func(string) string, env, xdgEnv, kind string) string {
+	if dir := getenv(env); dir != "" {
+		return dir
+	}
+	if dir := getenv(xdgEnv); dir != "" {
+		return filepath.Join(dir, "helm")
+	}
+
+	home := getenv("HOME")
+	switch runtime.GOOS {
+	case "darwin":
+		if kind == "config" {
+			return filepath.Join(home, "Library", "Preferences", "helm")
+		}
+		return filepath.Join(home, "Library", "Caches", "helm")
+	case "windows":
+		if kind == "config" {
+			return filepath.Join(getenv("APPDATA"), "helm")
+		}
+		return filepath.Join(getenv("TEMP"), "helm")
+	}
+	return filepath.Join(home, "."+kind, "helm")
+}
+
+// repositoryEntry is a repository of the Helm 3 repositories.yaml file
+type repositoryEntry struct {
+	Name                  string `json:"name"`
+	URL                   string `json:"url"`
+	Username              string `json:"username"`
+	Password              string `json:"password"`
+	CertFile              string `json:"certFile"`
+	KeyFile               string `json:"keyFile"`
+	CAFile                string `json:"caFile"`
+	InsecureSkipTLSVerify bool   `json:"insecure_skip_tls_verify"`
+	PassCredentialsAll    bool   `json:"pass_credentials_all"`
+}
+
+// repositories return the entries of the Helm 3 repositories.yaml file, none
+// if the file doesn't exist
+func (c helm3Config) repositories() ([]*repositoryEntry, error) {
+	data, err := os.ReadFile(c.repositoryConfig)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Repositories []*repositoryEntry `json:"repositories"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", c.repositoryConfig, err)
+	}
+	return file.Repositories, nil
+}
+
+// repository return the entry of a repository in the Helm 3 repositories.yaml
+// file, nil if the file or the repository doesn't exist
+func (c helm3Config) repository(name string) (*repositoryEntry, error) {
+	entries, err := c.repositories()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Name == name {
+			return entry, nil
+		}
+	}
+	return nil, nil
+}
+
+// dependencyHome return a temporary Helm 2 home for the dependency manager of
+// Helm 2: its repositories.yaml lists the Helm 3 repositories and its cache is
+// a link to the Helm 3 repository cache, so that the indexes are shared. The
+// returned function removes the home.
+func (c helm3Config) dependencyHome() (helmpath.Home, func(), error) {
+	entries, err := c.repositories()
+	if err != nil {
+		return "", nil, err
+	}
+	if err := os.MkdirAll(c.repositoryCache, 0755); err != nil {
+		return "", nil, fmt.Errorf("unable to create %s: %v", c.repositoryCache, err)
+	}
+
+	dir, err := os.MkdirTemp("", "helm-convert-home")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	home := helmpath.Home(dir)
+
+	if err := os.MkdirAll(home.Repository(), 0755); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if err := os.Symlink(c.repositoryCache, home.Cache()); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("unable to link the repository cache %s: %v", c.repositoryCache, err)
+	}
+
+	file := repo.NewRepoFile()
+	for _, entry := range entries {
+		file.Add(&repo.Entry{
+			Name:     entry.Name,
+			Cache:    entry.Name + "-index.yaml",
+			URL:      entry.URL,
+			Username: entry.Username,
+			Password: entry.Password,
+			CertFile: entry.CertFile,
+			KeyFile:  entry.KeyFile,
+			CAFile:   entry.CAFile,
+		})
+	}
+	if err := file.WriteFile(home.RepositoryFile(), 0644); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return home, cleanup, nil
+}
+
+// getters return the getters of the HTTP and HTTPS schemes, the downloader
+// plugins of Helm 2 aren't supported
+func getters() getter.Providers {
+	return getter.Providers{{
+		Schemes: []string{"http", "https"},
+		New: func(URL, CertFile, KeyFile, CAFile string) (getter.Getter, error) {
+			return getter.NewHTTPGetter(URL, CertFile, KeyFile, CAFile)
+		},
+	}}
+}
+
+// httpOptions are the credentials and TLS settings of a download, given by
+// the flags or by the repositories.yaml file
+type httpOptions struct {
+	username              string
+	password              string
+	certFile              string
+	keyFile               string
+	caFile                string
+	insecureSkipTLSVerify bool
+}
+
+// client return an HTTP client using the TLS settings
+func (o httpOptions) client() (*http.Client, error) {
+	tlsConfig, err := tlsutil.NewClientTLS(o.certFile, o.keyFile, o.caFile)
+	if err != nil {
+		return nil, fmt.Errorf("can't create TLS config for client: %v", err)
+	}
+	tlsConfig.InsecureSkipVerify = o.insecureSkipTLSVerify
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
+
+// get download a file, the credentials are sent with basic auth
+func (o httpOptions) get(u string) ([]byte, error) {
+	client, err := o.client()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if o.username != "" || o.password != "" {
+		req.SetBasicAuth(o.username, o.password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s : %s", u, resp.Status)
+	}
+
+	var buffer bytes.Buffer
+	_, err = io.Copy(&buffer, resp.Body)
+	return buffer.Bytes(), err
+}
+
+// locateRepositoryChart download a chart of a repository of the Helm 3
+// repositories.yaml file, ie: stable/mongodb. The credentials and TLS
+// settings of the repository are used unless they are given by the flags.
+// found is false if the repository isn't in repositories.yaml.
+func (h *Helm) locateRepositoryChart(name, version string, verify bool, keyring string, flags httpOptions) (
+	string, bool, error) {
+
+	repoName, chartName, ok := strings.Cut(name, "/")
+	if !ok || strings.Contains(name, "://") {
+		return "", false, nil
+	}
+	entry, err := h.helm3.repository(repoName)
+	if err != nil || entry == nil {
+		return "", false, err
+	}
+	glog.V(8).Infof("Found repository %s in %s", repoName, h.helm3.repositoryConfig)
+
+	indexFile := filepath.Join(h.helm3.repositoryCache, entry.Name+"-index.yaml")
+	index, err := repo.LoadIndexFile(indexFile)
+	if err != nil {
+		return "", true, fmt.Errorf("no cached repo found. (try 'helm repo update'). %s", err)
+	}
+	chartVersion, err := index.Get(chartName, version)
+	if err != nil {
+		return "", true, fmt.Errorf("chart %q matching version %q not found in %s index. (try 'helm repo update'). %s",
+			chartName, version, entry.Name, err)
+	}
+	if len(chartVersion.URLs) == 0 {
+		return "", true, fmt.Errorf("chart %q has no downloadable URLs", name)
+	}
+
+	repoURL, err := url.Parse(entry.URL)
+	if err != nil {
+		return "", true, fmt.Errorf("invalid repository URL %s: %v", entry.URL, err)
+	}
+	chartURL, err := url.Parse(chartVersion.URLs[0])
+	if err != nil {
+		return "", true, fmt.Errorf("invalid chart URL format: %s", chartVersion.URLs[0])
+	}
+	if !chartURL.IsAbs() {
+		// a trailing slash is needed to resolve the chart relatively to the
+		// repository
+		base := *repoURL
+		base.Path = strings.TrimSuffix(base.Path, "/") + "/"
+		chartURL = base.ResolveReference(chartURL)
+		chartURL.RawQuery = repoURL.RawQuery
+	}
+
+	options := httpOptions{
+		username:              entry.Username,
+		password:              entry.Password,
+		certFile:              entry.CertFile,
+		keyFile:               entry.KeyFile,
+		caFile:                entry.CAFile,
+		insecureSkipTLSVerify: entry.InsecureSkipTLSVerify,
+	}
+	if flags.username != "" || flags.password != "" {
+		options.username, options.password = flags.username, flags.password
+	}
+	if flags.certFile != "" || flags.keyFile != "" {
+		options.certFile, options.keyFile = flags.certFile, flags.keyFile
+	}
+	if flags.caFile != "" {
+		options.caFile = flags.caFile
+	}
+	// like Helm 3, the credentials aren't sent to the other hosts
+	if chartURL.Host != repoURL.Host && !entry.PassCredentialsAll {
+		options.username, options.password = "", ""
+	}
+
+	glog.V(8).Infof("Downloading chart '%s' version '%s' from %s", name, chartVersion.Version, chartURL)
+	filename, err := h.downloadChart(name, chartURL, verify, keyring, options)
+	return filename, true, err
+}
+
+// downloadChart download a chart and its provenance if verify is true to the
+// Helm 3 repository cache, and return the path of the archive
+func (h *Helm) downloadChart(name string, chartURL *url.URL, verify bool, keyring string, options httpOptions) (
+	string, error) {
+
+	if err := os.MkdirAll(h.helm3.repositoryCache, 0755); err != nil {
+		return "", fmt.Errorf("unable to create %s: %v", h.helm3.repositoryCache, err)
+	}
+
+	data, err := options.get(chartURL.String())
+	if err != nil {
+		return "", err
+	}
+	filename := filepath.Join(h.helm3.repositoryCache, filepath.Base(chartURL.Path))
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		return "", err
+	}
+
+	if verify {
+		provenance, err := options.get(chartURL.String() + ".prov")
+		if err != nil {
+			return "", fmt.Errorf("Failed to fetch provenance %q", chartURL.String()+".prov")
+		}
+		if err := os.WriteFile(filename+".prov", provenance, 0644); err != nil {
+			return "", err
+		}
+		if _, err := downloader.VerifyChart(filename, keyring); err != nil {
+			return "", err
+		}
+	}
+	glog.V(4).Infof("Fetched %s to %s\n", name, filename)
+
+	return filename, nil
+}
@@ -0,0 +1,313 @@
+package helm
+
+import (
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+func TestNewHelm3Config(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the default directories are platform specific")
+	}
+
+	testCases := []struct {
+		name     string
+		env      map[string]string
+		expected helm3Config
+	}{
+		{
+			name: "defaults",
+			env:  map[string]string{"HOME": "/home/user"},
+			expected: helm3Config{
+				repositoryConfig: "/home/user/.config/helm/repositories.yaml",
+				repositoryCache:  "/home/user/.cache/helm/repository",
+			},
+		},
+		{
+			name: "XDG base directories",
+			env:  map[string]string{"HOME": "/home/user", "XDG_CONFIG_HOME": "/xdg/config", "XDG_CACHE_HOME": "/xdg/cache"},
+			expected: helm3Config{
+				repositoryConfig: "/xdg/config/helm/repositories.yaml",
+				repositoryCache:  "/xdg/cache/helm/repository",
+			},
+		},
+		{
+			name: "Helm homes",
+			env: map[string]string{
+				"XDG_CONFIG_HOME":  "/xdg/config",
+				"HELM_CONFIG_HOME": "/helm/config",
+				"HELM_CACHE_HOME":  "/helm/cache",
+			},
+			expected: helm3Config{
+				repositoryConfig: "/helm/config/repositories.yaml",
+				repositoryCache:  "/helm/cache/repository",
+			},
+		},
+		{
+			name: "Helm paths",
+			env: map[string]string{
+				"HELM_CONFIG_HOME":       "/helm/config",
+				"HELM_REPOSITORY_CONFIG": "/plugin/repositories.yaml",
+				"HELM_REPOSITORY_CACHE":  "/plugin/cache",
+			},
+			expected: helm3Config{
+				repositoryConfig: "/plugin/repositories.yaml",
+				repositoryCache:  "/plugin/cache",
+			},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			config := newHelm3Config(func(key string) string { return test.env[key] })
+			if diff := pretty.Compare(config, test.expected); diff != "" {
+				t.Errorf("diff: (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+// writeServerCA write the certificate of a test server to a file
+func writeServerCA(t *testing.T, server *httptest.Server) string {
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, data, 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return caFile
+}
+
+// saveTestChart return the archive of a chart named app
+func saveTestChart(t *testing.T, version string) []byte {
+	filename, err := chartutil.Save(&chart.Chart{
+		Metadata: &chart.Metadata{Name: "app", Version: version},
+		Values:   &chart.Config{Raw: "replicaCount: 1\n"},
+	}, t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return data
+}
+
+// testRepositoryIndex is the index of the test repository
+const testRepositoryIndex = `apiVersion: v1
+entries:
+  app:
+  - name: app
+    version: 0.2.0
+    urls:
+    - app-0.2.0.tgz
+  - name: app
+    version: 0.1.0
+    urls:
+    - app-0.1.0.tgz
+`
+
+// testRepository return the handler of a repository serving the version 0.2.0
+// of a chart named app under /charts to the user user with the password secret
+func testRepository(t *testing.T) http.Handler {
+	archive := saveTestChart(t, "0.2.0")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/charts/app-0.2.0.tgz":
+			w.Write(archive)
+		case "/charts/index.yaml":
+			w.Write([]byte(testRepositoryIndex))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+// writeTestFiles write the given files, indexed by path
+func writeTestFiles(t *testing.T, files map[string]string) {
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestLocateChartPathRepositories(t *testing.T) {
+	server := httptest.NewTLSServer(testRepository(t))
+	defer server.Close()
+	caFile := writeServerCA(t, server)
+
+	dir := t.TempDir()
+	config := helm3Config{
+		repositoryConfig: filepath.Join(dir, "config", "repositories.yaml"),
+		repositoryCache:  filepath.Join(dir, "cache", "repository"),
+	}
+	files := map[string]string{
+		config.repositoryConfig: `apiVersion: ""
+generated: "0001-01-01T00:00:00Z"
+repositories:
+- name: private
+  url: ` + server.URL + `/charts
+  username: user
+  password: secret
+  caFile: ` + caFile + `
+  insecure_skip_tls_verify: false
+  pass_credentials_all: false
+- name: nocreds
+  url: ` + server.URL + `/charts
+  caFile: ` + caFile + `
+`,
+		filepath.Join(config.repositoryCache, "private-index.yaml"): testRepositoryIndex,
+		filepath.Join(config.repositoryCache, "nocreds-index.yaml"): testRepositoryIndex,
+	}
+	writeTestFiles(t, files)
+
+	testCases := []struct {
+		name     string
+		repoURL  string
+		chart    string
+		version  string
+		username string
+		password string
+		caFile   string
+		err      string
+	}{
+		{
+			name:  "latest version with the credentials and CA of the repository",
+			chart: "private/app",
+		},
+		{
+			name:     "credentials given by the flags",
+			chart:    "nocreds/app",
+			version:  "^0.2",
+			username: "user",
+			password: "secret",
+		},
+		{
+			name:  "missing credentials",
+			chart: "nocreds/app",
+			err:   "401 Unauthorized",
+		},
+		{
+			name:    "missing version",
+			chart:   "private/app",
+			version: "1.0.0",
+			err:     `chart "app" matching version "1.0.0" not found in private index`,
+		},
+		{
+			name:     "repository given by the flags",
+			repoURL:  server.URL + "/charts",
+			chart:    "app",
+			username: "user",
+			password: "secret",
+			caFile:   caFile,
+		},
+		{
+			name:     "chart URL",
+			chart:    server.URL + "/charts/app-0.2.0.tgz",
+			username: "user",
+			password: "secret",
+			caFile:   caFile,
+		},
+		{
+			name:  "unknown repository",
+			chart: "public/app",
+			err:   `chart "public/app" not found, the repository isn't in ` + config.repositoryConfig,
+		},
+		{
+			name:  "unsupported scheme",
+			chart: "oci://ghcr.io/org/charts/app",
+			err:   "the oci scheme isn't supported",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			h := NewHelm(nil)
+			h.helm3 = config
+
+			filename, err := h.LocateChartPath(test.repoURL, test.username, test.password, test.chart, test.version,
+				false, "", "", "", test.caFile)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if expected := filepath.Join(config.repositoryCache, "app-0.2.0.tgz"); filename != expected {
+				t.Errorf("expected %s, got %s", expected, filename)
+			}
+			c, err := chartutil.Load(filename)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if c.Metadata.Version != "0.2.0" {
+				t.Errorf("expected version 0.2.0, got %s", c.Metadata.Version)
+			}
+		})
+	}
+}
+
+func TestLoadChartDependencyUpdate(t *testing.T) {
+	// the dependency manager of Helm 2 only use the TLS settings of a
+	// repository for the absolute chart URLs of its index
+	server := httptest.NewServer(testRepository(t))
+	defer server.Close()
+
+	dir := t.TempDir()
+	config := helm3Config{
+		repositoryConfig: filepath.Join(dir, "config", "repositories.yaml"),
+		repositoryCache:  filepath.Join(dir, "cache", "repository"),
+	}
+	chartPath := filepath.Join(dir, "parent")
+	writeTestFiles(t, map[string]string{
+		config.repositoryConfig: `repositories:
+- name: private
+  url: ` + server.URL + `/charts
+  username: user
+  password: secret
+`,
+		filepath.Join(chartPath, "Chart.yaml"): "name: parent\nversion: 0.1.0\n",
+		filepath.Join(chartPath, "requirements.yaml"): `dependencies:
+- name: app
+  version: ^0.2
+  repository: "@private"
+`,
+	})
+
+	h := NewHelm(io.Discard)
+	h.helm3 = config
+	c, err := h.LoadChart(&LoadChartConfig{Chart: chartPath, DepUp: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(c.Dependencies) != 1 || c.Dependencies[0].Metadata.Version != "0.2.0" {
+		t.Errorf("expected the version 0.2.0 of app as dependency, got %v", c.Dependencies)
+	}
+	if _, err := os.Stat(filepath.Join(config.repositoryCache, "private-index.yaml")); err != nil {
+		t.Errorf("expected the index to be written to the Helm 3 repository cache: %v", err)
+	}
+}
@@ -0,0 +1,599 @@
+package helm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"github.com/layertwo/helm-convert/pkg/types"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/engine"
+	"k8s.io/helm/pkg/manifest"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/renderutil"
+	tversion "k8s.io/helm/pkg/version"
+)
+
+// perturbedValue is the value given to perturbed strings and empty values
+const perturbedValue = "hcperturbed"
+
+// renderCache keep the renders of a chart indexed by the digest of the values
+// and release, so that the baseline render shared by the analyses is only done
+// once. The perturbed renders are not cached.
+type renderCache struct {
+	mu      sync.Mutex
+	renders map[string]*cachedRender
+}
+
+// cachedRender is a render in progress or done, done is closed once the
+// manifests or the error are set
+type cachedRender struct {
+	done      chan struct{}
+	manifests []manifest.Manifest
+	err       error
+}
+
+// newRenderCache constructs a renderCache
+func newRenderCache() *renderCache {
+	return &renderCache{renders: make(map[string]*cachedRender)}
+}
+
+// get return the render of a key, render is called if the key isn't cached
+// yet. Concurrent calls for the same key wait for the first one.
+func (c *renderCache) get(key string, render func() ([]manifest.Manifest, error)) ([]manifest.Manifest, error) {
+	c.mu.Lock()
+	cached, found := c.renders[key]
+	if !found {
+		cached = &cachedRender{done: make(chan struct{})}
+		c.renders[key] = cached
+	}
+	c.mu.Unlock()
+
+	if found {
+		<-cached.done
+		return cached.manifests, cached.err
+	}
+
+	cached.manifests, cached.err = render()
+	close(cached.done)
+	return cached.manifests, cached.err
+}
+
+// valueLeaf is a leaf of the values of a chart, list elements are written as
+// [index] in the path
+type valueLeaf struct {
+	path  []string
+	value interface{}
+}
+
+// String return the path of the leaf, ie: tolerations[0].key
+func (l valueLeaf) String() string {
+	return types.ResourceField{Path: l.path}.String()
+}
+
+// copyChart return a copy of a chart and its dependencies, the processing of
+// the requirements replace the dependencies and values of a chart while the
+// templates and files are left untouched and shared
+func copyChart(c *chart.Chart) *chart.Chart {
+	output := &chart.Chart{
+		Metadata:  c.Metadata,
+		Templates: c.Templates,
+		Files:     c.Files,
+	}
+	if c.Values != nil {
+		output.Values = &chart.Config{Raw: c.Values.Raw, Values: c.Values.Values}
+	}
+	for _, dependency := range c.Dependencies {
+		output.Dependencies = append(output.Dependencies, copyChart(dependency))
+	}
+	return output
+}
+
+// renderValues render a chart with the given coalesced values like render,
+// renders are cached and only used for the renders shared by the analyses
+func (h *Helm) renderValues(c *chart.Chart, values map[string]interface{}, opts renderutil.Options) (
+	[]manifest.Manifest, error) {
+
+	raw, err := yaml.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.New()
+	fmt.Fprintf(digest, "%p\x00%s\x00%s\x00%s\x00", c, opts.ReleaseOptions.Name,
+		opts.ReleaseOptions.Namespace, opts.KubeVersion)
+	digest.Write(raw)
+	key := hex.EncodeToString(digest.Sum(nil))
+
+	return h.cache.get(key, func() ([]manifest.Manifest, error) {
+		return h.render(c, values, opts)
+	})
+}
+
+// render a chart with the given coalesced values, the chart is copied as
+// processing the requirements modifies the dependencies of a chart. Unlike
+// renderutil.Render, the values are not coalesced again with the Helm 2
+// semantics and renders can run concurrently.
+func (h *Helm) render(c *chart.Chart, values map[string]interface{}, opts renderutil.Options) (
+	[]manifest.Manifest, error) {
+
+	if req, err := chartutil.LoadRequirements(c); err == nil {
+		if err := renderutil.CheckDependencies(c, req); err != nil {
+			return nil, err
+		}
+	} else if err != chartutil.ErrRequirementsNotFound {
+		return nil, fmt.Errorf("cannot load requirements: %v", err)
+	}
+
+	raw, err := yaml.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	chartCopy := copyChart(c)
+	config := &chart.Config{Raw: string(raw), Values: map[string]*chart.Value{}}
+	if err := chartutil.ProcessRequirementsEnabled(chartCopy, config); err != nil {
+		return nil, err
+	}
+
+	// renderutil.Render updates the default capabilities, a copy is used
+	kubeVersion := *chartutil.DefaultKubeVersion
+	if opts.KubeVersion != "" {
+		major, minor, err := parseKubeVersion(opts.KubeVersion)
+		if err != nil {
+			return nil, err
+		}
+		kubeVersion.Major, kubeVersion.Minor = strconv.Itoa(major), strconv.Itoa(minor)
+		kubeVersion.GitVersion = fmt.Sprintf("v%d.%d.0", major, minor)
+	}
+	caps := &chartutil.Capabilities{
+		APIVersions:   chartutil.DefaultVersionSet,
+		KubeVersion:   &kubeVersion,
+		TillerVersion: tversion.GetVersionProto(),
+	}
+	if len(opts.APIVersions) > 0 {
+		caps.APIVersions = chartutil.NewVersionSet(append(opts.APIVersions, "v1")...)
+	}
+
+	// the values given to the templates, see chartutil.ToRenderValuesCaps
+	vals := chartutil.Values{
+		"Release": map[string]interface{}{
+			"Name":      opts.ReleaseOptions.Name,
+			"Time":      opts.ReleaseOptions.Time,
+			"Namespace": opts.ReleaseOptions.Namespace,
+			"IsUpgrade": opts.ReleaseOptions.IsUpgrade,
+			"IsInstall": opts.ReleaseOptions.IsInstall,
+			"Revision":  opts.ReleaseOptions.Revision,
+			"Service":   "Tiller",
+		},
+		"Chart":        chartCopy.Metadata,
+		"Files":        chartutil.NewFiles(chartCopy.Files),
+		"Capabilities": caps,
+		"Values":       chartutil.Values(copyValues(values).(map[string]interface{})),
+	}
+
+	renderedTemplates, err := engine.New().Render(chartCopy, vals)
+	if err != nil {
+		return nil, err
+	}
+	return manifest.SplitManifests(renderedTemplates), nil
+}
+
+// parseKubeVersion return the major and minor version of a Kubernetes
+// version, ie: 1.16 or v1.16.3
+func parseKubeVersion(version string) (int, int, error) {
+	fields := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	numbers := make([]int, 2)
+	for i := 0; i < len(fields) && i < 2; i++ {
+		field, _, _ := strings.Cut(fields[i], "-")
+		number, err := strconv.Atoi(field)
+		if err != nil {
+			return 0, 0, fmt.Errorf("could not parse a kubernetes version: %s", version)
+		}
+		numbers[i] = number
+	}
+	return numbers[0], numbers[1], nil
+}
+
+// valueMap perturb each leaf of the coalesced values of a chart, render the
+// chart again and record the fields which changed
+func (h *Helm) valueMap(c *chart.Chart, rawVals []byte, opts renderutil.Options, concurrency int,
+	nonDeterministic []types.ResourceField) (types.ValueMap, error) {
+
+	values, err := coalesceValues(c, rawVals)
+	if err != nil {
+		return nil, err
+	}
+
+	valueMap, _, err := h.perturbValues(c, rawVals, values, valueLeaves(values, nil), opts, concurrency,
+		nonDeterministic)
+	return valueMap, err
+}
+
+// unusedValues return the values supplied by the user whose perturbation
+// doesn't change the rendered manifests, ie: a misspelled key. Values whose
+// perturbation fails the render are read by a template and considered used.
+// Values deleted with null are ignored. The values found in the given value
+// map are used and not perturbed again.
+func (h *Helm) unusedValues(c *chart.Chart, rawVals []byte, opts renderutil.Options, concurrency int,
+	nonDeterministic []types.ResourceField, valueMap types.ValueMap) ([]string, error) {
+
+	values, err := coalesceValues(c, rawVals)
+	if err != nil {
+		return nil, err
+	}
+	userValues, err := chartutil.ReadValues(rawVals)
+	if err != nil {
+		return nil, err
+	}
+
+	var leaves []valueLeaf
+	for _, leaf := range valueLeaves(userValues, nil) {
+		if _, found := valueMap[leaf.String()]; found {
+			continue
+		}
+		if value, found := lookupValue(values, leaf.path); found {
+			leaves = append(leaves, valueLeaf{leaf.path, value})
+		}
+	}
+
+	perturbedMap, failed, err := h.perturbValues(c, rawVals, values, leaves, opts, concurrency, nonDeterministic)
+	if err != nil {
+		return nil, err
+	}
+
+	var unused []string
+	for _, leaf := range leaves {
+		key := leaf.String()
+		if _, found := perturbedMap[key]; found {
+			continue
+		}
+		if _, found := failed[key]; found {
+			continue
+		}
+		unused = append(unused, key)
+	}
+	return unused, nil
+}
+
+// unknownValues return the values supplied by the user which are not defined
+// in the default values of the chart or its subcharts. Keys of empty maps,
+// lists and null defaults are free-form (ie: nodeSelector: {}).
+func unknownValues(c *chart.Chart, rawVals []byte) ([]string, error) {
+	// the subcharts disabled by the user values are kept
+	chartCopy := copyChart(c)
+	if err := importChartValues(chartCopy); err != nil {
+		return nil, err
+	}
+	defaults, err := coalesceChartValues(chartCopy, nil)
+	if err != nil {
+		return nil, err
+	}
+	userValues, err := chartutil.ReadValues(rawVals)
+	if err != nil {
+		return nil, err
+	}
+
+	var unknown []string
+	for _, leaf := range valueLeaves(userValues, nil) {
+		known := isDefaultValue(defaults, leaf.path)
+		// globals may be defined by the subcharts reading them
+		if !known && leaf.path[0] == chartutil.GlobalKey {
+			for _, dependency := range c.Dependencies {
+				if isDefaultValue(defaults[dependency.Metadata.Name], leaf.path) {
+					known = true
+					break
+				}
+			}
+		}
+		if !known {
+			unknown = append(unknown, leaf.String())
+		}
+	}
+	return unknown, nil
+}
+
+// coalesceValues return the values supplied by the user merged with the
+// default values of a chart and its enabled subcharts, with the values
+// imported from the subcharts
+func coalesceValues(c *chart.Chart, rawVals []byte) (map[string]interface{}, error) {
+	userValues, err := chartutil.ReadValues(rawVals)
+	if err != nil {
+		return nil, err
+	}
+
+	chartCopy, err := enabledChart(c, rawVals)
+	if err != nil {
+		return nil, err
+	}
+	if err := importChartValues(chartCopy); err != nil {
+		return nil, err
+	}
+	return coalesceChartValues(chartCopy, userValues)
+}
+
+// enabledChart return a copy of a chart whose subcharts are processed like
+// Helm: the disabled subcharts are removed and the aliased ones renamed
+func enabledChart(c *chart.Chart, rawVals []byte) (*chart.Chart, error) {
+	chartCopy := copyChart(c)
+	config := &chart.Config{Raw: string(rawVals), Values: map[string]*chart.Value{}}
+	if err := chartutil.ProcessRequirementsEnabled(chartCopy, config); err != nil {
+		return nil, err
+	}
+	return chartCopy, nil
+}
+
+// perturbValues perturb the given leaves of the coalesced values of a chart,
+// render the chart again and record the fields which changed. The leaves are
+// set in the values supplied by the user which are coalesced again, so that
+// the globals reach the subcharts. Renders run concurrently. Leaves whose
+// perturbation fails the render (ie: a number parsed by the template) are
+// returned apart, the non-deterministic fields are ignored.
+func (h *Helm) perturbValues(c *chart.Chart, rawVals []byte, values map[string]interface{}, leaves []valueLeaf,
+	opts renderutil.Options, concurrency int, nonDeterministic []types.ResourceField) (
+	types.ValueMap, map[string]struct{}, error) {
+
+	userValues, err := chartutil.ReadValues(rawVals)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	baseline, err := h.renderValues(c, values, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	baselineResources, err := parseManifests(baseline)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ignored := make(map[string]struct{}, len(nonDeterministic))
+	for _, field := range nonDeterministic {
+		ignored[types.ResourceKey(field.Kind, field.Namespace, field.Name)+"|"+field.String()] = struct{}{}
+	}
+
+	glog.V(8).Infof("Perturbing %d values to map them to the rendered fields", len(leaves))
+
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+
+	fields := make([][]types.ResourceField, len(leaves))
+	failed := make([]bool, len(leaves))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				leaf := leaves[i]
+				raw, err := yaml.Marshal(setValueLeaf(userValues, values, leaf.path, perturbValue(leaf.value)))
+				if err != nil {
+					glog.V(8).Infof("Skipping the value '%s', the perturbed values can't be written: %v", leaf, err)
+					failed[i] = true
+					continue
+				}
+				perturbed, err := coalesceValues(c, raw)
+				if err != nil {
+					glog.V(8).Infof("Skipping the value '%s', the perturbed values can't be coalesced: %v", leaf, err)
+					failed[i] = true
+					continue
+				}
+
+				rendered, err := h.render(c, perturbed, opts)
+				if err != nil {
+					glog.V(8).Infof("Skipping the value '%s', the chart can't be rendered once perturbed: %v",
+						leaf, err)
+					failed[i] = true
+					continue
+				}
+				renderedResources, err := parseManifests(rendered)
+				if err != nil {
+					glog.V(8).Infof("Skipping the value '%s', the perturbed render can't be parsed: %v", leaf, err)
+					failed[i] = true
+					continue
+				}
+
+				diffParsedResources(baselineResources, renderedResources,
+					func(field types.ResourceField, _, _ interface{}) {
+						key := types.ResourceKey(field.Kind, field.Namespace, field.Name) + "|" + field.String()
+						if _, found := ignored[key]; !found {
+							fields[i] = append(fields[i], field)
+						}
+					})
+			}
+		}()
+	}
+	for i := range leaves {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	valueMap := make(types.ValueMap)
+	failedLeaves := make(map[string]struct{})
+	for i, leaf := range leaves {
+		if len(fields[i]) > 0 {
+			valueMap[leaf.String()] = fields[i]
+		}
+		if failed[i] {
+			failedLeaves[leaf.String()] = struct{}{}
+		}
+	}
+	return valueMap, failedLeaves, nil
+}
+
+// valueLeaves return the leaves of values in alphabetical order, empty maps
+// and lists are leaves
+func valueLeaves(value interface{}, path []string) (leaves []valueLeaf) {
+	child := func(field string) []string {
+		return append(path[:len(path):len(path)], field)
+	}
+
+	switch typedV := value.(type) {
+	case chartutil.Values:
+		return valueLeaves(map[string]interface{}(typedV), path)
+	case map[string]interface{}:
+		if len(typedV) == 0 && len(path) > 0 {
+			return []valueLeaf{{path, value}}
+		}
+		keys := make([]string, 0, len(typedV))
+		for key := range typedV {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			leaves = append(leaves, valueLeaves(typedV[key], child(key))...)
+		}
+	case []interface{}:
+		if len(typedV) == 0 {
+			return []valueLeaf{{path, value}}
+		}
+		for i, item := range typedV {
+			leaves = append(leaves, valueLeaves(item, child("["+strconv.Itoa(i)+"]"))...)
+		}
+	default:
+		leaves = append(leaves, valueLeaf{path, value})
+	}
+	return
+}
+
+// perturbValue return a value of the same type different from the given one,
+// empty maps and lists get an element
+func perturbValue(value interface{}) interface{} {
+	switch typedV := value.(type) {
+	case bool:
+		return !typedV
+	case float64:
+		return typedV + 1
+	case int64:
+		return typedV + 1
+	case int:
+		return typedV + 1
+	case string:
+		if typedV == "" {
+			return perturbedValue
+		}
+		return typedV + "-" + perturbedValue
+	case map[string]interface{}, chartutil.Values:
+		return map[string]interface{}{perturbedValue: perturbedValue}
+	case []interface{}:
+		return []interface{}{perturbedValue}
+	}
+	return perturbedValue
+}
+
+// setValueLeaf return a copy of values where the leaf at the given path is
+// replaced, the values are left untouched. The maps missing from values are
+// created and the lists are copied from defaults, ie: the coalesced values.
+func setValueLeaf(values, defaults interface{}, path []string, leaf interface{}) map[string]interface{} {
+	var set func(value, defaultValue interface{}, path []string) interface{}
+	set = func(value, defaultValue interface{}, path []string) interface{} {
+		if len(path) == 0 {
+			return leaf
+		}
+		if typedV, ok := value.(chartutil.Values); ok {
+			value = map[string]interface{}(typedV)
+		}
+		if typedV, ok := defaultValue.(chartutil.Values); ok {
+			defaultValue = map[string]interface{}(typedV)
+		}
+
+		if strings.HasPrefix(path[0], "[") {
+			list, ok := value.([]interface{})
+			defaultList, _ := defaultValue.([]interface{})
+			if !ok {
+				list = defaultList
+			}
+			i, _ := strconv.Atoi(strings.Trim(path[0], "[]"))
+			if i < 0 || i >= len(list) {
+				return value
+			}
+			output := make([]interface{}, len(list))
+			copy(output, list)
+			var defaultItem interface{}
+			if i < len(defaultList) {
+				defaultItem = defaultList[i]
+			}
+			output[i] = set(list[i], defaultItem, path[1:])
+			return output
+		}
+
+		typedV, _ := value.(map[string]interface{})
+		defaultMap, _ := defaultValue.(map[string]interface{})
+		output := make(map[string]interface{}, len(typedV)+1)
+		for k, v := range typedV {
+			output[k] = v
+		}
+		output[path[0]] = set(typedV[path[0]], defaultMap[path[0]], path[1:])
+		return output
+	}
+	output, _ := set(values, defaults, path).(map[string]interface{})
+	return output
+}
+
+// lookupValue return the value found at the given path of values
+func lookupValue(values interface{}, path []string) (interface{}, bool) {
+	current := values
+	for _, field := range path {
+		switch typedV := current.(type) {
+		case chartutil.Values:
+			child, found := typedV[field]
+			if !found {
+				return nil, false
+			}
+			current = child
+		case map[string]interface{}:
+			child, found := typedV[field]
+			if !found {
+				return nil, false
+			}
+			current = child
+		case []interface{}:
+			i, err := strconv.Atoi(strings.Trim(field, "[]"))
+			if err != nil || i < 0 || i >= len(typedV) {
+				return nil, false
+			}
+			current = typedV[i]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// isDefaultValue return true if the given path is defined by the default
+// values, the keys of empty maps, lists and null values are free-form
+func isDefaultValue(defaults interface{}, path []string) bool {
+	current := defaults
+	for _, field := range path {
+		switch typedV := current.(type) {
+		case chartutil.Values:
+			current = map[string]interface{}(typedV)
+		}
+
+		switch typedV := current.(type) {
+		case map[string]interface{}:
+			if len(typedV) == 0 {
+				return true
+			}
+			child, found := typedV[field]
+			if !found {
+				return false
+			}
+			current = child
+		case []interface{}, nil:
+			return true
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,152 @@
+package helm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"k8s.io/helm/pkg/strvals"
+)
+
+// The Helm 2 strvals package doesn't support --set-json and --set-literal.
+// The keys are still parsed by strvals, with a placeholder value replaced by
+// the actual value, so that the paths (ie: a.b[0].c, escaped dots) are read
+// the same way for all the flags.
+
+// valuePlaceholder is the value set with strvals before being replaced
+const valuePlaceholder = "\x00hcplaceholder\x00"
+
+// parseJSONInto parse a --set-json value into dest, ie:
+// tolerations=[{"key":"dedicated"}],replicaCount=3. The values are JSON
+// values, a JSON object without key is merged with dest.
+func parseJSONInto(s string, dest map[string]interface{}) error {
+	if strings.HasPrefix(strings.TrimSpace(s), "{") {
+		decoder := json.NewDecoder(strings.NewReader(s))
+		decoder.UseNumber()
+		values := map[string]interface{}{}
+		if err := decoder.Decode(&values); err != nil {
+			return fmt.Errorf("%s: %v", s, err)
+		}
+		mergeValues(dest, jsonNumbers(values).(map[string]interface{}))
+		return nil
+	}
+
+	for rest := s; rest != ""; {
+		key, raw, err := splitValueKey(rest)
+		if err != nil {
+			return err
+		}
+
+		decoder := json.NewDecoder(strings.NewReader(raw))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("missing value")
+			}
+			return fmt.Errorf("key %q: %v", key, err)
+		}
+
+		rest = strings.TrimLeft(raw[decoder.InputOffset():], " ")
+		if rest != "" {
+			if rest[0] != ',' {
+				return fmt.Errorf("key %q: unexpected %q after the JSON value", key, rest)
+			}
+			rest = rest[1:]
+		}
+
+		if err := setValueInto(key, jsonNumbers(value), dest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseLiteralInto parse a --set-literal value into dest, ie:
+// args=--a=1,--b=2. The value is the string after the first =, it isn't split
+// on commas nor unescaped.
+func parseLiteralInto(s string, dest map[string]interface{}) error {
+	key, value, err := splitValueKey(s)
+	if err != nil {
+		return err
+	}
+	return setValueInto(key, value, dest)
+}
+
+// splitValueKey return the key before the first unescaped = and the rest of s
+func splitValueKey(s string) (string, string, error) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '=':
+			if i == 0 {
+				return "", "", fmt.Errorf("key is empty in %q", s)
+			}
+			return s[:i], s[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("key %q has no value", s)
+}
+
+// setValueInto set the value of a strvals key in dest
+func setValueInto(key string, value interface{}, dest map[string]interface{}) error {
+	if err := strvals.ParseIntoString(key+"="+valuePlaceholder, dest); err != nil {
+		return err
+	}
+	if !replacePlaceholder(dest, value) {
+		return fmt.Errorf("cannot set the value of key %q", key)
+	}
+	return nil
+}
+
+// replacePlaceholder replace the placeholder set by strvals by value, return
+// false if the placeholder isn't found
+func replacePlaceholder(values interface{}, value interface{}) bool {
+	switch typedV := values.(type) {
+	case map[string]interface{}:
+		for k, v := range typedV {
+			if v == valuePlaceholder {
+				typedV[k] = value
+				return true
+			}
+			if replacePlaceholder(v, value) {
+				return true
+			}
+		}
+	case []interface{}:
+		for i, v := range typedV {
+			if v == valuePlaceholder {
+				typedV[i] = value
+				return true
+			}
+			if replacePlaceholder(v, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsonNumbers convert the JSON numbers to int64 or float64 like strvals does
+// for --set
+func jsonNumbers(value interface{}) interface{} {
+	switch typedV := value.(type) {
+	case json.Number:
+		if i, err := typedV.Int64(); err == nil {
+			return i
+		}
+		f, _ := typedV.Float64()
+		return f
+	case map[string]interface{}:
+		for k, v := range typedV {
+			typedV[k] = jsonNumbers(v)
+		}
+	case []interface{}:
+		for i, v := range typedV {
+			typedV[i] = jsonNumbers(v)
+		}
+	}
+	return value
+}
@@ -0,0 +1,190 @@
+package helm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/kylelemons/godebug/pretty"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+func TestParseJSONInto(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected map[string]interface{}
+		err      bool
+	}{
+		{
+			name:  "list of objects",
+			input: `tolerations=[{"key":"dedicated","operator":"Exists"}]`,
+			expected: map[string]interface{}{
+				"tolerations": []interface{}{
+					map[string]interface{}{"key": "dedicated", "operator": "Exists"},
+				},
+			},
+		},
+		{
+			name:  "several keys separated by commas",
+			input: `image.tag="2.0",replicaCount=3,ports=[80, 443]`,
+			expected: map[string]interface{}{
+				"image":        map[string]interface{}{"tag": "2.0"},
+				"replicaCount": int64(3),
+				"ports":        []interface{}{int64(80), int64(443)},
+			},
+		},
+		{
+			name:  "list index and escaped dot",
+			input: `nodeSelector.kubernetes\.io/os="linux",args[1]=1.5`,
+			expected: map[string]interface{}{
+				"nodeSelector": map[string]interface{}{"kubernetes.io/os": "linux"},
+				"args":         []interface{}{nil, 1.5},
+			},
+		},
+		{
+			name:     "null",
+			input:    `resources=null`,
+			expected: map[string]interface{}{"resources": nil},
+		},
+		{
+			name:  "object without key",
+			input: `{"image":{"tag":"2.0"}}`,
+			expected: map[string]interface{}{
+				"image": map[string]interface{}{"tag": "2.0"},
+			},
+		},
+		{
+			name:  "invalid JSON",
+			input: `image.tag=2.0.1`,
+			err:   true,
+		},
+		{
+			name:  "missing value",
+			input: `image.tag=`,
+			err:   true,
+		},
+		{
+			name:  "missing key",
+			input: `image.tag`,
+			err:   true,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			output := map[string]interface{}{}
+			err := parseJSONInto(test.input, output)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", output)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := pretty.Compare(output, test.expected); diff != "" {
+				t.Errorf("diff: (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseLiteralInto(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected map[string]interface{}
+		err      bool
+	}{
+		{
+			name:     "commas and equal signs are kept",
+			input:    `extraFlags=--a=1,--b=2`,
+			expected: map[string]interface{}{"extraFlags": "--a=1,--b=2"},
+		},
+		{
+			name:     "backslashes are kept",
+			input:    `config.pattern=^\d+\,\.$`,
+			expected: map[string]interface{}{"config": map[string]interface{}{"pattern": `^\d+\,\.$`}},
+		},
+		{
+			name:     "values aren't typed",
+			input:    `replicaCount=3`,
+			expected: map[string]interface{}{"replicaCount": "3"},
+		},
+		{
+			name:  "missing key",
+			input: `=value`,
+			err:   true,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			output := map[string]interface{}{}
+			err := parseLiteralInto(test.input, output)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", output)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := pretty.Compare(output, test.expected); diff != "" {
+				t.Errorf("diff: (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValsPrecedence(t *testing.T) {
+	h := NewHelm(nil)
+	c := &chart.Chart{Metadata: &chart.Metadata{Name: "app", Version: "0.1.0"}}
+
+	// --set-json < --set < --set-string < --set-literal
+	values, err := h.CoalescedVals(c, nil,
+		[]string{"a=set,b=set"},
+		[]string{"b=string,c=string"},
+		nil,
+		[]string{`a="json",d={"e":[1]}`},
+		[]string{"c=literal,with,commas"},
+		"", "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var expected map[string]interface{}
+	if err := yaml.Unmarshal([]byte("a: set\nb: string\nc: literal,with,commas\nd:\n  e: [1]\n"), &expected); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare(values.Values, expected); diff != "" {
+		t.Errorf("values diff: (-got +want)\n%s", diff)
+	}
+
+	sources := make(map[string]string, len(values.Sources))
+	for key, source := range values.Sources {
+		sources[key] = source.String()
+	}
+	expectedSources := map[string]string{
+		"a":      "--set a=set,b=set",
+		"b":      "--set-string b=string,c=string",
+		"c":      "--set-literal c=literal,with,commas",
+		"d.e[0]": `--set-json a="json",d={"e":[1]}`,
+	}
+	if diff := pretty.Compare(sources, expectedSources); diff != "" {
+		t.Errorf("sources diff: (-got +want)\n%s", diff)
+	}
+
+	for flag, args := range map[string][][]string{
+		"--set-json":    {nil, nil, nil, {"a=[1"}, nil},
+		"--set-literal": {nil, nil, nil, nil, {"novalue"}},
+	} {
+		_, err := h.Vals(nil, args[0], args[1], args[2], args[3], args[4], "", "", "")
+		if err == nil || !strings.Contains(err.Error(), "failed parsing "+flag+" data") {
+			t.Errorf("expected a %s error, got %v", flag, err)
+		}
+	}
+}
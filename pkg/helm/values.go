@@ -0,0 +1,262 @@
+package helm
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// ValueSource is where a value of a chart comes from, a values file, a flag or
+// the default values of the chart
+type ValueSource struct {
+	// File is the values file defining the value, ie: values.yaml or, for
+	// the default values, mychart/charts/redis/values.yaml
+	File string `json:"file,omitempty"`
+
+	// Line is the line of the value in File, if known
+	Line int `json:"line,omitempty"`
+
+	// Flag is the flag setting the value, ie: --set image.tag=2.0
+	Flag string `json:"flag,omitempty"`
+
+	// Default is true if the value is a default value of the chart
+	Default bool `json:"default,omitempty"`
+}
+
+// String return the source as shown to the user, ie: values.yaml:12, --set
+// image.tag=2.0 or chart default (mychart/values.yaml:3)
+func (s ValueSource) String() string {
+	if s.Flag != "" {
+		return s.Flag
+	}
+
+	location := s.File
+	if location == "-" {
+		location = "<stdin>"
+	}
+	if s.Line > 0 {
+		location = fmt.Sprintf("%s:%d", location, s.Line)
+	}
+
+	if s.Default {
+		if location == "" {
+			return "chart default"
+		}
+		return "chart default (" + location + ")"
+	}
+	return location
+}
+
+// CoalescedValues are the values used to render a chart, the values supplied
+// by the user merged with the default values of the chart and its subcharts
+type CoalescedValues struct {
+	Values map[string]interface{}
+
+	// Sources contains the source of each leaf of the values, indexed by the
+	// path of the leaf (ie: image.tag, tolerations[0].key)
+	Sources map[string]ValueSource
+}
+
+// valueLayer is a set of values merged on top of the previous ones, a values
+// file, a flag or the default values of a chart
+type valueLayer struct {
+	source ValueSource
+
+	// data is the content of a values file, the line of each value is taken
+	// from it
+	data []byte
+
+	// values are the values set by a flag
+	values map[string]interface{}
+
+	// prefix is the path of the values of a subchart
+	prefix []string
+}
+
+// sources return the source of each leaf of a layer, indexed by the path of
+// the leaf. Null values are ignored, they delete a value or are the unset
+// elements of a list set by index.
+func (l *valueLayer) sources() (map[string]ValueSource, error) {
+	sources := make(map[string]ValueSource)
+
+	if l.data == nil {
+		for _, leaf := range valueLeaves(l.values, l.prefix) {
+			if leaf.value != nil {
+				sources[leaf.String()] = l.source
+			}
+		}
+		return sources, nil
+	}
+
+	if strings.TrimSpace(string(l.data)) == "" {
+		return sources, nil
+	}
+	root, err := kyaml.Parse(string(l.data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", l.source.File, err)
+	}
+
+	var walk func(node *kyaml.Node, line int, path []string)
+	walk = func(node *kyaml.Node, line int, path []string) {
+		child := func(field string) []string {
+			return append(path[:len(path):len(path)], field)
+		}
+
+		switch node.Kind {
+		case kyaml.DocumentNode:
+			for _, content := range node.Content {
+				walk(content, content.Line, path)
+			}
+		case kyaml.MappingNode:
+			if len(node.Content) == 0 && len(path) > len(l.prefix) {
+				break
+			}
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key, value := node.Content[i], node.Content[i+1]
+				walk(value, key.Line, child(key.Value))
+			}
+			return
+		case kyaml.SequenceNode:
+			if len(node.Content) == 0 {
+				break
+			}
+			for i, item := range node.Content {
+				walk(item, item.Line, child("["+strconv.Itoa(i)+"]"))
+			}
+			return
+		case kyaml.AliasNode:
+			walk(node.Alias, line, path)
+			return
+		case kyaml.ScalarNode:
+			if node.Tag == kyaml.NodeTagNull {
+				return
+			}
+		}
+
+		source := l.source
+		source.Line = line
+		sources[valueLeaf{path: path}.String()] = source
+	}
+	walk(root.YNode(), root.YNode().Line, l.prefix)
+
+	return sources, nil
+}
+
+// CoalescedVals return the values used to render a chart with the source of
+// each value: a values file and its line, a flag or the default values of the
+// chart or one of its subcharts. The values supplied by the user are read
+// like Vals.
+func (h *Helm) CoalescedVals(c *chart.Chart, valueFiles ValueFiles, values []string, stringValues []string,
+	fileValues []string, jsonValues []string, literalValues []string, CertFile, KeyFile, CAFile string) (
+	*CoalescedValues, error) {
+
+	rawVals, layers, err := h.vals(valueFiles, values, stringValues, fileValues, jsonValues, literalValues,
+		CertFile, KeyFile, CAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	coalesced, err := coalesceValues(c, rawVals)
+	if err != nil {
+		return nil, err
+	}
+
+	// the subcharts are named after their alias in the values
+	enabled, err := enabledChart(c, rawVals)
+	if err != nil {
+		return nil, err
+	}
+
+	// the layers by precedence: the last flags and files first, then the
+	// default values of the chart before the ones of its subcharts
+	var ordered []*valueLayer
+	for i := len(layers) - 1; i >= 0; i-- {
+		ordered = append(ordered, layers[i])
+	}
+	ordered = append(ordered, defaultValueLayers(enabled, c.Metadata.Name, nil)...)
+
+	layerSources := make([]map[string]ValueSource, len(ordered))
+	for i, layer := range ordered {
+		if layerSources[i], err = layer.sources(); err != nil {
+			return nil, err
+		}
+	}
+
+	output := &CoalescedValues{Values: coalesced, Sources: make(map[string]ValueSource)}
+	for _, leaf := range valueLeaves(coalesced, nil) {
+		source := ValueSource{Default: true}
+		for _, candidate := range globalCandidates(leaf.path) {
+			found := false
+			for _, sources := range layerSources {
+				if s, ok := sources[valueLeaf{path: candidate}.String()]; ok {
+					source, found = s, true
+					break
+				}
+			}
+			if found {
+				break
+			}
+		}
+		output.Sources[leaf.String()] = source
+	}
+
+	return output, nil
+}
+
+// defaultValueLayers return the default values of a chart and its subcharts,
+// the values of a chart override the ones of its subcharts. The chart is
+// expected to be processed by enabledChart, aliased subcharts are read from
+// the directory of the chart they alias.
+func defaultValueLayers(c *chart.Chart, file string, prefix []string) []*valueLayer {
+	var layers []*valueLayer
+	if c.Values != nil && c.Values.Raw != "" {
+		layers = append(layers, &valueLayer{
+			source: ValueSource{File: path.Join(file, chartutil.ValuesfileName), Default: true},
+			data:   []byte(c.Values.Raw),
+			prefix: prefix,
+		})
+	}
+
+	aliases := make(map[string]string)
+	if requirements, err := chartutil.LoadRequirements(c); err == nil {
+		for _, requirement := range requirements.Dependencies {
+			if requirement.Alias != "" {
+				aliases[requirement.Alias] = requirement.Name
+			}
+		}
+	}
+
+	for _, dependency := range c.Dependencies {
+		name := dependency.Metadata.Name
+		dir := name
+		if chartName, ok := aliases[name]; ok {
+			dir = chartName
+		}
+		layers = append(layers, defaultValueLayers(dependency, path.Join(file, "charts", dir),
+			append(prefix[:len(prefix):len(prefix)], name))...)
+	}
+	return layers
+}
+
+// globalCandidates return the paths a value may come from, the globals of a
+// subchart are overridden by the globals of its parents, ie: redis.global.x
+// comes from global.x if it is set, from redis.global.x otherwise
+func globalCandidates(p []string) [][]string {
+	for i, field := range p {
+		if field != chartutil.GlobalKey {
+			continue
+		}
+		var candidates [][]string
+		for j := 0; j <= i; j++ {
+			candidate := append(append(append([]string{}, p[:j]...), chartutil.GlobalKey), p[i+1:]...)
+			candidates = append(candidates, candidate)
+		}
+		return candidates
+	}
+	return [][]string{p}
+}
@@ -0,0 +1,115 @@
+package helm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+func TestCoalescedVals(t *testing.T) {
+	c := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "app", Version: "0.1.0"},
+		Values: &chart.Config{Raw: `replicaCount: 1
+image:
+  repository: web
+  tag: "1.0"
+global:
+  env: prod
+redis:
+  port: 6380
+`},
+		Dependencies: []*chart.Chart{
+			{
+				Metadata: &chart.Metadata{Name: "redis", Version: "0.1.0"},
+				Values: &chart.Config{Raw: `port: 6379
+password: ""
+global:
+  env: dev
+  region: eu
+`},
+			},
+		},
+	}
+
+	valuesFile := filepath.Join(t.TempDir(), "values.yaml")
+	if err := os.WriteFile(valuesFile, []byte("# production\nimage:\n  tag: \"2.0\"\ntolerations:\n- key: dedicated\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	values, err := NewHelm(nil).CoalescedVals(c, ValueFiles{valuesFile},
+		[]string{"replicaCount=3"}, []string{"redis.password=s3cr3t"}, nil, nil, nil, "", "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sources := make(map[string]string, len(values.Sources))
+	for key, source := range values.Sources {
+		sources[key] = source.String()
+	}
+	expected := map[string]string{
+		"global.env":          "chart default (app/values.yaml:6)",
+		"image.repository":    "chart default (app/values.yaml:3)",
+		"image.tag":           valuesFile + ":3",
+		"replicaCount":        "--set replicaCount=3",
+		"redis.global.env":    "chart default (app/values.yaml:6)",
+		"redis.global.region": "chart default (app/charts/redis/values.yaml:5)",
+		"redis.password":      "--set-string redis.password=s3cr3t",
+		"redis.port":          "chart default (app/values.yaml:8)",
+		"tolerations[0].key":  valuesFile + ":5",
+	}
+
+	if diff := pretty.Compare(sources, expected); diff != "" {
+		t.Errorf("diff: (-got +want)\n%s", diff)
+	}
+}
+
+func TestCoalescedValsAlias(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"Chart.yaml":  "name: app\nversion: 0.1.0\n",
+		"values.yaml": "cache:\n  port: 6380\n",
+		"requirements.yaml": `dependencies:
+- name: redis
+  version: 0.1.0
+  alias: cache
+`,
+		"charts/redis/Chart.yaml":  "name: redis\nversion: 0.1.0\n",
+		"charts/redis/values.yaml": "port: 6379\npassword: \"\"\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	c, err := chartutil.Load(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	values, err := NewHelm(nil).CoalescedVals(c, nil, nil, nil, nil, nil, nil, "", "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sources := make(map[string]string, len(values.Sources))
+	for key, source := range values.Sources {
+		sources[key] = source.String()
+	}
+	expected := map[string]string{
+		"cache.global":   "chart default",
+		"cache.password": "chart default (app/charts/redis/values.yaml:2)",
+		"cache.port":     "chart default (app/values.yaml:2)",
+	}
+
+	if diff := pretty.Compare(sources, expected); diff != "" {
+		t.Errorf("diff: (-got +want)\n%s", diff)
+	}
+}
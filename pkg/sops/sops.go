@@ -0,0 +1,85 @@
+// Package sops encrypt generated files with SOPS
+package sops
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// DefaultBinary is the name of the sops executable looked up in $PATH
+const DefaultBinary = "sops"
+
+// Encrypter encrypt data with the sops binary. Plaintext is sent through
+// stdin and the ciphertext read from stdout so that it never touches the disk.
+type Encrypter struct {
+	binary        string
+	ageRecipients []string
+	configPath    string
+}
+
+// NewEncrypter constructs an Encrypter. If no age recipients are given, sops
+// resolve them from the creation rules of the .sops.yaml configuration file.
+func NewEncrypter(ageRecipients []string, configPath string) *Encrypter {
+	return &Encrypter{
+		binary:        DefaultBinary,
+		ageRecipients: ageRecipients,
+		configPath:    configPath,
+	}
+}
+
+// Encrypt return the encrypted content of a file. The file path is used to
+// match the .sops.yaml creation rules and to infer the file format, the same
+// way kustomize-controller and KSOPS infer it when decrypting.
+func (e *Encrypter) Encrypt(filePath string, data []byte) ([]byte, error) {
+	format := Format(filePath)
+
+	args := []string{
+		"--encrypt",
+		"--input-type", format,
+		"--output-type", format,
+		"--filename-override", filePath,
+	}
+	if e.configPath != "" {
+		args = append([]string{"--config", e.configPath}, args...)
+	}
+	if len(e.ageRecipients) > 0 {
+		args = append(args, "--age", strings.Join(e.ageRecipients, ","))
+	}
+	args = append(args, "/dev/stdin")
+
+	glog.V(4).Infof("Encrypting %s with sops as %s", filePath, format)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(e.binary, args...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("couldn't encrypt '%s' with sops: %v: %s", filePath, err,
+			strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// Format return the sops store format matching a file extension
+func Format(filePath string) string {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".env":
+		return "dotenv"
+	case ".json":
+		return "json"
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".ini":
+		return "ini"
+	default:
+		return "binary"
+	}
+}
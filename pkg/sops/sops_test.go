@@ -0,0 +1,140 @@
+package sops
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestFormat(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "it should detect env files",
+			input:    "secrets/my-secret.env",
+			expected: "dotenv",
+		},
+		{
+			name:     "it should detect yaml files",
+			input:    "my-secret-config.yml",
+			expected: "yaml",
+		},
+		{
+			name:     "it should fallback to binary",
+			input:    "my-secret-tls.crt",
+			expected: "binary",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			output := Format(test.input)
+			if output != test.expected {
+				t.Fatalf(
+					"expected: \n %v\ngot:\n %v",
+					test.expected,
+					output,
+				)
+			}
+		})
+	}
+}
+
+// installStubSOPS write a sops executable in a directory added to $PATH, it
+// record its arguments in the returned file and prefix stdin with ENC:
+func installStubSOPS(t *testing.T, script string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("the sops stub is a shell script")
+	}
+
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	stub := "#!/bin/sh\nfor arg in \"$@\"; do echo \"$arg\" >> " + argsFile + "; done\n" + script
+	if err := os.WriteFile(filepath.Join(dir, DefaultBinary), []byte(stub), 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return argsFile
+}
+
+func TestEncrypt(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		script        string
+		ageRecipients []string
+		configPath    string
+		filePath      string
+		expected      string
+		expectedArgs  []string
+		expectedErr   string
+	}{
+		{
+			name:          "it should encrypt with the given recipients",
+			script:        "printf 'ENC:'; cat\n",
+			ageRecipients: []string{"age1a", "age1b"},
+			filePath:      "out/secrets/app.env",
+			expected:      "ENC:PASSWORD=secret\n",
+			expectedArgs: []string{
+				"--encrypt",
+				"--input-type", "dotenv",
+				"--output-type", "dotenv",
+				"--filename-override", "out/secrets/app.env",
+				"--age", "age1a,age1b",
+				"/dev/stdin",
+			},
+		},
+		{
+			name:       "it should use the creation rules of the given config",
+			script:     "printf 'ENC:'; cat\n",
+			configPath: "/etc/sops.yaml",
+			filePath:   "out/secrets/tls.crt",
+			expected:   "ENC:PASSWORD=secret\n",
+			expectedArgs: []string{
+				"--config", "/etc/sops.yaml",
+				"--encrypt",
+				"--input-type", "binary",
+				"--output-type", "binary",
+				"--filename-override", "out/secrets/tls.crt",
+				"/dev/stdin",
+			},
+		},
+		{
+			name:        "it should return the error of sops",
+			script:      "echo 'no matching creation rules found' >&2; exit 1\n",
+			filePath:    "out/secrets/app.env",
+			expectedErr: "couldn't encrypt 'out/secrets/app.env' with sops: exit status 1: no matching creation rules found",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			argsFile := installStubSOPS(t, test.script)
+
+			output, err := NewEncrypter(test.ageRecipients, test.configPath).Encrypt(test.filePath,
+				[]byte("PASSWORD=secret\n"))
+			if test.expectedErr != "" {
+				if err == nil || err.Error() != test.expectedErr {
+					t.Fatalf("expected error '%s', got: %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if string(output) != test.expected {
+				t.Errorf("expected '%s', got '%s'", test.expected, output)
+			}
+			args, err := os.ReadFile(argsFile)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := pretty.Compare(strings.Split(strings.TrimSpace(string(args)), "\n"), test.expectedArgs); diff != "" {
+				t.Errorf("args diff: (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
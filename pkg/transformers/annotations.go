@@ -3,7 +3,7 @@ package transformers
 import (
 	"github.com/layertwo/helm-convert/pkg/types"
 	"github.com/layertwo/helm-convert/pkg/utils"
-	ktypes "sigs.k8s.io/kustomize/pkg/types"
+	ktypes "sigs.k8s.io/kustomize/api/types"
 )
 
 type annotationsTransformer struct {
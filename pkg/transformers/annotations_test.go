@@ -5,12 +5,12 @@ import (
 
 	"github.com/kylelemons/godebug/pretty"
 	"github.com/layertwo/helm-convert/pkg/types"
+	ktypes "sigs.k8s.io/kustomize/api/types"
 	"sigs.k8s.io/kustomize/k8sdeps/kunstruct"
 	"sigs.k8s.io/kustomize/pkg/gvk"
 	"sigs.k8s.io/kustomize/pkg/resid"
 	"sigs.k8s.io/kustomize/pkg/resmap"
 	"sigs.k8s.io/kustomize/pkg/resource"
-	ktypes "sigs.k8s.io/kustomize/pkg/types"
 )
 
 type annotationsTransformerArgs struct {
@@ -0,0 +1,516 @@
+package transformers
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/layertwo/helm-convert/pkg/types"
+	"github.com/layertwo/helm-convert/pkg/utils"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+)
+
+// DefaultKubeVersion is the Kubernetes version targeted by default
+const DefaultKubeVersion = "1.29"
+
+// RemovedAPIPolicy define what is done with a resource whose API version is
+// not served by the target Kubernetes version and can't be migrated
+type RemovedAPIPolicy string
+
+const (
+	// RemovedAPIPolicyFail stop the conversion
+	RemovedAPIPolicyFail RemovedAPIPolicy = "fail"
+
+	// RemovedAPIPolicyDrop remove the resource from the output
+	RemovedAPIPolicyDrop RemovedAPIPolicy = "drop"
+)
+
+// apiMigration describe the replacement of a deprecated API version
+type apiMigration struct {
+	apiVersion string
+	kinds      []string
+
+	// replacement API version, empty if the API is removed without replacement
+	target string
+
+	// minor versions from which the replacement is served and the deprecated
+	// version isn't served anymore
+	since   int
+	removed int
+
+	// migrate apply the field-level changes to a copy of the resource, it
+	// return the changes made or an error if the resource can't be migrated
+	migrate func(obj map[string]interface{}) ([]string, error)
+}
+
+var (
+	workloadKinds = []string{"Deployment", "StatefulSet", "DaemonSet", "ReplicaSet"}
+	rbacKinds     = []string{"Role", "ClusterRole", "RoleBinding", "ClusterRoleBinding"}
+	webhookKinds  = []string{"MutatingWebhookConfiguration", "ValidatingWebhookConfiguration"}
+)
+
+// workloadDefault is a field of a workload which was defaulted differently by
+// a deprecated API version, the field is a path relative to the spec
+type workloadDefault struct {
+	apiVersion string
+	kind       string
+	field      string
+	value      interface{}
+}
+
+// workloadDefaults is the list of defaults which changed with apps/v1, they
+// are written when the field is unset to keep the behavior of the workload.
+// math.MaxInt32 is how the API represent an unlimited history and no progress
+// deadline.
+var workloadDefaults = []workloadDefault{
+	{apiVersion: "extensions/v1beta1", kind: "Deployment", field: "revisionHistoryLimit", value: math.MaxInt32},
+	{apiVersion: "extensions/v1beta1", kind: "Deployment", field: "progressDeadlineSeconds", value: math.MaxInt32},
+	{apiVersion: "extensions/v1beta1", kind: "Deployment", field: "strategy.rollingUpdate.maxSurge", value: 1},
+	{apiVersion: "extensions/v1beta1", kind: "Deployment", field: "strategy.rollingUpdate.maxUnavailable", value: 1},
+	{apiVersion: "extensions/v1beta1", kind: "DaemonSet", field: "updateStrategy.type", value: "OnDelete"},
+	{apiVersion: "apps/v1beta1", kind: "Deployment", field: "revisionHistoryLimit", value: 2},
+	{apiVersion: "apps/v1beta1", kind: "StatefulSet", field: "updateStrategy.type", value: "OnDelete"},
+}
+
+// apiMigrations is the list of deprecated API versions known to the
+// transformer, migrations are chained (ie: extensions/v1beta1 then
+// policy/v1beta1 for PodSecurityPolicy)
+var apiMigrations = []apiMigration{
+	{apiVersion: "extensions/v1beta1", kinds: workloadKinds, target: "apps/v1", since: 9, removed: 16, migrate: migrateWorkload},
+	{apiVersion: "apps/v1beta1", kinds: workloadKinds, target: "apps/v1", since: 9, removed: 16, migrate: migrateWorkload},
+	{apiVersion: "apps/v1beta2", kinds: workloadKinds, target: "apps/v1", since: 9, removed: 16, migrate: migrateWorkload},
+	{apiVersion: "extensions/v1beta1", kinds: []string{"NetworkPolicy"}, target: "networking.k8s.io/v1", since: 7, removed: 16},
+	{apiVersion: "extensions/v1beta1", kinds: []string{"PodSecurityPolicy"}, target: "policy/v1beta1", since: 10, removed: 16},
+	{apiVersion: "extensions/v1beta1", kinds: []string{"Ingress"}, target: "networking.k8s.io/v1", since: 19, removed: 22, migrate: migrateIngress},
+	{apiVersion: "networking.k8s.io/v1beta1", kinds: []string{"Ingress"}, target: "networking.k8s.io/v1", since: 19, removed: 22, migrate: migrateIngress},
+	{apiVersion: "networking.k8s.io/v1beta1", kinds: []string{"IngressClass"}, target: "networking.k8s.io/v1", since: 19, removed: 22},
+	{apiVersion: "rbac.authorization.k8s.io/v1alpha1", kinds: rbacKinds, target: "rbac.authorization.k8s.io/v1", since: 8, removed: 22},
+	{apiVersion: "rbac.authorization.k8s.io/v1beta1", kinds: rbacKinds, target: "rbac.authorization.k8s.io/v1", since: 8, removed: 22},
+	{apiVersion: "scheduling.k8s.io/v1alpha1", kinds: []string{"PriorityClass"}, target: "scheduling.k8s.io/v1", since: 14, removed: 22},
+	{apiVersion: "scheduling.k8s.io/v1beta1", kinds: []string{"PriorityClass"}, target: "scheduling.k8s.io/v1", since: 14, removed: 22},
+	{apiVersion: "storage.k8s.io/v1beta1", kinds: []string{"StorageClass"}, target: "storage.k8s.io/v1", since: 6, removed: 22},
+	{apiVersion: "storage.k8s.io/v1beta1", kinds: []string{"CSIDriver"}, target: "storage.k8s.io/v1", since: 18, removed: 22},
+	{apiVersion: "apiextensions.k8s.io/v1beta1", kinds: []string{"CustomResourceDefinition"}, target: "apiextensions.k8s.io/v1", since: 16, removed: 22, migrate: migrateCustomResourceDefinition},
+	{apiVersion: "admissionregistration.k8s.io/v1beta1", kinds: webhookKinds, target: "admissionregistration.k8s.io/v1", since: 16, removed: 22, migrate: migrateWebhooks},
+	{apiVersion: "batch/v2alpha1", kinds: []string{"CronJob"}, target: "batch/v1", since: 21, removed: 21},
+	{apiVersion: "batch/v1beta1", kinds: []string{"CronJob"}, target: "batch/v1", since: 21, removed: 25},
+	{apiVersion: "policy/v1beta1", kinds: []string{"PodDisruptionBudget"}, target: "policy/v1", since: 21, removed: 25, migrate: migratePodDisruptionBudget},
+	{apiVersion: "policy/v1beta1", kinds: []string{"PodSecurityPolicy"}, removed: 25},
+	{apiVersion: "autoscaling/v2beta1", kinds: []string{"HorizontalPodAutoscaler"}, target: "autoscaling/v2", since: 23, removed: 25, migrate: migrateHorizontalPodAutoscaler},
+	{apiVersion: "autoscaling/v2beta2", kinds: []string{"HorizontalPodAutoscaler"}, target: "autoscaling/v2", since: 23, removed: 26},
+}
+
+type apiVersionTransformer struct {
+	kubeVersion string
+	policy      RemovedAPIPolicy
+}
+
+var _ Transformer = &apiVersionTransformer{}
+
+// NewAPIVersionTransformer constructs an apiVersionTransformer migrating
+// resources to the API versions served by the given Kubernetes version, ie:
+// 1.29
+func NewAPIVersionTransformer(kubeVersion string, policy RemovedAPIPolicy) Transformer {
+	return &apiVersionTransformer{
+		kubeVersion: kubeVersion,
+		policy:      policy,
+	}
+}
+
+// Transform rewrite the resources using a deprecated API version to the
+// version served by the target Kubernetes version. Resources which can't be
+// migrated are reported, they are dropped or the conversion fails depending
+// on the policy once their API version isn't served anymore.
+func (t *apiVersionTransformer) Transform(config *ktypes.Kustomization, resources *types.Resources) error {
+	minor, err := ParseKubeVersion(t.kubeVersion)
+	if err != nil {
+		return err
+	}
+
+	switch t.policy {
+	case RemovedAPIPolicyFail, RemovedAPIPolicyDrop:
+	default:
+		return fmt.Errorf("invalid removed API policy '%s', must be one of %s or %s",
+			t.policy, RemovedAPIPolicyFail, RemovedAPIPolicyDrop)
+	}
+
+	for _, id := range utils.SortedIds(resources.ResMap) {
+		res := resources.ResMap[id]
+		obj := res.Map()
+		kind := res.GetKind()
+
+		report := func(message string) {
+			resources.Report.Add(types.ReportEntry{
+				Transformer: "apiversion",
+				Kind:        kind,
+				Name:        res.GetName(),
+				Message:     message,
+			})
+		}
+
+		for {
+			apiVersion, _ := obj["apiVersion"].(string)
+			m := findAPIMigration(apiVersion, kind)
+			if m == nil || (m.target != "" && minor < m.since) {
+				break
+			}
+
+			var problem error
+			var changes []string
+			migrated := obj
+			if m.target == "" {
+				problem = fmt.Errorf("the API is removed without replacement")
+			} else {
+				migrated = deepCopyValue(obj).(map[string]interface{})
+				if m.migrate != nil {
+					changes, problem = m.migrate(migrated)
+				}
+			}
+
+			if problem != nil {
+				if minor < m.removed && m.target == "" {
+					report(fmt.Sprintf("%s is removed in Kubernetes 1.%d without replacement", apiVersion, m.removed))
+					break
+				}
+				if minor < m.removed {
+					report(fmt.Sprintf("%s can't be migrated to %s: %v", apiVersion, m.target, problem))
+					break
+				}
+
+				message := fmt.Sprintf("%s is not served by Kubernetes 1.%d: %v", apiVersion, minor, problem)
+				if t.policy == RemovedAPIPolicyFail {
+					return fmt.Errorf("%s '%s' can't be converted, %s", kind, res.GetName(), message)
+				}
+
+				delete(resources.ResMap, id)
+				report(fmt.Sprintf("dropped, %s", message))
+				glog.Warningf("Dropped %s '%s', %s", kind, res.GetName(), message)
+				break
+			}
+
+			migrated["apiVersion"] = m.target
+			for key := range obj {
+				delete(obj, key)
+			}
+			for key, value := range migrated {
+				obj[key] = value
+			}
+
+			report(fmt.Sprintf("migrated from %s to %s", apiVersion, m.target))
+			for _, change := range changes {
+				report(change)
+			}
+			glog.V(8).Infof("Migrated %s '%s' from %s to %s", kind, res.GetName(), apiVersion, m.target)
+		}
+
+		if newID := res.Id(); newID != id {
+			if _, found := resources.ResMap[id]; found {
+				delete(resources.ResMap, id)
+				resources.ResMap[newID] = res
+			}
+		}
+	}
+
+	return nil
+}
+
+// ParseKubeVersion return the minor version of a Kubernetes version, ie:
+// v1.29.3 or 1.29
+func ParseKubeVersion(version string) (int, error) {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(parts) < 2 || parts[0] != "1" {
+		return 0, fmt.Errorf("invalid Kubernetes version '%s', expected 1.<minor>", version)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid Kubernetes version '%s', expected 1.<minor>", version)
+	}
+	return minor, nil
+}
+
+// findAPIMigration return the migration of a deprecated API version or nil
+func findAPIMigration(apiVersion, kind string) *apiMigration {
+	for i, m := range apiMigrations {
+		if m.apiVersion != apiVersion {
+			continue
+		}
+		for _, k := range m.kinds {
+			if k == kind {
+				return &apiMigrations[i]
+			}
+		}
+	}
+	return nil
+}
+
+// migrateWorkload add the selector required by apps/v1, the deprecated API
+// defaulted it to the labels of the pod template. The fields whose default
+// changed are set to the previous default.
+func migrateWorkload(obj map[string]interface{}) (changes []string, err error) {
+	spec, _ := obj["spec"].(map[string]interface{})
+	if spec == nil {
+		return nil, fmt.Errorf("spec is missing")
+	}
+
+	for _, field := range []string{"rollbackTo", "templateGeneration"} {
+		if _, found := spec[field]; found {
+			delete(spec, field)
+			changes = append(changes, fmt.Sprintf("removed spec.%s, not supported by apps/v1", field))
+		}
+	}
+
+	apiVersion, _ := obj["apiVersion"].(string)
+	kind, _ := obj["kind"].(string)
+	for _, d := range workloadDefaults {
+		if d.apiVersion == apiVersion && d.kind == kind && setWorkloadDefault(spec, d.field, d.value) {
+			changes = append(changes, fmt.Sprintf("set spec.%s to the %s default of %v", d.field, apiVersion, d.value))
+		}
+	}
+
+	if _, found := spec["selector"]; found {
+		return changes, nil
+	}
+
+	template, _ := spec["template"].(map[string]interface{})
+	metadata, _ := template["metadata"].(map[string]interface{})
+	labels, _ := metadata["labels"].(map[string]interface{})
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("spec.selector is required and the pod template has no labels to build it from")
+	}
+
+	spec["selector"] = map[string]interface{}{
+		"matchLabels": deepCopyValue(labels),
+	}
+	changes = append(changes, "added spec.selector.matchLabels from the labels of the pod template")
+
+	return changes, nil
+}
+
+// setWorkloadDefault set a field of the spec if unset, ie:
+// strategy.rollingUpdate.maxSurge. The rolling update parameters are left
+// unset when the strategy is another type. It return true if the field is set.
+func setWorkloadDefault(spec map[string]interface{}, field string, value interface{}) bool {
+	parts := strings.Split(field, ".")
+	parent := spec
+	for i, part := range parts[:len(parts)-1] {
+		if part == "rollingUpdate" {
+			if strategyType, found := parent["type"]; found && strategyType != "RollingUpdate" {
+				return false
+			}
+		}
+
+		child, found := parent[part]
+		if !found || child == nil {
+			child = map[string]interface{}{}
+			parent[part] = child
+		}
+		next, ok := child.(map[string]interface{})
+		if !ok {
+			glog.V(4).Infof("Can't set the default of spec.%s, spec.%s isn't an object", field, strings.Join(parts[:i+1], "."))
+			return false
+		}
+		parent = next
+	}
+
+	last := parts[len(parts)-1]
+	if current, found := parent[last]; found && current != nil {
+		return false
+	}
+	parent[last] = value
+	return true
+}
+
+// migrateIngress convert the backends to the networking.k8s.io/v1 structure
+// and add the now required pathType
+func migrateIngress(obj map[string]interface{}) (changes []string, err error) {
+	spec, _ := obj["spec"].(map[string]interface{})
+	if spec == nil {
+		return nil, nil
+	}
+
+	if backend, found := spec["backend"]; found {
+		converted, err := migrateIngressBackend(backend)
+		if err != nil {
+			return nil, fmt.Errorf("spec.backend: %v", err)
+		}
+		delete(spec, "backend")
+		spec["defaultBackend"] = converted
+		changes = append(changes, "renamed spec.backend to spec.defaultBackend")
+	}
+
+	rules, _ := spec["rules"].([]interface{})
+	for i, r := range rules {
+		rule, _ := r.(map[string]interface{})
+		http, _ := rule["http"].(map[string]interface{})
+		paths, _ := http["paths"].([]interface{})
+		for j, p := range paths {
+			path, ok := p.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			fieldPath := fmt.Sprintf("spec.rules[%d].http.paths[%d]", i, j)
+
+			if backend, found := path["backend"]; found {
+				converted, err := migrateIngressBackend(backend)
+				if err != nil {
+					return nil, fmt.Errorf("%s.backend: %v", fieldPath, err)
+				}
+				path["backend"] = converted
+			}
+
+			if _, found := path["pathType"]; !found {
+				path["pathType"] = "ImplementationSpecific"
+				changes = append(changes, fmt.Sprintf("added pathType ImplementationSpecific to %s", fieldPath))
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+// migrateIngressBackend convert a serviceName and servicePort backend to a
+// service backend, resource backends are unchanged
+func migrateIngressBackend(value interface{}) (map[string]interface{}, error) {
+	backend, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid backend")
+	}
+	if _, found := backend["resource"]; found {
+		return backend, nil
+	}
+
+	name, _ := backend["serviceName"].(string)
+	if name == "" {
+		return nil, fmt.Errorf("serviceName is missing")
+	}
+
+	port := map[string]interface{}{}
+	switch typedV := backend["servicePort"].(type) {
+	case string:
+		if number, err := strconv.Atoi(typedV); err == nil {
+			port["number"] = number
+		} else {
+			port["name"] = typedV
+		}
+	case nil:
+		return nil, fmt.Errorf("servicePort is missing")
+	default:
+		port["number"] = typedV
+	}
+
+	return map[string]interface{}{
+		"service": map[string]interface{}{
+			"name": name,
+			"port": port,
+		},
+	}, nil
+}
+
+// migratePodDisruptionBudget refuse empty selectors, they select no pod with
+// policy/v1beta1 and every pod of the namespace with policy/v1
+func migratePodDisruptionBudget(obj map[string]interface{}) ([]string, error) {
+	spec, _ := obj["spec"].(map[string]interface{})
+	if selector, found := spec["selector"].(map[string]interface{}); found && len(selector) == 0 {
+		return nil, fmt.Errorf("an empty spec.selector selects every pod of the namespace with policy/v1")
+	}
+	return nil, nil
+}
+
+// migrateHorizontalPodAutoscaler convert the Resource and Pods metrics to the
+// autoscaling/v2 structure
+func migrateHorizontalPodAutoscaler(obj map[string]interface{}) (changes []string, err error) {
+	spec, _ := obj["spec"].(map[string]interface{})
+	metrics, _ := spec["metrics"].([]interface{})
+
+	for i, m := range metrics {
+		metric, _ := m.(map[string]interface{})
+		metricType, _ := metric["type"].(string)
+
+		switch metricType {
+		case "Resource":
+			source, _ := metric["resource"].(map[string]interface{})
+			target, err := migrateMetricTarget(source)
+			if err != nil {
+				return nil, fmt.Errorf("spec.metrics[%d]: %v", i, err)
+			}
+			source["target"] = target
+		case "Pods":
+			source, _ := metric["pods"].(map[string]interface{})
+			target, err := migrateMetricTarget(source)
+			if err != nil {
+				return nil, fmt.Errorf("spec.metrics[%d]: %v", i, err)
+			}
+			metricName, _ := source["metricName"].(string)
+			delete(source, "metricName")
+			source["metric"] = map[string]interface{}{"name": metricName}
+			source["target"] = target
+		default:
+			return nil, fmt.Errorf("spec.metrics[%d]: %s metrics must be migrated manually", i, metricType)
+		}
+		changes = append(changes, fmt.Sprintf("converted the target of spec.metrics[%d]", i))
+	}
+
+	return changes, nil
+}
+
+// migrateMetricTarget convert the autoscaling/v2beta1 target fields of a
+// metric source to a target
+func migrateMetricTarget(source map[string]interface{}) (map[string]interface{}, error) {
+	if source == nil {
+		return nil, fmt.Errorf("metric source is missing")
+	}
+	if utilization, found := source["targetAverageUtilization"]; found {
+		delete(source, "targetAverageUtilization")
+		return map[string]interface{}{"type": "Utilization", "averageUtilization": utilization}, nil
+	}
+	if value, found := source["targetAverageValue"]; found {
+		delete(source, "targetAverageValue")
+		return map[string]interface{}{"type": "AverageValue", "averageValue": value}, nil
+	}
+	return nil, fmt.Errorf("metric target is missing")
+}
+
+// migrateWebhooks set the fields required by admissionregistration.k8s.io/v1
+// and the fields whose default changed to their previous default, sideEffects
+// can't be guessed
+func migrateWebhooks(obj map[string]interface{}) (changes []string, err error) {
+	webhooks, _ := obj["webhooks"].([]interface{})
+	for i, w := range webhooks {
+		webhook, _ := w.(map[string]interface{})
+		if webhook == nil {
+			continue
+		}
+
+		switch sideEffects, _ := webhook["sideEffects"].(string); sideEffects {
+		case "None", "NoneOnDryRun":
+		default:
+			return nil, fmt.Errorf("webhooks[%d].sideEffects must be None or NoneOnDryRun", i)
+		}
+
+		if _, found := webhook["admissionReviewVersions"]; !found {
+			webhook["admissionReviewVersions"] = []interface{}{"v1beta1"}
+			changes = append(changes, fmt.Sprintf("set webhooks[%d].admissionReviewVersions to [v1beta1]", i))
+		}
+		if _, found := webhook["timeoutSeconds"]; !found {
+			webhook["timeoutSeconds"] = 30
+			changes = append(changes, fmt.Sprintf("set webhooks[%d].timeoutSeconds to the previous default of 30", i))
+		}
+		if _, found := webhook["failurePolicy"]; !found {
+			webhook["failurePolicy"] = "Ignore"
+			changes = append(changes, fmt.Sprintf("set webhooks[%d].failurePolicy to the previous default of Ignore", i))
+		}
+		if _, found := webhook["matchPolicy"]; !found {
+			webhook["matchPolicy"] = "Exact"
+			changes = append(changes, fmt.Sprintf("set webhooks[%d].matchPolicy to the previous default of Exact", i))
+		}
+	}
+	return changes, nil
+}
+
+// migrateCustomResourceDefinition refuse the migration, the schema and the
+// versions of apiextensions.k8s.io/v1 are structured differently
+func migrateCustomResourceDefinition(obj map[string]interface{}) ([]string, error) {
+	return nil, fmt.Errorf("the validation schema and versions must be migrated manually")
+}
@@ -0,0 +1,600 @@
+package transformers
+
+import (
+	"math"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/layertwo/helm-convert/pkg/types"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/k8sdeps/kunstruct"
+	"sigs.k8s.io/kustomize/pkg/gvk"
+	"sigs.k8s.io/kustomize/pkg/resid"
+	"sigs.k8s.io/kustomize/pkg/resmap"
+	"sigs.k8s.io/kustomize/pkg/resource"
+)
+
+type apiVersionTransformerArgs struct {
+	config    *ktypes.Kustomization
+	resources *types.Resources
+}
+
+func TestAPIVersionRun(t *testing.T) {
+	var extensionsDeploy = gvk.Gvk{Group: "extensions", Version: "v1beta1", Kind: "Deployment"}
+	var deploy = gvk.Gvk{Group: "apps", Version: "v1", Kind: "Deployment"}
+	var extensionsIngress = gvk.Gvk{Group: "extensions", Version: "v1beta1", Kind: "Ingress"}
+	var ingress = gvk.Gvk{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"}
+	var extensionsPSP = gvk.Gvk{Group: "extensions", Version: "v1beta1", Kind: "PodSecurityPolicy"}
+	var psp = gvk.Gvk{Group: "policy", Version: "v1beta1", Kind: "PodSecurityPolicy"}
+	var crd = gvk.Gvk{Group: "apiextensions.k8s.io", Version: "v1beta1", Kind: "CustomResourceDefinition"}
+	var rf = resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
+
+	newDeployment := func(apiVersion string) *resource.Resource {
+		return rf.FromMap(map[string]interface{}{
+			"apiVersion": apiVersion,
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name": "app",
+			},
+			"spec": map[string]interface{}{
+				"rollbackTo": map[string]interface{}{"revision": 1},
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{
+						"labels": map[string]interface{}{"app": "app"},
+					},
+				},
+			},
+		})
+	}
+
+	newIngress := func() *resource.Resource {
+		return rf.FromMap(map[string]interface{}{
+			"apiVersion": "extensions/v1beta1",
+			"kind":       "Ingress",
+			"metadata": map[string]interface{}{
+				"name": "app",
+			},
+			"spec": map[string]interface{}{
+				"backend": map[string]interface{}{"serviceName": "default", "servicePort": "http"},
+				"rules": []interface{}{
+					map[string]interface{}{
+						"host": "example.com",
+						"http": map[string]interface{}{
+							"paths": []interface{}{
+								map[string]interface{}{
+									"path":    "/",
+									"backend": map[string]interface{}{"serviceName": "app", "servicePort": 80},
+								},
+							},
+						},
+					},
+				},
+			},
+		})
+	}
+
+	newPodSecurityPolicy := func(apiVersion string) *resource.Resource {
+		return rf.FromMap(map[string]interface{}{
+			"apiVersion": apiVersion,
+			"kind":       "PodSecurityPolicy",
+			"metadata": map[string]interface{}{
+				"name": "restricted",
+			},
+		})
+	}
+
+	for _, test := range []struct {
+		name        string
+		kubeVersion string
+		policy      RemovedAPIPolicy
+		input       *apiVersionTransformerArgs
+		expected    *apiVersionTransformerArgs
+		expectedErr string
+	}{
+		{
+			name:        "it should migrate workloads and ingresses",
+			kubeVersion: "1.29",
+			policy:      RemovedAPIPolicyFail,
+			input: &apiVersionTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(extensionsDeploy, "app"):  newDeployment("extensions/v1beta1"),
+						resid.NewResId(extensionsIngress, "app"): newIngress(),
+					},
+				},
+			},
+			expected: &apiVersionTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "app"): rf.FromMap(map[string]interface{}{
+							"apiVersion": "apps/v1",
+							"kind":       "Deployment",
+							"metadata": map[string]interface{}{
+								"name": "app",
+							},
+							"spec": map[string]interface{}{
+								"revisionHistoryLimit":    math.MaxInt32,
+								"progressDeadlineSeconds": math.MaxInt32,
+								"strategy": map[string]interface{}{
+									"rollingUpdate": map[string]interface{}{"maxSurge": 1, "maxUnavailable": 1},
+								},
+								"selector": map[string]interface{}{
+									"matchLabels": map[string]interface{}{"app": "app"},
+								},
+								"template": map[string]interface{}{
+									"metadata": map[string]interface{}{
+										"labels": map[string]interface{}{"app": "app"},
+									},
+								},
+							},
+						}),
+						resid.NewResId(ingress, "app"): rf.FromMap(map[string]interface{}{
+							"apiVersion": "networking.k8s.io/v1",
+							"kind":       "Ingress",
+							"metadata": map[string]interface{}{
+								"name": "app",
+							},
+							"spec": map[string]interface{}{
+								"defaultBackend": map[string]interface{}{
+									"service": map[string]interface{}{
+										"name": "default",
+										"port": map[string]interface{}{"name": "http"},
+									},
+								},
+								"rules": []interface{}{
+									map[string]interface{}{
+										"host": "example.com",
+										"http": map[string]interface{}{
+											"paths": []interface{}{
+												map[string]interface{}{
+													"path":     "/",
+													"pathType": "ImplementationSpecific",
+													"backend": map[string]interface{}{
+														"service": map[string]interface{}{
+															"name": "app",
+															"port": map[string]interface{}{"number": 80},
+														},
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						}),
+					},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{Transformer: "apiversion", Kind: "Deployment", Name: "app", Message: "migrated from extensions/v1beta1 to apps/v1"},
+							{Transformer: "apiversion", Kind: "Deployment", Name: "app", Message: "removed spec.rollbackTo, not supported by apps/v1"},
+							{Transformer: "apiversion", Kind: "Deployment", Name: "app", Message: "set spec.revisionHistoryLimit to the extensions/v1beta1 default of 2147483647"},
+							{Transformer: "apiversion", Kind: "Deployment", Name: "app", Message: "set spec.progressDeadlineSeconds to the extensions/v1beta1 default of 2147483647"},
+							{Transformer: "apiversion", Kind: "Deployment", Name: "app", Message: "set spec.strategy.rollingUpdate.maxSurge to the extensions/v1beta1 default of 1"},
+							{Transformer: "apiversion", Kind: "Deployment", Name: "app", Message: "set spec.strategy.rollingUpdate.maxUnavailable to the extensions/v1beta1 default of 1"},
+							{Transformer: "apiversion", Kind: "Deployment", Name: "app", Message: "added spec.selector.matchLabels from the labels of the pod template"},
+							{Transformer: "apiversion", Kind: "Ingress", Name: "app", Message: "migrated from extensions/v1beta1 to networking.k8s.io/v1"},
+							{Transformer: "apiversion", Kind: "Ingress", Name: "app", Message: "renamed spec.backend to spec.defaultBackend"},
+							{Transformer: "apiversion", Kind: "Ingress", Name: "app", Message: "added pathType ImplementationSpecific to spec.rules[0].http.paths[0]"},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:        "it should only migrate to the versions served by the target",
+			kubeVersion: "v1.18.3",
+			policy:      RemovedAPIPolicyFail,
+			input: &apiVersionTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(extensionsIngress, "app"):    newIngress(),
+						resid.NewResId(extensionsPSP, "restricted"): newPodSecurityPolicy("extensions/v1beta1"),
+					},
+				},
+			},
+			expected: &apiVersionTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(extensionsIngress, "app"): newIngress(),
+						resid.NewResId(psp, "restricted"):        newPodSecurityPolicy("policy/v1beta1"),
+					},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{Transformer: "apiversion", Kind: "PodSecurityPolicy", Name: "restricted", Message: "migrated from extensions/v1beta1 to policy/v1beta1"},
+							{Transformer: "apiversion", Kind: "PodSecurityPolicy", Name: "restricted", Message: "policy/v1beta1 is removed in Kubernetes 1.25 without replacement"},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:        "it should drop the resources which can't be migrated",
+			kubeVersion: "1.25",
+			policy:      RemovedAPIPolicyDrop,
+			input: &apiVersionTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(psp, "restricted"): newPodSecurityPolicy("policy/v1beta1"),
+						resid.NewResId(crd, "widgets.example.com"): rf.FromMap(map[string]interface{}{
+							"apiVersion": "apiextensions.k8s.io/v1beta1",
+							"kind":       "CustomResourceDefinition",
+							"metadata": map[string]interface{}{
+								"name": "widgets.example.com",
+							},
+						}),
+					},
+				},
+			},
+			expected: &apiVersionTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "apiversion",
+								Kind:        "CustomResourceDefinition",
+								Name:        "widgets.example.com",
+								Message: "dropped, apiextensions.k8s.io/v1beta1 is not served by Kubernetes 1.25: " +
+									"the validation schema and versions must be migrated manually",
+							},
+							{
+								Transformer: "apiversion",
+								Kind:        "PodSecurityPolicy",
+								Name:        "restricted",
+								Message:     "dropped, policy/v1beta1 is not served by Kubernetes 1.25: the API is removed without replacement",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:        "it should fail on resources which can't be migrated",
+			kubeVersion: "1.25",
+			policy:      RemovedAPIPolicyFail,
+			input: &apiVersionTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(psp, "restricted"): newPodSecurityPolicy("policy/v1beta1"),
+					},
+				},
+			},
+			expectedErr: "PodSecurityPolicy 'restricted' can't be converted, policy/v1beta1 is not served by " +
+				"Kubernetes 1.25: the API is removed without replacement",
+		},
+		{
+			name:        "it should fail on an invalid version",
+			kubeVersion: "2.0",
+			policy:      RemovedAPIPolicyFail,
+			input: &apiVersionTransformerArgs{
+				config:    &ktypes.Kustomization{},
+				resources: &types.Resources{},
+			},
+			expectedErr: "invalid Kubernetes version '2.0', expected 1.<minor>",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := NewAPIVersionTransformer(test.kubeVersion, test.policy).Transform(test.input.config, test.input.resources)
+			if test.expectedErr != "" {
+				if err == nil || err.Error() != test.expectedErr {
+					t.Fatalf("expected error '%s', got: %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := pretty.Compare(test.input.config, test.expected.config); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+
+			if diff := pretty.Compare(test.input.resources, test.expected.resources); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+		})
+	}
+}
+
+func TestMigrateWorkload(t *testing.T) {
+	template := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]interface{}{"app": "app"},
+		},
+	}
+	selector := map[string]interface{}{
+		"matchLabels": map[string]interface{}{"app": "app"},
+	}
+
+	for _, test := range []struct {
+		name            string
+		input           map[string]interface{}
+		expected        map[string]interface{}
+		expectedChanges []string
+	}{
+		{
+			name: "it should keep the OnDelete update strategy of extensions/v1beta1 daemonsets",
+			input: map[string]interface{}{
+				"apiVersion": "extensions/v1beta1",
+				"kind":       "DaemonSet",
+				"spec":       map[string]interface{}{"selector": selector, "template": template},
+			},
+			expected: map[string]interface{}{
+				"apiVersion": "extensions/v1beta1",
+				"kind":       "DaemonSet",
+				"spec": map[string]interface{}{
+					"selector":       selector,
+					"template":       template,
+					"updateStrategy": map[string]interface{}{"type": "OnDelete"},
+				},
+			},
+			expectedChanges: []string{"set spec.updateStrategy.type to the extensions/v1beta1 default of OnDelete"},
+		},
+		{
+			name: "it should keep the OnDelete update strategy of apps/v1beta1 statefulsets",
+			input: map[string]interface{}{
+				"apiVersion": "apps/v1beta1",
+				"kind":       "StatefulSet",
+				"spec": map[string]interface{}{
+					"selector":       selector,
+					"template":       template,
+					"updateStrategy": map[string]interface{}{},
+				},
+			},
+			expected: map[string]interface{}{
+				"apiVersion": "apps/v1beta1",
+				"kind":       "StatefulSet",
+				"spec": map[string]interface{}{
+					"selector":       selector,
+					"template":       template,
+					"updateStrategy": map[string]interface{}{"type": "OnDelete"},
+				},
+			},
+			expectedChanges: []string{"set spec.updateStrategy.type to the apps/v1beta1 default of OnDelete"},
+		},
+		{
+			name: "it should keep the revision history limit of apps/v1beta1 deployments",
+			input: map[string]interface{}{
+				"apiVersion": "apps/v1beta1",
+				"kind":       "Deployment",
+				"spec":       map[string]interface{}{"selector": selector, "template": template},
+			},
+			expected: map[string]interface{}{
+				"apiVersion": "apps/v1beta1",
+				"kind":       "Deployment",
+				"spec": map[string]interface{}{
+					"revisionHistoryLimit": 2,
+					"selector":             selector,
+					"template":             template,
+				},
+			},
+			expectedChanges: []string{"set spec.revisionHistoryLimit to the apps/v1beta1 default of 2"},
+		},
+		{
+			name: "it should keep the fields set by extensions/v1beta1 deployments",
+			input: map[string]interface{}{
+				"apiVersion": "extensions/v1beta1",
+				"kind":       "Deployment",
+				"spec": map[string]interface{}{
+					"revisionHistoryLimit":    3,
+					"progressDeadlineSeconds": 120,
+					"strategy":                map[string]interface{}{"type": "Recreate"},
+					"selector":                selector,
+					"template":                template,
+				},
+			},
+			expected: map[string]interface{}{
+				"apiVersion": "extensions/v1beta1",
+				"kind":       "Deployment",
+				"spec": map[string]interface{}{
+					"revisionHistoryLimit":    3,
+					"progressDeadlineSeconds": 120,
+					"strategy":                map[string]interface{}{"type": "Recreate"},
+					"selector":                selector,
+					"template":                template,
+				},
+			},
+		},
+		{
+			name: "it should only set the missing rolling update parameters",
+			input: map[string]interface{}{
+				"apiVersion": "extensions/v1beta1",
+				"kind":       "Deployment",
+				"spec": map[string]interface{}{
+					"revisionHistoryLimit":    3,
+					"progressDeadlineSeconds": 120,
+					"strategy": map[string]interface{}{
+						"type":          "RollingUpdate",
+						"rollingUpdate": map[string]interface{}{"maxSurge": "50%"},
+					},
+					"selector": selector,
+					"template": template,
+				},
+			},
+			expected: map[string]interface{}{
+				"apiVersion": "extensions/v1beta1",
+				"kind":       "Deployment",
+				"spec": map[string]interface{}{
+					"revisionHistoryLimit":    3,
+					"progressDeadlineSeconds": 120,
+					"strategy": map[string]interface{}{
+						"type":          "RollingUpdate",
+						"rollingUpdate": map[string]interface{}{"maxSurge": "50%", "maxUnavailable": 1},
+					},
+					"selector": selector,
+					"template": template,
+				},
+			},
+			expectedChanges: []string{"set spec.strategy.rollingUpdate.maxUnavailable to the extensions/v1beta1 default of 1"},
+		},
+		{
+			name: "it should leave apps/v1beta2 workloads unchanged",
+			input: map[string]interface{}{
+				"apiVersion": "apps/v1beta2",
+				"kind":       "DaemonSet",
+				"spec":       map[string]interface{}{"selector": selector, "template": template},
+			},
+			expected: map[string]interface{}{
+				"apiVersion": "apps/v1beta2",
+				"kind":       "DaemonSet",
+				"spec":       map[string]interface{}{"selector": selector, "template": template},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			changes, err := migrateWorkload(test.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := pretty.Compare(test.input, test.expected); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+			if diff := pretty.Compare(changes, test.expectedChanges); diff != "" {
+				t.Errorf("%s, changes diff: (-got +want)\n%s", test.name, diff)
+			}
+		})
+	}
+}
+
+func TestMigrateWebhooks(t *testing.T) {
+	input := map[string]interface{}{
+		"webhooks": []interface{}{
+			map[string]interface{}{"name": "defaults", "sideEffects": "None"},
+			map[string]interface{}{
+				"name":                    "explicit",
+				"sideEffects":             "NoneOnDryRun",
+				"admissionReviewVersions": []interface{}{"v1"},
+				"timeoutSeconds":          10,
+				"failurePolicy":           "Fail",
+				"matchPolicy":             "Equivalent",
+			},
+		},
+	}
+	expected := map[string]interface{}{
+		"webhooks": []interface{}{
+			map[string]interface{}{
+				"name":                    "defaults",
+				"sideEffects":             "None",
+				"admissionReviewVersions": []interface{}{"v1beta1"},
+				"timeoutSeconds":          30,
+				"failurePolicy":           "Ignore",
+				"matchPolicy":             "Exact",
+			},
+			map[string]interface{}{
+				"name":                    "explicit",
+				"sideEffects":             "NoneOnDryRun",
+				"admissionReviewVersions": []interface{}{"v1"},
+				"timeoutSeconds":          10,
+				"failurePolicy":           "Fail",
+				"matchPolicy":             "Equivalent",
+			},
+		},
+	}
+	expectedChanges := []string{
+		"set webhooks[0].admissionReviewVersions to [v1beta1]",
+		"set webhooks[0].timeoutSeconds to the previous default of 30",
+		"set webhooks[0].failurePolicy to the previous default of Ignore",
+		"set webhooks[0].matchPolicy to the previous default of Exact",
+	}
+
+	changes, err := migrateWebhooks(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare(input, expected); diff != "" {
+		t.Errorf("diff: (-got +want)\n%s", diff)
+	}
+	if diff := pretty.Compare(changes, expectedChanges); diff != "" {
+		t.Errorf("changes diff: (-got +want)\n%s", diff)
+	}
+
+	input["webhooks"] = []interface{}{map[string]interface{}{"name": "unknown"}}
+	if _, err := migrateWebhooks(input); err == nil || err.Error() != "webhooks[0].sideEffects must be None or NoneOnDryRun" {
+		t.Errorf("expected a sideEffects error, got: %v", err)
+	}
+}
+
+func TestMigrateHorizontalPodAutoscaler(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		input       map[string]interface{}
+		expected    map[string]interface{}
+		expectedErr string
+	}{
+		{
+			name: "it should convert resource and pods metrics",
+			input: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"metrics": []interface{}{
+						map[string]interface{}{
+							"type":     "Resource",
+							"resource": map[string]interface{}{"name": "cpu", "targetAverageUtilization": 80},
+						},
+						map[string]interface{}{
+							"type":     "Pods",
+							"resource": nil,
+							"pods":     map[string]interface{}{"metricName": "qps", "targetAverageValue": "1k"},
+						},
+					},
+				},
+			},
+			expected: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"metrics": []interface{}{
+						map[string]interface{}{
+							"type": "Resource",
+							"resource": map[string]interface{}{
+								"name":   "cpu",
+								"target": map[string]interface{}{"type": "Utilization", "averageUtilization": 80},
+							},
+						},
+						map[string]interface{}{
+							"type":     "Pods",
+							"resource": nil,
+							"pods": map[string]interface{}{
+								"metric": map[string]interface{}{"name": "qps"},
+								"target": map[string]interface{}{"type": "AverageValue", "averageValue": "1k"},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "it should refuse external metrics",
+			input: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"metrics": []interface{}{
+						map[string]interface{}{"type": "External"},
+					},
+				},
+			},
+			expectedErr: "spec.metrics[0]: External metrics must be migrated manually",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := migrateHorizontalPodAutoscaler(test.input)
+			if test.expectedErr != "" {
+				if err == nil || err.Error() != test.expectedErr {
+					t.Fatalf("expected error '%s', got: %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := pretty.Compare(test.input, test.expected); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package transformers
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/layertwo/helm-convert/pkg/types"
+	"github.com/layertwo/helm-convert/pkg/utils"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	kresid "sigs.k8s.io/kustomize/kyaml/resid"
+	"sigs.k8s.io/kustomize/pkg/resource"
+)
+
+const (
+	// certManagerAPIVersion is the API version of the generated Issuer and
+	// Certificate resources
+	certManagerAPIVersion = "cert-manager.io/v1"
+
+	// certManagerInjectAnnotation let the cert-manager CA injector fill the
+	// caBundle of a webhook from the secret of a Certificate
+	certManagerInjectAnnotation = "cert-manager.io/inject-ca-from"
+)
+
+// certManagerNameReference let kustomize update the issuer referenced by a
+// Certificate, ie: when a namePrefix is set
+const certManagerNameReference = `nameReference:
+- kind: Issuer
+  group: cert-manager.io
+  fieldSpecs:
+  - kind: Certificate
+    group: cert-manager.io
+    path: spec/issuerRef/name
+`
+
+// certManagerWebhookKinds are the resources holding a caBundle per webhook
+var certManagerWebhookKinds = map[string]struct{}{
+	"MutatingWebhookConfiguration":   {},
+	"ValidatingWebhookConfiguration": {},
+}
+
+type certManagerTransformer struct {
+	namespace string
+}
+
+var _ Transformer = &certManagerTransformer{}
+
+// NewCertManagerTransformer constructs a certManagerTransformer, namespace is
+// the namespace of the release, used for secrets rendered without namespace
+func NewCertManagerTransformer(namespace string) Transformer {
+	return &certManagerTransformer{namespace}
+}
+
+// certManagerSecret is a rendered TLS secret and its certificates
+type certManagerSecret struct {
+	res  *resource.Resource
+	ca   []byte
+	cert []byte
+	leaf *x509.Certificate
+}
+
+// Transform replace the TLS secrets and webhook caBundle generated by charts
+// with genCA and genSignedCert by cert-manager resources. These values change
+// on every render, once converted they would freeze a random key pair in the
+// base. Each matching Secret is replaced by a self-signed Issuer and a
+// Certificate writing to the same secret, the caBundle is removed and injected
+// by cert-manager instead.
+func (t *certManagerTransformer) Transform(config *ktypes.Kustomization, resources *types.Resources) error {
+	secrets := t.tlsSecrets(resources)
+
+	converted := make(map[*certManagerSecret]*resource.Resource)
+	for _, id := range utils.SortedIds(resources.ResMap) {
+		// secrets are removed while iterating
+		res, found := resources.ResMap[id]
+		if !found {
+			continue
+		}
+		if _, ok := certManagerWebhookKinds[res.GetKind()]; !ok {
+			continue
+		}
+
+		report := func(message string) {
+			resources.Report.Add(types.ReportEntry{
+				Transformer: "certmanager",
+				Kind:        res.GetKind(),
+				Name:        res.GetName(),
+				Path:        "webhooks[].clientConfig.caBundle",
+				Message:     message,
+			})
+		}
+
+		webhooks, _ := res.Map()["webhooks"].([]interface{})
+		var clientConfigs []map[string]interface{}
+		var matched *certManagerSecret
+		for i, w := range webhooks {
+			webhook, _ := w.(map[string]interface{})
+			clientConfig, _ := webhook["clientConfig"].(map[string]interface{})
+			caBundle, _ := clientConfig["caBundle"].(string)
+			if caBundle == "" {
+				continue
+			}
+
+			secret := matchCABundle(caBundle, secrets)
+			if secret == nil {
+				report(fmt.Sprintf("the caBundle of the webhook %d doesn't match a rendered TLS Secret, "+
+					"it is kept", i))
+				matched = nil
+				break
+			}
+			if matched != nil && matched != secret {
+				report("the webhooks are signed by different TLS Secrets, cert-manager can only inject one CA")
+				matched = nil
+				break
+			}
+			matched = secret
+			clientConfigs = append(clientConfigs, clientConfig)
+		}
+		if matched == nil {
+			continue
+		}
+
+		certificate, ok := converted[matched]
+		if !ok {
+			certificate = t.replaceSecret(matched, resources)
+			converted[matched] = certificate
+		}
+
+		for _, clientConfig := range clientConfigs {
+			delete(clientConfig, "caBundle")
+		}
+
+		namespace, _ := certificate.GetFieldValue("metadata.namespace")
+		metadata, _ := res.Map()["metadata"].(map[string]interface{})
+		annotations, _ := metadata["annotations"].(map[string]interface{})
+		if annotations == nil {
+			annotations = make(map[string]interface{})
+			metadata["annotations"] = annotations
+		}
+		annotations[certManagerInjectAnnotation] = namespace + "/" + certificate.GetName()
+
+		// namePrefix and namespace changes of the Certificate must propagate to
+		// the annotation
+		targetPath := replacementFieldPath([]string{"metadata", "annotations", certManagerInjectAnnotation})
+		for i, field := range []string{"metadata.namespace", "metadata.name"} {
+			config.Replacements = append(config.Replacements, ktypes.ReplacementField{
+				Replacement: ktypes.Replacement{
+					Source: &ktypes.SourceSelector{
+						ResId:     referenceResId(certificate),
+						FieldPath: field,
+					},
+					Targets: []*ktypes.TargetSelector{
+						{
+							Select: &ktypes.Selector{ResId: kresid.ResId{
+								Gvk:  kresid.Gvk{Kind: res.GetKind()},
+								Name: res.GetName(),
+							}},
+							FieldPaths: []string{targetPath},
+							Options:    &ktypes.FieldOptions{Delimiter: "/", Index: i},
+						},
+					},
+				},
+			})
+		}
+
+		if len(converted) == 1 && !ok {
+			filePath := addSourceFile(resources.SourceFiles, DefaultKustomizeConfigDir, "certmanager.yaml",
+				certManagerNameReference)
+			config.Configurations = append(config.Configurations, filePath)
+		}
+
+		glog.V(8).Infof("Replaced the caBundle of %s '%s' by the CA of the Certificate '%s'",
+			res.GetKind(), res.GetName(), certificate.GetName())
+		report(fmt.Sprintf("replaced by the CA of the Certificate '%s' injected by cert-manager",
+			certificate.GetName()))
+	}
+
+	return nil
+}
+
+// tlsSecrets return the secrets holding a certificate and its private key
+func (t *certManagerTransformer) tlsSecrets(resources *types.Resources) (secrets []*certManagerSecret) {
+	for _, id := range utils.SortedIds(resources.ResMap) {
+		res := resources.ResMap[id]
+		if res.GetKind() != "Secret" {
+			continue
+		}
+
+		data, err := secretData(res.Map())
+		if err != nil || data["tls.crt"] == "" || data["tls.key"] == "" {
+			continue
+		}
+
+		certs := parseCertificates([]byte(data["tls.crt"]))
+		if len(certs) == 0 {
+			continue
+		}
+
+		secrets = append(secrets, &certManagerSecret{
+			res:  res,
+			ca:   bytes.TrimSpace([]byte(data["ca.crt"])),
+			cert: bytes.TrimSpace([]byte(data["tls.crt"])),
+			leaf: certs[0],
+		})
+	}
+	return
+}
+
+// replaceSecret replace a TLS secret by a self-signed Issuer and a
+// Certificate writing to the same secret, it return the Certificate
+func (t *certManagerTransformer) replaceSecret(secret *certManagerSecret, resources *types.Resources) *resource.Resource {
+	name := secret.res.GetName()
+	namespace, _ := secret.res.GetFieldValue("metadata.namespace")
+	if namespace == "" {
+		namespace = t.namespace
+	}
+
+	newMetadata := func(name string) map[string]interface{} {
+		metadata := map[string]interface{}{"name": name, "namespace": namespace}
+		if secretMetadata, ok := secret.res.Map()["metadata"].(map[string]interface{}); ok {
+			if labels, ok := secretMetadata["labels"]; ok {
+				metadata["labels"] = deepCopyValue(labels)
+			}
+		}
+		return metadata
+	}
+
+	dnsNames := make([]interface{}, 0, len(secret.leaf.DNSNames))
+	for _, dnsName := range secret.leaf.DNSNames {
+		dnsNames = append(dnsNames, dnsName)
+	}
+	if len(dnsNames) == 0 && secret.leaf.Subject.CommonName != "" {
+		dnsNames = append(dnsNames, secret.leaf.Subject.CommonName)
+	}
+
+	issuer := resourceFactory.FromMap(map[string]interface{}{
+		"apiVersion": certManagerAPIVersion,
+		"kind":       "Issuer",
+		"metadata":   newMetadata(name + "-selfsigned"),
+		"spec": map[string]interface{}{
+			"selfSigned": map[string]interface{}{},
+		},
+	})
+
+	certificate := resourceFactory.FromMap(map[string]interface{}{
+		"apiVersion": certManagerAPIVersion,
+		"kind":       "Certificate",
+		"metadata":   newMetadata(name),
+		"spec": map[string]interface{}{
+			"secretName": name,
+			"dnsNames":   dnsNames,
+			"issuerRef": map[string]interface{}{
+				"kind": "Issuer",
+				"name": issuer.GetName(),
+			},
+		},
+	})
+
+	delete(resources.ResMap, secret.res.Id())
+	resources.ResMap[issuer.Id()] = issuer
+	resources.ResMap[certificate.Id()] = certificate
+
+	glog.V(8).Infof("Replaced the TLS secret '%s' by a cert-manager Certificate", name)
+	resources.Report.Add(types.ReportEntry{
+		Transformer: "certmanager",
+		Kind:        "Secret",
+		Name:        name,
+		Message:     "removed the key pair generated by the chart, it is issued by the cert-manager Certificate instead",
+	})
+
+	return certificate
+}
+
+// matchCABundle return the secret whose certificate is the CA of a webhook,
+// or is signed by it
+func matchCABundle(caBundle string, secrets []*certManagerSecret) *certManagerSecret {
+	decoded, err := base64.StdEncoding.DecodeString(caBundle)
+	if err != nil {
+		return nil
+	}
+	decoded = bytes.TrimSpace(decoded)
+	cas := parseCertificates(decoded)
+
+	for _, secret := range secrets {
+		if bytes.Equal(decoded, secret.ca) || bytes.Equal(decoded, secret.cert) {
+			return secret
+		}
+		for _, ca := range cas {
+			if secret.leaf.CheckSignatureFrom(ca) == nil {
+				return secret
+			}
+		}
+	}
+	return nil
+}
+
+// parseCertificates return the certificates of a PEM bundle
+func parseCertificates(data []byte) (certs []*x509.Certificate) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		certs = append(certs, cert)
+	}
+}
@@ -0,0 +1,667 @@
+package transformers
+
+import (
+	"path"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/layertwo/helm-convert/pkg/types"
+	"github.com/layertwo/helm-convert/pkg/utils"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/k8sdeps/kunstruct"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	kresid "sigs.k8s.io/kustomize/kyaml/resid"
+	"sigs.k8s.io/kustomize/pkg/gvk"
+	"sigs.k8s.io/kustomize/pkg/resid"
+	"sigs.k8s.io/kustomize/pkg/resmap"
+	"sigs.k8s.io/kustomize/pkg/resource"
+)
+
+type certManagerTransformerArgs struct {
+	config    *ktypes.Kustomization
+	resources *types.Resources
+}
+
+// certManagerTestCA is the base64 encoded PEM of the myrel-ca CA
+const certManagerTestCA = "LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0tCk1JSUJWekNCLzZBREFnRUNBZ0VCTUFvR0NDcUdT" +
+	"TTQ5QkFNQ01CTXhFVEFQQmdOVkJBTVRDRzE1Y21Wc0xXTmgKTUNBWERUSTBNREV3TVRBd01EQXdN" +
+	"Rm9ZRHpJeE1qUXdNVEF4TURBd01EQXdXakFUTVJFd0R3WURWUVFERXdodAplWEpsYkMxallUQlpN" +
+	"Qk1HQnlxR1NNNDlBZ0VHQ0NxR1NNNDlBd0VIQTBJQUJFLzUzYVJQZUQyWWI1TXVSY2NwCkx3K1Rm" +
+	"eU5QZHBpU3RDeXVPWk85M2Z3akMySlhsWlJKN3BwTlIwZFhEaHRRclFkUmFzelhtZ2QrcXVlQmU0" +
+	"MWkKbU0ralFqQkFNQTRHQTFVZER3RUIvd1FFQXdJQ2hEQVBCZ05WSFJNQkFmOEVCVEFEQVFIL01C" +
+	"MEdBMVVkRGdRVwpCQlFybmlWMVJBUWtsTFdHYmhkLytmT0M1eDB5dmpBS0JnZ3Foa2pPUFFRREFn" +
+	"TkhBREJFQWlCdzZHRnYzdlNiCmN0cHVmbk8ybEttVGtrMjJaMWp3L25wQmdzcjBZZWxIaFFJZ1hD" +
+	"dTlQZkV0M1ZORmJIbTNrcjB4MnVyNFVxUlMKTGdjWGwwT2hPVFl2NDl3PQotLS0tLUVORCBDRVJU" +
+	"SUZJQ0FURS0tLS0tCg=="
+
+// certManagerTestSignedCert is the base64 encoded PEM of the myrel-webhook certificate for myrel-webhook.system.svc and
+// myrel-webhook.system.svc.cluster.local signed by certManagerTestCA
+const certManagerTestSignedCert = "LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0tCk1JSUJyRENDQVZLZ0F3SUJBZ0lCQWpBS0JnZ3Fo" +
+	"a2pPUFFRREFqQVRNUkV3RHdZRFZRUURFd2h0ZVhKbGJDMWoKWVRBZ0Z3MHlOREF4TURFd01EQXdN" +
+	"REJhR0E4eU1USTBNREV3TVRBd01EQXdNRm93R0RFV01CUUdBMVVFQXhNTgpiWGx5Wld3dGQyVmlh" +
+	"Rzl2YXpCWk1CTUdCeXFHU000OUFnRUdDQ3FHU000OUF3RUhBMElBQkNtcW0yMURjUk9ZCmhDWGhI" +
+	"djJCcUp6bTNUSDdUdFRZWFEvTTF4VGJWRGNSd0ZKcEZsMkNUN2Y0bFg1NjBRMWlCYldiclpCSm01" +
+	"NloKQ0lNRzFmZWl2Y0dqZ1k4d2dZd3dEZ1lEVlIwUEFRSC9CQVFEQWdLRU1Bd0dBMVVkRXdFQi93" +
+	"UUNNQUF3SHdZRApWUjBqQkJnd0ZvQVVLNTRsZFVRRUpKUzFobTRYZi9uemd1Y2RNcjR3U3dZRFZS" +
+	"MFJCRVF3UW9JWWJYbHlaV3d0CmQyVmlhRzl2YXk1emVYTjBaVzB1YzNaamdpWnRlWEpsYkMxM1pX" +
+	"Sm9iMjlyTG5ONWMzUmxiUzV6ZG1NdVkyeDEKYzNSbGNpNXNiMk5oYkRBS0JnZ3Foa2pPUFFRREFn" +
+	"TklBREJGQWlFQW9xejhZWldsSTErZGpaZG1wa0N1cnR1Rwp6VWljNVBkZ3UvdXg5cExEeHB3Q0lB" +
+	"aUZBSGpvdG5nS1RkTHAxb1JHcXc1VTczdE55RkhlVlJWblZodmtxWkptCi0tLS0tRU5EIENFUlRJ" +
+	"RklDQVRFLS0tLS0K"
+
+// certManagerTestSelfSignedCert is the base64 encoded PEM of a myrel-webhook.system.svc self-signed certificate
+const certManagerTestSelfSignedCert = "LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0tCk1JSUJlakNDQVIrZ0F3SUJBZ0lCQXpBS0JnZ3Fo" +
+	"a2pPUFFRREFqQWpNU0V3SHdZRFZRUURFeGh0ZVhKbGJDMTMKWldKb2IyOXJMbk41YzNSbGJTNXpk" +
+	"bU13SUJjTk1qUXdNVEF4TURBd01EQXdXaGdQTWpFeU5EQXhNREV3TURBdwpNREJhTUNNeElUQWZC" +
+	"Z05WQkFNVEdHMTVjbVZzTFhkbFltaHZiMnN1YzNsemRHVnRMbk4yWXpCWk1CTUdCeXFHClNNNDlB" +
+	"Z0VHQ0NxR1NNNDlBd0VIQTBJQUJQU05QVllxbU9OTjc5bzM2QjJ5L1pEZGZMWDA2TmVCanlFb1lP" +
+	"UmUKNXZrU2pMRitnbWN4ZSt0ZTRjK2cxVWt2V20vMitTWFIya2xXN240TXVBL3RXcWlqUWpCQU1B" +
+	"NEdBMVVkRHdFQgovd1FFQXdJQ2hEQVBCZ05WSFJNQkFmOEVCVEFEQVFIL01CMEdBMVVkRGdRV0JC" +
+	"UVZ1ODV0aWgzWjFPS2pHNlR3CjBLTnNxYSszUXpBS0JnZ3Foa2pPUFFRREFnTkpBREJHQWlFQW9l" +
+	"V3BMZ09rQWtoSDgveTBDYU53czI1MDBEUHYKVFJweER4VENmdGhBYk93Q0lRRFhUOGl2eWJtNXYw" +
+	"dS9UUHhxSUp1dCtJMDhycTQwVmtGMnhsSi92aEpDT3c9PQotLS0tLUVORCBDRVJUSUZJQ0FURS0t" +
+	"LS0tCg=="
+
+// certManagerTestOtherCA is the base64 encoded PEM of another CA
+const certManagerTestOtherCA = "LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0tCk1JSUJXVENCLzZBREFnRUNBZ0VFTUFvR0NDcUdT" +
+	"TTQ5QkFNQ01CTXhFVEFQQmdOVkJBTVRDRzkwYUdWeUxXTmgKTUNBWERUSTBNREV3TVRBd01EQXdN" +
+	"Rm9ZRHpJeE1qUXdNVEF4TURBd01EQXdXakFUTVJFd0R3WURWUVFERXdodgpkR2hsY2kxallUQlpN" +
+	"Qk1HQnlxR1NNNDlBZ0VHQ0NxR1NNNDlBd0VIQTBJQUJJNWNVOCtNNmtoOWNOUjVBNnQxCjYwRk1Y" +
+	"VE0vSHZodmtjNE9OR3FuNEowWi9Xa0ZDVkZ6UWIrYnlCUzVPbWJlc1N3WUNuV1RJWWp0WVBQU1BC" +
+	"enAKMWVHalFqQkFNQTRHQTFVZER3RUIvd1FFQXdJQ2hEQVBCZ05WSFJNQkFmOEVCVEFEQVFIL01C" +
+	"MEdBMVVkRGdRVwpCQlJXbWNidDNrNGpyNElPM0VFR3ZObk9tbmE5eHpBS0JnZ3Foa2pPUFFRREFn" +
+	"TkpBREJHQWlFQS9NVFgwYkdnCm1hTFZmVkFOTnQrSWtoekpCWVQvWVlmTFpFOEdVY1BEbmhRQ0lR" +
+	"Q3NsSVYySE1oRVJDSTNCSWdBSlQ0Qlh3NEgKV2hDMFpmNXhlenFOTUhCWlNRPT0KLS0tLS1FTkQg" +
+	"Q0VSVElGSUNBVEUtLS0tLQo="
+
+func TestCertManagerRun(t *testing.T) {
+	var certificate = gvk.Gvk{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+	var issuer = gvk.Gvk{Group: "cert-manager.io", Version: "v1", Kind: "Issuer"}
+	var secret = gvk.Gvk{Version: "v1", Kind: "Secret"}
+	var webhook = gvk.Gvk{Group: "admissionregistration.k8s.io", Version: "v1", Kind: "ValidatingWebhookConfiguration"}
+	var rf = resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
+
+	for _, test := range []struct {
+		name     string
+		input    *certManagerTransformerArgs
+		expected *certManagerTransformerArgs
+	}{
+		{
+			name: "it should replace a certificate signed by the caBundle",
+			input: &certManagerTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResIdWithPrefixNamespace(secret, "myrel-webhook-tls", "", "system"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"type":       "kubernetes.io/tls",
+								"metadata": map[string]interface{}{
+									"name":      "myrel-webhook-tls",
+									"namespace": "system",
+									"labels": map[string]interface{}{
+										"app": "webhook",
+									},
+								},
+								"data": map[string]interface{}{
+									"tls.crt": certManagerTestSignedCert,
+									"tls.key": "a2V5",
+								},
+							}),
+						resid.NewResId(webhook, "myrel-webhook"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "admissionregistration.k8s.io/v1",
+								"kind":       "ValidatingWebhookConfiguration",
+								"metadata": map[string]interface{}{
+									"name": "myrel-webhook",
+								},
+								"webhooks": []interface{}{
+									map[string]interface{}{
+										"name": "validate.example.com",
+										"clientConfig": map[string]interface{}{
+											"caBundle": certManagerTestCA,
+											"service": map[string]interface{}{
+												"name":      "myrel-webhook",
+												"namespace": "system",
+											},
+										},
+									},
+								},
+							}),
+					},
+					SourceFiles: map[string]string{},
+				},
+			},
+			expected: &certManagerTransformerArgs{
+				config: &ktypes.Kustomization{
+					Configurations: []string{"kustomizeconfig/certmanager.yaml"},
+					Replacements: []ktypes.ReplacementField{
+						{
+							Replacement: ktypes.Replacement{
+								Source: &ktypes.SourceSelector{
+									ResId: kresid.ResId{
+										Gvk:       kresid.Gvk{Kind: "Certificate"},
+										Name:      "myrel-webhook-tls",
+										Namespace: "system",
+									},
+									FieldPath: "metadata.namespace",
+								},
+								Targets: []*ktypes.TargetSelector{
+									{
+										Select: &ktypes.Selector{ResId: kresid.ResId{
+											Gvk:  kresid.Gvk{Kind: "ValidatingWebhookConfiguration"},
+											Name: "myrel-webhook",
+										}},
+										FieldPaths: []string{"metadata.annotations.[cert-manager.io/inject-ca-from]"},
+										Options:    &ktypes.FieldOptions{Delimiter: "/", Index: 0},
+									},
+								},
+							},
+						},
+						{
+							Replacement: ktypes.Replacement{
+								Source: &ktypes.SourceSelector{
+									ResId: kresid.ResId{
+										Gvk:       kresid.Gvk{Kind: "Certificate"},
+										Name:      "myrel-webhook-tls",
+										Namespace: "system",
+									},
+									FieldPath: "metadata.name",
+								},
+								Targets: []*ktypes.TargetSelector{
+									{
+										Select: &ktypes.Selector{ResId: kresid.ResId{
+											Gvk:  kresid.Gvk{Kind: "ValidatingWebhookConfiguration"},
+											Name: "myrel-webhook",
+										}},
+										FieldPaths: []string{"metadata.annotations.[cert-manager.io/inject-ca-from]"},
+										Options:    &ktypes.FieldOptions{Delimiter: "/", Index: 1},
+									},
+								},
+							},
+						},
+					},
+				},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResIdWithPrefixNamespace(issuer, "myrel-webhook-tls-selfsigned", "", "system"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "cert-manager.io/v1",
+								"kind":       "Issuer",
+								"metadata": map[string]interface{}{
+									"name":      "myrel-webhook-tls-selfsigned",
+									"namespace": "system",
+									"labels": map[string]interface{}{
+										"app": "webhook",
+									},
+								},
+								"spec": map[string]interface{}{
+									"selfSigned": map[string]interface{}{},
+								},
+							}),
+						resid.NewResIdWithPrefixNamespace(certificate, "myrel-webhook-tls", "", "system"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "cert-manager.io/v1",
+								"kind":       "Certificate",
+								"metadata": map[string]interface{}{
+									"name":      "myrel-webhook-tls",
+									"namespace": "system",
+									"labels": map[string]interface{}{
+										"app": "webhook",
+									},
+								},
+								"spec": map[string]interface{}{
+									"secretName": "myrel-webhook-tls",
+									"dnsNames": []interface{}{
+										"myrel-webhook.system.svc",
+										"myrel-webhook.system.svc.cluster.local",
+									},
+									"issuerRef": map[string]interface{}{
+										"kind": "Issuer",
+										"name": "myrel-webhook-tls-selfsigned",
+									},
+								},
+							}),
+						resid.NewResId(webhook, "myrel-webhook"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "admissionregistration.k8s.io/v1",
+								"kind":       "ValidatingWebhookConfiguration",
+								"metadata": map[string]interface{}{
+									"name": "myrel-webhook",
+									"annotations": map[string]interface{}{
+										"cert-manager.io/inject-ca-from": "system/myrel-webhook-tls",
+									},
+								},
+								"webhooks": []interface{}{
+									map[string]interface{}{
+										"name": "validate.example.com",
+										"clientConfig": map[string]interface{}{
+											"service": map[string]interface{}{
+												"name":      "myrel-webhook",
+												"namespace": "system",
+											},
+										},
+									},
+								},
+							}),
+					},
+					SourceFiles: map[string]string{
+						"kustomizeconfig/certmanager.yaml": certManagerNameReference,
+					},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "certmanager",
+								Kind:        "Secret",
+								Name:        "myrel-webhook-tls",
+								Message:     "removed the key pair generated by the chart, it is issued by the cert-manager Certificate instead",
+							},
+							{
+								Transformer: "certmanager",
+								Kind:        "ValidatingWebhookConfiguration",
+								Name:        "myrel-webhook",
+								Path:        "webhooks[].clientConfig.caBundle",
+								Message:     "replaced by the CA of the Certificate 'myrel-webhook-tls' injected by cert-manager",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "it should replace a self-signed certificate",
+			input: &certManagerTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResIdWithPrefixNamespace(secret, "myrel-webhook-tls", "", "system"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"type":       "kubernetes.io/tls",
+								"metadata": map[string]interface{}{
+									"name":      "myrel-webhook-tls",
+									"namespace": "system",
+									"labels": map[string]interface{}{
+										"app": "webhook",
+									},
+								},
+								"data": map[string]interface{}{
+									"tls.crt": certManagerTestSelfSignedCert,
+									"tls.key": "a2V5",
+								},
+							}),
+						resid.NewResId(webhook, "myrel-webhook"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "admissionregistration.k8s.io/v1",
+								"kind":       "ValidatingWebhookConfiguration",
+								"metadata": map[string]interface{}{
+									"name": "myrel-webhook",
+								},
+								"webhooks": []interface{}{
+									map[string]interface{}{
+										"name": "validate.example.com",
+										"clientConfig": map[string]interface{}{
+											"caBundle": certManagerTestSelfSignedCert,
+											"service": map[string]interface{}{
+												"name":      "myrel-webhook",
+												"namespace": "system",
+											},
+										},
+									},
+								},
+							}),
+					},
+					SourceFiles: map[string]string{},
+				},
+			},
+			expected: &certManagerTransformerArgs{
+				config: &ktypes.Kustomization{
+					Configurations: []string{"kustomizeconfig/certmanager.yaml"},
+					Replacements: []ktypes.ReplacementField{
+						{
+							Replacement: ktypes.Replacement{
+								Source: &ktypes.SourceSelector{
+									ResId: kresid.ResId{
+										Gvk:       kresid.Gvk{Kind: "Certificate"},
+										Name:      "myrel-webhook-tls",
+										Namespace: "system",
+									},
+									FieldPath: "metadata.namespace",
+								},
+								Targets: []*ktypes.TargetSelector{
+									{
+										Select: &ktypes.Selector{ResId: kresid.ResId{
+											Gvk:  kresid.Gvk{Kind: "ValidatingWebhookConfiguration"},
+											Name: "myrel-webhook",
+										}},
+										FieldPaths: []string{"metadata.annotations.[cert-manager.io/inject-ca-from]"},
+										Options:    &ktypes.FieldOptions{Delimiter: "/", Index: 0},
+									},
+								},
+							},
+						},
+						{
+							Replacement: ktypes.Replacement{
+								Source: &ktypes.SourceSelector{
+									ResId: kresid.ResId{
+										Gvk:       kresid.Gvk{Kind: "Certificate"},
+										Name:      "myrel-webhook-tls",
+										Namespace: "system",
+									},
+									FieldPath: "metadata.name",
+								},
+								Targets: []*ktypes.TargetSelector{
+									{
+										Select: &ktypes.Selector{ResId: kresid.ResId{
+											Gvk:  kresid.Gvk{Kind: "ValidatingWebhookConfiguration"},
+											Name: "myrel-webhook",
+										}},
+										FieldPaths: []string{"metadata.annotations.[cert-manager.io/inject-ca-from]"},
+										Options:    &ktypes.FieldOptions{Delimiter: "/", Index: 1},
+									},
+								},
+							},
+						},
+					},
+				},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResIdWithPrefixNamespace(issuer, "myrel-webhook-tls-selfsigned", "", "system"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "cert-manager.io/v1",
+								"kind":       "Issuer",
+								"metadata": map[string]interface{}{
+									"name":      "myrel-webhook-tls-selfsigned",
+									"namespace": "system",
+									"labels": map[string]interface{}{
+										"app": "webhook",
+									},
+								},
+								"spec": map[string]interface{}{
+									"selfSigned": map[string]interface{}{},
+								},
+							}),
+						resid.NewResIdWithPrefixNamespace(certificate, "myrel-webhook-tls", "", "system"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "cert-manager.io/v1",
+								"kind":       "Certificate",
+								"metadata": map[string]interface{}{
+									"name":      "myrel-webhook-tls",
+									"namespace": "system",
+									"labels": map[string]interface{}{
+										"app": "webhook",
+									},
+								},
+								"spec": map[string]interface{}{
+									"secretName": "myrel-webhook-tls",
+									"dnsNames": []interface{}{
+										"myrel-webhook.system.svc",
+									},
+									"issuerRef": map[string]interface{}{
+										"kind": "Issuer",
+										"name": "myrel-webhook-tls-selfsigned",
+									},
+								},
+							}),
+						resid.NewResId(webhook, "myrel-webhook"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "admissionregistration.k8s.io/v1",
+								"kind":       "ValidatingWebhookConfiguration",
+								"metadata": map[string]interface{}{
+									"name": "myrel-webhook",
+									"annotations": map[string]interface{}{
+										"cert-manager.io/inject-ca-from": "system/myrel-webhook-tls",
+									},
+								},
+								"webhooks": []interface{}{
+									map[string]interface{}{
+										"name": "validate.example.com",
+										"clientConfig": map[string]interface{}{
+											"service": map[string]interface{}{
+												"name":      "myrel-webhook",
+												"namespace": "system",
+											},
+										},
+									},
+								},
+							}),
+					},
+					SourceFiles: map[string]string{
+						"kustomizeconfig/certmanager.yaml": certManagerNameReference,
+					},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "certmanager",
+								Kind:        "Secret",
+								Name:        "myrel-webhook-tls",
+								Message:     "removed the key pair generated by the chart, it is issued by the cert-manager Certificate instead",
+							},
+							{
+								Transformer: "certmanager",
+								Kind:        "ValidatingWebhookConfiguration",
+								Name:        "myrel-webhook",
+								Path:        "webhooks[].clientConfig.caBundle",
+								Message:     "replaced by the CA of the Certificate 'myrel-webhook-tls' injected by cert-manager",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "it should keep a caBundle which doesn't match a rendered secret",
+			input: &certManagerTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResIdWithPrefixNamespace(secret, "myrel-webhook-tls", "", "system"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"type":       "kubernetes.io/tls",
+								"metadata": map[string]interface{}{
+									"name":      "myrel-webhook-tls",
+									"namespace": "system",
+									"labels": map[string]interface{}{
+										"app": "webhook",
+									},
+								},
+								"data": map[string]interface{}{
+									"tls.crt": certManagerTestSignedCert,
+									"tls.key": "a2V5",
+								},
+							}),
+						resid.NewResId(webhook, "myrel-webhook"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "admissionregistration.k8s.io/v1",
+								"kind":       "ValidatingWebhookConfiguration",
+								"metadata": map[string]interface{}{
+									"name": "myrel-webhook",
+								},
+								"webhooks": []interface{}{
+									map[string]interface{}{
+										"name": "validate.example.com",
+										"clientConfig": map[string]interface{}{
+											"caBundle": certManagerTestOtherCA,
+											"service": map[string]interface{}{
+												"name":      "myrel-webhook",
+												"namespace": "system",
+											},
+										},
+									},
+								},
+							}),
+					},
+					SourceFiles: map[string]string{},
+				},
+			},
+			expected: &certManagerTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResIdWithPrefixNamespace(secret, "myrel-webhook-tls", "", "system"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"type":       "kubernetes.io/tls",
+								"metadata": map[string]interface{}{
+									"name":      "myrel-webhook-tls",
+									"namespace": "system",
+									"labels": map[string]interface{}{
+										"app": "webhook",
+									},
+								},
+								"data": map[string]interface{}{
+									"tls.crt": certManagerTestSignedCert,
+									"tls.key": "a2V5",
+								},
+							}),
+						resid.NewResId(webhook, "myrel-webhook"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "admissionregistration.k8s.io/v1",
+								"kind":       "ValidatingWebhookConfiguration",
+								"metadata": map[string]interface{}{
+									"name": "myrel-webhook",
+								},
+								"webhooks": []interface{}{
+									map[string]interface{}{
+										"name": "validate.example.com",
+										"clientConfig": map[string]interface{}{
+											"caBundle": certManagerTestOtherCA,
+											"service": map[string]interface{}{
+												"name":      "myrel-webhook",
+												"namespace": "system",
+											},
+										},
+									},
+								},
+							}),
+					},
+					SourceFiles: map[string]string{},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "certmanager",
+								Kind:        "ValidatingWebhookConfiguration",
+								Name:        "myrel-webhook",
+								Path:        "webhooks[].clientConfig.caBundle",
+								Message:     "the caBundle of the webhook 0 doesn't match a rendered TLS Secret, it is kept",
+							},
+						},
+					},
+				},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := NewCertManagerTransformer("default").Transform(test.input.config, test.input.resources)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := pretty.Compare(test.input, test.expected); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+		})
+	}
+}
+
+func TestCertManagerRoundTrip(t *testing.T) {
+	var secret = gvk.Gvk{Version: "v1", Kind: "Secret"}
+	var webhook = gvk.Gvk{Group: "admissionregistration.k8s.io", Version: "v1", Kind: "ValidatingWebhookConfiguration"}
+	var rf = resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
+
+	resources := types.NewResources()
+	resources.ResMap = resmap.ResMap{
+		resid.NewResIdWithPrefixNamespace(secret, "myrel-webhook-tls", "", "system"): rf.FromMap(
+			map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Secret",
+				"type":       "kubernetes.io/tls",
+				"metadata": map[string]interface{}{
+					"name":      "myrel-webhook-tls",
+					"namespace": "system",
+					"labels": map[string]interface{}{
+						"app": "webhook",
+					},
+				},
+				"data": map[string]interface{}{
+					"tls.crt": certManagerTestSignedCert,
+					"tls.key": "a2V5",
+				},
+			}),
+		resid.NewResId(webhook, "myrel-webhook"): rf.FromMap(
+			map[string]interface{}{
+				"apiVersion": "admissionregistration.k8s.io/v1",
+				"kind":       "ValidatingWebhookConfiguration",
+				"metadata": map[string]interface{}{
+					"name": "myrel-webhook",
+				},
+				"webhooks": []interface{}{
+					map[string]interface{}{
+						"name": "validate.example.com",
+						"clientConfig": map[string]interface{}{
+							"caBundle": certManagerTestCA,
+							"service": map[string]interface{}{
+								"name":      "myrel-webhook",
+								"namespace": "system",
+							},
+						},
+					},
+				},
+			}),
+	}
+
+	config := &ktypes.Kustomization{NamePrefix: "pre-", Namespace: "prod"}
+	if err := NewCertManagerTransformer("default").Transform(config, resources); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := NewReplacementsTransformer(false).Transform(config, resources); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fs := filesys.MakeFsInMemory()
+	for filename, content := range resources.SourceFiles {
+		fs.WriteFile(path.Join("/app", filename), []byte(content))
+	}
+	for id, res := range resources.ResMap {
+		output, err := yaml.Marshal(res.Map())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		filename, err := utils.GetResourceFileName(id, res)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		fs.WriteFile(path.Join("/app", filename), output)
+		config.Resources = append(config.Resources, filename)
+	}
+
+	output, err := kustomizeBuild(fs, config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, f := range []struct {
+		resource string
+		path     []string
+		expected string
+	}{
+		{"ValidatingWebhookConfiguration/pre-myrel-webhook", []string{"metadata", "annotations", certManagerInjectAnnotation}, "prod/pre-myrel-webhook-tls"},
+		{"Certificate/pre-myrel-webhook-tls", []string{"spec", "issuerRef", "name"}, "pre-myrel-webhook-tls-selfsigned"},
+	} {
+		obj, _ := output[f.resource].(map[string]interface{})
+		value, err := getFieldValue(obj, f.path)
+		if err != nil {
+			t.Errorf("%s: %v", f.resource, err)
+			continue
+		}
+		if value != f.expected {
+			t.Errorf("%s %v: expected '%s', got '%s'", f.resource, f.path, f.expected, value)
+		}
+	}
+}
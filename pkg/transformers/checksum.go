@@ -0,0 +1,236 @@
+package transformers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/layertwo/helm-convert/pkg/types"
+	"github.com/layertwo/helm-convert/pkg/utils"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+)
+
+// Hexadecimal digest, from md5 to sha512 (ie: {{ sha256sum ... }})
+var regexpDigest = regexp.MustCompile(`^(?i)[0-9a-f]{32,128}$`)
+
+// checksumReferenceFields are the fields of a pod spec referencing a
+// ConfigMap or a Secret, with the key holding the referenced name
+var checksumReferenceFields = map[string]struct{ kind, nameKey string }{
+	"configMap":       {"ConfigMap", "name"},
+	"configMapRef":    {"ConfigMap", "name"},
+	"configMapKeyRef": {"ConfigMap", "name"},
+	"secretRef":       {"Secret", "name"},
+	"secretKeyRef":    {"Secret", "name"},
+	"secret":          {"Secret", "secretName"},
+}
+
+type checksumTransformer struct{}
+
+var _ Transformer = &checksumTransformer{}
+
+// NewChecksumTransformer constructs a checksumTransformer.
+func NewChecksumTransformer() Transformer {
+	return &checksumTransformer{}
+}
+
+// checksumGenerator is a configMapGenerator or secretGenerator entry
+type checksumGenerator struct {
+	kind      string
+	name      string
+	namespace string
+	hashed    bool
+}
+
+// Transform remove the checksum annotations of pod templates (ie:
+// checksum/config: <sha256>) used by charts to trigger a rollout when a
+// ConfigMap or Secret change. Once these resources are generated with a name
+// hash, kustomize already trigger the rollout and the frozen digest is
+// misleading. Annotations are kept, and reported, when the pod doesn't
+// reference a generator or when the name hash is disabled.
+func (t *checksumTransformer) Transform(config *ktypes.Kustomization, resources *types.Resources) error {
+	globalHashDisabled := config.GeneratorOptions != nil && config.GeneratorOptions.DisableNameSuffixHash
+
+	var generators []checksumGenerator
+	addGenerator := func(kind string, args ktypes.GeneratorArgs) {
+		hashed := !globalHashDisabled && (args.Options == nil || !args.Options.DisableNameSuffixHash)
+		generators = append(generators, checksumGenerator{kind, args.Name, args.Namespace, hashed})
+	}
+	for _, args := range config.ConfigMapGenerator {
+		addGenerator("ConfigMap", args.GeneratorArgs)
+	}
+	for _, args := range config.SecretGenerator {
+		addGenerator("Secret", args.GeneratorArgs)
+	}
+
+	for _, id := range utils.SortedIds(resources.ResMap) {
+		res := resources.ResMap[id]
+		namespace, _ := res.GetFieldValue("metadata.namespace")
+
+		for _, template := range findPodTemplates(res.Map(), nil) {
+			metadata, _ := template.value["metadata"].(map[string]interface{})
+			annotations, _ := metadata["annotations"].(map[string]interface{})
+
+			var referenced []checksumGenerator
+			for _, ref := range checksumReferences(template.value["spec"]) {
+				for _, generator := range generators {
+					if generator.kind == ref[0] && generator.name == ref[1] && generator.namespace == namespace &&
+						!containsGenerator(referenced, generator) {
+						referenced = append(referenced, generator)
+					}
+				}
+			}
+
+			for _, key := range sortedInterfaceKeys(annotations) {
+				value, _ := annotations[key].(string)
+				if !isChecksumAnnotation(key) || !regexpDigest.MatchString(value) {
+					continue
+				}
+
+				path := strings.Join(append(template.path, "metadata", "annotations", key), ".")
+				report := func(message string) {
+					resources.Report.Add(types.ReportEntry{
+						Transformer: "checksum",
+						Kind:        res.GetKind(),
+						Name:        res.GetName(),
+						Path:        path,
+						Message:     message,
+					})
+				}
+
+				candidates := checksumCandidates(key, referenced)
+				if len(candidates) == 0 {
+					report("kept the frozen digest, the pod doesn't reference a ConfigMap or Secret generator")
+					continue
+				}
+
+				var unhashed []string
+				for _, generator := range candidates {
+					if !generator.hashed {
+						unhashed = append(unhashed, generator.kind+" '"+generator.name+"'")
+					}
+				}
+				if len(unhashed) > 0 {
+					glog.Warningf("The name hash of %s is disabled, the annotation '%s' of %s '%s' won't trigger "+
+						"rollouts anymore", strings.Join(unhashed, ", "), key, res.GetKind(), res.GetName())
+					report(fmt.Sprintf("kept the frozen digest, the name hash of %s is disabled so changes won't "+
+						"trigger a rollout", strings.Join(unhashed, ", ")))
+					continue
+				}
+
+				delete(annotations, key)
+				glog.V(8).Infof("Removed the annotation '%s' of %s '%s'", key, res.GetKind(), res.GetName())
+				report("removed the frozen digest, the generator name hash triggers rollouts")
+			}
+
+			if annotations != nil && len(annotations) == 0 {
+				delete(metadata, "annotations")
+			}
+		}
+	}
+
+	return nil
+}
+
+// podTemplate is a pod template found in a resource and its path
+type podTemplate struct {
+	path  []string
+	value map[string]interface{}
+}
+
+// findPodTemplates return the pod templates found in a resource, whatever
+// their depth (ie: spec.jobTemplate.spec.template of a CronJob)
+func findPodTemplates(obj map[string]interface{}, path []string) (templates []podTemplate) {
+	for _, key := range sortedInterfaceKeys(obj) {
+		child, ok := obj[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		childPath := append(path[:len(path):len(path)], key)
+		if spec, ok := child["spec"].(map[string]interface{}); ok && key == "template" && spec["containers"] != nil {
+			templates = append(templates, podTemplate{childPath, child})
+			continue
+		}
+		templates = append(templates, findPodTemplates(child, childPath)...)
+	}
+	return
+}
+
+// checksumReferences return the kind and name of the ConfigMaps and Secrets
+// referenced by a pod spec (volumes, projected volumes, envFrom, env)
+func checksumReferences(value interface{}) (references [][2]string) {
+	switch typedV := value.(type) {
+	case map[string]interface{}:
+		for _, key := range sortedInterfaceKeys(typedV) {
+			if field, ok := checksumReferenceFields[key]; ok {
+				if ref, ok := typedV[key].(map[string]interface{}); ok {
+					name, _ := ref[field.nameKey].(string)
+					if name == "" {
+						// projected volume sources use name
+						name, _ = ref["name"].(string)
+					}
+					if name != "" {
+						references = append(references, [2]string{field.kind, name})
+					}
+				}
+			}
+			references = append(references, checksumReferences(typedV[key])...)
+		}
+	case []interface{}:
+		for _, item := range typedV {
+			references = append(references, checksumReferences(item)...)
+		}
+	}
+	return
+}
+
+// isChecksumAnnotation return true if an annotation key looks like a rollout
+// checksum (ie: checksum/config, checksum/secret.yaml, config-checksum)
+func isChecksumAnnotation(key string) bool {
+	return strings.HasPrefix(key, "checksum/") || strings.Contains(key[strings.LastIndex(key, "/")+1:], "checksum")
+}
+
+// checksumCandidates return the referenced generators an annotation is about.
+// The annotation key is matched against the generator names, then against
+// the kind (ie: checksum/secret), all the references are returned otherwise.
+func checksumCandidates(key string, referenced []checksumGenerator) []checksumGenerator {
+	hint := strings.ToLower(key[strings.LastIndex(key, "/")+1:])
+	hint = strings.TrimSuffix(strings.TrimSuffix(hint, ".yaml"), ".yml")
+
+	filter := func(match func(checksumGenerator) bool) (output []checksumGenerator) {
+		for _, generator := range referenced {
+			if match(generator) {
+				output = append(output, generator)
+			}
+		}
+		return
+	}
+
+	if byName := filter(func(g checksumGenerator) bool {
+		return hint != "" && strings.HasSuffix(g.name, hint)
+	}); len(byName) > 0 {
+		return byName
+	}
+
+	var byKind []checksumGenerator
+	switch {
+	case strings.Contains(hint, "secret"):
+		byKind = filter(func(g checksumGenerator) bool { return g.kind == "Secret" })
+	case strings.Contains(hint, "config"):
+		byKind = filter(func(g checksumGenerator) bool { return g.kind == "ConfigMap" })
+	default:
+		byKind = referenced
+	}
+
+	return byKind
+}
+
+// containsGenerator return true if a generator is part of a list
+func containsGenerator(generators []checksumGenerator, generator checksumGenerator) bool {
+	for _, g := range generators {
+		if g == generator {
+			return true
+		}
+	}
+	return false
+}
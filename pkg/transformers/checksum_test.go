@@ -0,0 +1,535 @@
+package transformers
+
+import (
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/layertwo/helm-convert/pkg/types"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/k8sdeps/kunstruct"
+	"sigs.k8s.io/kustomize/pkg/gvk"
+	"sigs.k8s.io/kustomize/pkg/resid"
+	"sigs.k8s.io/kustomize/pkg/resmap"
+	"sigs.k8s.io/kustomize/pkg/resource"
+)
+
+type checksumTransformerArgs struct {
+	config    *ktypes.Kustomization
+	resources *types.Resources
+}
+
+var checksumTestDigest = "4f9b6b8c1d3c5c7a9e8f2a1b0c3d4e5f60718293a4b5c6d7e8f9012345678901"
+
+func TestChecksumRun(t *testing.T) {
+	var cronjob = gvk.Gvk{Group: "batch", Version: "v1", Kind: "CronJob"}
+	var deploy = gvk.Gvk{Group: "apps", Version: "v1", Kind: "Deployment"}
+	var rf = resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
+
+	var generators = &ktypes.Kustomization{
+		ConfigMapGenerator: []ktypes.ConfigMapArgs{
+			{GeneratorArgs: ktypes.GeneratorArgs{Name: "myrel-config"}},
+		},
+		SecretGenerator: []ktypes.SecretArgs{
+			{GeneratorArgs: ktypes.GeneratorArgs{Name: "myrel-secret"}},
+		},
+	}
+
+	var unhashed = &ktypes.Kustomization{
+		ConfigMapGenerator: []ktypes.ConfigMapArgs{
+			{GeneratorArgs: ktypes.GeneratorArgs{Name: "myrel-config"}},
+		},
+		SecretGenerator: []ktypes.SecretArgs{
+			{GeneratorArgs: ktypes.GeneratorArgs{
+				Name:    "myrel-secret",
+				Options: &ktypes.GeneratorOptions{DisableNameSuffixHash: true},
+			}},
+		},
+	}
+
+	for _, test := range []struct {
+		name     string
+		input    *checksumTransformerArgs
+		expected *checksumTransformerArgs
+	}{
+		{
+			name: "it should remove checksums of hashed generators",
+			input: &checksumTransformerArgs{
+				config: generators,
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "myrel-app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "myrel-app",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"metadata": map[string]interface{}{
+											"labels": map[string]interface{}{
+												"app": "app",
+											},
+											"annotations": map[string]interface{}{
+												"checksum/config": checksumTestDigest,
+												"checksum/secret": checksumTestDigest[:32],
+											},
+										},
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name": "app",
+													"envFrom": []interface{}{
+														map[string]interface{}{
+															"secretRef": map[string]interface{}{
+																"name": "myrel-secret",
+															},
+														},
+													},
+												},
+											},
+											"volumes": []interface{}{
+												map[string]interface{}{
+													"name": "config",
+													"configMap": map[string]interface{}{
+														"name": "myrel-config",
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+				},
+			},
+			expected: &checksumTransformerArgs{
+				config: generators,
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "myrel-app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "myrel-app",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"metadata": map[string]interface{}{
+											"labels": map[string]interface{}{
+												"app": "app",
+											},
+										},
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name": "app",
+													"envFrom": []interface{}{
+														map[string]interface{}{
+															"secretRef": map[string]interface{}{
+																"name": "myrel-secret",
+															},
+														},
+													},
+												},
+											},
+											"volumes": []interface{}{
+												map[string]interface{}{
+													"name": "config",
+													"configMap": map[string]interface{}{
+														"name": "myrel-config",
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "checksum",
+								Kind:        "Deployment",
+								Name:        "myrel-app",
+								Path:        "spec.template.metadata.annotations.checksum/config",
+								Message:     "removed the frozen digest, the generator name hash triggers rollouts",
+							},
+							{
+								Transformer: "checksum",
+								Kind:        "Deployment",
+								Name:        "myrel-app",
+								Path:        "spec.template.metadata.annotations.checksum/secret",
+								Message:     "removed the frozen digest, the generator name hash triggers rollouts",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "it should keep checksums which aren't digests or don't match a generator",
+			input: &checksumTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "myrel-app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "myrel-app",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"metadata": map[string]interface{}{
+											"labels": map[string]interface{}{
+												"app": "app",
+											},
+											"annotations": map[string]interface{}{
+												"checksum/config":   checksumTestDigest,
+												"checksum/version":  "1.2.3",
+												"example.com/owner": "team",
+											},
+										},
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name": "app",
+													"envFrom": []interface{}{
+														map[string]interface{}{
+															"secretRef": map[string]interface{}{
+																"name": "myrel-secret",
+															},
+														},
+													},
+												},
+											},
+											"volumes": []interface{}{
+												map[string]interface{}{
+													"name": "config",
+													"configMap": map[string]interface{}{
+														"name": "myrel-config",
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+				},
+			},
+			expected: &checksumTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "myrel-app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "myrel-app",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"metadata": map[string]interface{}{
+											"labels": map[string]interface{}{
+												"app": "app",
+											},
+											"annotations": map[string]interface{}{
+												"checksum/config":   checksumTestDigest,
+												"checksum/version":  "1.2.3",
+												"example.com/owner": "team",
+											},
+										},
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name": "app",
+													"envFrom": []interface{}{
+														map[string]interface{}{
+															"secretRef": map[string]interface{}{
+																"name": "myrel-secret",
+															},
+														},
+													},
+												},
+											},
+											"volumes": []interface{}{
+												map[string]interface{}{
+													"name": "config",
+													"configMap": map[string]interface{}{
+														"name": "myrel-config",
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "checksum",
+								Kind:        "Deployment",
+								Name:        "myrel-app",
+								Path:        "spec.template.metadata.annotations.checksum/config",
+								Message:     "kept the frozen digest, the pod doesn't reference a ConfigMap or Secret generator",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "it should keep checksums of generators without name hash",
+			input: &checksumTransformerArgs{
+				config: unhashed,
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "myrel-app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "myrel-app",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"metadata": map[string]interface{}{
+											"labels": map[string]interface{}{
+												"app": "app",
+											},
+											"annotations": map[string]interface{}{
+												"checksum/config":      checksumTestDigest,
+												"checksum/secret.yaml": checksumTestDigest,
+											},
+										},
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name": "app",
+													"envFrom": []interface{}{
+														map[string]interface{}{
+															"secretRef": map[string]interface{}{
+																"name": "myrel-secret",
+															},
+														},
+													},
+												},
+											},
+											"volumes": []interface{}{
+												map[string]interface{}{
+													"name": "config",
+													"configMap": map[string]interface{}{
+														"name": "myrel-config",
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+				},
+			},
+			expected: &checksumTransformerArgs{
+				config: unhashed,
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "myrel-app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "myrel-app",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"metadata": map[string]interface{}{
+											"labels": map[string]interface{}{
+												"app": "app",
+											},
+											"annotations": map[string]interface{}{
+												"checksum/secret.yaml": checksumTestDigest,
+											},
+										},
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name": "app",
+													"envFrom": []interface{}{
+														map[string]interface{}{
+															"secretRef": map[string]interface{}{
+																"name": "myrel-secret",
+															},
+														},
+													},
+												},
+											},
+											"volumes": []interface{}{
+												map[string]interface{}{
+													"name": "config",
+													"configMap": map[string]interface{}{
+														"name": "myrel-config",
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "checksum",
+								Kind:        "Deployment",
+								Name:        "myrel-app",
+								Path:        "spec.template.metadata.annotations.checksum/config",
+								Message:     "removed the frozen digest, the generator name hash triggers rollouts",
+							},
+							{
+								Transformer: "checksum",
+								Kind:        "Deployment",
+								Name:        "myrel-app",
+								Path:        "spec.template.metadata.annotations.checksum/secret.yaml",
+								Message:     "kept the frozen digest, the name hash of Secret 'myrel-secret' is disabled so changes won't trigger a rollout",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "it should handle global generator options and nested pod templates",
+			input: &checksumTransformerArgs{
+				config: &ktypes.Kustomization{
+					ConfigMapGenerator: []ktypes.ConfigMapArgs{
+						{GeneratorArgs: ktypes.GeneratorArgs{Name: "myrel-config"}},
+					},
+					GeneratorOptions: &ktypes.GeneratorOptions{DisableNameSuffixHash: true},
+				},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(cronjob, "myrel-backup"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "batch/v1",
+								"kind":       "CronJob",
+								"metadata": map[string]interface{}{
+									"name": "myrel-backup",
+								},
+								"spec": map[string]interface{}{
+									"jobTemplate": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"template": map[string]interface{}{
+												"metadata": map[string]interface{}{
+													"annotations": map[string]interface{}{
+														"backup-checksum": checksumTestDigest,
+													},
+												},
+												"spec": map[string]interface{}{
+													"containers": []interface{}{
+														map[string]interface{}{
+															"name": "backup",
+															"env": []interface{}{
+																map[string]interface{}{
+																	"name": "TARGET",
+																	"valueFrom": map[string]interface{}{
+																		"configMapKeyRef": map[string]interface{}{
+																			"name": "myrel-config",
+																			"key":  "target",
+																		},
+																	},
+																},
+															},
+														},
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+				},
+			},
+			expected: &checksumTransformerArgs{
+				config: &ktypes.Kustomization{
+					ConfigMapGenerator: []ktypes.ConfigMapArgs{
+						{GeneratorArgs: ktypes.GeneratorArgs{Name: "myrel-config"}},
+					},
+					GeneratorOptions: &ktypes.GeneratorOptions{DisableNameSuffixHash: true},
+				},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(cronjob, "myrel-backup"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "batch/v1",
+								"kind":       "CronJob",
+								"metadata": map[string]interface{}{
+									"name": "myrel-backup",
+								},
+								"spec": map[string]interface{}{
+									"jobTemplate": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"template": map[string]interface{}{
+												"metadata": map[string]interface{}{
+													"annotations": map[string]interface{}{
+														"backup-checksum": checksumTestDigest,
+													},
+												},
+												"spec": map[string]interface{}{
+													"containers": []interface{}{
+														map[string]interface{}{
+															"name": "backup",
+															"env": []interface{}{
+																map[string]interface{}{
+																	"name": "TARGET",
+																	"valueFrom": map[string]interface{}{
+																		"configMapKeyRef": map[string]interface{}{
+																			"name": "myrel-config",
+																			"key":  "target",
+																		},
+																	},
+																},
+															},
+														},
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "checksum",
+								Kind:        "CronJob",
+								Name:        "myrel-backup",
+								Path:        "spec.jobTemplate.spec.template.metadata.annotations.backup-checksum",
+								Message:     "kept the frozen digest, the name hash of ConfigMap 'myrel-config' is disabled so changes won't trigger a rollout",
+							},
+						},
+					},
+				},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := NewChecksumTransformer().Transform(test.input.config, test.input.resources)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := pretty.Compare(test.input, test.expected); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+		})
+	}
+}
@@ -1,9 +1,14 @@
 package transformers
 
 import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+
 	"github.com/golang/glog"
 	"github.com/layertwo/helm-convert/pkg/types"
-	ktypes "sigs.k8s.io/kustomize/pkg/types"
+	"github.com/layertwo/helm-convert/pkg/utils"
+	ktypes "sigs.k8s.io/kustomize/api/types"
 )
 
 type configMapTransformer struct{}
@@ -17,7 +22,8 @@ func NewConfigMapTransformer() Transformer {
 
 // Transform retrieve configmap from manifests and store them as configMapGenerator in the kustomization.yaml
 func (t *configMapTransformer) Transform(config *ktypes.Kustomization, resources *types.Resources) error {
-	for id, res := range resources.ResMap {
+	for _, id := range utils.SortedIds(resources.ResMap) {
+		res := resources.ResMap[id]
 		kind, err := res.GetFieldValue("kind")
 		if err != nil {
 			return err
@@ -34,29 +40,39 @@ func (t *configMapTransformer) Transform(config *ktypes.Kustomization, resources
 
 		obj := resources.ResMap[id].Map()
 
-		if _, found := obj["data"]; !found {
-			glog.V(8).Infof("Data field from configmap '%s' was not found", name)
+		if obj["data"] == nil && obj["binaryData"] == nil {
+			glog.V(8).Infof("Data field from configmap '%s' is empty", name)
 			continue
 		}
 
-		if obj["data"] == nil {
-			glog.V(8).Infof("Data field from configmap '%s' is empty", name)
-			continue
+		dataMap, err := configMapData(obj)
+		if err != nil {
+			return fmt.Errorf("configmap '%s': %v", name, err)
+		}
+
+		binaryDataMap, err := configMapBinaryData(obj)
+		if err != nil {
+			return fmt.Errorf("configmap '%s': %v", name, err)
 		}
 
-		data := obj["data"].(map[string]interface{})
-		dataMap := make(map[string]string, len(data))
-		for key, value := range data {
-			dataMap[key] = value.(string)
+		args, err := generatorArgs(name, obj)
+		if err != nil {
+			return fmt.Errorf("configmap '%s': %v", name, err)
 		}
 
 		configMapArg := ktypes.ConfigMapArgs{
-			GeneratorArgs: ktypes.GeneratorArgs{
-				Name: name,
-			},
+			GeneratorArgs: args,
 		}
 
-		configMapArg.GeneratorArgs.DataSources = TransformDataSource(name, dataMap, resources.SourceFiles)
+		configMapArg.GeneratorArgs.KvPairSources = TransformDataSource(kind, name, dataMap, resources.SourceFiles)
+
+		// binary data is always stored as raw file
+		if len(binaryDataMap) > 0 {
+			binarySources := TransformKeyedFileDataSource(kind, name, binaryDataMap, resources.SourceFiles)
+			configMapArg.GeneratorArgs.FileSources = append(configMapArg.GeneratorArgs.FileSources,
+				binarySources.FileSources...)
+			sort.Strings(configMapArg.GeneratorArgs.FileSources)
+		}
 
 		config.ConfigMapGenerator = append(config.ConfigMapGenerator, configMapArg)
 		delete(resources.ResMap, res.Id())
@@ -64,3 +80,46 @@ func (t *configMapTransformer) Transform(config *ktypes.Kustomization, resources
 
 	return nil
 }
+
+// configMapData return the data field of a configmap
+func configMapData(obj map[string]interface{}) (map[string]string, error) {
+	data, ok := obj["data"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	output := make(map[string]string, len(data))
+	for key, value := range data {
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("value of the key '%s' is not a string but %T", key, value)
+		}
+		output[key] = str
+	}
+
+	return output, nil
+}
+
+// configMapBinaryData return the decoded binaryData field of a configmap
+func configMapBinaryData(obj map[string]interface{}) (map[string]string, error) {
+	binaryData, ok := obj["binaryData"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	output := make(map[string]string, len(binaryData))
+	for key, value := range binaryData {
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("binary value of the key '%s' is not a string but %T", key, value)
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(str)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't base64 decode the binary key '%s': %v", key, err)
+		}
+		output[key] = string(decoded)
+	}
+
+	return output, nil
+}
@@ -1,16 +1,17 @@
 package transformers
 
 import (
+	"encoding/base64"
 	"testing"
 
 	"github.com/kylelemons/godebug/pretty"
 	"github.com/layertwo/helm-convert/pkg/types"
+	ktypes "sigs.k8s.io/kustomize/api/types"
 	"sigs.k8s.io/kustomize/k8sdeps/kunstruct"
 	"sigs.k8s.io/kustomize/pkg/gvk"
 	"sigs.k8s.io/kustomize/pkg/resid"
 	"sigs.k8s.io/kustomize/pkg/resmap"
 	"sigs.k8s.io/kustomize/pkg/resource"
-	ktypes "sigs.k8s.io/kustomize/pkg/types"
 )
 
 type configMapTransformerArgs struct {
@@ -26,6 +27,7 @@ func TestConfigMapRun(t *testing.T) {
 		name     string
 		input    *configMapTransformerArgs
 		expected *configMapTransformerArgs
+		err      string
 	}{
 		{
 			name: "it should convert configmaps",
@@ -62,12 +64,12 @@ spring.datasource.password=pass123
 						{
 							GeneratorArgs: ktypes.GeneratorArgs{
 								Name: "configmap1",
-								DataSources: ktypes.DataSources{
+								KvPairSources: ktypes.KvPairSources{
 									LiteralSources: []string{
 										"SOME_ENV=development",
 										"somekey=not a file",
 									},
-									FileSources: []string{"configmap1-application.properties"},
+									FileSources: []string{"application.properties=files/configmap/configmap1/application.properties"},
 								},
 							},
 						},
@@ -76,7 +78,7 @@ spring.datasource.password=pass123
 				resources: &types.Resources{
 					ResMap: resmap.ResMap{},
 					SourceFiles: map[string]string{
-						"configmap1-application.properties": `
+						"files/configmap/configmap1/application.properties": `
 app.name=My app
 spring.jpa.hibernate.ddl-auto=update
 spring.datasource.url=jdbc:mysql://<db_ip>:3306/db_example
@@ -87,6 +89,138 @@ spring.datasource.password=pass123
 				},
 			},
 		},
+		{
+			name: "it should convert binary data to raw files",
+			input: &configMapTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(configmap, "configmap1"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "ConfigMap",
+								"metadata": map[string]interface{}{
+									"name": "configmap1",
+								},
+								"data": map[string]interface{}{
+									"somekey": "not a file",
+									"gzip":    "\x1f\x8b\x08\x00",
+								},
+								"binaryData": map[string]interface{}{
+									"keystore.jks": base64.StdEncoding.EncodeToString([]byte("\xfe\xed\xfe\xed")),
+								},
+							}),
+					},
+				},
+			},
+			expected: &configMapTransformerArgs{
+				config: &ktypes.Kustomization{
+					ConfigMapGenerator: []ktypes.ConfigMapArgs{
+						{
+							GeneratorArgs: ktypes.GeneratorArgs{
+								Name: "configmap1",
+								KvPairSources: ktypes.KvPairSources{
+									LiteralSources: []string{
+										"somekey=not a file",
+									},
+									FileSources: []string{
+										"gzip=files/configmap/configmap1/gzip",
+										"keystore.jks=files/configmap/configmap1/keystore.jks",
+									},
+								},
+							},
+						},
+					},
+				},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{},
+					SourceFiles: map[string]string{
+						"files/configmap/configmap1/gzip":         "\x1f\x8b\x08\x00",
+						"files/configmap/configmap1/keystore.jks": "\xfe\xed\xfe\xed",
+					},
+				},
+			},
+		},
+		{
+			name: "it should keep the metadata and immutability of configmaps",
+			input: &configMapTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResIdWithPrefixNamespace(configmap, "dashboard", "", "monitoring"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "ConfigMap",
+								"metadata": map[string]interface{}{
+									"name":      "dashboard",
+									"namespace": "monitoring",
+									"labels": map[string]interface{}{
+										"grafana_dashboard": "1",
+									},
+									"annotations": map[string]interface{}{
+										"k8s-sidecar-target-directory": "/tmp/dashboards",
+									},
+								},
+								"immutable": true,
+								"data": map[string]interface{}{
+									"somekey": "not a file",
+								},
+							}),
+					},
+				},
+			},
+			expected: &configMapTransformerArgs{
+				config: &ktypes.Kustomization{
+					ConfigMapGenerator: []ktypes.ConfigMapArgs{
+						{
+							GeneratorArgs: ktypes.GeneratorArgs{
+								Name:      "dashboard",
+								Namespace: "monitoring",
+								KvPairSources: ktypes.KvPairSources{
+									LiteralSources: []string{
+										"somekey=not a file",
+									},
+								},
+								Options: &ktypes.GeneratorOptions{
+									Labels: map[string]string{
+										"grafana_dashboard": "1",
+									},
+									Annotations: map[string]string{
+										"k8s-sidecar-target-directory": "/tmp/dashboards",
+									},
+									Immutable: true,
+								},
+							},
+						},
+					},
+				},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{},
+				},
+			},
+		},
+		{
+			name: "it should fail on non string values",
+			input: &configMapTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(configmap, "configmap1"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "ConfigMap",
+								"metadata": map[string]interface{}{
+									"name": "configmap1",
+								},
+								"data": map[string]interface{}{
+									"replicas": int64(3),
+								},
+							}),
+					},
+				},
+			},
+			err: "configmap 'configmap1': value of the key 'replicas' is not a string but int64",
+		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
 			res := types.NewResources()
@@ -95,6 +229,13 @@ spring.datasource.password=pass123
 			lt := NewConfigMapTransformer()
 			err := lt.Transform(test.input.config, res)
 
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("expected error: %v, got: %v", test.err, err)
+				}
+				return
+			}
+
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
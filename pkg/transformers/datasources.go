@@ -2,45 +2,59 @@ package transformers
 
 import (
 	"fmt"
+	"path"
 	"regexp"
 	"sort"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/golang/glog"
-	ktypes "sigs.k8s.io/kustomize/pkg/types"
+	"github.com/layertwo/helm-convert/pkg/utils"
+	ktypes "sigs.k8s.io/kustomize/api/types"
 )
 
-var regexpEnv = regexp.MustCompile("^[A-Z0-9_]+$")
+// DefaultSourceFilesDir is the directory holding the source files of the
+// generated ConfigMaps and Secrets
+const DefaultSourceFilesDir = "files"
+
+// Environment variable names accepted by the Kustomize env file loader,
+// restricted to uppercase names
+var regexpEnv = regexp.MustCompile("^[A-Z_][A-Z0-9_]*$")
 
 // TransformDataSource return a Kustomize DataSource from a given ConfigMap.Data or
-// Secret.Data. If all keys from the resource matches an environment variable
-// format, the resource is converted as EnvFile. If keys contains a file
-// extension and the value is multiline then the file is stored as FileSources,
-// otherwise LiteralSources.
-func TransformDataSource(resourceName string, input map[string]string,
-	sourceFiles map[string]string) (dataSources ktypes.DataSources) {
+// Secret.Data. Each key of the input is represented exactly once and keeps its
+// original name:
+//   - if all keys match an environment variable format and no value contains a
+//     line break, the resource is converted as EnvFile
+//   - if the key contains a file extension, or the value can't be expressed as
+//     a literal without being altered by Kustomize (line breaks, surrounding
+//     quotes, binary content), the value is stored as a raw file in
+//     FileSources using the `key=path` form
+//   - otherwise the value is stored as LiteralSources
+//
+// Source files are stored in the directory returned by SourceFileDir.
+func TransformDataSource(kind, resourceName string, input map[string]string,
+	sourceFiles map[string]string) (dataSources ktypes.KvPairSources) {
 
 	if len(input) == 0 {
 		return
 	}
 
 	if isEnvFile(input) {
-		envFilename := fmt.Sprintf("%s.env", resourceName)
-		envFile := TransformEnvDataSource(input)
-		sourceFiles[envFilename] = envFile
+		envFilename := addSourceFile(sourceFiles, SourceFileDir(kind, resourceName),
+			resourceName+".env", TransformEnvDataSource(input))
 		dataSources.EnvSource = envFilename
 
 		glog.V(8).Infof("Converting '%s' as environment file with filename '%s'",
 			resourceName, envFilename)
 	} else {
-		for key, value := range TransformFileDataSource(input) {
-			filename := fmt.Sprintf("%s-%s", resourceName, key)
-			sourceFiles[filename] = value
-			dataSources.FileSources = append(dataSources.FileSources, filename)
+		files := TransformFileDataSource(input)
+		if len(files) > 0 {
+			dataSources.FileSources = TransformKeyedFileDataSource(kind, resourceName, files, sourceFiles).FileSources
 		}
 		dataSources.LiteralSources = TransformLiteralDataSource(input)
 
-		sort.Strings(dataSources.FileSources)
 		sort.Strings(dataSources.LiteralSources)
 
 		glog.V(8).Infof("Converting %d file(s) as external file and %d literal(s) "+
@@ -68,39 +82,135 @@ func TransformEnvDataSource(input map[string]string) (envFile string) {
 func TransformFileDataSource(input map[string]string) (files map[string]string) {
 	files = make(map[string]string)
 	for key, value := range input {
-		if isFileExtension(key) && isMultiline(value) {
+		if isFileSource(key, value) {
 			files[key] = value
 		}
 	}
 	return
 }
 
+// TransformKeyedFileDataSource return a Kustomize DataSource where every key of
+// the input is stored as a file source using the `key=path` form, which
+// preserve key names that couldn't be used as filename (ie: .dockerconfigjson)
+func TransformKeyedFileDataSource(kind, resourceName string, input map[string]string,
+	sourceFiles map[string]string) (dataSources ktypes.KvPairSources) {
+
+	dir := SourceFileDir(kind, resourceName)
+	for _, key := range sortedKeys(input) {
+		filename := addSourceFile(sourceFiles, dir, key, input[key])
+		dataSources.FileSources = append(dataSources.FileSources, fmt.Sprintf("%s=%s", key, filename))
+	}
+
+	sort.Strings(dataSources.FileSources)
+
+	glog.V(8).Infof("Converting %d key(s) as external file from resource '%s'",
+		len(dataSources.FileSources), resourceName)
+
+	return
+}
+
 // TransformLiteralDataSource return a list of literals (key=value) from a
 // given map
 func TransformLiteralDataSource(input map[string]string) (literal []string) {
 	for key, value := range input {
-		if !isFileExtension(key) && !isMultiline(value) {
+		if !isFileSource(key, value) {
 			literal = append(literal, fmt.Sprintf("%s=%s", key, value))
 		}
 	}
 	return
 }
 
+// SourceFileDir return the directory holding the source files of a resource,
+// ie: files/configmap/my-configmap
+func SourceFileDir(kind, resourceName string) string {
+	return path.Join(DefaultSourceFilesDir, utils.SanitizeFilename(strings.ToLower(kind)),
+		utils.SanitizeFilename(resourceName))
+}
+
+// addSourceFile store a source file in a given directory and return its path.
+// The filename is sanitized so that it can't escape the directory, if the path
+// is already taken a numbered suffix is added before the file extension.
+func addSourceFile(sourceFiles map[string]string, dir, filename, content string) string {
+	filePath := sourceFilePath(func(p string) bool {
+		_, ok := sourceFiles[p]
+		return ok
+	}, dir, filename)
+	sourceFiles[filePath] = content
+	return filePath
+}
+
+// sourceFilePath return a collision free path for a file in a given directory
+func sourceFilePath(taken func(string) bool, dir, filename string) string {
+	filename = utils.SanitizeFilename(filename)
+	filePath := path.Join(dir, filename)
+
+	ext := path.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	for i := 1; taken(filePath); i++ {
+		filePath = path.Join(dir, fmt.Sprintf("%s-%d%s", base, i, ext))
+	}
+
+	return filePath
+}
+
+// dataSourceFiles return the filename of the source files referenced by a
+// Kustomize DataSource
+func dataSourceFiles(dataSources ktypes.KvPairSources) (files []string) {
+	if dataSources.EnvSource != "" {
+		files = append(files, dataSources.EnvSource)
+	}
+	for _, source := range dataSources.FileSources {
+		if i := strings.Index(source, "="); i >= 0 {
+			source = source[i+1:]
+		}
+		files = append(files, source)
+	}
+	return
+}
+
 // isEnvFile return true if all the keys provided from a map match an
 // environment variable pattern (uppercase, underscore separated words and value
 // isn't multiline)
 func isEnvFile(input map[string]string) bool {
 	for key, value := range input {
-		if !isEnvVariable(key) || isMultiline(value) {
+		if !isEnvVariable(key) || isMultiline(value) || isBinary(value) {
 			return false
 		}
 	}
 	return true
 }
 
-// isMultiline return true if the provided value contains one of more line break
+// isFileSource return true if a key/value pair should be stored as a file
+// source rather than a literal
+func isFileSource(key, value string) bool {
+	return isFileExtension(key) || isMultiline(value) || isQuoted(value) || isBinary(value)
+}
+
+// isBinary return true if the provided value isn't valid UTF-8 (ie: keystore,
+// image, gzip) or contains control characters other than tabs and line
+// breaks, Kustomize store such file sources as binaryData
+func isBinary(s string) bool {
+	if !utf8.ValidString(s) {
+		return true
+	}
+	for _, r := range s {
+		if unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r' {
+			return true
+		}
+	}
+	return false
+}
+
+// isMultiline return true if the provided value contains one of more line
+// break, carriage returns are stripped from env files by Kustomize
 func isMultiline(s string) bool {
-	return strings.Contains(s, "\n")
+	return strings.ContainsAny(s, "\n\r")
+}
+
+// isQuoted return true if the provided value is surrounded by quotes, which
+// Kustomize removes from literals
+func isQuoted(s string) bool {
+	return len(s) >= 2 && s[0] == s[len(s)-1] && (s[0] == '"' || s[0] == '\'')
 }
 
 // isFileExtension return true if the provided string contains a dot
@@ -1,12 +1,118 @@
 package transformers
 
 import (
+	"encoding/base64"
+	"math/rand"
+	"path"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/quick"
 
+	"github.com/ghodss/yaml"
 	"github.com/kylelemons/godebug/pretty"
-	ktypes "sigs.k8s.io/kustomize/pkg/types"
+	"sigs.k8s.io/kustomize/api/krusty"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
 )
 
+// dataSourceInput is a ConfigMap.Data generated by testing/quick
+type dataSourceInput map[string]string
+
+const (
+	envKeyChars   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ_0123456789"
+	keyChars      = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_."
+	valueChars    = "aZ09 =\"'#:$-_.\t\n\réµ"
+	dataSourceDir = "/app"
+)
+
+// Generate return a random ConfigMap.Data, a third of the inputs only contain
+// environment variables so that env files are covered as well. Other inputs
+// may contain binary values.
+func (dataSourceInput) Generate(r *rand.Rand, size int) reflect.Value {
+	envOnly := r.Intn(3) == 0
+	input := make(dataSourceInput)
+
+	for i := r.Intn(size + 1); i > 0; i-- {
+		var key, value string
+		if envOnly {
+			key = string(envKeyChars[r.Intn(26)]) + randomString(r, envKeyChars, r.Intn(10))
+			value = strings.NewReplacer("\n", "", "\r", "").Replace(randomString(r, valueChars, r.Intn(size+1)))
+		} else {
+			key = string(keyChars[r.Intn(52)]) + randomString(r, keyChars, r.Intn(10))
+			value = randomString(r, valueChars, r.Intn(size+1))
+			if r.Intn(5) == 0 {
+				value += randomBytes(r, r.Intn(size+1))
+			}
+		}
+		input[key] = value
+	}
+
+	return reflect.ValueOf(input)
+}
+
+func randomString(r *rand.Rand, charset string, length int) string {
+	chars := []rune(charset)
+	output := make([]rune, length)
+	for i := range output {
+		output[i] = chars[r.Intn(len(chars))]
+	}
+	return string(output)
+}
+
+func randomBytes(r *rand.Rand, length int) string {
+	output := make([]byte, length)
+	r.Read(output)
+	return string(output)
+}
+
+// kustomizeBuildConfigMap return the data of the ConfigMap built by Kustomize
+// from the given data sources and source files
+func kustomizeBuildConfigMap(dataSources ktypes.KvPairSources, sourceFiles map[string]string) (map[string]string, error) {
+	fSys := filesys.MakeFsInMemory()
+	for filename, content := range sourceFiles {
+		if err := fSys.WriteFile(path.Join(dataSourceDir, filename), []byte(content)); err != nil {
+			return nil, err
+		}
+	}
+
+	kustomization, err := yaml.Marshal(ktypes.Kustomization{
+		ConfigMapGenerator: []ktypes.ConfigMapArgs{
+			{
+				GeneratorArgs: ktypes.GeneratorArgs{
+					Name:          "test",
+					KvPairSources: dataSources,
+				},
+			},
+		},
+		GeneratorOptions: &ktypes.GeneratorOptions{
+			DisableNameSuffixHash: true,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := fSys.WriteFile(path.Join(dataSourceDir, "kustomization.yaml"), kustomization); err != nil {
+		return nil, err
+	}
+
+	resMap, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(fSys, dataSourceDir)
+	if err != nil {
+		return nil, err
+	}
+
+	output := resMap.Resources()[0].GetDataMap()
+	for key, value := range resMap.Resources()[0].GetBinaryDataMap() {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, err
+		}
+		output[key] = string(decoded)
+	}
+
+	return output, nil
+}
+
 func TestTransformDataSource(t *testing.T) {
 	for _, test := range []struct {
 		name               string
@@ -14,7 +120,7 @@ func TestTransformDataSource(t *testing.T) {
 		input              map[string]string
 		sourceFiles        map[string]string
 		expectedSourceFile map[string]string
-		expectedOutput     ktypes.DataSources
+		expectedOutput     ktypes.KvPairSources
 	}{
 		{
 			name:         "it should detect file source and literal",
@@ -27,15 +133,64 @@ func TestTransformDataSource(t *testing.T) {
 				"file1.yaml": "content",
 			},
 			expectedSourceFile: map[string]string{
-				"file1.yaml":            "content",
-				"my-configmap-name.txt": "multi\nline",
+				"file1.yaml":                            "content",
+				"files/configmap/my-configmap/name.txt": "multi\nline",
 			},
-			expectedOutput: ktypes.DataSources{
+			expectedOutput: ktypes.KvPairSources{
 				LiteralSources: []string{
 					"somevar=single line",
 				},
 				FileSources: []string{
-					"my-configmap-name.txt",
+					"name.txt=files/configmap/my-configmap/name.txt",
+				},
+			},
+		},
+		{
+			name:         "it should keep keys which are neither a file nor a literal",
+			resourceName: "my-configmap",
+			input: map[string]string{
+				"version.txt": "1.0.0",
+				"script":      "#!/bin/sh\necho hello",
+				"quoted":      `"hello"`,
+				"equal":       "a=b",
+			},
+			sourceFiles: map[string]string{},
+			expectedSourceFile: map[string]string{
+				"files/configmap/my-configmap/version.txt": "1.0.0",
+				"files/configmap/my-configmap/script":      "#!/bin/sh\necho hello",
+				"files/configmap/my-configmap/quoted":      `"hello"`,
+			},
+			expectedOutput: ktypes.KvPairSources{
+				LiteralSources: []string{
+					"equal=a=b",
+				},
+				FileSources: []string{
+					"quoted=files/configmap/my-configmap/quoted",
+					"script=files/configmap/my-configmap/script",
+					"version.txt=files/configmap/my-configmap/version.txt",
+				},
+			},
+		},
+		{
+			name:         "it should store values with control characters as files",
+			resourceName: "my-configmap",
+			input: map[string]string{
+				"bell":    "ring\a",
+				"tab":     "a\tb",
+				"escaped": "\x1b[31mred",
+			},
+			sourceFiles: map[string]string{},
+			expectedSourceFile: map[string]string{
+				"files/configmap/my-configmap/bell":    "ring\a",
+				"files/configmap/my-configmap/escaped": "\x1b[31mred",
+			},
+			expectedOutput: ktypes.KvPairSources{
+				LiteralSources: []string{
+					"tab=a\tb",
+				},
+				FileSources: []string{
+					"bell=files/configmap/my-configmap/bell",
+					"escaped=files/configmap/my-configmap/escaped",
 				},
 			},
 		},
@@ -45,21 +200,104 @@ func TestTransformDataSource(t *testing.T) {
 			input: map[string]string{
 				"NODE_ENV": "production",
 				"SOMEENV":  "blop",
+				"OPTS":     `-Dkey="a=b"`,
 			},
 			sourceFiles: map[string]string{
 				"file1.yaml": "content",
 			},
 			expectedSourceFile: map[string]string{
-				"file1.yaml":       "content",
-				"my-configmap.env": "NODE_ENV=production\nSOMEENV=blop",
+				"file1.yaml": "content",
+				"files/configmap/my-configmap/my-configmap.env": "NODE_ENV=production\nOPTS=-Dkey=\"a=b\"\nSOMEENV=blop",
+			},
+			expectedOutput: ktypes.KvPairSources{
+				EnvSource: "files/configmap/my-configmap/my-configmap.env",
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			output := TransformDataSource("ConfigMap", test.resourceName, test.input, test.sourceFiles)
+			if diff := pretty.Compare(output, test.expectedOutput); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+			if diff := pretty.Compare(test.sourceFiles, test.expectedSourceFile); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+		})
+	}
+}
+
+func TestTransformKeyedFileDataSource(t *testing.T) {
+	for _, test := range []struct {
+		name               string
+		resourceName       string
+		input              map[string]string
+		sourceFiles        map[string]string
+		expectedSourceFile map[string]string
+		expectedOutput     ktypes.KvPairSources
+	}{
+		{
+			name:         "it should keep the original key names",
+			resourceName: "my-secret",
+			input: map[string]string{
+				".dockerconfigjson": "{}",
+				"tls.crt":           "cert",
+			},
+			sourceFiles: map[string]string{},
+			expectedSourceFile: map[string]string{
+				"files/secret/my-secret/dockerconfigjson": "{}",
+				"files/secret/my-secret/tls.crt":          "cert",
 			},
-			expectedOutput: ktypes.DataSources{
-				EnvSource: "my-configmap.env",
+			expectedOutput: ktypes.KvPairSources{
+				FileSources: []string{
+					".dockerconfigjson=files/secret/my-secret/dockerconfigjson",
+					"tls.crt=files/secret/my-secret/tls.crt",
+				},
+			},
+		},
+		{
+			name:         "it should resolve filename collisions deterministically",
+			resourceName: "my-secret",
+			input: map[string]string{
+				"a b.txt": "first",
+				"a_b.txt": "second",
+			},
+			sourceFiles: map[string]string{
+				"files/secret/my-secret/a_b-1.txt": "taken",
+			},
+			expectedSourceFile: map[string]string{
+				"files/secret/my-secret/a_b.txt":   "first",
+				"files/secret/my-secret/a_b-1.txt": "taken",
+				"files/secret/my-secret/a_b-2.txt": "second",
+			},
+			expectedOutput: ktypes.KvPairSources{
+				FileSources: []string{
+					"a b.txt=files/secret/my-secret/a_b.txt",
+					"a_b.txt=files/secret/my-secret/a_b-2.txt",
+				},
+			},
+		},
+		{
+			name:         "it should prevent keys and names from escaping the directory",
+			resourceName: "../my-secret",
+			input: map[string]string{
+				"../../etc/passwd": "root",
+				"..":               "dots",
+			},
+			sourceFiles: map[string]string{},
+			expectedSourceFile: map[string]string{
+				"files/secret/_my-secret/_._etc_passwd": "root",
+				"files/secret/_my-secret/_":             "dots",
+			},
+			expectedOutput: ktypes.KvPairSources{
+				FileSources: []string{
+					"../../etc/passwd=files/secret/_my-secret/_._etc_passwd",
+					"..=files/secret/_my-secret/_",
+				},
 			},
 		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
-			output := TransformDataSource(test.resourceName, test.input, test.sourceFiles)
+			output := TransformKeyedFileDataSource("Secret", test.resourceName, test.input, test.sourceFiles)
 			if diff := pretty.Compare(output, test.expectedOutput); diff != "" {
 				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
 			}
@@ -69,3 +307,30 @@ func TestTransformDataSource(t *testing.T) {
 		})
 	}
 }
+
+func TestTransformDataSourceRoundTrip(t *testing.T) {
+	property := func(input dataSourceInput) bool {
+		sourceFiles := make(map[string]string)
+		dataSources := TransformDataSource("ConfigMap", "my-configmap", input, sourceFiles)
+
+		output, err := kustomizeBuildConfigMap(dataSources, sourceFiles)
+		if err != nil {
+			t.Logf("kustomize build failed for %#v: %v", input, err)
+			return false
+		}
+
+		if len(output) == 0 && len(input) == 0 {
+			return true
+		}
+
+		if diff := pretty.Compare(output, map[string]string(input)); diff != "" {
+			t.Logf("data differ, diff: (-got +want)\n%s", diff)
+			return false
+		}
+		return true
+	}
+
+	if err := quick.Check(property, &quick.Config{MaxCount: 200}); err != nil {
+		t.Error(err)
+	}
+}
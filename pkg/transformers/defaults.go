@@ -0,0 +1,313 @@
+package transformers
+
+import (
+	_ "embed"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"github.com/layertwo/helm-convert/pkg/types"
+	"github.com/layertwo/helm-convert/pkg/utils"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/openapi"
+	"sigs.k8s.io/kustomize/pkg/gvk"
+)
+
+// defaultsTable holds the server-side defaults of the core workload kinds,
+// indexed by group and kind
+//
+//go:embed defaults.yaml
+var defaultsTable []byte
+
+// serverManagedFields are set by the API server and removed whatever their
+// value, templates are included as kubectl renders their creationTimestamp
+var serverManagedFields = []string{
+	"status",
+	"metadata.creationTimestamp",
+	"metadata.deletionTimestamp",
+	"metadata.deletionGracePeriodSeconds",
+	"metadata.generation",
+	"metadata.managedFields",
+	"metadata.resourceVersion",
+	"metadata.selfLink",
+	"metadata.uid",
+	"spec.template.metadata.creationTimestamp",
+	"spec.jobTemplate.metadata.creationTimestamp",
+	"spec.jobTemplate.spec.template.metadata.creationTimestamp",
+	"spec.volumeClaimTemplates[].metadata.creationTimestamp",
+	"spec.volumeClaimTemplates[].status",
+}
+
+// serverManagedAnnotations are set by kubectl or controllers, they are matched
+// as metadata.annotations.<key> by the paths to keep
+var serverManagedAnnotations = []string{
+	"deployment.kubernetes.io/revision",
+	"kubectl.kubernetes.io/last-applied-configuration",
+}
+
+// defaultConditions are the predicates a default may depend on, they are
+// evaluated on the parent of the field
+var defaultConditions = map[string]func(map[string]interface{}) bool{
+	"latestImage": isLatestImage,
+	"taggedImage": func(container map[string]interface{}) bool {
+		return !isLatestImage(container)
+	},
+}
+
+// defaultEntry is an entry of the defaults table
+type defaultEntry struct {
+	Path      string      `json:"path"`
+	Value     interface{} `json:"value"`
+	When      string      `json:"when,omitempty"`
+	Container bool        `json:"container,omitempty"`
+	Probe     bool        `json:"probe,omitempty"`
+}
+
+// defaultsFile is the format of the defaults table
+type defaultsFile struct {
+	PodSpec   []defaultEntry `json:"podSpec"`
+	Container []defaultEntry `json:"container"`
+	Probe     []defaultEntry `json:"probe"`
+	Kinds     map[string]struct {
+		PodSpec string         `json:"podSpec"`
+		Fields  []defaultEntry `json:"fields"`
+	} `json:"kinds"`
+}
+
+// defaultField is a field of a kind and its default value
+type defaultField struct {
+	path     string
+	segments []string
+	value    interface{}
+	when     string
+}
+
+type defaultsTransformer struct {
+	keepPaths map[string]struct{}
+	kinds     map[string][]defaultField
+}
+
+var _ Transformer = &defaultsTransformer{}
+
+// NewDefaultsTransformer constructs a defaultsTransformer. Fields matching one
+// of keepPaths, ie: spec.template.spec.containers[].imagePullPolicy, are kept.
+func NewDefaultsTransformer(keepPaths []string) Transformer {
+	t := &defaultsTransformer{
+		keepPaths: make(map[string]struct{}, len(keepPaths)),
+	}
+	for _, p := range keepPaths {
+		t.keepPaths[p] = struct{}{}
+	}
+	return t
+}
+
+// Transform remove the fields equal to their server-side default, the status
+// and the metadata managed by the API server
+func (t *defaultsTransformer) Transform(config *ktypes.Kustomization, resources *types.Resources) error {
+	if t.kinds == nil {
+		kinds, err := loadDefaults(defaultsTable)
+		if err != nil {
+			return err
+		}
+		t.kinds = kinds
+	}
+
+	for _, id := range utils.SortedIds(resources.ResMap) {
+		res := resources.ResMap[id]
+		obj := res.Map()
+
+		for _, p := range serverManagedFields {
+			if _, keep := t.keepPaths[p]; keep {
+				continue
+			}
+			if pruneField(obj, splitPatchPath(p), nil) {
+				glog.V(8).Infof("Removed the server managed field '%s' of %s '%s'", p, res.GetKind(), res.GetName())
+			}
+		}
+
+		for _, key := range serverManagedAnnotations {
+			if _, keep := t.keepPaths["metadata.annotations."+key]; keep {
+				continue
+			}
+			metadata, _ := obj["metadata"].(map[string]interface{})
+			if pruneField(metadata, []string{"annotations", key}, nil) {
+				glog.V(8).Infof("Removed the server managed annotation '%s' of %s '%s'", key, res.GetKind(), res.GetName())
+			}
+		}
+
+		for _, field := range t.kinds[groupKind(id.Gvk())] {
+			if _, keep := t.keepPaths[field.path]; keep {
+				continue
+			}
+			matches := func(parent map[string]interface{}, value interface{}) bool {
+				if field.when != "" && !defaultConditions[field.when](parent) {
+					return false
+				}
+				return defaultValueEqual(value, field.value)
+			}
+			if pruneField(obj, field.segments, matches) {
+				glog.V(8).Infof("Removed the default value of the field '%s' of %s '%s'",
+					field.path, res.GetKind(), res.GetName())
+			}
+		}
+	}
+
+	return nil
+}
+
+// groupKind return the key of a kind in the defaults table, ie:
+// apps/Deployment, kinds of the core group are not qualified
+func groupKind(g gvk.Gvk) string {
+	if g.Group == "" {
+		return g.Kind
+	}
+	return g.Group + "/" + g.Kind
+}
+
+// loadDefaults return the default fields of each kind found in the defaults
+// table indexed by group and kind, pod spec, container and probe fields are
+// expanded
+func loadDefaults(content []byte) (map[string][]defaultField, error) {
+	var file defaultsFile
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("invalid defaults table: %v", err)
+	}
+
+	var expand func(prefix string, entries []defaultEntry) ([]defaultField, error)
+	expand = func(prefix string, entries []defaultEntry) (fields []defaultField, err error) {
+		for _, entry := range entries {
+			p := entry.Path
+			if prefix != "" {
+				p = prefix + "." + p
+			}
+
+			var nested []defaultField
+			switch {
+			case entry.Container:
+				nested, err = expand(p, file.Container)
+			case entry.Probe:
+				nested, err = expand(p, file.Probe)
+			default:
+				if _, found := defaultConditions[entry.When]; entry.When != "" && !found {
+					return nil, fmt.Errorf("invalid defaults table: unknown condition '%s' of the field '%s'",
+						entry.When, p)
+				}
+				nested = []defaultField{{
+					path:     p,
+					segments: splitPatchPath(p),
+					value:    entry.Value,
+					when:     entry.When,
+				}}
+			}
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, nested...)
+		}
+		return
+	}
+
+	kinds := make(map[string][]defaultField, len(file.Kinds))
+	for kind, spec := range file.Kinds {
+		fields, err := expand("", spec.Fields)
+		if err != nil {
+			return nil, err
+		}
+		if spec.PodSpec != "" {
+			podFields, err := expand(spec.PodSpec, file.PodSpec)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, podFields...)
+		}
+		kinds[kind] = fields
+	}
+
+	return kinds, nil
+}
+
+// pruneField remove the field found at the given path if matches return true,
+// or unconditionally if matches is nil. Maps emptied by the removal are
+// removed as well, empty maps already present are left untouched.
+func pruneField(obj map[string]interface{}, p []string, matches func(map[string]interface{}, interface{}) bool) bool {
+	field := p[0]
+	value, found := obj[field]
+	if !found {
+		return false
+	}
+
+	if len(p) == 1 {
+		if matches != nil && !matches(obj, value) {
+			return false
+		}
+		delete(obj, field)
+		return true
+	}
+
+	pruned := false
+	if p[1] == openapi.Elements {
+		list, ok := value.([]interface{})
+		if !ok || len(p) < 3 {
+			return false
+		}
+		for _, item := range list {
+			if element, ok := item.(map[string]interface{}); ok && pruneField(element, p[2:], matches) {
+				pruned = true
+			}
+		}
+		return pruned
+	}
+
+	child, ok := value.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	if pruned = pruneField(child, p[1:], matches); pruned && len(child) == 0 {
+		delete(obj, field)
+	}
+	return pruned
+}
+
+// defaultValueEqual compare a value with a default, numbers are compared
+// whatever their type
+func defaultValueEqual(value, defaultValue interface{}) bool {
+	a, aIsNumber := toFloat(value)
+	b, bIsNumber := toFloat(defaultValue)
+	if aIsNumber || bIsNumber {
+		return aIsNumber && bIsNumber && a == b
+	}
+	return reflect.DeepEqual(value, defaultValue)
+}
+
+// toFloat convert a number to a float64
+func toFloat(value interface{}) (float64, bool) {
+	switch typedV := value.(type) {
+	case int:
+		return float64(typedV), true
+	case int32:
+		return float64(typedV), true
+	case int64:
+		return float64(typedV), true
+	case float32:
+		return float64(typedV), true
+	case float64:
+		return typedV, true
+	}
+	return 0, false
+}
+
+// isLatestImage return true if the image of a container has no digest and no
+// tag or the latest tag, the pull policy then defaults to Always
+func isLatestImage(container map[string]interface{}) bool {
+	image, _ := container["image"].(string)
+	if image == "" || strings.Contains(image, "@") {
+		return false
+	}
+	tag := ""
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		tag = image[i+1:]
+	}
+	return tag == "" || tag == "latest"
+}
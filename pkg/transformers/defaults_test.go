@@ -0,0 +1,393 @@
+package transformers
+
+import (
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/layertwo/helm-convert/pkg/types"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/k8sdeps/kunstruct"
+	"sigs.k8s.io/kustomize/pkg/gvk"
+	"sigs.k8s.io/kustomize/pkg/resid"
+	"sigs.k8s.io/kustomize/pkg/resmap"
+	"sigs.k8s.io/kustomize/pkg/resource"
+)
+
+type defaultsTransformerArgs struct {
+	config    *ktypes.Kustomization
+	resources *types.Resources
+}
+
+func TestDefaultsRun(t *testing.T) {
+	var deploy = gvk.Gvk{Group: "apps", Version: "v1", Kind: "Deployment"}
+	var service = gvk.Gvk{Version: "v1", Kind: "Service"}
+	var extensionsDeploy = gvk.Gvk{Group: "extensions", Version: "v1beta1", Kind: "Deployment"}
+	var knativeService = gvk.Gvk{Group: "serving.knative.dev", Version: "v1", Kind: "Service"}
+	var rf = resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
+
+	newDeployment := func() map[string]interface{} {
+		return map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name":              "app",
+				"creationTimestamp": nil,
+				"annotations": map[string]interface{}{
+					"deployment.kubernetes.io/revision": "3",
+				},
+			},
+			"spec": map[string]interface{}{
+				"replicas":             1,
+				"revisionHistoryLimit": 10,
+				"strategy": map[string]interface{}{
+					"type": "RollingUpdate",
+					"rollingUpdate": map[string]interface{}{
+						"maxSurge":       "25%",
+						"maxUnavailable": 0,
+					},
+				},
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{
+						"creationTimestamp": nil,
+					},
+					"spec": map[string]interface{}{
+						"dnsPolicy":                     "ClusterFirst",
+						"restartPolicy":                 "Always",
+						"terminationGracePeriodSeconds": float64(30),
+						"securityContext":               map[string]interface{}{},
+						"containers": []interface{}{
+							map[string]interface{}{
+								"name":                   "app",
+								"image":                  "app:1.0",
+								"imagePullPolicy":        "IfNotPresent",
+								"terminationMessagePath": "/dev/termination-log",
+								"ports": []interface{}{
+									map[string]interface{}{"containerPort": 80, "protocol": "TCP"},
+									map[string]interface{}{"containerPort": 53, "protocol": "UDP"},
+								},
+								"readinessProbe": map[string]interface{}{
+									"httpGet":        map[string]interface{}{"path": "/", "port": 80, "scheme": "HTTP"},
+									"periodSeconds":  10,
+									"timeoutSeconds": 5,
+								},
+							},
+							map[string]interface{}{
+								"name":            "sidecar",
+								"image":           "sidecar:latest",
+								"imagePullPolicy": "IfNotPresent",
+							},
+						},
+					},
+				},
+			},
+			"status": map[string]interface{}{},
+		}
+	}
+
+	for _, test := range []struct {
+		name      string
+		keepPaths []string
+		input     *defaultsTransformerArgs
+		expected  *defaultsTransformerArgs
+	}{
+		{
+			name: "it should remove default values, status and server managed metadata",
+			input: &defaultsTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "app"): rf.FromMap(newDeployment()),
+					},
+				},
+			},
+			expected: &defaultsTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "app"): rf.FromMap(map[string]interface{}{
+							"apiVersion": "apps/v1",
+							"kind":       "Deployment",
+							"metadata": map[string]interface{}{
+								"name": "app",
+							},
+							"spec": map[string]interface{}{
+								"replicas": 1,
+								"strategy": map[string]interface{}{
+									"rollingUpdate": map[string]interface{}{
+										"maxUnavailable": 0,
+									},
+								},
+								"template": map[string]interface{}{
+									"spec": map[string]interface{}{
+										"securityContext": map[string]interface{}{},
+										"containers": []interface{}{
+											map[string]interface{}{
+												"name":  "app",
+												"image": "app:1.0",
+												"ports": []interface{}{
+													map[string]interface{}{"containerPort": 80},
+													map[string]interface{}{"containerPort": 53, "protocol": "UDP"},
+												},
+												"readinessProbe": map[string]interface{}{
+													"httpGet":        map[string]interface{}{"path": "/", "port": 80},
+													"timeoutSeconds": 5,
+												},
+											},
+											map[string]interface{}{
+												"name":            "sidecar",
+												"image":           "sidecar:latest",
+												"imagePullPolicy": "IfNotPresent",
+											},
+										},
+									},
+								},
+							},
+						}),
+					},
+				},
+			},
+		},
+		{
+			name: "it should keep the given paths",
+			keepPaths: []string{
+				"status",
+				"spec.strategy.type",
+				"spec.template.spec.containers[].imagePullPolicy",
+				"metadata.annotations.deployment.kubernetes.io/revision",
+			},
+			input: &defaultsTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(service, "app"): rf.FromMap(map[string]interface{}{
+							"apiVersion": "v1",
+							"kind":       "Service",
+							"metadata": map[string]interface{}{
+								"name": "app",
+							},
+							"spec": map[string]interface{}{
+								"type":            "ClusterIP",
+								"sessionAffinity": "ClientIP",
+								"ports": []interface{}{
+									map[string]interface{}{"port": 80, "protocol": "TCP"},
+								},
+							},
+							"status": map[string]interface{}{
+								"loadBalancer": map[string]interface{}{},
+							},
+						}),
+						resid.NewResId(deploy, "app"): rf.FromMap(newDeployment()),
+					},
+				},
+			},
+			expected: &defaultsTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(service, "app"): rf.FromMap(map[string]interface{}{
+							"apiVersion": "v1",
+							"kind":       "Service",
+							"metadata": map[string]interface{}{
+								"name": "app",
+							},
+							"spec": map[string]interface{}{
+								"sessionAffinity": "ClientIP",
+								"ports": []interface{}{
+									map[string]interface{}{"port": 80},
+								},
+							},
+							"status": map[string]interface{}{
+								"loadBalancer": map[string]interface{}{},
+							},
+						}),
+						resid.NewResId(deploy, "app"): rf.FromMap(map[string]interface{}{
+							"apiVersion": "apps/v1",
+							"kind":       "Deployment",
+							"metadata": map[string]interface{}{
+								"name": "app",
+								"annotations": map[string]interface{}{
+									"deployment.kubernetes.io/revision": "3",
+								},
+							},
+							"spec": map[string]interface{}{
+								"replicas": 1,
+								"strategy": map[string]interface{}{
+									"type": "RollingUpdate",
+									"rollingUpdate": map[string]interface{}{
+										"maxUnavailable": 0,
+									},
+								},
+								"template": map[string]interface{}{
+									"spec": map[string]interface{}{
+										"securityContext": map[string]interface{}{},
+										"containers": []interface{}{
+											map[string]interface{}{
+												"name":            "app",
+												"image":           "app:1.0",
+												"imagePullPolicy": "IfNotPresent",
+												"ports": []interface{}{
+													map[string]interface{}{"containerPort": 80},
+													map[string]interface{}{"containerPort": 53, "protocol": "UDP"},
+												},
+												"readinessProbe": map[string]interface{}{
+													"httpGet":        map[string]interface{}{"path": "/", "port": 80},
+													"timeoutSeconds": 5,
+												},
+											},
+											map[string]interface{}{
+												"name":            "sidecar",
+												"image":           "sidecar:latest",
+												"imagePullPolicy": "IfNotPresent",
+											},
+										},
+									},
+								},
+							},
+							"status": map[string]interface{}{},
+						}),
+					},
+				},
+			},
+		},
+		{
+			name: "it should only remove the defaults of the kinds of known groups",
+			input: &defaultsTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(extensionsDeploy, "legacy"): rf.FromMap(map[string]interface{}{
+							"apiVersion": "extensions/v1beta1",
+							"kind":       "Deployment",
+							"metadata": map[string]interface{}{
+								"name": "legacy",
+							},
+							"spec": map[string]interface{}{
+								"revisionHistoryLimit": 10,
+								"strategy": map[string]interface{}{
+									"type": "RollingUpdate",
+								},
+							},
+							"status": map[string]interface{}{},
+						}),
+						resid.NewResId(knativeService, "app"): rf.FromMap(map[string]interface{}{
+							"apiVersion": "serving.knative.dev/v1",
+							"kind":       "Service",
+							"metadata": map[string]interface{}{
+								"name": "app",
+							},
+							"spec": map[string]interface{}{
+								"type": "ClusterIP",
+								"template": map[string]interface{}{
+									"spec": map[string]interface{}{
+										"containers": []interface{}{
+											map[string]interface{}{
+												"image": "app:1.0",
+												"ports": []interface{}{
+													map[string]interface{}{"containerPort": 8080, "protocol": "TCP"},
+												},
+											},
+										},
+									},
+								},
+							},
+							"status": map[string]interface{}{
+								"url": "http://app.default.example.com",
+							},
+						}),
+					},
+				},
+			},
+			expected: &defaultsTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(extensionsDeploy, "legacy"): rf.FromMap(map[string]interface{}{
+							"apiVersion": "extensions/v1beta1",
+							"kind":       "Deployment",
+							"metadata": map[string]interface{}{
+								"name": "legacy",
+							},
+							"spec": map[string]interface{}{
+								"revisionHistoryLimit": 10,
+								"strategy": map[string]interface{}{
+									"type": "RollingUpdate",
+								},
+							},
+						}),
+						resid.NewResId(knativeService, "app"): rf.FromMap(map[string]interface{}{
+							"apiVersion": "serving.knative.dev/v1",
+							"kind":       "Service",
+							"metadata": map[string]interface{}{
+								"name": "app",
+							},
+							"spec": map[string]interface{}{
+								"type": "ClusterIP",
+								"template": map[string]interface{}{
+									"spec": map[string]interface{}{
+										"containers": []interface{}{
+											map[string]interface{}{
+												"image": "app:1.0",
+												"ports": []interface{}{
+													map[string]interface{}{"containerPort": 8080, "protocol": "TCP"},
+												},
+											},
+										},
+									},
+								},
+							},
+						}),
+					},
+				},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := NewDefaultsTransformer(test.keepPaths).Transform(test.input.config, test.input.resources)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := pretty.Compare(test.input.config, test.expected.config); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+
+			if diff := pretty.Compare(test.input.resources, test.expected.resources); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+		})
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	kinds, err := loadDefaults(defaultsTable)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for kind, expected := range map[string]string{
+		"Pod":              "spec.containers[].readinessProbe.httpGet.scheme",
+		"apps/Deployment":  "spec.template.spec.initContainers[].ports[].protocol",
+		"batch/CronJob":    "spec.jobTemplate.spec.template.spec.containers[].terminationMessagePolicy",
+		"apps/StatefulSet": "spec.volumeClaimTemplates[].spec.volumeMode",
+	} {
+		found := false
+		for _, field := range kinds[kind] {
+			if field.path == expected {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: expected a default for '%s'", kind, expected)
+		}
+	}
+
+	for _, kind := range []string{"Deployment", "extensions/Deployment", "serving.knative.dev/Service"} {
+		if _, found := kinds[kind]; found {
+			t.Errorf("%s: expected no defaults", kind)
+		}
+	}
+
+	if _, err := loadDefaults([]byte("podSpec:\n- path: dnsPolicy\n  when: unknown\nkinds:\n  Pod:\n    podSpec: spec\n")); err == nil {
+		t.Errorf("expected an error for an unknown condition")
+	}
+}
@@ -1,67 +1,195 @@
 package transformers
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
 	"github.com/layertwo/helm-convert/pkg/types"
-	ktypes "sigs.k8s.io/kustomize/pkg/types"
+	"github.com/layertwo/helm-convert/pkg/utils"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/openapi"
 )
 
-type emptyTransformer struct{}
+// DefaultEmptyKeepPaths is the list of fields kept even when empty, their
+// empty value is meaningful. Paths are matched against the end of the field
+// path, [] match the elements of a list and * any key of a map.
+var DefaultEmptyKeepPaths = []string{
+	// volume source without option
+	"volumes[].emptyDir",
+	// security contexts are commonly patched
+	"securityContext",
+	// an empty storage class disables dynamic provisioning
+	"storageClassName",
+	// the empty API group is the core group
+	"rules[].apiGroups[]",
+	// empty selectors and rules select everything in network policies
+	"podSelector",
+	"namespaceSelector",
+	"ingress[]",
+	"egress[]",
+	// empty arguments are positional
+	"args[]",
+	"command[]",
+	// empty values are valid data, labels and annotations
+	"metadata.labels.*",
+	"metadata.annotations.*",
+	"matchLabels.*",
+	"selector.*",
+	"data.*",
+	"stringData.*",
+	"binaryData.*",
+}
+
+type emptyTransformer struct {
+	keepPaths [][]string
+}
 
 var _ Transformer = &emptyTransformer{}
 
-// NewEmptyTransformer constructs an emptyTransformer
-func NewEmptyTransformer() Transformer {
-	return &emptyTransformer{}
+// NewEmptyTransformer constructs an emptyTransformer, fields matching one of
+// keepPaths are kept even when empty
+func NewEmptyTransformer(keepPaths []string) Transformer {
+	t := &emptyTransformer{}
+	for _, p := range keepPaths {
+		t.keepPaths = append(t.keepPaths, splitPatchPath(p))
+	}
+	return t
 }
 
-// Transform remove empty maps from manifests (ie: empty labels, resources, etc.)
+// Transform remove null values, empty maps, lists and strings from manifests
+// (ie: empty labels, resources, etc.). Only the empty elements of a list are
+// removed, maps and lists emptied this way are removed as well. Each removed
+// value is recorded in the report.
 func (t *emptyTransformer) Transform(config *ktypes.Kustomization, resources *types.Resources) error {
-	for id := range resources.ResMap {
-		obj := resources.ResMap[id].Map()
+	for _, id := range utils.SortedIds(resources.ResMap) {
+		res := resources.ResMap[id]
 
-		_, err := t.emptyRecursive(obj)
-		if err != nil {
-			continue
-		}
+		t.prune(res.Map(), nil, func(path []string, value interface{}) {
+			p := emptyDisplayPath(path)
+			glog.V(8).Infof("Removed the %s '%s' of %s '%s'", describeEmpty(value), p, res.GetKind(), res.GetName())
+			resources.Report.Add(types.ReportEntry{
+				Transformer: "empty",
+				Kind:        res.GetKind(),
+				Name:        res.GetName(),
+				Path:        p,
+				Message:     fmt.Sprintf("removed %s", describeEmpty(value)),
+			})
+		})
 	}
 
 	return nil
 }
 
-func (t *emptyTransformer) emptyRecursive(obj map[string]interface{}) (bool, error) {
-	for key := range obj {
-		switch typedV := obj[key].(type) {
-		case map[string]interface{}:
-			if len(typedV) == 0 {
-				delete(obj, key)
+// prune remove the empty values nested in a value, it return the pruned value
+// and true if the value is empty and should be removed from its parent. List
+// elements are part of the path as [index]. Only the removal of values which
+// were empty before being pruned is recorded.
+func (t *emptyTransformer) prune(value interface{}, path []string,
+	removed func([]string, interface{})) (interface{}, bool) {
+
+	wasEmpty := isEmptyLeaf(value)
+
+	switch typedV := value.(type) {
+	case map[string]interface{}:
+		for _, key := range sortedInterfaceKeys(typedV) {
+			v, remove := t.prune(typedV[key], append(path[:len(path):len(path)], key), removed)
+			if remove {
+				delete(typedV, key)
 			} else {
-				d, err := t.emptyRecursive(typedV)
-				if err != nil {
-					return false, err
-				}
-				if d {
-					delete(obj, key)
-				}
+				typedV[key] = v
 			}
-		case []interface{}:
-			for i := range typedV {
-				item := typedV[i]
-				typedItem, ok := item.(map[string]interface{})
-				if ok {
-					d, err := t.emptyRecursive(typedItem)
-					if err != nil {
-						return false, err
-					}
-					if d {
-						delete(obj, key)
-					}
-				}
+		}
+	case []interface{}:
+		kept := make([]interface{}, 0, len(typedV))
+		for i, item := range typedV {
+			v, remove := t.prune(item, append(path[:len(path):len(path)], "["+strconv.Itoa(i)+"]"), removed)
+			if !remove {
+				kept = append(kept, v)
 			}
-		// Remove keys with nil value
-		case nil:
-			delete(obj, key)
 		}
+		value = kept
+	}
+
+	if len(path) == 0 || !isEmptyLeaf(value) || t.keep(path) {
+		return value, false
 	}
+	if wasEmpty {
+		removed(path, value)
+	}
+	return value, true
+}
 
-	return len(obj) == 0, nil
+// keep return true if a path match one of the paths to keep
+func (t *emptyTransformer) keep(path []string) bool {
+	for _, keepPath := range t.keepPaths {
+		if len(keepPath) > len(path) {
+			continue
+		}
+		offset := len(path) - len(keepPath)
+		matched := true
+		for i, segment := range keepPath {
+			field := path[offset+i]
+			isElement := strings.HasPrefix(field, "[")
+			switch {
+			case segment == openapi.Elements:
+				matched = isElement
+			case segment == "*":
+				matched = !isElement
+			default:
+				matched = segment == field
+			}
+			if !matched {
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmptyLeaf return true if a value is null, an empty string, map or list
+func isEmptyLeaf(value interface{}) bool {
+	switch typedV := value.(type) {
+	case nil:
+		return true
+	case string:
+		return typedV == ""
+	case map[string]interface{}:
+		return len(typedV) == 0
+	case []interface{}:
+		return len(typedV) == 0
+	}
+	return false
+}
+
+// describeEmpty return a description of an empty value
+func describeEmpty(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null value"
+	case string:
+		return "empty string"
+	case map[string]interface{}:
+		return "empty map"
+	case []interface{}:
+		return "empty list"
+	}
+	return "empty value"
+}
+
+// emptyDisplayPath join a path, list elements are appended to the field
+// holding the list (ie: spec.volumes[0].name)
+func emptyDisplayPath(path []string) string {
+	var b strings.Builder
+	for i, field := range path {
+		if i > 0 && !strings.HasPrefix(field, "[") {
+			b.WriteString(".")
+		}
+		b.WriteString(field)
+	}
+	return b.String()
 }
@@ -5,12 +5,12 @@ import (
 
 	"github.com/kylelemons/godebug/pretty"
 	"github.com/layertwo/helm-convert/pkg/types"
+	ktypes "sigs.k8s.io/kustomize/api/types"
 	"sigs.k8s.io/kustomize/k8sdeps/kunstruct"
 	"sigs.k8s.io/kustomize/pkg/gvk"
 	"sigs.k8s.io/kustomize/pkg/resid"
 	"sigs.k8s.io/kustomize/pkg/resmap"
 	"sigs.k8s.io/kustomize/pkg/resource"
-	ktypes "sigs.k8s.io/kustomize/pkg/types"
 )
 
 type emptyTransformerArgs struct {
@@ -21,6 +21,7 @@ type emptyTransformerArgs struct {
 func TestEmptyRun(t *testing.T) {
 	var ingress = gvk.Gvk{Kind: "Ingress"}
 	var deploy = gvk.Gvk{Group: "apps", Version: "v1", Kind: "Deployment"}
+	var role = gvk.Gvk{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "Role"}
 	var rf = resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
 
 	for _, test := range []struct {
@@ -161,12 +162,229 @@ func TestEmptyRun(t *testing.T) {
 								},
 							}),
 					},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "empty",
+								Kind:        "Deployment",
+								Name:        "deploy1",
+								Path:        "spec.template.metadata.labels",
+								Message:     "removed empty map",
+							},
+							{
+								Transformer: "empty",
+								Kind:        "Deployment",
+								Name:        "deploy2",
+								Path:        "spec.containers[0].resources",
+								Message:     "removed empty map",
+							},
+							{
+								Transformer: "empty",
+								Kind:        "Deployment",
+								Name:        "deploy2",
+								Path:        "spec.containers[1].env[1].value",
+								Message:     "removed null value",
+							},
+							{
+								Transformer: "empty",
+								Kind:        "Ingress",
+								Name:        "ing1",
+								Path:        "metadata.labels",
+								Message:     "removed empty map",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "it should only remove the empty elements of lists and keep meaningful empty values",
+			input: &emptyTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "deploy1"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "deploy1",
+									"annotations": map[string]interface{}{
+										"example.com/flag": "",
+									},
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"securityContext": map[string]interface{}{},
+											"volumes": []interface{}{
+												map[string]interface{}{
+													"name":     "cache",
+													"emptyDir": map[string]interface{}{},
+												},
+												map[string]interface{}{},
+												map[string]interface{}{
+													"name":      "config",
+													"configMap": map[string]interface{}{"name": "config", "items": []interface{}{}},
+												},
+											},
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name":       "app",
+													"args":       []interface{}{"--flag", ""},
+													"workingDir": "",
+												},
+											},
+											"hostAliases": []interface{}{
+												map[string]interface{}{
+													"ip":        "127.0.0.1",
+													"hostnames": []interface{}{[]interface{}{}, "", "local"},
+												},
+											},
+										},
+									},
+								},
+							}),
+						resid.NewResId(role, "role1"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "rbac.authorization.k8s.io/v1",
+								"kind":       "Role",
+								"metadata": map[string]interface{}{
+									"name": "role1",
+								},
+								"rules": []interface{}{
+									map[string]interface{}{
+										"apiGroups": []interface{}{""},
+										"resources": []interface{}{"pods"},
+										"verbs":     []interface{}{"get", ""},
+									},
+									map[string]interface{}{
+										"apiGroups": []interface{}{},
+									},
+								},
+							}),
+					},
+				},
+			},
+			expected: &emptyTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "deploy1"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "deploy1",
+									"annotations": map[string]interface{}{
+										"example.com/flag": "",
+									},
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"securityContext": map[string]interface{}{},
+											"volumes": []interface{}{
+												map[string]interface{}{
+													"name":     "cache",
+													"emptyDir": map[string]interface{}{},
+												},
+												map[string]interface{}{
+													"name":      "config",
+													"configMap": map[string]interface{}{"name": "config"},
+												},
+											},
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name": "app",
+													"args": []interface{}{"--flag", ""},
+												},
+											},
+											"hostAliases": []interface{}{
+												map[string]interface{}{
+													"ip":        "127.0.0.1",
+													"hostnames": []interface{}{"local"},
+												},
+											},
+										},
+									},
+								},
+							}),
+						resid.NewResId(role, "role1"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "rbac.authorization.k8s.io/v1",
+								"kind":       "Role",
+								"metadata": map[string]interface{}{
+									"name": "role1",
+								},
+								"rules": []interface{}{
+									map[string]interface{}{
+										"apiGroups": []interface{}{""},
+										"resources": []interface{}{"pods"},
+										"verbs":     []interface{}{"get"},
+									},
+								},
+							}),
+					},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "empty",
+								Kind:        "Deployment",
+								Name:        "deploy1",
+								Path:        "spec.template.spec.containers[0].workingDir",
+								Message:     "removed empty string",
+							},
+							{
+								Transformer: "empty",
+								Kind:        "Deployment",
+								Name:        "deploy1",
+								Path:        "spec.template.spec.hostAliases[0].hostnames[0]",
+								Message:     "removed empty list",
+							},
+							{
+								Transformer: "empty",
+								Kind:        "Deployment",
+								Name:        "deploy1",
+								Path:        "spec.template.spec.hostAliases[0].hostnames[1]",
+								Message:     "removed empty string",
+							},
+							{
+								Transformer: "empty",
+								Kind:        "Deployment",
+								Name:        "deploy1",
+								Path:        "spec.template.spec.volumes[1]",
+								Message:     "removed empty map",
+							},
+							{
+								Transformer: "empty",
+								Kind:        "Deployment",
+								Name:        "deploy1",
+								Path:        "spec.template.spec.volumes[2].configMap.items",
+								Message:     "removed empty list",
+							},
+							{
+								Transformer: "empty",
+								Kind:        "Role",
+								Name:        "role1",
+								Path:        "rules[0].verbs[1]",
+								Message:     "removed empty string",
+							},
+							{
+								Transformer: "empty",
+								Kind:        "Role",
+								Name:        "role1",
+								Path:        "rules[1].apiGroups",
+								Message:     "removed empty list",
+							},
+						},
+					},
 				},
 			},
 		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
-			lt := NewEmptyTransformer()
+			lt := NewEmptyTransformer(DefaultEmptyKeepPaths)
 			err := lt.Transform(test.input.config, test.input.resources)
 
 			if err != nil {
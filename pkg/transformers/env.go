@@ -0,0 +1,293 @@
+package transformers
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/golang/glog"
+	"github.com/layertwo/helm-convert/pkg/types"
+	"github.com/layertwo/helm-convert/pkg/utils"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/pkg/resource"
+)
+
+// Environment variable names which usually hold a credential
+var regexpSensitiveEnv = regexp.MustCompile(
+	`(?i)(^|_)(PASSWORD|PASSWD|PASS|SECRET|TOKEN|API_?KEY|ACCESS_?KEY|PRIVATE_?KEY|CREDENTIALS?)$`)
+
+// Reference to another variable, ie: $(VAR), expanded by the kubelet
+var regexpEnvReference = regexp.MustCompile(`\$\([A-Za-z_][A-Za-z0-9_.-]*\)`)
+
+type envTransformer struct {
+	enabled bool
+}
+
+var _ Transformer = &envTransformer{}
+
+// NewEnvTransformer constructs an envTransformer, the environment variables
+// are only moved if enabled is true.
+func NewEnvTransformer(enabled bool) Transformer {
+	return &envTransformer{enabled}
+}
+
+// Transform move the literal environment variables of containers to a
+// ConfigMap, or a Secret if their name looks like a credential or their value
+// is generated on every render or looks random, referenced with envFrom. The ConfigMap and
+// Secret resources are later converted to generators by the
+// configMapTransformer and secretTransformer.
+func (t *envTransformer) Transform(config *ktypes.Kustomization, resources *types.Resources) error {
+	if !t.enabled {
+		return nil
+	}
+
+	var generated []*resource.Resource
+
+	for _, id := range utils.SortedIds(resources.ResMap) {
+		res := resources.ResMap[id]
+		kind, err := res.GetFieldValue("kind")
+		if err != nil || kind == "ConfigMap" || kind == "Secret" {
+			continue
+		}
+
+		name, err := res.GetFieldValue("metadata.name")
+		if err != nil {
+			continue
+		}
+		namespace, _ := res.GetFieldValue("metadata.namespace")
+
+		nonDeterministic := nonDeterministicEnv(resources, res)
+
+		for _, container := range findContainers(res.Map()) {
+			containerName, _ := container["name"].(string)
+
+			data, secretData, ok := extractEnv(container, func(envName string) bool {
+				_, found := nonDeterministic[containerName+"/"+envName]
+				return found
+			})
+			if !ok {
+				glog.V(8).Infof("Keeping environment variables of the container '%s' from '%s'",
+					containerName, name)
+				continue
+			}
+
+			var envFrom []interface{}
+			if existing, ok := container["envFrom"].([]interface{}); ok {
+				envFrom = existing
+			}
+
+			// envFrom sources are appended so that they take precedence over
+			// the existing ones, like env used to
+			envName := fmt.Sprintf("%s-%s-env", name, containerName)
+			if len(data) > 0 {
+				configMap := envResource("ConfigMap", "data", envName, namespace, data, resources, generated)
+				generated = append(generated, configMap)
+				envFrom = append(envFrom, map[string]interface{}{
+					"configMapRef": map[string]interface{}{"name": configMap.GetName()},
+				})
+			}
+			if len(secretData) > 0 {
+				secret := envResource("Secret", "stringData", envName, namespace, secretData, resources, generated)
+				generated = append(generated, secret)
+				envFrom = append(envFrom, map[string]interface{}{
+					"secretRef": map[string]interface{}{"name": secret.GetName()},
+				})
+
+				// the generated values are now handled as secret keys by the
+				// nonDeterministicTransformer
+				for envName := range secretData {
+					if i, found := nonDeterministic[containerName+"/"+envName]; found {
+						resources.NonDeterministic[i] = types.ResourceField{
+							Kind:      "Secret",
+							Name:      secret.GetName(),
+							Namespace: namespace,
+							Path:      []string{"stringData", envName},
+						}
+					}
+				}
+			}
+
+			container["envFrom"] = envFrom
+
+			glog.V(8).Infof("Moved %d literal(s) and %d sensitive literal(s) from the container '%s' of '%s' "+
+				"to envFrom", len(data), len(secretData), containerName, name)
+		}
+	}
+
+	for _, res := range generated {
+		resources.ResMap[res.Id()] = res
+	}
+
+	return nil
+}
+
+// extractEnv remove the literal environment variables of a container and
+// return them, sensitive values being returned separately. Containers are
+// left untouched if there is nothing to extract or if the order of the
+// variables matters ($(VAR) references, duplicated names, non literal value).
+func extractEnv(container map[string]interface{}, generated func(name string) bool) (
+	data, secretData map[string]string, ok bool) {
+	env, found := container["env"].([]interface{})
+	if !found || len(env) == 0 {
+		return nil, nil, false
+	}
+
+	data = make(map[string]string)
+	secretData = make(map[string]string)
+	seen := make(map[string]struct{}, len(env))
+	var kept []interface{}
+
+	for _, item := range env {
+		envVar, isMap := item.(map[string]interface{})
+		if !isMap {
+			return nil, nil, false
+		}
+
+		name, isString := envVar["name"].(string)
+		if !isString {
+			return nil, nil, false
+		}
+		if _, duplicated := seen[name]; duplicated {
+			return nil, nil, false
+		}
+		seen[name] = struct{}{}
+
+		if _, found := envVar["valueFrom"]; found {
+			kept = append(kept, item)
+			continue
+		}
+
+		var value string
+		if v, found := envVar["value"]; found && v != nil {
+			if value, isString = v.(string); !isString {
+				return nil, nil, false
+			}
+		}
+
+		if regexpEnvReference.MatchString(value) {
+			return nil, nil, false
+		}
+
+		if regexpSensitiveEnv.MatchString(name) || generated(name) || utils.LooksRandom(value) {
+			secretData[name] = value
+		} else {
+			data[name] = value
+		}
+	}
+
+	if len(data) == 0 && len(secretData) == 0 {
+		return nil, nil, false
+	}
+
+	if len(kept) > 0 {
+		container["env"] = kept
+	} else {
+		delete(container, "env")
+	}
+
+	return data, secretData, true
+}
+
+// nonDeterministicEnv return the index of the non-deterministic fields of a
+// resource holding the value of an environment variable, indexed by the name
+// of the container and of the variable (ie: server/SESSION_KEY)
+func nonDeterministicEnv(resources *types.Resources, res *resource.Resource) map[string]int {
+	namespace, _ := res.GetFieldValue("metadata.namespace")
+	fields := make(map[string]int)
+
+	for i, field := range resources.NonDeterministic {
+		n := len(field.Path)
+		if field.Kind != res.GetKind() || field.Name != res.GetName() || field.Namespace != namespace ||
+			n < 4 || field.Path[n-3] != "env" || field.Path[n-1] != "value" {
+			continue
+		}
+
+		container, _ := lookupField(res.Map(), field.Path[:n-3])
+		envVar, _ := lookupField(res.Map(), field.Path[:n-1])
+		containerMap, isContainer := container.(map[string]interface{})
+		envVarMap, isEnvVar := envVar.(map[string]interface{})
+		if !isContainer || !isEnvVar {
+			continue
+		}
+
+		containerName, _ := containerMap["name"].(string)
+		envName, _ := envVarMap["name"].(string)
+		fields[containerName+"/"+envName] = i
+	}
+
+	return fields
+}
+
+// envResource return a ConfigMap or Secret holding the given data, the name is
+// suffixed if it is already used by another resource
+func envResource(kind, field, name, namespace string, data map[string]string,
+	resources *types.Resources, generated []*resource.Resource) *resource.Resource {
+
+	taken := func(n string) bool {
+		isTaken := func(res *resource.Resource) bool {
+			resNamespace, _ := res.GetFieldValue("metadata.namespace")
+			return res.GetKind() == kind && res.GetName() == n && resNamespace == namespace
+		}
+		for _, res := range generated {
+			if isTaken(res) {
+				return true
+			}
+		}
+		for _, res := range resources.ResMap {
+			if isTaken(res) {
+				return true
+			}
+		}
+		return false
+	}
+
+	resourceName := name
+	for i := 1; taken(resourceName); i++ {
+		resourceName = fmt.Sprintf("%s-%d", name, i)
+	}
+
+	metadata := map[string]interface{}{
+		"name": resourceName,
+	}
+	if namespace != "" {
+		metadata["namespace"] = namespace
+	}
+
+	return resourceFactory.FromMap(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       kind,
+		"metadata":   metadata,
+		field:        stringMapToInterface(data),
+	})
+}
+
+// findContainers return the containers and init containers found in a
+// resource, whatever the depth of the pod template
+func findContainers(obj map[string]interface{}) (containers []map[string]interface{}) {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		switch typedV := obj[key].(type) {
+		case map[string]interface{}:
+			containers = append(containers, findContainers(typedV)...)
+		case []interface{}:
+			isContainerList := key == "containers" || key == "initContainers"
+			for _, item := range typedV {
+				typedItem, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if isContainerList {
+					containers = append(containers, typedItem)
+				} else {
+					containers = append(containers, findContainers(typedItem)...)
+				}
+			}
+		}
+	}
+	return
+}
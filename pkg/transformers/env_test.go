@@ -0,0 +1,695 @@
+package transformers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/layertwo/helm-convert/pkg/types"
+	"github.com/layertwo/helm-convert/pkg/utils"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/k8sdeps/kunstruct"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/kustomize/pkg/gvk"
+	"sigs.k8s.io/kustomize/pkg/resid"
+	"sigs.k8s.io/kustomize/pkg/resmap"
+	"sigs.k8s.io/kustomize/pkg/resource"
+)
+
+type envTransformerArgs struct {
+	config    *ktypes.Kustomization
+	resources *types.Resources
+}
+
+func TestEnvRun(t *testing.T) {
+	var deploy = gvk.Gvk{Group: "apps", Version: "v1", Kind: "Deployment"}
+	var configmap = gvk.Gvk{Version: "v1", Kind: "ConfigMap"}
+	var secret = gvk.Gvk{Version: "v1", Kind: "Secret"}
+	var rf = resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
+
+	for _, test := range []struct {
+		name     string
+		disabled bool
+		input    *envTransformerArgs
+		expected *envTransformerArgs
+	}{
+		{
+			name: "it should move literal environment variables to envFrom",
+			input: &envTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResIdWithPrefixNamespace(deploy, "app", "", "web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name":      "app",
+									"namespace": "web",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name": "server",
+													"env": []interface{}{
+														map[string]interface{}{"name": "NODE_ENV", "value": "production"},
+														map[string]interface{}{"name": "DB_PASSWORD", "value": "admin"},
+														map[string]interface{}{"name": "EMPTY"},
+														map[string]interface{}{
+															"name": "POD_IP",
+															"valueFrom": map[string]interface{}{
+																"fieldRef": map[string]interface{}{"fieldPath": "status.podIP"},
+															},
+														},
+													},
+													"envFrom": []interface{}{
+														map[string]interface{}{
+															"configMapRef": map[string]interface{}{"name": "common"},
+														},
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+				},
+			},
+			expected: &envTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResIdWithPrefixNamespace(deploy, "app", "", "web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name":      "app",
+									"namespace": "web",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name": "server",
+													"env": []interface{}{
+														map[string]interface{}{
+															"name": "POD_IP",
+															"valueFrom": map[string]interface{}{
+																"fieldRef": map[string]interface{}{"fieldPath": "status.podIP"},
+															},
+														},
+													},
+													"envFrom": []interface{}{
+														map[string]interface{}{
+															"configMapRef": map[string]interface{}{"name": "common"},
+														},
+														map[string]interface{}{
+															"configMapRef": map[string]interface{}{"name": "app-server-env"},
+														},
+														map[string]interface{}{
+															"secretRef": map[string]interface{}{"name": "app-server-env"},
+														},
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+						resid.NewResIdWithPrefixNamespace(configmap, "app-server-env", "", "web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "ConfigMap",
+								"metadata": map[string]interface{}{
+									"name":      "app-server-env",
+									"namespace": "web",
+								},
+								"data": map[string]interface{}{
+									"EMPTY":    "",
+									"NODE_ENV": "production",
+								},
+							}),
+						resid.NewResIdWithPrefixNamespace(secret, "app-server-env", "", "web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name":      "app-server-env",
+									"namespace": "web",
+								},
+								"stringData": map[string]interface{}{
+									"DB_PASSWORD": "admin",
+								},
+							}),
+					},
+				},
+			},
+		},
+		{
+			name: "it should move the generated values to the Secret and avoid name collisions",
+			input: &envTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "app",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"initContainers": []interface{}{
+												map[string]interface{}{
+													"name": "init",
+													"env": []interface{}{
+														map[string]interface{}{"name": "COMMIT", "value": "a1b2c3d"},
+														map[string]interface{}{"name": "SESSION", "value": "x7KqP2mZr9"},
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+						resid.NewResId(secret, "app-init-env"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name": "app-init-env",
+								},
+							}),
+					},
+					NonDeterministic: []types.ResourceField{
+						{
+							Kind: "Deployment",
+							Name: "app",
+							Path: []string{"spec", "template", "spec", "initContainers", "[0]", "env", "[1]", "value"},
+						},
+					},
+				},
+			},
+			expected: &envTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "app",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"initContainers": []interface{}{
+												map[string]interface{}{
+													"name": "init",
+													"envFrom": []interface{}{
+														map[string]interface{}{
+															"configMapRef": map[string]interface{}{"name": "app-init-env"},
+														},
+														map[string]interface{}{
+															"secretRef": map[string]interface{}{"name": "app-init-env-1"},
+														},
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+						resid.NewResId(configmap, "app-init-env"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "ConfigMap",
+								"metadata": map[string]interface{}{
+									"name": "app-init-env",
+								},
+								"data": map[string]interface{}{
+									"COMMIT": "a1b2c3d",
+								},
+							}),
+						resid.NewResId(secret, "app-init-env"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name": "app-init-env",
+								},
+							}),
+						resid.NewResId(secret, "app-init-env-1"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name": "app-init-env-1",
+								},
+								"stringData": map[string]interface{}{
+									"SESSION": "x7KqP2mZr9",
+								},
+							}),
+					},
+					NonDeterministic: []types.ResourceField{
+						{
+							Kind: "Secret",
+							Name: "app-init-env-1",
+							Path: []string{"stringData", "SESSION"},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "it should move the values which look random to the Secret",
+			input: &envTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "app",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name": "server",
+													"env": []interface{}{
+														map[string]interface{}{"name": "BUILD", "value": "20240101"},
+														map[string]interface{}{"name": "COOKIE_SEED", "value": "Zx8Qm2Lp9Tr4Vw7N"},
+														map[string]interface{}{"name": "NODE_ENV", "value": "production"},
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+				},
+			},
+			expected: &envTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "app",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name": "server",
+													"envFrom": []interface{}{
+														map[string]interface{}{
+															"configMapRef": map[string]interface{}{"name": "app-server-env"},
+														},
+														map[string]interface{}{
+															"secretRef": map[string]interface{}{"name": "app-server-env"},
+														},
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+						resid.NewResId(configmap, "app-server-env"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "ConfigMap",
+								"metadata": map[string]interface{}{
+									"name": "app-server-env",
+								},
+								"data": map[string]interface{}{
+									"BUILD":    "20240101",
+									"NODE_ENV": "production",
+								},
+							}),
+						resid.NewResId(secret, "app-server-env"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name": "app-server-env",
+								},
+								"stringData": map[string]interface{}{
+									"COOKIE_SEED": "Zx8Qm2Lp9Tr4Vw7N",
+								},
+							}),
+					},
+				},
+			},
+		},
+		{
+			name:     "it should leave containers alone if disabled",
+			disabled: true,
+			input: &envTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "app",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name": "server",
+													"env": []interface{}{
+														map[string]interface{}{"name": "NODE_ENV", "value": "production"},
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+				},
+			},
+			expected: &envTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "app",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name": "server",
+													"env": []interface{}{
+														map[string]interface{}{"name": "NODE_ENV", "value": "production"},
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+				},
+			},
+		},
+		{
+			name: "it should leave containers using variable references alone",
+			input: &envTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "app",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name": "server",
+													"env": []interface{}{
+														map[string]interface{}{"name": "HOST", "value": "localhost"},
+														map[string]interface{}{"name": "URL", "value": "http://$(HOST):8080"},
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+				},
+			},
+			expected: &envTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "app",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name": "server",
+													"env": []interface{}{
+														map[string]interface{}{"name": "HOST", "value": "localhost"},
+														map[string]interface{}{"name": "URL", "value": "http://$(HOST):8080"},
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+				},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := NewEnvTransformer(!test.disabled).Transform(test.input.config, test.input.resources)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := pretty.Compare(test.input.config, test.expected.config); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+
+			if diff := pretty.Compare(test.input.resources, test.expected.resources); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+		})
+	}
+}
+
+// resolveEnv return the environment of each container of the app Deployment
+// as seen by the kubelet, sources from envFrom are applied in order then
+// overridden by env. Variables using valueFrom are returned as their source.
+func resolveEnv(t *testing.T, objects map[string]interface{}, deployment string) map[string]map[string]string {
+	obj, _ := objects["Deployment/"+deployment].(map[string]interface{})
+	output := make(map[string]map[string]string)
+
+	for _, container := range findContainers(obj) {
+		env := make(map[string]string)
+
+		envFrom, _ := container["envFrom"].([]interface{})
+		for _, item := range envFrom {
+			source, _ := item.(map[string]interface{})
+			for field, kind := range map[string]string{"configMapRef": "ConfigMap", "secretRef": "Secret"} {
+				ref, found := source[field].(map[string]interface{})
+				if !found {
+					continue
+				}
+				res, found := objects[kind+"/"+ref["name"].(string)].(map[string]interface{})
+				if !found {
+					t.Fatalf("the %s '%s' referenced by the container '%s' doesn't exist", kind, ref["name"], container["name"])
+				}
+				data, _ := res["data"].(map[string]interface{})
+				for key, value := range data {
+					value := value.(string)
+					if kind == "Secret" {
+						decoded, err := base64.StdEncoding.DecodeString(value)
+						if err != nil {
+							t.Fatalf("unexpected error: %v", err)
+						}
+						value = string(decoded)
+					}
+					env[key] = value
+				}
+			}
+		}
+
+		vars, _ := container["env"].([]interface{})
+		for _, item := range vars {
+			envVar, _ := item.(map[string]interface{})
+			if valueFrom, found := envVar["valueFrom"]; found {
+				env[envVar["name"].(string)] = fmt.Sprintf("valueFrom: %v", valueFrom)
+				continue
+			}
+			value, _ := envVar["value"].(string)
+			env[envVar["name"].(string)] = value
+		}
+
+		output[container["name"].(string)] = env
+	}
+
+	return output
+}
+
+func TestEnvRoundTrip(t *testing.T) {
+	var configmap = gvk.Gvk{Version: "v1", Kind: "ConfigMap"}
+	var deploy = gvk.Gvk{Group: "apps", Version: "v1", Kind: "Deployment"}
+	var rf = resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
+
+	config := &ktypes.Kustomization{}
+	resources := types.NewResources()
+	resources.ResMap = resmap.ResMap{
+		resid.NewResId(configmap, "common"): rf.FromMap(
+			map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata": map[string]interface{}{
+					"name": "common",
+				},
+				"data": map[string]interface{}{
+					"LOG_LEVEL": "info",
+					"NODE_ENV":  "development",
+				},
+			}),
+		resid.NewResId(deploy, "app"): rf.FromMap(
+			map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"metadata": map[string]interface{}{
+					"name": "app",
+				},
+				"spec": map[string]interface{}{
+					"template": map[string]interface{}{
+						"spec": map[string]interface{}{
+							"initContainers": []interface{}{
+								map[string]interface{}{
+									"name": "init",
+									"env": []interface{}{
+										map[string]interface{}{
+											"name":  "API_TOKEN",
+											"value": "abc123",
+										},
+									},
+								},
+							},
+							"containers": []interface{}{
+								map[string]interface{}{
+									"name": "server",
+									"envFrom": []interface{}{
+										map[string]interface{}{
+											"configMapRef": map[string]interface{}{
+												"name": "common",
+											},
+										},
+									},
+									"env": []interface{}{
+										map[string]interface{}{
+											"name":  "NODE_ENV",
+											"value": "production",
+										},
+										map[string]interface{}{
+											"name":  "OPTS",
+											"value": "-Dkey=\"a=b\" -Dother=c",
+										},
+										map[string]interface{}{
+											"name": "EMPTY",
+										},
+										map[string]interface{}{
+											"name":  "DB_PASSWORD",
+											"value": "admin",
+										},
+										map[string]interface{}{
+											"name": "POD_IP",
+											"valueFrom": map[string]interface{}{
+												"fieldRef": map[string]interface{}{
+													"fieldPath": "status.podIP",
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			}),
+	}
+
+	objects := make(map[string]interface{})
+	for _, res := range resources.ResMap {
+		objects[res.GetKind()+"/"+res.GetName()] = res.Map()
+	}
+	expected := resolveEnv(t, objects, "app")
+
+	for _, transformer := range []Transformer{
+		NewEnvTransformer(true),
+		NewConfigMapTransformer(),
+		NewSecretTransformer(SecretOptions{}),
+	} {
+		if err := transformer.Transform(config, resources); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	obj := resources.ResMap[resid.NewResId(deploy, "app")].Map()
+	for _, container := range findContainers(obj) {
+		if _, found := container["envFrom"]; !found {
+			t.Errorf("expected the variables of the container '%s' to be moved to envFrom", container["name"])
+		}
+	}
+
+	fs := filesys.MakeFsInMemory()
+	for id, res := range resources.ResMap {
+		output, err := yaml.Marshal(res.Map())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		filename, err := utils.GetResourceFileName(id, res)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		fs.WriteFile(path.Join("/app", filename), []byte(output))
+		config.Resources = append(config.Resources, filename)
+	}
+	for filename, content := range resources.SourceFiles {
+		fs.WriteFile(path.Join("/app", filename), []byte(content))
+	}
+
+	output, err := kustomizeBuild(fs, config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if diff := pretty.Compare(resolveEnv(t, output, "app"), expected); diff != "" {
+		t.Errorf("environment diff: (-got +want)\n%s", diff)
+	}
+}
@@ -0,0 +1,481 @@
+package transformers
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"github.com/layertwo/helm-convert/pkg/types"
+	"github.com/layertwo/helm-convert/pkg/utils"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/pkg/resource"
+)
+
+const (
+	// DefaultGatewayDir is the directory holding the Gateway listener patch
+	DefaultGatewayDir = "gateway"
+
+	// DefaultKustomizeConfigDir is the directory holding the kustomize
+	// transformer configurations
+	DefaultKustomizeConfigDir = "kustomizeconfig"
+
+	// gatewayAPIVersion is the API version of the generated routes
+	gatewayAPIVersion = "gateway.networking.k8s.io/v1"
+
+	// gatewayHTTPSPort is the port of the generated HTTPS listeners
+	gatewayHTTPSPort = 443
+)
+
+// gatewayNameReference let kustomize update the service names referenced by
+// HTTPRoute backends, ie: when a namePrefix is set
+const gatewayNameReference = `nameReference:
+- kind: Service
+  fieldSpecs:
+  - kind: HTTPRoute
+    group: gateway.networking.k8s.io
+    path: spec/rules/backendRefs/name
+`
+
+// gatewayIgnoredAnnotations are translated by the parent reference of the
+// routes
+var gatewayIgnoredAnnotations = map[string]struct{}{
+	"kubernetes.io/ingress.class": {},
+}
+
+// gatewayPathTypes map the pathType of an Ingress to a HTTPRoute path match
+var gatewayPathTypes = map[string]string{
+	"Prefix":                 "PathPrefix",
+	"Exact":                  "Exact",
+	"ImplementationSpecific": "PathPrefix",
+}
+
+// GatewayOptions define the Gateway the generated routes are attached to
+type GatewayOptions struct {
+	// Name of the parent Gateway, the transformer does nothing if empty
+	Name string
+
+	// Namespace of the parent Gateway, the namespace of the route if empty
+	Namespace string
+}
+
+type gatewayTransformer struct {
+	options GatewayOptions
+}
+
+var _ Transformer = &gatewayTransformer{}
+
+// NewGatewayTransformer constructs a gatewayTransformer.
+func NewGatewayTransformer(options GatewayOptions) Transformer {
+	return &gatewayTransformer{options}
+}
+
+// gatewayRoute is a route being built from the rules of an Ingress sharing
+// the same host
+type gatewayRoute struct {
+	host  string
+	rules []interface{}
+}
+
+// Transform replace Ingress resources by HTTPRoute resources attached to the
+// configured Gateway. TLS settings are written to a JSON patch adding the
+// listeners to the Gateway, annotations which can't be translated are
+// reported.
+func (t *gatewayTransformer) Transform(config *ktypes.Kustomization, resources *types.Resources) error {
+	if t.options.Name == "" {
+		return nil
+	}
+
+	var listeners []interface{}
+	converted := 0
+
+	for _, id := range utils.SortedIds(resources.ResMap) {
+		res := resources.ResMap[id]
+		if res.GetKind() != "Ingress" {
+			continue
+		}
+
+		report := func(path, message string) {
+			resources.Report.Add(types.ReportEntry{
+				Transformer: "gateway",
+				Kind:        res.GetKind(),
+				Name:        res.GetName(),
+				Path:        path,
+				Message:     message,
+			})
+		}
+
+		// legacy versions are converted to the networking.k8s.io/v1 structure
+		obj := deepCopyValue(res.Map()).(map[string]interface{})
+		if id.Gvk().Group != "networking.k8s.io" || id.Gvk().Version != "v1" {
+			if _, err := migrateIngress(obj); err != nil {
+				report("", fmt.Sprintf("can't be converted to a HTTPRoute: %v", err))
+				continue
+			}
+		}
+
+		namespace, _ := res.GetFieldValue("metadata.namespace")
+		filters := t.convertAnnotations(obj, report)
+		routes := t.convertRules(obj, namespace, resources, filters, report)
+
+		for _, route := range routes {
+			routeRes := t.newRoute(res, obj, namespace, route, len(routes))
+			resources.ResMap[routeRes.Id()] = routeRes
+		}
+
+		listeners = t.convertTLS(obj, res.GetName(), namespace, listeners, report)
+
+		delete(resources.ResMap, id)
+		converted++
+		glog.V(8).Infof("Converted Ingress '%s' to %d HTTPRoute(s)", res.GetName(), len(routes))
+	}
+
+	if converted == 0 {
+		return nil
+	}
+
+	filePath := addSourceFile(resources.SourceFiles, DefaultKustomizeConfigDir, "gateway.yaml", gatewayNameReference)
+	config.Configurations = append(config.Configurations, filePath)
+
+	if len(listeners) > 0 {
+		var operations []interface{}
+		for _, listener := range listeners {
+			operations = append(operations, map[string]interface{}{
+				"op":    "add",
+				"path":  "/spec/listeners/-",
+				"value": listener,
+			})
+		}
+		output, err := yaml.Marshal(operations)
+		if err != nil {
+			return err
+		}
+
+		gateway := t.options.Name
+		if t.options.Namespace != "" {
+			gateway = t.options.Namespace + "/" + gateway
+		}
+		filePath := addSourceFile(resources.SourceFiles, DefaultGatewayDir, t.options.Name+"-listeners.yaml", string(output))
+		resources.Report.Add(types.ReportEntry{
+			Transformer: "gateway",
+			Kind:        "Gateway",
+			Name:        gateway,
+			Path:        filePath,
+			Message:     "the HTTPS listeners must be added to the Gateway by applying this JSON patch",
+		})
+	}
+
+	return nil
+}
+
+// convertAnnotations return the filters translated from the annotations of an
+// Ingress, the others are reported
+func (t *gatewayTransformer) convertAnnotations(obj map[string]interface{},
+	report func(string, string)) []interface{} {
+
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+
+	var filters []interface{}
+	for _, key := range sortedInterfaceKeys(annotations) {
+		if _, ignored := gatewayIgnoredAnnotations[key]; ignored {
+			continue
+		}
+
+		value, _ := annotations[key].(string)
+		if key == "nginx.ingress.kubernetes.io/rewrite-target" && !strings.Contains(value, "$") {
+			filters = append(filters, map[string]interface{}{
+				"type": "URLRewrite",
+				"urlRewrite": map[string]interface{}{
+					"path": map[string]interface{}{
+						"type":               "ReplacePrefixMatch",
+						"replacePrefixMatch": value,
+					},
+				},
+			})
+			continue
+		}
+
+		report("metadata.annotations."+key, fmt.Sprintf("the annotation '%s' can't be translated to the HTTPRoute", value))
+	}
+
+	return filters
+}
+
+// convertRules group the paths of an Ingress by host, each group becomes a
+// route. The default backend is added to the routes without host.
+func (t *gatewayTransformer) convertRules(obj map[string]interface{}, namespace string, resources *types.Resources,
+	filters []interface{}, report func(string, string)) []*gatewayRoute {
+
+	spec, _ := obj["spec"].(map[string]interface{})
+
+	var routes []*gatewayRoute
+	routeFor := func(host string) *gatewayRoute {
+		for _, route := range routes {
+			if route.host == host {
+				return route
+			}
+		}
+		route := &gatewayRoute{host: host}
+		routes = append(routes, route)
+		return route
+	}
+
+	rules, _ := spec["rules"].([]interface{})
+	for i, r := range rules {
+		rule, _ := r.(map[string]interface{})
+		host, _ := rule["host"].(string)
+		http, _ := rule["http"].(map[string]interface{})
+		paths, _ := http["paths"].([]interface{})
+
+		for j, p := range paths {
+			ingressPath, _ := p.(map[string]interface{})
+			fieldPath := fmt.Sprintf("spec.rules[%d].http.paths[%d]", i, j)
+
+			value, _ := ingressPath["path"].(string)
+			if value == "" {
+				value = "/"
+			}
+			pathType, _ := ingressPath["pathType"].(string)
+			matchType, found := gatewayPathTypes[pathType]
+			if !found {
+				report(fieldPath, fmt.Sprintf("unknown pathType '%s', the path is skipped", pathType))
+				continue
+			}
+			if pathType == "ImplementationSpecific" {
+				report(fieldPath, fmt.Sprintf("the ImplementationSpecific path '%s' is matched as a prefix", value))
+			}
+
+			backendRef, err := t.backendRef(ingressPath["backend"], namespace, resources)
+			if err != nil {
+				report(fieldPath, fmt.Sprintf("%v, the path is skipped", err))
+				continue
+			}
+
+			routeRule := map[string]interface{}{
+				"matches": []interface{}{
+					map[string]interface{}{
+						"path": map[string]interface{}{"type": matchType, "value": value},
+					},
+				},
+				"backendRefs": []interface{}{backendRef},
+			}
+			// ReplacePrefixMatch is only valid with a prefix match
+			if len(filters) > 0 && matchType == "PathPrefix" {
+				routeRule["filters"] = deepCopyValue(filters)
+			} else if len(filters) > 0 {
+				report(fieldPath, fmt.Sprintf("the rewrite-target annotation can't be applied to the %s path '%s', "+
+					"the path isn't rewritten", matchType, value))
+			}
+
+			route := routeFor(host)
+			route.rules = append(route.rules, routeRule)
+		}
+	}
+
+	if backend, found := spec["defaultBackend"]; found {
+		backendRef, err := t.backendRef(backend, namespace, resources)
+		if err != nil {
+			report("spec.defaultBackend", fmt.Sprintf("%v, the default backend is skipped", err))
+		} else {
+			route := routeFor("")
+			route.rules = append(route.rules, map[string]interface{}{
+				"matches": []interface{}{
+					map[string]interface{}{
+						"path": map[string]interface{}{"type": "PathPrefix", "value": "/"},
+					},
+				},
+				"backendRefs": []interface{}{backendRef},
+			})
+		}
+	}
+
+	return routes
+}
+
+// backendRef convert an Ingress backend to a HTTPRoute backend reference,
+// named ports are resolved with the ports of the Service
+func (t *gatewayTransformer) backendRef(value interface{}, namespace string,
+	resources *types.Resources) (map[string]interface{}, error) {
+
+	backend, _ := value.(map[string]interface{})
+	service, _ := backend["service"].(map[string]interface{})
+	if service == nil {
+		return nil, fmt.Errorf("only service backends can be converted")
+	}
+
+	name, _ := service["name"].(string)
+	port, _ := service["port"].(map[string]interface{})
+	if number, found := port["number"]; found {
+		return map[string]interface{}{"name": name, "port": number}, nil
+	}
+
+	portName, _ := port["name"].(string)
+	for _, res := range resources.ResMap {
+		resNamespace, _ := res.GetFieldValue("metadata.namespace")
+		if res.GetKind() != "Service" || res.GetName() != name || resNamespace != namespace {
+			continue
+		}
+		spec, _ := res.Map()["spec"].(map[string]interface{})
+		ports, _ := spec["ports"].([]interface{})
+		for _, p := range ports {
+			servicePort, _ := p.(map[string]interface{})
+			if servicePort["name"] == portName {
+				return map[string]interface{}{"name": name, "port": servicePort["port"]}, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("the port '%s' of the service '%s' can't be resolved to a number", portName, name)
+}
+
+// newRoute return the HTTPRoute of a group of rules, routes are suffixed with
+// their host when an Ingress has several of them
+func (t *gatewayTransformer) newRoute(res *resource.Resource, obj map[string]interface{}, namespace string,
+	route *gatewayRoute, count int) *resource.Resource {
+
+	name := res.GetName()
+	if count > 1 {
+		suffix := "default"
+		if route.host != "" {
+			suffix = gatewayHostName(route.host)
+		}
+		name = name + "-" + suffix
+	}
+
+	metadata := map[string]interface{}{"name": name}
+	if namespace != "" {
+		metadata["namespace"] = namespace
+	}
+	if ingressMetadata, ok := obj["metadata"].(map[string]interface{}); ok {
+		if labels, ok := ingressMetadata["labels"]; ok {
+			metadata["labels"] = deepCopyValue(labels)
+		}
+	}
+
+	parentRef := map[string]interface{}{"name": t.options.Name}
+	if t.options.Namespace != "" {
+		parentRef["namespace"] = t.options.Namespace
+	}
+
+	spec := map[string]interface{}{
+		"parentRefs": []interface{}{parentRef},
+		"rules":      route.rules,
+	}
+	if route.host != "" {
+		spec["hostnames"] = []interface{}{route.host}
+	}
+
+	return resourceFactory.FromMap(map[string]interface{}{
+		"apiVersion": gatewayAPIVersion,
+		"kind":       "HTTPRoute",
+		"metadata":   metadata,
+		"spec":       spec,
+	})
+}
+
+// convertTLS add a HTTPS listener for each host of the TLS settings of an
+// Ingress, certificates of another namespace require a ReferenceGrant. The
+// listeners of a hostname already served by another Ingress are merged, the
+// Gateway refuses listeners sharing a port and a hostname.
+func (t *gatewayTransformer) convertTLS(obj map[string]interface{}, name, namespace string, listeners []interface{},
+	report func(string, string)) []interface{} {
+
+	spec, _ := obj["spec"].(map[string]interface{})
+	tlsList, _ := spec["tls"].([]interface{})
+
+	for i, item := range tlsList {
+		tls, _ := item.(map[string]interface{})
+		secretName, _ := tls["secretName"].(string)
+		if secretName == "" {
+			report(fmt.Sprintf("spec.tls[%d]", i), "no secretName, the listener is skipped")
+			continue
+		}
+
+		certificateRef := map[string]interface{}{"kind": "Secret", "name": secretName}
+		if namespace != "" && t.options.Namespace != "" && namespace != t.options.Namespace {
+			certificateRef["namespace"] = namespace
+			report(fmt.Sprintf("spec.tls[%d]", i), fmt.Sprintf("the Secret '%s' is referenced from the namespace "+
+				"of the Gateway, a ReferenceGrant is required", secretName))
+		}
+
+		hosts, _ := tls["hosts"].([]interface{})
+		var hostnames []string
+		for _, h := range hosts {
+			if host, ok := h.(string); ok {
+				hostnames = append(hostnames, host)
+			}
+		}
+		sort.Strings(hostnames)
+		if len(hostnames) == 0 {
+			hostnames = []string{""}
+		}
+
+		for _, host := range hostnames {
+			if existing := gatewayListener(listeners, host); existing != nil {
+				tls, _ := existing["tls"].(map[string]interface{})
+				refs, _ := tls["certificateRefs"].([]interface{})
+				if !containsValue(refs, certificateRef) {
+					tls["certificateRefs"] = append(refs, deepCopyValue(certificateRef))
+					report(fmt.Sprintf("spec.tls[%d]", i), fmt.Sprintf("the listener '%s' already serves the "+
+						"hostname '%s' with another certificate, the Secret '%s' is added to its certificateRefs",
+						existing["name"], host, secretName))
+				}
+				continue
+			}
+
+			listenerName := "https-" + name
+			if host != "" {
+				listenerName = "https-" + gatewayHostName(host)
+			}
+
+			listener := map[string]interface{}{
+				"name":     listenerName,
+				"port":     gatewayHTTPSPort,
+				"protocol": "HTTPS",
+				"tls": map[string]interface{}{
+					"mode":            "Terminate",
+					"certificateRefs": []interface{}{deepCopyValue(certificateRef)},
+				},
+				"allowedRoutes": map[string]interface{}{
+					"namespaces": map[string]interface{}{"from": "All"},
+				},
+			}
+			if host != "" {
+				listener["hostname"] = host
+			}
+			listeners = append(listeners, listener)
+		}
+	}
+
+	return listeners
+}
+
+// gatewayListener return the listener of a hostname, empty for the listeners
+// without hostname
+func gatewayListener(listeners []interface{}, host string) map[string]interface{} {
+	for _, l := range listeners {
+		listener, _ := l.(map[string]interface{})
+		if hostname, _ := listener["hostname"].(string); hostname == host {
+			return listener
+		}
+	}
+	return nil
+}
+
+// containsValue return true if a list contains a value
+func containsValue(list []interface{}, value interface{}) bool {
+	for _, item := range list {
+		if reflect.DeepEqual(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// gatewayHostName return a host usable in a resource name
+func gatewayHostName(host string) string {
+	return strings.NewReplacer(".", "-", "*", "wildcard").Replace(host)
+}
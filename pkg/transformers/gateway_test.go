@@ -0,0 +1,1174 @@
+package transformers
+
+import (
+	"path"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/layertwo/helm-convert/pkg/types"
+	"github.com/layertwo/helm-convert/pkg/utils"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/k8sdeps/kunstruct"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/kustomize/pkg/gvk"
+	"sigs.k8s.io/kustomize/pkg/resid"
+	"sigs.k8s.io/kustomize/pkg/resmap"
+	"sigs.k8s.io/kustomize/pkg/resource"
+)
+
+type gatewayTransformerArgs struct {
+	config    *ktypes.Kustomization
+	resources *types.Resources
+}
+
+func TestGatewayRun(t *testing.T) {
+	var ingress = gvk.Gvk{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"}
+	var ingressV1beta1 = gvk.Gvk{Group: "extensions", Version: "v1beta1", Kind: "Ingress"}
+	var route = gvk.Gvk{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "HTTPRoute"}
+	var service = gvk.Gvk{Version: "v1", Kind: "Service"}
+	var rf = resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
+
+	for _, test := range []struct {
+		name     string
+		options  GatewayOptions
+		input    *gatewayTransformerArgs
+		expected *gatewayTransformerArgs
+	}{
+		{
+			name: "it should keep Ingress resources without gateway",
+			input: &gatewayTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResIdWithPrefixNamespace(service, "app", "", "web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Service",
+								"metadata": map[string]interface{}{
+									"name":      "app",
+									"namespace": "web",
+								},
+								"spec": map[string]interface{}{
+									"ports": []interface{}{
+										map[string]interface{}{
+											"name": "http",
+											"port": 8080,
+										},
+									},
+								},
+							}),
+						resid.NewResIdWithPrefixNamespace(ingress, "app", "", "web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "networking.k8s.io/v1",
+								"kind":       "Ingress",
+								"metadata": map[string]interface{}{
+									"name":      "app",
+									"namespace": "web",
+									"labels": map[string]interface{}{
+										"app": "app",
+									},
+									"annotations": map[string]interface{}{
+										"kubernetes.io/ingress.class":                 "nginx",
+										"nginx.ingress.kubernetes.io/proxy-body-size": "8m",
+										"nginx.ingress.kubernetes.io/rewrite-target":  "/",
+									},
+								},
+								"spec": map[string]interface{}{
+									"defaultBackend": map[string]interface{}{
+										"service": map[string]interface{}{
+											"name": "app",
+											"port": map[string]interface{}{
+												"name": "http",
+											},
+										},
+									},
+									"tls": []interface{}{
+										map[string]interface{}{
+											"hosts": []interface{}{
+												"example.com",
+											},
+											"secretName": "app-tls",
+										},
+									},
+									"rules": []interface{}{
+										map[string]interface{}{
+											"host": "example.com",
+											"http": map[string]interface{}{
+												"paths": []interface{}{
+													map[string]interface{}{
+														"path":     "/api",
+														"pathType": "Prefix",
+														"backend": map[string]interface{}{
+															"service": map[string]interface{}{
+																"name": "app",
+																"port": map[string]interface{}{
+																	"number": 80,
+																},
+															},
+														},
+													},
+												},
+											},
+										},
+										map[string]interface{}{
+											"host": "api.example.com",
+											"http": map[string]interface{}{
+												"paths": []interface{}{
+													map[string]interface{}{
+														"path":     "/v1",
+														"pathType": "Exact",
+														"backend": map[string]interface{}{
+															"service": map[string]interface{}{
+																"name": "app",
+																"port": map[string]interface{}{
+																	"name": "http",
+																},
+															},
+														},
+													},
+													map[string]interface{}{
+														"path":     "/admin",
+														"pathType": "Prefix",
+														"backend": map[string]interface{}{
+															"resource": map[string]interface{}{
+																"apiGroup": "example.com",
+																"kind":     "Bucket",
+																"name":     "admin",
+															},
+														},
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					SourceFiles: map[string]string{},
+				},
+			},
+			expected: &gatewayTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResIdWithPrefixNamespace(service, "app", "", "web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Service",
+								"metadata": map[string]interface{}{
+									"name":      "app",
+									"namespace": "web",
+								},
+								"spec": map[string]interface{}{
+									"ports": []interface{}{
+										map[string]interface{}{
+											"name": "http",
+											"port": 8080,
+										},
+									},
+								},
+							}),
+						resid.NewResIdWithPrefixNamespace(ingress, "app", "", "web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "networking.k8s.io/v1",
+								"kind":       "Ingress",
+								"metadata": map[string]interface{}{
+									"name":      "app",
+									"namespace": "web",
+									"labels": map[string]interface{}{
+										"app": "app",
+									},
+									"annotations": map[string]interface{}{
+										"kubernetes.io/ingress.class":                 "nginx",
+										"nginx.ingress.kubernetes.io/proxy-body-size": "8m",
+										"nginx.ingress.kubernetes.io/rewrite-target":  "/",
+									},
+								},
+								"spec": map[string]interface{}{
+									"defaultBackend": map[string]interface{}{
+										"service": map[string]interface{}{
+											"name": "app",
+											"port": map[string]interface{}{
+												"name": "http",
+											},
+										},
+									},
+									"tls": []interface{}{
+										map[string]interface{}{
+											"hosts": []interface{}{
+												"example.com",
+											},
+											"secretName": "app-tls",
+										},
+									},
+									"rules": []interface{}{
+										map[string]interface{}{
+											"host": "example.com",
+											"http": map[string]interface{}{
+												"paths": []interface{}{
+													map[string]interface{}{
+														"path":     "/api",
+														"pathType": "Prefix",
+														"backend": map[string]interface{}{
+															"service": map[string]interface{}{
+																"name": "app",
+																"port": map[string]interface{}{
+																	"number": 80,
+																},
+															},
+														},
+													},
+												},
+											},
+										},
+										map[string]interface{}{
+											"host": "api.example.com",
+											"http": map[string]interface{}{
+												"paths": []interface{}{
+													map[string]interface{}{
+														"path":     "/v1",
+														"pathType": "Exact",
+														"backend": map[string]interface{}{
+															"service": map[string]interface{}{
+																"name": "app",
+																"port": map[string]interface{}{
+																	"name": "http",
+																},
+															},
+														},
+													},
+													map[string]interface{}{
+														"path":     "/admin",
+														"pathType": "Prefix",
+														"backend": map[string]interface{}{
+															"resource": map[string]interface{}{
+																"apiGroup": "example.com",
+																"kind":     "Bucket",
+																"name":     "admin",
+															},
+														},
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					SourceFiles: map[string]string{},
+				},
+			},
+		},
+		{
+			name:    "it should convert Ingress resources to HTTPRoute resources",
+			options: GatewayOptions{Name: "public", Namespace: "infra"},
+			input: &gatewayTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResIdWithPrefixNamespace(service, "app", "", "web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Service",
+								"metadata": map[string]interface{}{
+									"name":      "app",
+									"namespace": "web",
+								},
+								"spec": map[string]interface{}{
+									"ports": []interface{}{
+										map[string]interface{}{
+											"name": "http",
+											"port": 8080,
+										},
+									},
+								},
+							}),
+						resid.NewResIdWithPrefixNamespace(ingress, "app", "", "web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "networking.k8s.io/v1",
+								"kind":       "Ingress",
+								"metadata": map[string]interface{}{
+									"name":      "app",
+									"namespace": "web",
+									"labels": map[string]interface{}{
+										"app": "app",
+									},
+									"annotations": map[string]interface{}{
+										"kubernetes.io/ingress.class":                 "nginx",
+										"nginx.ingress.kubernetes.io/proxy-body-size": "8m",
+										"nginx.ingress.kubernetes.io/rewrite-target":  "/",
+									},
+								},
+								"spec": map[string]interface{}{
+									"defaultBackend": map[string]interface{}{
+										"service": map[string]interface{}{
+											"name": "app",
+											"port": map[string]interface{}{
+												"name": "http",
+											},
+										},
+									},
+									"tls": []interface{}{
+										map[string]interface{}{
+											"hosts": []interface{}{
+												"example.com",
+											},
+											"secretName": "app-tls",
+										},
+									},
+									"rules": []interface{}{
+										map[string]interface{}{
+											"host": "example.com",
+											"http": map[string]interface{}{
+												"paths": []interface{}{
+													map[string]interface{}{
+														"path":     "/api",
+														"pathType": "Prefix",
+														"backend": map[string]interface{}{
+															"service": map[string]interface{}{
+																"name": "app",
+																"port": map[string]interface{}{
+																	"number": 80,
+																},
+															},
+														},
+													},
+												},
+											},
+										},
+										map[string]interface{}{
+											"host": "api.example.com",
+											"http": map[string]interface{}{
+												"paths": []interface{}{
+													map[string]interface{}{
+														"path":     "/v1",
+														"pathType": "Exact",
+														"backend": map[string]interface{}{
+															"service": map[string]interface{}{
+																"name": "app",
+																"port": map[string]interface{}{
+																	"name": "http",
+																},
+															},
+														},
+													},
+													map[string]interface{}{
+														"path":     "/admin",
+														"pathType": "Prefix",
+														"backend": map[string]interface{}{
+															"resource": map[string]interface{}{
+																"apiGroup": "example.com",
+																"kind":     "Bucket",
+																"name":     "admin",
+															},
+														},
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					SourceFiles: map[string]string{},
+				},
+			},
+			expected: &gatewayTransformerArgs{
+				config: &ktypes.Kustomization{
+					Configurations: []string{"kustomizeconfig/gateway.yaml"},
+				},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResIdWithPrefixNamespace(service, "app", "", "web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Service",
+								"metadata": map[string]interface{}{
+									"name":      "app",
+									"namespace": "web",
+								},
+								"spec": map[string]interface{}{
+									"ports": []interface{}{
+										map[string]interface{}{
+											"name": "http",
+											"port": 8080,
+										},
+									},
+								},
+							}),
+						resid.NewResIdWithPrefixNamespace(route, "app-example-com", "", "web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "gateway.networking.k8s.io/v1",
+								"kind":       "HTTPRoute",
+								"metadata": map[string]interface{}{
+									"name":      "app-example-com",
+									"namespace": "web",
+									"labels": map[string]interface{}{
+										"app": "app",
+									},
+								},
+								"spec": map[string]interface{}{
+									"parentRefs": []interface{}{
+										map[string]interface{}{
+											"name":      "public",
+											"namespace": "infra",
+										},
+									},
+									"hostnames": []interface{}{
+										"example.com",
+									},
+									"rules": []interface{}{
+										map[string]interface{}{
+											"matches": []interface{}{
+												map[string]interface{}{
+													"path": map[string]interface{}{
+														"type":  "PathPrefix",
+														"value": "/api",
+													},
+												},
+											},
+											"filters": []interface{}{
+												map[string]interface{}{
+													"type": "URLRewrite",
+													"urlRewrite": map[string]interface{}{
+														"path": map[string]interface{}{
+															"type":               "ReplacePrefixMatch",
+															"replacePrefixMatch": "/",
+														},
+													},
+												},
+											},
+											"backendRefs": []interface{}{
+												map[string]interface{}{
+													"name": "app",
+													"port": 80,
+												},
+											},
+										},
+									},
+								},
+							}),
+						resid.NewResIdWithPrefixNamespace(route, "app-api-example-com", "", "web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "gateway.networking.k8s.io/v1",
+								"kind":       "HTTPRoute",
+								"metadata": map[string]interface{}{
+									"name":      "app-api-example-com",
+									"namespace": "web",
+									"labels": map[string]interface{}{
+										"app": "app",
+									},
+								},
+								"spec": map[string]interface{}{
+									"parentRefs": []interface{}{
+										map[string]interface{}{
+											"name":      "public",
+											"namespace": "infra",
+										},
+									},
+									"hostnames": []interface{}{
+										"api.example.com",
+									},
+									"rules": []interface{}{
+										map[string]interface{}{
+											"matches": []interface{}{
+												map[string]interface{}{
+													"path": map[string]interface{}{
+														"type":  "Exact",
+														"value": "/v1",
+													},
+												},
+											},
+											"backendRefs": []interface{}{
+												map[string]interface{}{
+													"name": "app",
+													"port": 8080,
+												},
+											},
+										},
+									},
+								},
+							}),
+						resid.NewResIdWithPrefixNamespace(route, "app-default", "", "web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "gateway.networking.k8s.io/v1",
+								"kind":       "HTTPRoute",
+								"metadata": map[string]interface{}{
+									"name":      "app-default",
+									"namespace": "web",
+									"labels": map[string]interface{}{
+										"app": "app",
+									},
+								},
+								"spec": map[string]interface{}{
+									"parentRefs": []interface{}{
+										map[string]interface{}{
+											"name":      "public",
+											"namespace": "infra",
+										},
+									},
+									"rules": []interface{}{
+										map[string]interface{}{
+											"matches": []interface{}{
+												map[string]interface{}{
+													"path": map[string]interface{}{
+														"type":  "PathPrefix",
+														"value": "/",
+													},
+												},
+											},
+											"backendRefs": []interface{}{
+												map[string]interface{}{
+													"name": "app",
+													"port": 8080,
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					SourceFiles: map[string]string{
+						"kustomizeconfig/gateway.yaml": gatewayNameReference,
+						"gateway/public-listeners.yaml": `- op: add
+  path: /spec/listeners/-
+  value:
+    allowedRoutes:
+      namespaces:
+        from: All
+    hostname: example.com
+    name: https-example-com
+    port: 443
+    protocol: HTTPS
+    tls:
+      certificateRefs:
+      - kind: Secret
+        name: app-tls
+        namespace: web
+      mode: Terminate
+`,
+					},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "gateway",
+								Kind:        "Ingress",
+								Name:        "app",
+								Path:        "metadata.annotations.nginx.ingress.kubernetes.io/proxy-body-size",
+								Message:     "the annotation '8m' can't be translated to the HTTPRoute",
+							},
+							{
+								Transformer: "gateway",
+								Kind:        "Ingress",
+								Name:        "app",
+								Path:        "spec.rules[1].http.paths[0]",
+								Message:     "the rewrite-target annotation can't be applied to the Exact path '/v1', the path isn't rewritten",
+							},
+							{
+								Transformer: "gateway",
+								Kind:        "Ingress",
+								Name:        "app",
+								Path:        "spec.rules[1].http.paths[1]",
+								Message:     "only service backends can be converted, the path is skipped",
+							},
+							{
+								Transformer: "gateway",
+								Kind:        "Ingress",
+								Name:        "app",
+								Path:        "spec.tls[0]",
+								Message:     "the Secret 'app-tls' is referenced from the namespace of the Gateway, a ReferenceGrant is required",
+							},
+							{
+								Transformer: "gateway",
+								Kind:        "Gateway",
+								Name:        "infra/public",
+								Path:        "gateway/public-listeners.yaml",
+								Message:     "the HTTPS listeners must be added to the Gateway by applying this JSON patch",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:    "it should merge the listeners of the same hostname",
+			options: GatewayOptions{Name: "public"},
+			input: &gatewayTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(ingress, "admin"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "networking.k8s.io/v1",
+								"kind":       "Ingress",
+								"metadata": map[string]interface{}{
+									"name": "admin",
+								},
+								"spec": map[string]interface{}{
+									"tls": []interface{}{
+										map[string]interface{}{
+											"secretName": "admin-tls",
+											"hosts": []interface{}{
+												"admin.example.com",
+												"example.com",
+											},
+										},
+									},
+									"rules": []interface{}{
+										map[string]interface{}{
+											"host": "admin.example.com",
+											"http": map[string]interface{}{
+												"paths": []interface{}{
+													map[string]interface{}{
+														"path":     "/",
+														"pathType": "Prefix",
+														"backend": map[string]interface{}{
+															"service": map[string]interface{}{
+																"name": "admin",
+																"port": map[string]interface{}{
+																	"number": 80,
+																},
+															},
+														},
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+						resid.NewResId(ingress, "app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "networking.k8s.io/v1",
+								"kind":       "Ingress",
+								"metadata": map[string]interface{}{
+									"name": "app",
+								},
+								"spec": map[string]interface{}{
+									"tls": []interface{}{
+										map[string]interface{}{
+											"secretName": "app-tls",
+											"hosts": []interface{}{
+												"example.com",
+											},
+										},
+									},
+									"rules": []interface{}{
+										map[string]interface{}{
+											"host": "example.com",
+											"http": map[string]interface{}{
+												"paths": []interface{}{
+													map[string]interface{}{
+														"path":     "/",
+														"pathType": "Prefix",
+														"backend": map[string]interface{}{
+															"service": map[string]interface{}{
+																"name": "app",
+																"port": map[string]interface{}{
+																	"number": 80,
+																},
+															},
+														},
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+						resid.NewResId(ingress, "web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "networking.k8s.io/v1",
+								"kind":       "Ingress",
+								"metadata": map[string]interface{}{
+									"name": "web",
+								},
+								"spec": map[string]interface{}{
+									"tls": []interface{}{
+										map[string]interface{}{
+											"secretName": "app-tls",
+											"hosts": []interface{}{
+												"example.com",
+											},
+										},
+									},
+									"rules": []interface{}{
+										map[string]interface{}{
+											"host": "example.com",
+											"http": map[string]interface{}{
+												"paths": []interface{}{
+													map[string]interface{}{
+														"path":     "/",
+														"pathType": "Prefix",
+														"backend": map[string]interface{}{
+															"service": map[string]interface{}{
+																"name": "web",
+																"port": map[string]interface{}{
+																	"number": 80,
+																},
+															},
+														},
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					SourceFiles: map[string]string{},
+				},
+			},
+			expected: &gatewayTransformerArgs{
+				config: &ktypes.Kustomization{
+					Configurations: []string{"kustomizeconfig/gateway.yaml"},
+				},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(route, "admin"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "gateway.networking.k8s.io/v1",
+								"kind":       "HTTPRoute",
+								"metadata": map[string]interface{}{
+									"name": "admin",
+								},
+								"spec": map[string]interface{}{
+									"parentRefs": []interface{}{
+										map[string]interface{}{
+											"name": "public",
+										},
+									},
+									"hostnames": []interface{}{
+										"admin.example.com",
+									},
+									"rules": []interface{}{
+										map[string]interface{}{
+											"matches": []interface{}{
+												map[string]interface{}{
+													"path": map[string]interface{}{
+														"type":  "PathPrefix",
+														"value": "/",
+													},
+												},
+											},
+											"backendRefs": []interface{}{
+												map[string]interface{}{
+													"name": "admin",
+													"port": 80,
+												},
+											},
+										},
+									},
+								},
+							}),
+						resid.NewResId(route, "app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "gateway.networking.k8s.io/v1",
+								"kind":       "HTTPRoute",
+								"metadata": map[string]interface{}{
+									"name": "app",
+								},
+								"spec": map[string]interface{}{
+									"parentRefs": []interface{}{
+										map[string]interface{}{
+											"name": "public",
+										},
+									},
+									"hostnames": []interface{}{
+										"example.com",
+									},
+									"rules": []interface{}{
+										map[string]interface{}{
+											"matches": []interface{}{
+												map[string]interface{}{
+													"path": map[string]interface{}{
+														"type":  "PathPrefix",
+														"value": "/",
+													},
+												},
+											},
+											"backendRefs": []interface{}{
+												map[string]interface{}{
+													"name": "app",
+													"port": 80,
+												},
+											},
+										},
+									},
+								},
+							}),
+						resid.NewResId(route, "web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "gateway.networking.k8s.io/v1",
+								"kind":       "HTTPRoute",
+								"metadata": map[string]interface{}{
+									"name": "web",
+								},
+								"spec": map[string]interface{}{
+									"parentRefs": []interface{}{
+										map[string]interface{}{
+											"name": "public",
+										},
+									},
+									"hostnames": []interface{}{
+										"example.com",
+									},
+									"rules": []interface{}{
+										map[string]interface{}{
+											"matches": []interface{}{
+												map[string]interface{}{
+													"path": map[string]interface{}{
+														"type":  "PathPrefix",
+														"value": "/",
+													},
+												},
+											},
+											"backendRefs": []interface{}{
+												map[string]interface{}{
+													"name": "web",
+													"port": 80,
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					SourceFiles: map[string]string{
+						"kustomizeconfig/gateway.yaml": gatewayNameReference,
+						"gateway/public-listeners.yaml": `- op: add
+  path: /spec/listeners/-
+  value:
+    allowedRoutes:
+      namespaces:
+        from: All
+    hostname: admin.example.com
+    name: https-admin-example-com
+    port: 443
+    protocol: HTTPS
+    tls:
+      certificateRefs:
+      - kind: Secret
+        name: admin-tls
+      mode: Terminate
+- op: add
+  path: /spec/listeners/-
+  value:
+    allowedRoutes:
+      namespaces:
+        from: All
+    hostname: example.com
+    name: https-example-com
+    port: 443
+    protocol: HTTPS
+    tls:
+      certificateRefs:
+      - kind: Secret
+        name: admin-tls
+      - kind: Secret
+        name: app-tls
+      mode: Terminate
+`,
+					},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "gateway",
+								Kind:        "Ingress",
+								Name:        "app",
+								Path:        "spec.tls[0]",
+								Message: "the listener 'https-example-com' already serves the hostname 'example.com' with " +
+									"another certificate, the Secret 'app-tls' is added to its certificateRefs",
+							},
+							{
+								Transformer: "gateway",
+								Kind:        "Gateway",
+								Name:        "public",
+								Path:        "gateway/public-listeners.yaml",
+								Message:     "the HTTPS listeners must be added to the Gateway by applying this JSON patch",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:    "it should report unresolved named ports and legacy path types",
+			options: GatewayOptions{Name: "public"},
+			input: &gatewayTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(ingressV1beta1, "app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "extensions/v1beta1",
+								"kind":       "Ingress",
+								"metadata": map[string]interface{}{
+									"name": "app",
+								},
+								"spec": map[string]interface{}{
+									"rules": []interface{}{
+										map[string]interface{}{
+											"http": map[string]interface{}{
+												"paths": []interface{}{
+													map[string]interface{}{
+														"backend": map[string]interface{}{
+															"serviceName": "app",
+															"servicePort": 80,
+														},
+													},
+													map[string]interface{}{
+														"path": "/metrics",
+														"backend": map[string]interface{}{
+															"serviceName": "metrics",
+															"servicePort": "http",
+														},
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					SourceFiles: map[string]string{},
+				},
+			},
+			expected: &gatewayTransformerArgs{
+				config: &ktypes.Kustomization{
+					Configurations: []string{"kustomizeconfig/gateway.yaml"},
+				},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(route, "app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "gateway.networking.k8s.io/v1",
+								"kind":       "HTTPRoute",
+								"metadata": map[string]interface{}{
+									"name": "app",
+								},
+								"spec": map[string]interface{}{
+									"parentRefs": []interface{}{
+										map[string]interface{}{
+											"name": "public",
+										},
+									},
+									"rules": []interface{}{
+										map[string]interface{}{
+											"matches": []interface{}{
+												map[string]interface{}{
+													"path": map[string]interface{}{
+														"type":  "PathPrefix",
+														"value": "/",
+													},
+												},
+											},
+											"backendRefs": []interface{}{
+												map[string]interface{}{
+													"name": "app",
+													"port": 80,
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					SourceFiles: map[string]string{
+						"kustomizeconfig/gateway.yaml": gatewayNameReference,
+					},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "gateway",
+								Kind:        "Ingress",
+								Name:        "app",
+								Path:        "spec.rules[0].http.paths[0]",
+								Message:     "the ImplementationSpecific path '/' is matched as a prefix",
+							},
+							{
+								Transformer: "gateway",
+								Kind:        "Ingress",
+								Name:        "app",
+								Path:        "spec.rules[0].http.paths[1]",
+								Message:     "the ImplementationSpecific path '/metrics' is matched as a prefix",
+							},
+							{
+								Transformer: "gateway",
+								Kind:        "Ingress",
+								Name:        "app",
+								Path:        "spec.rules[0].http.paths[1]",
+								Message:     "the port 'http' of the service 'metrics' can't be resolved to a number, the path is skipped",
+							},
+						},
+					},
+				},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := NewGatewayTransformer(test.options).Transform(test.input.config, test.input.resources)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := pretty.Compare(test.input, test.expected); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+		})
+	}
+}
+
+func TestGatewayRoundTrip(t *testing.T) {
+	var ingress = gvk.Gvk{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"}
+	var service = gvk.Gvk{Version: "v1", Kind: "Service"}
+	var rf = resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
+
+	resources := types.NewResources()
+	resources.ResMap = resmap.ResMap{
+		resid.NewResIdWithPrefixNamespace(service, "app", "", "web"): rf.FromMap(
+			map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Service",
+				"metadata": map[string]interface{}{
+					"name":      "app",
+					"namespace": "web",
+				},
+				"spec": map[string]interface{}{
+					"ports": []interface{}{
+						map[string]interface{}{
+							"name": "http",
+							"port": 8080,
+						},
+					},
+				},
+			}),
+		resid.NewResIdWithPrefixNamespace(ingress, "app", "", "web"): rf.FromMap(
+			map[string]interface{}{
+				"apiVersion": "networking.k8s.io/v1",
+				"kind":       "Ingress",
+				"metadata": map[string]interface{}{
+					"name":      "app",
+					"namespace": "web",
+					"labels": map[string]interface{}{
+						"app": "app",
+					},
+					"annotations": map[string]interface{}{
+						"kubernetes.io/ingress.class":                 "nginx",
+						"nginx.ingress.kubernetes.io/proxy-body-size": "8m",
+						"nginx.ingress.kubernetes.io/rewrite-target":  "/",
+					},
+				},
+				"spec": map[string]interface{}{
+					"defaultBackend": map[string]interface{}{
+						"service": map[string]interface{}{
+							"name": "app",
+							"port": map[string]interface{}{
+								"name": "http",
+							},
+						},
+					},
+					"tls": []interface{}{
+						map[string]interface{}{
+							"hosts": []interface{}{
+								"example.com",
+							},
+							"secretName": "app-tls",
+						},
+					},
+					"rules": []interface{}{
+						map[string]interface{}{
+							"host": "example.com",
+							"http": map[string]interface{}{
+								"paths": []interface{}{
+									map[string]interface{}{
+										"path":     "/api",
+										"pathType": "Prefix",
+										"backend": map[string]interface{}{
+											"service": map[string]interface{}{
+												"name": "app",
+												"port": map[string]interface{}{
+													"number": 80,
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+						map[string]interface{}{
+							"host": "api.example.com",
+							"http": map[string]interface{}{
+								"paths": []interface{}{
+									map[string]interface{}{
+										"path":     "/v1",
+										"pathType": "Exact",
+										"backend": map[string]interface{}{
+											"service": map[string]interface{}{
+												"name": "app",
+												"port": map[string]interface{}{
+													"name": "http",
+												},
+											},
+										},
+									},
+									map[string]interface{}{
+										"path":     "/admin",
+										"pathType": "Prefix",
+										"backend": map[string]interface{}{
+											"resource": map[string]interface{}{
+												"apiGroup": "example.com",
+												"kind":     "Bucket",
+												"name":     "admin",
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			}),
+	}
+
+	config := &ktypes.Kustomization{NamePrefix: "pre-"}
+	err := NewGatewayTransformer(GatewayOptions{Name: "public", Namespace: "infra"}).Transform(config, resources)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fs := filesys.MakeFsInMemory()
+	for filename, content := range resources.SourceFiles {
+		fs.WriteFile(path.Join("/app", filename), []byte(content))
+	}
+	for id, res := range resources.ResMap {
+		output, err := yaml.Marshal(res.Map())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		filename, err := utils.GetResourceFileName(id, res)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		fs.WriteFile(path.Join("/app", filename), output)
+		config.Resources = append(config.Resources, filename)
+	}
+
+	output, err := kustomizeBuild(fs, config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, name := range []string{"pre-app-example-com", "pre-app-api-example-com", "pre-app-default"} {
+		obj, _ := output["HTTPRoute/"+name].(map[string]interface{})
+		value, err := getFieldValue(obj, []string{"spec", "rules", "0", "backendRefs", "0", "name"})
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if value != "pre-app" {
+			t.Errorf("%s: expected the backend 'pre-app', got '%s'", name, value)
+		}
+	}
+}
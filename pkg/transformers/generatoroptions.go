@@ -0,0 +1,178 @@
+package transformers
+
+import (
+	"fmt"
+
+	"github.com/layertwo/helm-convert/pkg/types"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+)
+
+type generatorOptionsTransformer struct{}
+
+var _ Transformer = &generatorOptionsTransformer{}
+
+// NewGeneratorOptionsTransformer constructs a generatorOptionsTransformer.
+func NewGeneratorOptionsTransformer() Transformer {
+	return &generatorOptionsTransformer{}
+}
+
+// Transform lift the labels and annotations shared by all the configMapGenerator
+// and secretGenerator entries into the global generatorOptions
+func (t *generatorOptionsTransformer) Transform(config *ktypes.Kustomization, resources *types.Resources) error {
+	var generators []*ktypes.GeneratorArgs
+	for i := range config.ConfigMapGenerator {
+		generators = append(generators, &config.ConfigMapGenerator[i].GeneratorArgs)
+	}
+	for i := range config.SecretGenerator {
+		generators = append(generators, &config.SecretGenerator[i].GeneratorArgs)
+	}
+
+	// a single generator keep its own options
+	if len(generators) < 2 {
+		return nil
+	}
+
+	labels := commonGeneratorOptions(generators, func(o *ktypes.GeneratorOptions) map[string]string {
+		return o.Labels
+	})
+	annotations := commonGeneratorOptions(generators, func(o *ktypes.GeneratorOptions) map[string]string {
+		return o.Annotations
+	})
+
+	if len(labels) == 0 && len(annotations) == 0 {
+		return nil
+	}
+
+	if config.GeneratorOptions == nil {
+		config.GeneratorOptions = &ktypes.GeneratorOptions{}
+	}
+	config.GeneratorOptions.Labels = mergeStringMap(config.GeneratorOptions.Labels, labels)
+	config.GeneratorOptions.Annotations = mergeStringMap(config.GeneratorOptions.Annotations, annotations)
+
+	for _, generator := range generators {
+		for key := range labels {
+			delete(generator.Options.Labels, key)
+		}
+		for key := range annotations {
+			delete(generator.Options.Annotations, key)
+		}
+		generator.Options = compactGeneratorOptions(generator.Options)
+	}
+
+	return nil
+}
+
+// commonGeneratorOptions return the key/value pairs found in every generator
+func commonGeneratorOptions(generators []*ktypes.GeneratorArgs,
+	field func(*ktypes.GeneratorOptions) map[string]string) map[string]string {
+
+	var common map[string]string
+	for i, generator := range generators {
+		if generator.Options == nil {
+			return nil
+		}
+
+		values := field(generator.Options)
+		if i == 0 {
+			common = make(map[string]string, len(values))
+			for key, value := range values {
+				common[key] = value
+			}
+			continue
+		}
+
+		for key, value := range common {
+			if v, ok := values[key]; !ok || v != value {
+				delete(common, key)
+			}
+		}
+	}
+
+	return common
+}
+
+// generatorArgs return the generator arguments matching the metadata of a
+// ConfigMap or Secret: name, namespace and options (labels, annotations,
+// immutable)
+func generatorArgs(name string, obj map[string]interface{}) (ktypes.GeneratorArgs, error) {
+	args := ktypes.GeneratorArgs{
+		Name: name,
+	}
+
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	if namespace, ok := metadata["namespace"].(string); ok {
+		args.Namespace = namespace
+	}
+
+	labels, err := metadataStringMap(metadata, "labels")
+	if err != nil {
+		return args, err
+	}
+
+	annotations, err := metadataStringMap(metadata, "annotations")
+	if err != nil {
+		return args, err
+	}
+
+	immutable, _ := obj["immutable"].(bool)
+
+	args.Options = compactGeneratorOptions(&ktypes.GeneratorOptions{
+		Labels:      labels,
+		Annotations: annotations,
+		Immutable:   immutable,
+	})
+
+	return args, nil
+}
+
+// metadataStringMap return a metadata field such as labels or annotations as
+// a map of string
+func metadataStringMap(metadata map[string]interface{}, field string) (map[string]string, error) {
+	values, ok := metadata[field].(map[string]interface{})
+	if !ok || len(values) == 0 {
+		return nil, nil
+	}
+
+	output := make(map[string]string, len(values))
+	for key, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("value of the %s '%s' is not a string but %T", field, key, value)
+		}
+		output[key] = str
+	}
+
+	return output, nil
+}
+
+// compactGeneratorOptions return nil if the given options are empty
+func compactGeneratorOptions(options *ktypes.GeneratorOptions) *ktypes.GeneratorOptions {
+	if options == nil {
+		return nil
+	}
+	if len(options.Labels) == 0 {
+		options.Labels = nil
+	}
+	if len(options.Annotations) == 0 {
+		options.Annotations = nil
+	}
+	if options.Labels == nil && options.Annotations == nil && !options.Immutable &&
+		!options.DisableNameSuffixHash {
+		return nil
+	}
+	return options
+}
+
+// mergeStringMap copy the values of src into dst, dst is allocated if needed
+func mergeStringMap(dst, src map[string]string) map[string]string {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]string, len(src))
+	}
+	for key, value := range src {
+		dst[key] = value
+	}
+	return dst
+}
@@ -0,0 +1,178 @@
+package transformers
+
+import (
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/layertwo/helm-convert/pkg/types"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+)
+
+func TestGeneratorOptionsRun(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		input    *ktypes.Kustomization
+		expected *ktypes.Kustomization
+	}{
+		{
+			name: "it should lift shared labels and annotations into generatorOptions",
+			input: &ktypes.Kustomization{
+				ConfigMapGenerator: []ktypes.ConfigMapArgs{
+					{
+						GeneratorArgs: ktypes.GeneratorArgs{
+							Name: "dashboard",
+							Options: &ktypes.GeneratorOptions{
+								Labels: map[string]string{
+									"app":               "grafana",
+									"grafana_dashboard": "1",
+								},
+								Annotations: map[string]string{
+									"owner": "team-a",
+								},
+							},
+						},
+					},
+				},
+				SecretGenerator: []ktypes.SecretArgs{
+					{
+						GeneratorArgs: ktypes.GeneratorArgs{
+							Name: "credentials",
+							Options: &ktypes.GeneratorOptions{
+								Labels: map[string]string{
+									"app": "grafana",
+								},
+								Annotations: map[string]string{
+									"owner": "team-a",
+								},
+								Immutable: true,
+							},
+						},
+					},
+				},
+			},
+			expected: &ktypes.Kustomization{
+				GeneratorOptions: &ktypes.GeneratorOptions{
+					Labels: map[string]string{
+						"app": "grafana",
+					},
+					Annotations: map[string]string{
+						"owner": "team-a",
+					},
+				},
+				ConfigMapGenerator: []ktypes.ConfigMapArgs{
+					{
+						GeneratorArgs: ktypes.GeneratorArgs{
+							Name: "dashboard",
+							Options: &ktypes.GeneratorOptions{
+								Labels: map[string]string{
+									"grafana_dashboard": "1",
+								},
+							},
+						},
+					},
+				},
+				SecretGenerator: []ktypes.SecretArgs{
+					{
+						GeneratorArgs: ktypes.GeneratorArgs{
+							Name: "credentials",
+							Options: &ktypes.GeneratorOptions{
+								Immutable: true,
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "it should not lift values which differ between generators",
+			input: &ktypes.Kustomization{
+				ConfigMapGenerator: []ktypes.ConfigMapArgs{
+					{
+						GeneratorArgs: ktypes.GeneratorArgs{
+							Name: "configmap1",
+							Options: &ktypes.GeneratorOptions{
+								Labels: map[string]string{"app": "a"},
+							},
+						},
+					},
+					{
+						GeneratorArgs: ktypes.GeneratorArgs{
+							Name: "configmap2",
+							Options: &ktypes.GeneratorOptions{
+								Labels: map[string]string{"app": "b"},
+							},
+						},
+					},
+					{
+						GeneratorArgs: ktypes.GeneratorArgs{
+							Name: "configmap3",
+						},
+					},
+				},
+			},
+			expected: &ktypes.Kustomization{
+				ConfigMapGenerator: []ktypes.ConfigMapArgs{
+					{
+						GeneratorArgs: ktypes.GeneratorArgs{
+							Name: "configmap1",
+							Options: &ktypes.GeneratorOptions{
+								Labels: map[string]string{"app": "a"},
+							},
+						},
+					},
+					{
+						GeneratorArgs: ktypes.GeneratorArgs{
+							Name: "configmap2",
+							Options: &ktypes.GeneratorOptions{
+								Labels: map[string]string{"app": "b"},
+							},
+						},
+					},
+					{
+						GeneratorArgs: ktypes.GeneratorArgs{
+							Name: "configmap3",
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "it should keep the options of a single generator",
+			input: &ktypes.Kustomization{
+				ConfigMapGenerator: []ktypes.ConfigMapArgs{
+					{
+						GeneratorArgs: ktypes.GeneratorArgs{
+							Name: "configmap1",
+							Options: &ktypes.GeneratorOptions{
+								Labels: map[string]string{"app": "a"},
+							},
+						},
+					},
+				},
+			},
+			expected: &ktypes.Kustomization{
+				ConfigMapGenerator: []ktypes.ConfigMapArgs{
+					{
+						GeneratorArgs: ktypes.GeneratorArgs{
+							Name: "configmap1",
+							Options: &ktypes.GeneratorOptions{
+								Labels: map[string]string{"app": "a"},
+							},
+						},
+					},
+				},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := NewGeneratorOptionsTransformer().Transform(test.input, types.NewResources())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := pretty.Compare(test.input, test.expected); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+		})
+	}
+}
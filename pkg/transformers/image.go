@@ -5,8 +5,7 @@ import (
 	"strings"
 
 	"github.com/layertwo/helm-convert/pkg/types"
-	kimage "sigs.k8s.io/kustomize/pkg/image"
-	ktypes "sigs.k8s.io/kustomize/pkg/types"
+	ktypes "sigs.k8s.io/kustomize/api/types"
 )
 
 // imageTransformer replace images
@@ -81,7 +80,7 @@ LOOP_CONTAINERS:
 	return nil
 }
 
-func createKImage(imagePathStr string) kimage.Image {
+func createKImage(imagePathStr string) ktypes.Image {
 	hasDigest := strings.Contains(imagePathStr, "@")
 	separator := ":"
 
@@ -90,7 +89,7 @@ func createKImage(imagePathStr string) kimage.Image {
 	}
 
 	s := strings.Split(imagePathStr, separator)
-	image := kimage.Image{
+	image := ktypes.Image{
 		Name: s[0],
 	}
 	if len(s) > 1 {
@@ -130,7 +129,7 @@ func (pt *imageTransformer) findContainers(config *ktypes.Kustomization, obj map
 	return nil
 }
 
-func imageString(image kimage.Image) string {
+func imageString(image ktypes.Image) string {
 	if image.Digest != "" {
 		return image.Name + "@" + image.Digest
 	}
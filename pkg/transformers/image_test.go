@@ -5,13 +5,12 @@ import (
 
 	"github.com/kylelemons/godebug/pretty"
 	"github.com/layertwo/helm-convert/pkg/types"
+	ktypes "sigs.k8s.io/kustomize/api/types"
 	"sigs.k8s.io/kustomize/k8sdeps/kunstruct"
 	"sigs.k8s.io/kustomize/pkg/gvk"
-	kimage "sigs.k8s.io/kustomize/pkg/image"
 	"sigs.k8s.io/kustomize/pkg/resid"
 	"sigs.k8s.io/kustomize/pkg/resmap"
 	"sigs.k8s.io/kustomize/pkg/resource"
-	ktypes "sigs.k8s.io/kustomize/pkg/types"
 )
 
 type imageTransformerArgs struct {
@@ -93,7 +92,7 @@ func TestImageRun(t *testing.T) {
 			},
 			expected: &imageTransformerArgs{
 				config: &ktypes.Kustomization{
-					Images: []kimage.Image{
+					Images: []ktypes.Image{
 						{Name: "alpine", Digest: "sha256:24a0c4b4a4c0eb97a1aabb8e29f18e917d05abfe1b7a7c07857230879ce7d3d3"},
 						{Name: "busybox"},
 						{Name: "myregistry:5000/namespace/centos", NewTag: "1.2.3"},
@@ -2,7 +2,7 @@ package transformers
 
 import (
 	"github.com/layertwo/helm-convert/pkg/types"
-	ktypes "sigs.k8s.io/kustomize/pkg/types"
+	ktypes "sigs.k8s.io/kustomize/api/types"
 )
 
 // multiTransformer contains a list of transformers
@@ -0,0 +1,179 @@
+package transformers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/layertwo/helm-convert/pkg/types"
+	"github.com/layertwo/helm-convert/pkg/utils"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/pkg/resource"
+)
+
+// NonDeterministicPolicy is what to do with values generated on every render
+// of a chart
+type NonDeterministicPolicy string
+
+const (
+	// NonDeterministicPolicyKeep keep the rendered value and mark it with a
+	// comment in the resource file
+	NonDeterministicPolicyKeep NonDeterministicPolicy = "keep"
+
+	// NonDeterministicPolicyPlaceholder replace the rendered value by
+	// NonDeterministicPlaceholder
+	NonDeterministicPolicyPlaceholder NonDeterministicPolicy = "placeholder"
+
+	// NonDeterministicPolicySecret move the secret keys to placeholder files
+	// of the secretGenerator which must be supplied by the user, other values
+	// are replaced by NonDeterministicPlaceholder
+	NonDeterministicPolicySecret NonDeterministicPolicy = "secret"
+
+	// NonDeterministicPlaceholder is the value replacing the generated values
+	NonDeterministicPlaceholder = "REPLACE_ME"
+)
+
+type nonDeterministicTransformer struct {
+	policy NonDeterministicPolicy
+}
+
+var _ Transformer = &nonDeterministicTransformer{}
+
+// NewNonDeterministicTransformer constructs a nonDeterministicTransformer.
+func NewNonDeterministicTransformer(policy NonDeterministicPolicy) Transformer {
+	return &nonDeterministicTransformer{policy}
+}
+
+// Transform handle the fields whose value differ between two renders of the
+// chart (ie: randAlphaNum, uuidv4, now, genPrivateKey). Once converted they
+// would freeze a sample value in the base, each of them is reported and
+// handled according to the policy. Checksum annotations are left to the
+// checksum transformer.
+func (t *nonDeterministicTransformer) Transform(config *ktypes.Kustomization, resources *types.Resources) error {
+	switch t.policy {
+	case NonDeterministicPolicyKeep, NonDeterministicPolicyPlaceholder, NonDeterministicPolicySecret:
+	default:
+		return fmt.Errorf("unknown non-deterministic policy '%s', expected keep, placeholder or secret", t.policy)
+	}
+
+	for _, field := range resources.NonDeterministic {
+		res := findResource(resources, field.Kind, field.Namespace, field.Name)
+		if res == nil {
+			glog.V(8).Infof("Ignoring the non-deterministic field '%s' of %s '%s', the resource was removed",
+				field, field.Kind, field.Name)
+			continue
+		}
+
+		if n := len(field.Path); n >= 2 && field.Path[n-2] == "annotations" && isChecksumAnnotation(field.Path[n-1]) {
+			continue
+		}
+
+		value, set := lookupField(res.Map(), field.Path)
+		if set == nil {
+			glog.V(8).Infof("Ignoring the non-deterministic field '%s' of %s '%s', the field was removed",
+				field, field.Kind, field.Name)
+			continue
+		}
+
+		report := func(message string) {
+			resources.Report.Add(types.ReportEntry{
+				Transformer: "nondeterministic",
+				Kind:        field.Kind,
+				Name:        field.Name,
+				Path:        field.String(),
+				Message:     message,
+			})
+		}
+
+		policy := t.policy
+		if policy == NonDeterministicPolicySecret && !isSecretDataField(field) {
+			policy = NonDeterministicPolicyPlaceholder
+		}
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			// the structure itself change, there is no single value to replace
+			policy = NonDeterministicPolicyKeep
+		}
+
+		switch policy {
+		case NonDeterministicPolicySecret:
+			if resources.RequiredSecretKeys == nil {
+				resources.RequiredSecretKeys = make(map[string][]string)
+			}
+			key := types.ResourceKey(field.Kind, field.Namespace, field.Name)
+			resources.RequiredSecretKeys[key] = append(resources.RequiredSecretKeys[key], field.Path[1])
+			glog.V(8).Infof("Moved the non-deterministic key '%s' of the secret '%s' to a placeholder",
+				field.Path[1], field.Name)
+			report("generated on every render, the value must be supplied in the placeholder of the secretGenerator")
+		case NonDeterministicPolicyPlaceholder:
+			placeholder := NonDeterministicPlaceholder
+			if field.Kind == "Secret" && field.Path[0] == "data" {
+				placeholder = base64.StdEncoding.EncodeToString([]byte(placeholder))
+			}
+			set(placeholder)
+			glog.V(8).Infof("Replaced the non-deterministic field '%s' of %s '%s' by a placeholder",
+				field, field.Kind, field.Name)
+			report(fmt.Sprintf("generated on every render, replaced by %s", NonDeterministicPlaceholder))
+		default:
+			resources.AddComment(field.Kind, field.Namespace, field.Name,
+				fmt.Sprintf("%s is generated on every render of the chart, the value is a sample", field))
+			glog.Warningf("The field '%s' of %s '%s' is generated on every render of the chart, "+
+				"the value is a sample", field, field.Kind, field.Name)
+			report("generated on every render, the value is a sample")
+		}
+	}
+
+	return nil
+}
+
+// isSecretDataField return true if the field is a key of a secret
+func isSecretDataField(field types.ResourceField) bool {
+	return field.Kind == "Secret" && len(field.Path) == 2 && (field.Path[0] == "data" || field.Path[0] == "stringData")
+}
+
+// findResource return the resource of the given kind, namespace and name
+func findResource(resources *types.Resources, kind, namespace, name string) *resource.Resource {
+	for _, id := range utils.SortedIds(resources.ResMap) {
+		res := resources.ResMap[id]
+		if res.GetKind() != kind || res.GetName() != name {
+			continue
+		}
+		if resNamespace, _ := res.GetFieldValue("metadata.namespace"); resNamespace != namespace {
+			continue
+		}
+		return res
+	}
+	return nil
+}
+
+// lookupField return the value of a field and a function to set it, the
+// function is nil if the field doesn't exist. List elements are written as
+// [index].
+func lookupField(obj map[string]interface{}, path []string) (value interface{}, set func(interface{})) {
+	var current interface{} = obj
+	for _, field := range path {
+		switch typed := current.(type) {
+		case map[string]interface{}:
+			child, found := typed[field]
+			if !found {
+				return nil, nil
+			}
+			field := field
+			current, set = child, func(v interface{}) { typed[field] = v }
+		case []interface{}:
+			if !strings.HasPrefix(field, "[") || !strings.HasSuffix(field, "]") {
+				return nil, nil
+			}
+			i, err := strconv.Atoi(field[1 : len(field)-1])
+			if err != nil || i < 0 || i >= len(typed) {
+				return nil, nil
+			}
+			current, set = typed[i], func(v interface{}) { typed[i] = v }
+		default:
+			return nil, nil
+		}
+	}
+	return current, set
+}
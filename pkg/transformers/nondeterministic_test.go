@@ -0,0 +1,435 @@
+package transformers
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/layertwo/helm-convert/pkg/types"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/k8sdeps/kunstruct"
+	"sigs.k8s.io/kustomize/pkg/gvk"
+	"sigs.k8s.io/kustomize/pkg/resid"
+	"sigs.k8s.io/kustomize/pkg/resmap"
+	"sigs.k8s.io/kustomize/pkg/resource"
+)
+
+type nonDeterministicTransformerArgs struct {
+	config    *ktypes.Kustomization
+	resources *types.Resources
+}
+
+func TestNonDeterministicRun(t *testing.T) {
+	var deploy = gvk.Gvk{Group: "apps", Version: "v1", Kind: "Deployment"}
+	var secret = gvk.Gvk{Version: "v1", Kind: "Secret"}
+	var rf = resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
+
+	var fields = []types.ResourceField{
+		{Kind: "Deployment", Name: "web", Path: []string{"spec", "template", "metadata", "annotations", "rollme"}},
+		{Kind: "Deployment", Name: "web", Path: []string{"spec", "template", "metadata", "annotations", "checksum/secret"}},
+		{Kind: "Secret", Name: "db", Path: []string{"data", "password"}},
+		{Kind: "Secret", Name: "removed", Path: []string{"data", "password"}},
+	}
+
+	for _, test := range []struct {
+		name     string
+		policy   NonDeterministicPolicy
+		input    *nonDeterministicTransformerArgs
+		expected *nonDeterministicTransformerArgs
+	}{
+		{
+			name:   "it should keep the generated values and comment them",
+			policy: NonDeterministicPolicyKeep,
+			input: &nonDeterministicTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(secret, "db"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name": "db",
+								},
+								"data": map[string]interface{}{
+									"password": base64.StdEncoding.EncodeToString([]byte("s3cr3t")),
+									"username": base64.StdEncoding.EncodeToString([]byte("admin")),
+								},
+							}),
+						resid.NewResId(deploy, "web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "web",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"metadata": map[string]interface{}{
+											"annotations": map[string]interface{}{
+												"checksum/secret": "0c1ad12c8e4e7a1c7d1a6c2fb1f4e1a0bd6f6c2bbd3c7e1e1f4b5c6d7e8f9a0b",
+												"rollme":          "x7Kq2",
+											},
+										},
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name":  "web",
+													"image": "web:1.0",
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					NonDeterministic: fields,
+					Comments:         map[string][]string{},
+				},
+			},
+			expected: &nonDeterministicTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(secret, "db"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name": "db",
+								},
+								"data": map[string]interface{}{
+									"password": base64.StdEncoding.EncodeToString([]byte("s3cr3t")),
+									"username": base64.StdEncoding.EncodeToString([]byte("admin")),
+								},
+							}),
+						resid.NewResId(deploy, "web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "web",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"metadata": map[string]interface{}{
+											"annotations": map[string]interface{}{
+												"checksum/secret": "0c1ad12c8e4e7a1c7d1a6c2fb1f4e1a0bd6f6c2bbd3c7e1e1f4b5c6d7e8f9a0b",
+												"rollme":          "x7Kq2",
+											},
+										},
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name":  "web",
+													"image": "web:1.0",
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					NonDeterministic: fields,
+					Comments: map[string][]string{
+						"Deployment//web": {
+							"spec.template.metadata.annotations.rollme is generated on every render of the chart, " +
+								"the value is a sample",
+						},
+						"Secret//db": {
+							"data.password is generated on every render of the chart, the value is a sample",
+						},
+					},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "nondeterministic",
+								Kind:        "Deployment",
+								Name:        "web",
+								Path:        "spec.template.metadata.annotations.rollme",
+								Message:     "generated on every render, the value is a sample",
+							},
+							{
+								Transformer: "nondeterministic",
+								Kind:        "Secret",
+								Name:        "db",
+								Path:        "data.password",
+								Message:     "generated on every render, the value is a sample",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:   "it should replace the generated values by placeholders",
+			policy: NonDeterministicPolicyPlaceholder,
+			input: &nonDeterministicTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(secret, "db"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name": "db",
+								},
+								"data": map[string]interface{}{
+									"password": base64.StdEncoding.EncodeToString([]byte("s3cr3t")),
+									"username": base64.StdEncoding.EncodeToString([]byte("admin")),
+								},
+							}),
+						resid.NewResId(deploy, "web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "web",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"metadata": map[string]interface{}{
+											"annotations": map[string]interface{}{
+												"checksum/secret": "0c1ad12c8e4e7a1c7d1a6c2fb1f4e1a0bd6f6c2bbd3c7e1e1f4b5c6d7e8f9a0b",
+												"rollme":          "x7Kq2",
+											},
+										},
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name":  "web",
+													"image": "web:1.0",
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					NonDeterministic: fields,
+				},
+			},
+			expected: &nonDeterministicTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(secret, "db"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name": "db",
+								},
+								"data": map[string]interface{}{
+									"password": base64.StdEncoding.EncodeToString([]byte(NonDeterministicPlaceholder)),
+									"username": base64.StdEncoding.EncodeToString([]byte("admin")),
+								},
+							}),
+						resid.NewResId(deploy, "web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "web",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"metadata": map[string]interface{}{
+											"annotations": map[string]interface{}{
+												"checksum/secret": "0c1ad12c8e4e7a1c7d1a6c2fb1f4e1a0bd6f6c2bbd3c7e1e1f4b5c6d7e8f9a0b",
+												"rollme":          NonDeterministicPlaceholder,
+											},
+										},
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name":  "web",
+													"image": "web:1.0",
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					NonDeterministic: fields,
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "nondeterministic",
+								Kind:        "Deployment",
+								Name:        "web",
+								Path:        "spec.template.metadata.annotations.rollme",
+								Message:     "generated on every render, replaced by REPLACE_ME",
+							},
+							{
+								Transformer: "nondeterministic",
+								Kind:        "Secret",
+								Name:        "db",
+								Path:        "data.password",
+								Message:     "generated on every render, replaced by REPLACE_ME",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:   "it should require the generated secret keys from the user",
+			policy: NonDeterministicPolicySecret,
+			input: &nonDeterministicTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(secret, "db"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name": "db",
+								},
+								"data": map[string]interface{}{
+									"password": base64.StdEncoding.EncodeToString([]byte("s3cr3t")),
+									"username": base64.StdEncoding.EncodeToString([]byte("admin")),
+								},
+							}),
+						resid.NewResId(deploy, "web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "web",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"metadata": map[string]interface{}{
+											"annotations": map[string]interface{}{
+												"checksum/secret": "0c1ad12c8e4e7a1c7d1a6c2fb1f4e1a0bd6f6c2bbd3c7e1e1f4b5c6d7e8f9a0b",
+												"rollme":          "x7Kq2",
+											},
+										},
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name":  "web",
+													"image": "web:1.0",
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					NonDeterministic:   fields,
+					RequiredSecretKeys: map[string][]string{},
+				},
+			},
+			expected: &nonDeterministicTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(secret, "db"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name": "db",
+								},
+								"data": map[string]interface{}{
+									"password": base64.StdEncoding.EncodeToString([]byte("s3cr3t")),
+									"username": base64.StdEncoding.EncodeToString([]byte("admin")),
+								},
+							}),
+						resid.NewResId(deploy, "web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "web",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"metadata": map[string]interface{}{
+											"annotations": map[string]interface{}{
+												"checksum/secret": "0c1ad12c8e4e7a1c7d1a6c2fb1f4e1a0bd6f6c2bbd3c7e1e1f4b5c6d7e8f9a0b",
+												"rollme":          NonDeterministicPlaceholder,
+											},
+										},
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name":  "web",
+													"image": "web:1.0",
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					NonDeterministic: fields,
+					RequiredSecretKeys: map[string][]string{
+						"Secret//db": {"password"},
+					},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "nondeterministic",
+								Kind:        "Deployment",
+								Name:        "web",
+								Path:        "spec.template.metadata.annotations.rollme",
+								Message:     "generated on every render, replaced by REPLACE_ME",
+							},
+							{
+								Transformer: "nondeterministic",
+								Kind:        "Secret",
+								Name:        "db",
+								Path:        "data.password",
+								Message: "generated on every render, the value must be supplied in the placeholder " +
+									"of the secretGenerator",
+							},
+						},
+					},
+				},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := NewNonDeterministicTransformer(test.policy).Transform(test.input.config, test.input.resources)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := pretty.Compare(test.input, test.expected); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+		})
+	}
+}
+
+func TestNonDeterministicUnknownPolicy(t *testing.T) {
+	var secret = gvk.Gvk{Version: "v1", Kind: "Secret"}
+	var rf = resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
+
+	resources := &types.Resources{
+		ResMap: resmap.ResMap{
+			resid.NewResId(secret, "db"): rf.FromMap(
+				map[string]interface{}{
+					"apiVersion": "v1",
+					"kind":       "Secret",
+					"metadata": map[string]interface{}{
+						"name": "db",
+					},
+					"data": map[string]interface{}{
+						"password": base64.StdEncoding.EncodeToString([]byte("s3cr3t")),
+						"username": base64.StdEncoding.EncodeToString([]byte("admin")),
+					},
+				}),
+		},
+	}
+	err := NewNonDeterministicTransformer("random").Transform(&ktypes.Kustomization{}, resources)
+	if err == nil {
+		t.Errorf("expected an error for an unknown non-deterministic policy")
+	}
+}
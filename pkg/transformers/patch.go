@@ -0,0 +1,368 @@
+package transformers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"github.com/layertwo/helm-convert/pkg/types"
+	"github.com/layertwo/helm-convert/pkg/utils"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/openapi"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// DefaultPatchesDir is the directory holding the generated patches
+const DefaultPatchesDir = "patches"
+
+// DefaultPatchPaths is the list of fields moved to patches by default. Fields
+// are separated by a dot, [] iterate over the elements of a list.
+var DefaultPatchPaths = []string{
+	"spec.template.spec.containers[].resources",
+	"spec.template.spec.initContainers[].resources",
+	"spec.template.spec.nodeSelector",
+	"spec.template.spec.tolerations",
+	"spec.template.spec.affinity",
+	"spec.jobTemplate.spec.template.spec.containers[].resources",
+	"spec.jobTemplate.spec.template.spec.initContainers[].resources",
+	"spec.jobTemplate.spec.template.spec.nodeSelector",
+	"spec.jobTemplate.spec.template.spec.tolerations",
+	"spec.jobTemplate.spec.template.spec.affinity",
+	"spec.volumeClaimTemplates[].spec.storageClassName",
+	"spec.volumeClaimTemplates[].spec.resources.requests.storage",
+	"spec.storageClassName",
+	"spec.resources.requests.storage",
+	"spec.rules[].host",
+	"spec.tls[].hosts",
+}
+
+type patchTransformer struct {
+	paths         [][]string
+	valueComments bool
+}
+
+var _ Transformer = &patchTransformer{}
+
+// NewPatchTransformer constructs a patchTransformer, if valueComments is true
+// the fields of the patches are commented with the values of the chart
+// controlling them.
+func NewPatchTransformer(paths []string, valueComments bool) Transformer {
+	t := &patchTransformer{valueComments: valueComments}
+	for _, p := range paths {
+		t.paths = append(t.paths, splitPatchPath(p))
+	}
+	return t
+}
+
+// Transform move the given field paths of each resource to a strategic merge
+// patch file referenced from the patches field of the kustomization.yaml. The
+// output of kustomize build is unchanged.
+func (t *patchTransformer) Transform(config *ktypes.Kustomization, resources *types.Resources) error {
+	for _, id := range utils.SortedIds(resources.ResMap) {
+		res := resources.ResMap[id]
+		obj := res.Map()
+
+		apiVersion, _ := obj["apiVersion"].(string)
+		kind, _ := obj["kind"].(string)
+		schema := openapi.SchemaForResourceType(kyaml.TypeMeta{APIVersion: apiVersion, Kind: kind})
+
+		patch := make(map[string]interface{})
+		for _, p := range t.paths {
+			extractPatch(obj, patch, p, schema)
+		}
+
+		if len(patch) == 0 {
+			continue
+		}
+
+		metadata := map[string]interface{}{
+			"name": res.GetName(),
+		}
+		if namespace, err := res.GetFieldValue("metadata.namespace"); err == nil && namespace != "" {
+			metadata["namespace"] = namespace
+		}
+		patch["apiVersion"] = apiVersion
+		patch["kind"] = kind
+		patch["metadata"] = metadata
+
+		output, err := yaml.Marshal(patch)
+		if err != nil {
+			return err
+		}
+
+		filename, err := utils.GetResourceFileName(id, res)
+		if err != nil {
+			return err
+		}
+
+		if t.valueComments {
+			output = append(patchValueComments(resources.ValueMap, res.GetKind(), metadata["namespace"], res.GetName(),
+				obj, patch), output...)
+		}
+
+		filePath := addSourceFile(resources.SourceFiles, DefaultPatchesDir, filename, string(output))
+		config.Patches = append(config.Patches, ktypes.Patch{Path: filePath})
+
+		glog.V(8).Infof("Moved tunable fields of %s '%s' to the patch '%s'", kind, res.GetName(), filePath)
+	}
+
+	sort.Slice(config.Patches, func(i, j int) bool {
+		return config.Patches[i].Path < config.Patches[j].Path
+	})
+
+	return nil
+}
+
+// patchValueComments return the comments listing the values of the chart
+// controlling the fields moved from a resource to its patch, ie:
+// # spec.template.spec.nodeSelector is controlled by the value nodeSelector
+func patchValueComments(valueMap types.ValueMap, kind string, namespace interface{}, name string,
+	obj, patch map[string]interface{}) []byte {
+
+	namespaceValue, _ := namespace.(string)
+	controlledBy := make(map[string][]string)
+	for _, value := range sortedKeys(valueMap) {
+		for _, field := range valueMap[value] {
+			if field.Kind != kind || field.Name != name || field.Namespace != namespaceValue {
+				continue
+			}
+			// the parents of the patched fields are left in the resource
+			if _, set := lookupField(patch, field.Path); set == nil {
+				continue
+			}
+			if _, set := lookupField(obj, field.Path); set != nil {
+				continue
+			}
+			controlledBy[field.String()] = append(controlledBy[field.String()], value)
+		}
+	}
+
+	var b strings.Builder
+	for _, field := range sortedKeys(controlledBy) {
+		values := controlledBy[field]
+		if len(values) == 1 {
+			fmt.Fprintf(&b, "# %s is controlled by the value %s\n", field, values[0])
+			continue
+		}
+		fmt.Fprintf(&b, "# %s is controlled by the values %s\n", field, strings.Join(values, ", "))
+	}
+	return []byte(b.String())
+}
+
+// splitPatchPath split a field path, ie: spec.rules[].host, into the
+// segments spec, rules, [] and host
+func splitPatchPath(p string) (segments []string) {
+	for _, field := range strings.Split(p, ".") {
+		if strings.HasSuffix(field, openapi.Elements) {
+			segments = append(segments, strings.TrimSuffix(field, openapi.Elements), openapi.Elements)
+			continue
+		}
+		segments = append(segments, field)
+	}
+	return
+}
+
+// extractPatch move the field found at the given path from src to patch and
+// return true if something was moved. Lists with a merge key are patched
+// element by element, every element is listed to keep their order. Other
+// lists are replaced as a whole by kustomize, they are copied entirely in the
+// patch while the extracted fields are removed from the base.
+func extractPatch(src, patch map[string]interface{}, p []string, schema *openapi.ResourceSchema) bool {
+	field := p[0]
+	value, found := src[field]
+	if !found || value == nil {
+		return false
+	}
+
+	var fieldSchema *openapi.ResourceSchema
+	if schema != nil {
+		fieldSchema = schema.Field(field)
+	}
+
+	// leaf, move the value unless it would be dropped by the patch
+	if len(p) == 1 {
+		if isEmptyValue(value) || containsNull(value) {
+			return false
+		}
+		if existing, ok := patch[field].(map[string]interface{}); ok {
+			if m, ok := value.(map[string]interface{}); ok {
+				for k, v := range m {
+					existing[k] = v
+				}
+				delete(src, field)
+				return true
+			}
+		}
+		patch[field] = value
+		delete(src, field)
+		return true
+	}
+
+	if p[1] == openapi.Elements {
+		list, ok := value.([]interface{})
+		if !ok || len(p) < 3 {
+			return false
+		}
+		return extractPatchList(src, patch, field, list, p[2:], fieldSchema)
+	}
+
+	child, ok := value.(map[string]interface{})
+	if !ok {
+		return false
+	}
+
+	childPatch, _ := patch[field].(map[string]interface{})
+	if childPatch == nil {
+		childPatch = make(map[string]interface{})
+	}
+
+	if !extractPatch(child, childPatch, p[1:], fieldSchema) {
+		return false
+	}
+
+	patch[field] = childPatch
+	return true
+}
+
+// extractPatchList extract the given path from each element of a list
+func extractPatchList(src, patch map[string]interface{}, field string, list []interface{}, p []string,
+	schema *openapi.ResourceSchema) bool {
+
+	var elementSchema *openapi.ResourceSchema
+	var mergeKeys []string
+	if schema != nil {
+		var strategy string
+		strategy, mergeKeys = schema.PatchStrategyAndKeyList()
+		if !strings.Contains(strategy, "merge") || !hasMergeKeys(list, mergeKeys) {
+			mergeKeys = nil
+		}
+		elementSchema = schema.Elements()
+	}
+
+	if len(mergeKeys) == 0 {
+		// copied before anything is removed, another path may already have
+		// copied the list
+		full := deepCopyValue(list)
+
+		extracted := false
+		for _, item := range list {
+			if element, ok := item.(map[string]interface{}); ok {
+				if extractPatch(element, make(map[string]interface{}), p, elementSchema) {
+					extracted = true
+				}
+			}
+		}
+
+		if _, copied := patch[field]; extracted && !copied {
+			patch[field] = full
+		}
+		return extracted
+	}
+
+	// reuse the elements already added by another path
+	existing, _ := patch[field].([]interface{})
+	patchList := make([]interface{}, len(list))
+
+	extracted := false
+	for i, item := range list {
+		element := item.(map[string]interface{})
+
+		var patchElement map[string]interface{}
+		if i < len(existing) {
+			patchElement, _ = existing[i].(map[string]interface{})
+		}
+		if patchElement == nil {
+			patchElement = make(map[string]interface{}, len(mergeKeys))
+			for _, key := range mergeKeys {
+				if v, found := element[key]; found {
+					patchElement[key] = v
+				}
+			}
+		}
+
+		if extractPatch(element, patchElement, p, elementSchema) {
+			extracted = true
+		}
+		patchList[i] = patchElement
+	}
+
+	if extracted {
+		patch[field] = patchList
+	}
+	return extracted
+}
+
+// hasMergeKeys return true if every element of a list is a map holding the
+// merge keys, strategic merge patches can't target the other elements
+func hasMergeKeys(list []interface{}, mergeKeys []string) bool {
+	if len(mergeKeys) == 0 {
+		return false
+	}
+	for _, item := range list {
+		element, ok := item.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for _, key := range mergeKeys {
+			if _, found := element[key]; !found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// containsNull return true if a value contains a null, which means delete in
+// a strategic merge patch
+func containsNull(value interface{}) bool {
+	switch typedV := value.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		for _, v := range typedV {
+			if containsNull(v) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, v := range typedV {
+			if containsNull(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isEmptyValue return true if the value is an empty map or list, which is
+// dropped by strategic merge patches
+func isEmptyValue(value interface{}) bool {
+	switch typedV := value.(type) {
+	case map[string]interface{}:
+		return len(typedV) == 0
+	case []interface{}:
+		return len(typedV) == 0
+	}
+	return false
+}
+
+// deepCopyValue return a deep copy of an unstructured value
+func deepCopyValue(value interface{}) interface{} {
+	switch typedV := value.(type) {
+	case map[string]interface{}:
+		output := make(map[string]interface{}, len(typedV))
+		for k, v := range typedV {
+			output[k] = deepCopyValue(v)
+		}
+		return output
+	case []interface{}:
+		output := make([]interface{}, len(typedV))
+		for i, v := range typedV {
+			output[i] = deepCopyValue(v)
+		}
+		return output
+	}
+	return value
+}
@@ -0,0 +1,522 @@
+package transformers
+
+import (
+	"path"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/layertwo/helm-convert/pkg/types"
+	"github.com/layertwo/helm-convert/pkg/utils"
+	"sigs.k8s.io/kustomize/api/krusty"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/k8sdeps/kunstruct"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/kustomize/pkg/gvk"
+	"sigs.k8s.io/kustomize/pkg/resid"
+	"sigs.k8s.io/kustomize/pkg/resmap"
+	"sigs.k8s.io/kustomize/pkg/resource"
+)
+
+type patchTransformerArgs struct {
+	config    *ktypes.Kustomization
+	resources *types.Resources
+}
+
+func TestPatchRun(t *testing.T) {
+	var deploy = gvk.Gvk{Group: "apps", Version: "v1", Kind: "Deployment"}
+	var rf = resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
+
+	for _, test := range []struct {
+		name          string
+		paths         []string
+		valueComments bool
+		input         *patchTransformerArgs
+		expected      *patchTransformerArgs
+	}{
+		{
+			name:  "it should move fields to a strategic merge patch",
+			paths: DefaultPatchPaths,
+			input: &patchTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "app",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"nodeSelector": map[string]interface{}{
+												"disktype": "ssd",
+											},
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name":      "sidecar",
+													"image":     "sidecar",
+													"resources": map[string]interface{}{},
+												},
+												map[string]interface{}{
+													"name":  "app",
+													"image": "app",
+													"resources": map[string]interface{}{
+														"limits": map[string]interface{}{"cpu": "1"},
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					SourceFiles: map[string]string{},
+				},
+			},
+			expected: &patchTransformerArgs{
+				config: &ktypes.Kustomization{
+					Patches: []ktypes.Patch{
+						{Path: "patches/app-deploy.yaml"},
+					},
+				},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "app",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name":      "sidecar",
+													"image":     "sidecar",
+													"resources": map[string]interface{}{},
+												},
+												map[string]interface{}{
+													"name":  "app",
+													"image": "app",
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					SourceFiles: map[string]string{
+						"patches/app-deploy.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  template:
+    spec:
+      containers:
+      - name: sidecar
+      - name: app
+        resources:
+          limits:
+            cpu: "1"
+      nodeSelector:
+        disktype: ssd
+`,
+					},
+				},
+			},
+		},
+		{
+			name:  "it should not create a patch if no field is found",
+			paths: []string{"spec.replicas"},
+			input: &patchTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "app",
+								},
+							}),
+					},
+					SourceFiles: map[string]string{},
+				},
+			},
+			expected: &patchTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "app",
+								},
+							}),
+					},
+				},
+			},
+		},
+		{
+			name:          "it should comment the patched fields with the values controlling them",
+			paths:         DefaultPatchPaths,
+			valueComments: true,
+			input: &patchTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "app",
+								},
+								"spec": map[string]interface{}{
+									"replicas": 2,
+									"template": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"nodeSelector": map[string]interface{}{
+												"disktype": "ssd",
+											},
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name":  "app",
+													"image": "app",
+													"resources": map[string]interface{}{
+														"limits": map[string]interface{}{"cpu": "1"},
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					SourceFiles: map[string]string{},
+					ValueMap: types.ValueMap{
+						"nodeSelector.disktype": {
+							{Kind: "Deployment", Name: "app", Path: []string{"spec", "template", "spec", "nodeSelector", "disktype"}},
+						},
+						"nodeSelector.zone": {
+							{Kind: "Deployment", Name: "app", Path: []string{"spec", "template", "spec", "nodeSelector", "disktype"}},
+						},
+						"replicaCount": {
+							{Kind: "Deployment", Name: "app", Path: []string{"spec", "replicas"}},
+						},
+						"resources": {
+							{Kind: "Deployment", Name: "app", Path: []string{"spec", "template", "spec", "containers", "[0]", "resources"}},
+						},
+						"sidecar.image": {
+							{Kind: "Deployment", Name: "app", Path: []string{"spec", "template", "spec", "containers", "[0]", "image"}},
+						},
+					},
+				},
+			},
+			expected: &patchTransformerArgs{
+				config: &ktypes.Kustomization{
+					Patches: []ktypes.Patch{
+						{Path: "patches/app-deploy.yaml"},
+					},
+				},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(deploy, "app"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "app",
+								},
+								"spec": map[string]interface{}{
+									"replicas": 2,
+									"template": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name":  "app",
+													"image": "app",
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					SourceFiles: map[string]string{
+						"patches/app-deploy.yaml": `# spec.template.spec.containers[0].resources is controlled by the value resources
+# spec.template.spec.nodeSelector.disktype is controlled by the values nodeSelector.disktype, nodeSelector.zone
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        resources:
+          limits:
+            cpu: "1"
+      nodeSelector:
+        disktype: ssd
+`,
+					},
+					ValueMap: types.ValueMap{
+						"nodeSelector.disktype": {
+							{Kind: "Deployment", Name: "app", Path: []string{"spec", "template", "spec", "nodeSelector", "disktype"}},
+						},
+						"nodeSelector.zone": {
+							{Kind: "Deployment", Name: "app", Path: []string{"spec", "template", "spec", "nodeSelector", "disktype"}},
+						},
+						"replicaCount": {
+							{Kind: "Deployment", Name: "app", Path: []string{"spec", "replicas"}},
+						},
+						"resources": {
+							{Kind: "Deployment", Name: "app", Path: []string{"spec", "template", "spec", "containers", "[0]", "resources"}},
+						},
+						"sidecar.image": {
+							{Kind: "Deployment", Name: "app", Path: []string{"spec", "template", "spec", "containers", "[0]", "image"}},
+						},
+					},
+				},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := NewPatchTransformer(test.paths, test.valueComments).Transform(test.input.config, test.input.resources)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := pretty.Compare(test.input.config, test.expected.config); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+
+			if diff := pretty.Compare(test.input.resources, test.expected.resources); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+		})
+	}
+}
+
+// patchTestManifests cover lists with a merge key (containers), atomic lists
+// (ingress rules, volumeClaimTemplates) and resources without schema
+var patchTestManifests = []string{`
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+  namespace: data
+spec:
+  replicas: 3
+  template:
+    spec:
+      initContainers:
+      - name: init
+        image: busybox
+        resources:
+          requests:
+            cpu: 10m
+      containers:
+      - name: db
+        image: postgres
+        resources:
+          limits:
+            cpu: "2"
+            memory: 1Gi
+      - name: exporter
+        image: exporter
+      tolerations:
+      - key: dedicated
+        operator: Equal
+        value: db
+        effect: NoSchedule
+      affinity:
+        nodeAffinity:
+          requiredDuringSchedulingIgnoredDuringExecution:
+            nodeSelectorTerms:
+            - matchExpressions:
+              - key: disktype
+                operator: In
+                values: [ssd]
+  volumeClaimTemplates:
+  - metadata:
+      name: data
+    spec:
+      accessModes: [ReadWriteOnce]
+      storageClassName: fast
+      resources:
+        requests:
+          storage: 10Gi
+`, `
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  tls:
+  - hosts: [a.example.com]
+    secretName: web-tls
+  rules:
+  - host: a.example.com
+    http:
+      paths:
+      - path: /
+        pathType: Prefix
+        backend:
+          service:
+            name: web
+            port:
+              number: 80
+  - host: b.example.com
+    http:
+      paths:
+      - path: /b
+        pathType: Prefix
+        backend:
+          service:
+            name: web
+            port:
+              number: 80
+`, `
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: cache
+spec:
+  accessModes: [ReadWriteOnce]
+  storageClassName: standard
+  resources:
+    requests:
+      storage: 1Gi
+`, `
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: backup
+spec:
+  schedule: "0 0 * * *"
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          restartPolicy: OnFailure
+          nodeSelector:
+            pool: batch
+          containers:
+          - name: backup
+            image: backup
+            resources:
+              requests:
+                memory: 64Mi
+`, `
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: widget
+spec:
+  storageClassName: slow
+  rules:
+  - host: c.example.com
+    port: 80
+`}
+
+func TestPatchRoundTrip(t *testing.T) {
+	var rf = resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
+
+	resources := types.NewResources()
+	for _, manifest := range patchTestManifests {
+		var obj map[string]interface{}
+		if err := yaml.Unmarshal([]byte(manifest), &obj); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		res := rf.FromMap(obj)
+		resources.ResMap[res.Id()] = res
+	}
+
+	fs := filesys.MakeFsInMemory()
+	original := &ktypes.Kustomization{}
+	for i, manifest := range patchTestManifests {
+		filename := path.Join("original", string(rune('a'+i))+".yaml")
+		fs.WriteFile(path.Join("/app", filename), []byte(manifest))
+		original.Resources = append(original.Resources, filename)
+	}
+
+	config := &ktypes.Kustomization{}
+	if err := NewPatchTransformer(DefaultPatchPaths, false).Transform(config, resources); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(config.Patches) != len(patchTestManifests) {
+		t.Errorf("expected %d patches, got %d", len(patchTestManifests), len(config.Patches))
+	}
+
+	for id, res := range resources.ResMap {
+		output, err := yaml.Marshal(res.Map())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		filename, err := utils.GetResourceFileName(id, res)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		filename = path.Join("base", filename)
+		fs.WriteFile(path.Join("/app", filename), output)
+		config.Resources = append(config.Resources, filename)
+	}
+	for filename, content := range resources.SourceFiles {
+		fs.WriteFile(path.Join("/app", filename), []byte(content))
+	}
+
+	expected, err := kustomizeBuild(fs, original)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output, err := kustomizeBuild(fs, config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if diff := pretty.Compare(output, expected); diff != "" {
+		t.Errorf("kustomize build output differ, diff: (-got +want)\n%s", diff)
+	}
+}
+
+// kustomizeBuild return the resources built from a kustomization written in
+// the /app directory, indexed by kind and name
+func kustomizeBuild(fs filesys.FileSystem, config *ktypes.Kustomization) (map[string]interface{}, error) {
+	content, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := fs.WriteFile("/app/kustomization.yaml", content); err != nil {
+		return nil, err
+	}
+
+	m, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(fs, "/app")
+	if err != nil {
+		return nil, err
+	}
+
+	output := make(map[string]interface{})
+	for _, res := range m.Resources() {
+		obj, err := res.Map()
+		if err != nil {
+			return nil, err
+		}
+		output[res.GetKind()+"/"+res.GetName()] = obj
+	}
+
+	return output, nil
+}
@@ -0,0 +1,386 @@
+package transformers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"github.com/layertwo/helm-convert/pkg/types"
+	"github.com/layertwo/helm-convert/pkg/utils"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+)
+
+const (
+	// releaseNameToken and releaseNamespaceToken replace the markers of a
+	// release field template, they never contain a delimiter
+	releaseNameToken      = "\x01"
+	releaseNamespaceToken = "\x02"
+)
+
+// releaseMarkerTokens replace the markers of a release field template by tokens
+var releaseMarkerTokens = strings.NewReplacer(
+	types.ReleaseNameMarker, releaseNameToken,
+	types.ReleaseNamespaceMarker, releaseNamespaceToken,
+)
+
+type releaseFieldsTransformer struct {
+	name      string
+	namespace string
+}
+
+var _ Transformer = &releaseFieldsTransformer{}
+
+// NewReleaseFieldsTransformer constructs a releaseFieldsTransformer, name and
+// namespace are the release name and namespace used to render the chart.
+func NewReleaseFieldsTransformer(name, namespace string) Transformer {
+	return &releaseFieldsTransformer{name, namespace}
+}
+
+// Transform substitute the release name and namespace in the string fields
+// derived from them (ie: URLs, JVM arguments) with vars, they are found by
+// rendering the chart with a sentinel release. Hard-coded occurrences would
+// otherwise be left behind when the namespace or namePrefix change in an
+// overlay. Vars are resolved once the overlays are applied, unlike the
+// replacements of a base. The namespace is taken from a resource of the
+// release namespace and the name from a resource whose name starts with the
+// release name and matches the rest of the field (ie: myrel-api in
+// http://myrel-api:8080). The fields which aren't substituted by default get
+// a var reference in a kustomize configuration and the fields which can't be
+// expressed with a var are reported. Labels, selectors and fields already
+// targeted by a replacement, ie: references to other resources, are left
+// untouched.
+func (t *releaseFieldsTransformer) Transform(config *ktypes.Kustomization, resources *types.Resources) error {
+	if len(resources.ReleaseFields) == 0 {
+		return nil
+	}
+
+	// source field paths of the replacements targeting each field
+	targeted := make(map[string]map[string]struct{})
+	for _, replacement := range config.Replacements {
+		if replacement.Source == nil {
+			continue
+		}
+		for _, target := range replacement.Targets {
+			if target.Select == nil {
+				continue
+			}
+			for _, fieldPath := range target.FieldPaths {
+				key := target.Select.ResId.String() + "|" + fieldPath
+				if targeted[key] == nil {
+					targeted[key] = make(map[string]struct{})
+				}
+				targeted[key][replacement.Source.FieldPath] = struct{}{}
+			}
+		}
+	}
+
+	sources := &releaseFieldSources{
+		namespace: t.namespaceSource(config, resources),
+		names:     t.nameSources(resources),
+		vars:      make(map[string]ktypes.Var),
+	}
+	var varReferences []map[string]string
+	varReferenced := make(map[string]struct{})
+
+	for _, field := range resources.ReleaseFields {
+		if isReleaseLabel(field.Path) {
+			glog.V(8).Infof("Ignoring the label '%s' of %s '%s' derived from the release", field, field.Kind,
+				field.Name)
+			continue
+		}
+
+		report := func(message string) {
+			resources.Report.Add(types.ReportEntry{
+				Transformer: "releasefields",
+				Kind:        field.Kind,
+				Name:        field.Name,
+				Path:        field.String(),
+				Message:     message,
+			})
+		}
+		manual := func(reason string) {
+			glog.Warningf("The field '%s' of %s '%s' is derived from the %s (%s), %s, it must be updated "+
+				"manually", field, field.Kind, field.Name, t.derivedFrom(field), field.Template, reason)
+			report(fmt.Sprintf("derived from the %s (%s), %s, it must be updated manually",
+				t.derivedFrom(field), field.Template, reason))
+		}
+
+		res := findResource(resources, field.Kind, field.Namespace, field.Name)
+		if res == nil {
+			manual("the resource was converted")
+			continue
+		}
+		value, set := lookupField(res.Map(), field.Path)
+		if set == nil || value != field.Value {
+			manual("the field was moved or modified")
+			continue
+		}
+
+		if t.expand(field.Template) != field.Value {
+			manual("the value isn't copied verbatim (ie: truncated)")
+			continue
+		}
+		if res.GetKind() == "Secret" {
+			manual("secret values can't be replaced")
+			continue
+		}
+
+		path := indexPath(field.Path)
+		sourcedBy := targeted[referenceResId(res).String()+"|"+replacementFieldPath(path)]
+		substituted, replaced, missing := t.substitute(field.Template, sources, sourcedBy)
+
+		if len(replaced) > 0 {
+			set(substituted)
+			glog.V(8).Infof("Replaced the release name or namespace in the field '%s' of %s '%s' by vars",
+				field, field.Kind, field.Name)
+
+			if fieldSpec := varReferencePath(path); !isContainerVarField(path) {
+				key := res.GetKind() + "|" + fieldSpec
+				if _, found := varReferenced[key]; !found {
+					varReferenced[key] = struct{}{}
+					varReferences = append(varReferences, map[string]string{"kind": res.GetKind(), "path": fieldSpec})
+				}
+			}
+		}
+		switch {
+		case len(missing) > 0 && len(replaced) > 0:
+			manual(fmt.Sprintf("replaced by %s but %s", strings.Join(replaced, " and "),
+				strings.Join(missing, " and ")))
+		case len(missing) > 0:
+			manual(strings.Join(missing, " and "))
+		case len(replaced) > 0:
+			report(fmt.Sprintf("derived from the %s (%s), replaced by %s", t.derivedFrom(field), field.Template,
+				strings.Join(replaced, " and ")))
+		}
+	}
+
+	existing := make(map[string]struct{}, len(config.Vars))
+	for _, v := range config.Vars {
+		existing[v.Name] = struct{}{}
+	}
+	names := make([]string, 0, len(sources.vars))
+	for name := range sources.vars {
+		if _, found := existing[name]; !found {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		config.Vars = append(config.Vars, sources.vars[name])
+	}
+
+	if len(varReferences) > 0 {
+		output, err := yaml.Marshal(map[string]interface{}{"varReference": varReferences})
+		if err != nil {
+			return err
+		}
+		filePath := addSourceFile(resources.SourceFiles, DefaultKustomizeConfigDir, "releasefields.yaml", string(output))
+		config.Configurations = append(config.Configurations, filePath)
+	}
+
+	return nil
+}
+
+// releaseFieldSources are the resources holding the release name and
+// namespace, and the vars referencing them
+type releaseFieldSources struct {
+	namespace *referenceSource
+
+	// names are sorted by preference, the longest names first
+	names []*referenceSource
+
+	vars map[string]ktypes.Var
+}
+
+// substitute replace the release name and namespace of a release field
+// template by vars, it return the value and the description of the replaced
+// and missing parts. The parts sourced by a replacement keep their value.
+func (t *releaseFieldsTransformer) substitute(template string, sources *releaseFieldSources,
+	sourcedBy map[string]struct{}) (value string, replaced, missing []string) {
+
+	template = releaseMarkerTokens.Replace(template)
+	var b strings.Builder
+	add := func(list []string, description string) []string {
+		for _, item := range list {
+			if item == description {
+				return list
+			}
+		}
+		return append(list, description)
+	}
+	useVar := func(source *referenceSource, fieldPath string) {
+		v := referenceVar(source, fieldPath)
+		sources.vars[v.Name] = v
+		b.WriteString("$(" + v.Name + ")")
+		replaced = add(replaced, fmt.Sprintf("the %s of %s '%s'", strings.TrimPrefix(fieldPath, "metadata."),
+			source.res.GetKind(), source.name))
+	}
+
+	for i := 0; i < len(template); i++ {
+		switch template[i : i+1] {
+		case releaseNamespaceToken:
+			switch _, found := sourcedBy["metadata.namespace"]; {
+			case found:
+				// references to other resources already follow their namespace
+				b.WriteString(t.namespace)
+			case sources.namespace == nil:
+				missing = add(missing, "no resource holds the release namespace")
+				b.WriteString(t.namespace)
+			default:
+				useVar(sources.namespace, "metadata.namespace")
+			}
+		case releaseNameToken:
+			if _, found := sourcedBy["metadata.name"]; found {
+				b.WriteString(t.name)
+				continue
+			}
+			source := t.matchNameSource(sources.names, template[i+1:])
+			if source == nil {
+				missing = add(missing, "no resource holds the release name")
+				b.WriteString(t.name)
+				continue
+			}
+			useVar(source, "metadata.name")
+			// the rest of the name is part of the var
+			i += len(source.name) - len(t.name)
+		default:
+			b.WriteByte(template[i])
+		}
+	}
+
+	return b.String(), replaced, missing
+}
+
+// matchNameSource return the resource whose name is the release name followed
+// by the beginning of the rest of a template, the name must not be followed
+// by a character allowed in a name
+func (t *releaseFieldsTransformer) matchNameSource(sources []*referenceSource, rest string) *referenceSource {
+	for _, source := range sources {
+		suffix := strings.TrimPrefix(source.name, t.name)
+		if !strings.HasPrefix(rest, suffix) {
+			continue
+		}
+		after := rest[len(suffix):]
+		if after == "" {
+			return source
+		}
+		if after[:1] == releaseNameToken || after[:1] == releaseNamespaceToken ||
+			strings.Contains(referenceNameChars, after[:1]) {
+			continue
+		}
+		return source
+	}
+	return nil
+}
+
+// namespaceSource return a resource of the release namespace, the namespace
+// of the kustomization is used for the resources without namespace. Services
+// are preferred like for references, then the resource named after the
+// release. Secrets are ignored as they may be replaced by resources of another
+// kind.
+func (t *releaseFieldsTransformer) namespaceSource(config *ktypes.Kustomization, resources *types.Resources) (
+	source *referenceSource) {
+
+	for _, id := range utils.SortedIds(resources.ResMap) {
+		res := resources.ResMap[id]
+		if res.GetKind() == "Secret" {
+			continue
+		}
+		namespace, _ := res.GetFieldValue("metadata.namespace")
+		effective := namespace
+		if effective == "" {
+			effective = config.Namespace
+		}
+		if effective != t.namespace {
+			continue
+		}
+		if source != nil {
+			priority, current := referencePriority(res.GetKind()), referencePriority(source.res.GetKind())
+			if priority > current || (priority == current && (res.GetName() != t.name || source.name == t.name)) {
+				continue
+			}
+		}
+		source = &referenceSource{id: id, res: res, name: res.GetName(), namespace: namespace}
+	}
+	return
+}
+
+// nameSources return the resources whose name starts with the release name,
+// the longest names first then services like for references
+func (t *releaseFieldsTransformer) nameSources(resources *types.Resources) (sources []*referenceSource) {
+	for _, id := range utils.SortedIds(resources.ResMap) {
+		res := resources.ResMap[id]
+		if res.GetKind() == "Secret" || !strings.HasPrefix(res.GetName(), t.name) {
+			continue
+		}
+		namespace, _ := res.GetFieldValue("metadata.namespace")
+		sources = append(sources, &referenceSource{id: id, res: res, name: res.GetName(), namespace: namespace})
+	}
+	sort.SliceStable(sources, func(i, j int) bool {
+		if len(sources[i].name) != len(sources[j].name) {
+			return len(sources[i].name) > len(sources[j].name)
+		}
+		return referencePriority(sources[i].res.GetKind()) < referencePriority(sources[j].res.GetKind())
+	})
+	return
+}
+
+// varReferencePath return the path of a field in a var reference of a
+// kustomize configuration, ie: data/app.properties. The list indexes are
+// removed and the slashes of the keys escaped.
+func varReferencePath(path []string) string {
+	fields := withoutIndexes(path)
+	for i, field := range fields {
+		fields[i] = strings.ReplaceAll(field, "/", `\/`)
+	}
+	return strings.Join(fields, "/")
+}
+
+// isReleaseLabel return true if a field is a label or a selector, they
+// identify the release (ie: app.kubernetes.io/instance) and selectors can't
+// change once deployed
+func isReleaseLabel(path []string) bool {
+	for _, field := range path {
+		switch field {
+		case "labels", "matchLabels", "selector":
+			return true
+		}
+	}
+	return false
+}
+
+// derivedFrom describe what a field is derived from
+func (t *releaseFieldsTransformer) derivedFrom(field types.ReleaseField) string {
+	switch {
+	case field.HasName() && field.HasNamespace():
+		return "release name and namespace"
+	case field.HasNamespace():
+		return "release namespace"
+	}
+	return "release name"
+}
+
+// expand return the value of a release field template
+func (t *releaseFieldsTransformer) expand(template string) string {
+	return strings.NewReplacer(
+		types.ReleaseNameMarker, t.name,
+		types.ReleaseNamespaceMarker, t.namespace,
+	).Replace(template)
+}
+
+// indexPath convert the list indexes of a field path from [index] to index
+func indexPath(path []string) []string {
+	output := make([]string, len(path))
+	for i, field := range path {
+		if strings.HasPrefix(field, "[") && strings.HasSuffix(field, "]") {
+			if _, err := strconv.Atoi(field[1 : len(field)-1]); err == nil {
+				field = field[1 : len(field)-1]
+			}
+		}
+		output[i] = field
+	}
+	return output
+}
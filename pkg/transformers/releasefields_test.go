@@ -0,0 +1,888 @@
+package transformers
+
+import (
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/layertwo/helm-convert/pkg/types"
+	"github.com/layertwo/helm-convert/pkg/utils"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/k8sdeps/kunstruct"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	kresid "sigs.k8s.io/kustomize/kyaml/resid"
+	"sigs.k8s.io/kustomize/pkg/gvk"
+	"sigs.k8s.io/kustomize/pkg/resid"
+	"sigs.k8s.io/kustomize/pkg/resmap"
+	"sigs.k8s.io/kustomize/pkg/resource"
+)
+
+type releaseFieldsTransformerArgs struct {
+	config    *ktypes.Kustomization
+	resources *types.Resources
+}
+
+func TestReleaseFieldsRun(t *testing.T) {
+	var configmap = gvk.Gvk{Version: "v1", Kind: "ConfigMap"}
+	var deploy = gvk.Gvk{Group: "apps", Version: "v1", Kind: "Deployment"}
+	var service = gvk.Gvk{Version: "v1", Kind: "Service"}
+	var rf = resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
+
+	var deployment = kresid.ResId{Gvk: kresid.Gvk{Kind: "Deployment"}, Name: "myrel-web", Namespace: "prod"}
+
+	var fields = []types.ReleaseField{
+		{
+			ResourceField: types.ResourceField{
+				Kind:      "Deployment",
+				Name:      "myrel-web",
+				Namespace: "prod",
+				Path:      []string{"spec", "template", "spec", "containers", "[0]", "args", "[0]"},
+			},
+			Value:    "-Dapp.namespace=prod",
+			Template: "-Dapp.namespace={{ .Release.Namespace }}",
+		},
+		{
+			ResourceField: types.ResourceField{
+				Kind:      "Deployment",
+				Name:      "myrel-web",
+				Namespace: "prod",
+				Path:      []string{"spec", "template", "spec", "containers", "[0]", "args", "[1]"},
+			},
+			Value:    "--db=myrel",
+			Template: "--db={{ .Release.Name }}",
+		},
+		{
+			ResourceField: types.ResourceField{
+				Kind:      "Deployment",
+				Name:      "myrel-web",
+				Namespace: "prod",
+				Path:      []string{"spec", "template", "spec", "containers", "[0]", "args", "[2]"},
+			},
+			Value:    "--url=db.prod/myrel",
+			Template: "--url=db.{{ .Release.Namespace }}/{{ .Release.Name }}",
+		},
+		{
+			ResourceField: types.ResourceField{
+				Kind:      "Deployment",
+				Name:      "myrel-web",
+				Namespace: "prod",
+				Path:      []string{"spec", "template", "spec", "containers", "[0]", "args", "[3]"},
+			},
+			Value:    "--id=my",
+			Template: "--id={{ .Release.Name | trunc 2 }}",
+		},
+		{
+			ResourceField: types.ResourceField{
+				Kind:      "Deployment",
+				Name:      "myrel-web",
+				Namespace: "prod",
+				Path:      []string{"spec", "template", "spec", "containers", "[0]", "args", "[4]"},
+			},
+			Value:    "--api=myrel-api:8080",
+			Template: "--api={{ .Release.Name }}-api:8080",
+		},
+		{
+			ResourceField: types.ResourceField{
+				Kind:      "Deployment",
+				Name:      "myrel-web",
+				Namespace: "prod",
+				Path:      []string{"spec", "selector", "matchLabels", "release"},
+			},
+			Value:    "myrel",
+			Template: "{{ .Release.Name }}",
+		},
+		{
+			ResourceField: types.ResourceField{
+				Kind:      "ConfigMap",
+				Name:      "myrel-config",
+				Namespace: "prod",
+				Path:      []string{"data", "url"},
+			},
+			Value:    "http://myrel-api.prod:8080",
+			Template: "http://{{ .Release.Name }}-api.{{ .Release.Namespace }}:8080",
+		},
+	}
+
+	for _, test := range []struct {
+		name     string
+		input    *releaseFieldsTransformerArgs
+		expected *releaseFieldsTransformerArgs
+	}{
+		{
+			name: "it should replace the release name and namespace by vars",
+			input: &releaseFieldsTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResIdWithPrefixNamespace(service, "myrel", "", "prod"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Service",
+								"metadata": map[string]interface{}{
+									"name":      "myrel",
+									"namespace": "prod",
+								},
+								"spec": map[string]interface{}{
+									"ports": []interface{}{
+										map[string]interface{}{
+											"port": 80,
+										},
+									},
+								},
+							}),
+						resid.NewResIdWithPrefixNamespace(service, "myrel-api", "", "prod"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Service",
+								"metadata": map[string]interface{}{
+									"name":      "myrel-api",
+									"namespace": "prod",
+								},
+								"spec": map[string]interface{}{
+									"ports": []interface{}{
+										map[string]interface{}{
+											"port": 8080,
+										},
+									},
+								},
+							}),
+						resid.NewResIdWithPrefixNamespace(configmap, "myrel-config", "", "prod"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "ConfigMap",
+								"metadata": map[string]interface{}{
+									"name":      "myrel-config",
+									"namespace": "prod",
+								},
+								"data": map[string]interface{}{
+									"url": "http://myrel-api.prod:8080",
+								},
+							}),
+						resid.NewResIdWithPrefixNamespace(deploy, "myrel-web", "", "prod"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name":      "myrel-web",
+									"namespace": "prod",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name":  "web",
+													"image": "web:1.0",
+													"args": []interface{}{
+														"-Dapp.namespace=prod",
+														"--db=myrel",
+														"--url=db.prod/myrel",
+														"--id=my",
+														"--api=myrel-api:8080",
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					ReleaseFields: fields,
+					SourceFiles:   map[string]string{},
+				},
+			},
+			expected: &releaseFieldsTransformerArgs{
+				config: &ktypes.Kustomization{
+					Vars: []ktypes.Var{
+						{
+							Name: "SERVICE_MYREL_API_NAME",
+							ObjRef: ktypes.Target{
+								APIVersion: "v1",
+								Gvk:        kresid.Gvk{Kind: "Service"},
+								Name:       "myrel-api",
+								Namespace:  "prod",
+							},
+							FieldRef: ktypes.FieldSelector{FieldPath: "metadata.name"},
+						},
+						{
+							Name: "SERVICE_MYREL_NAME",
+							ObjRef: ktypes.Target{
+								APIVersion: "v1",
+								Gvk:        kresid.Gvk{Kind: "Service"},
+								Name:       "myrel",
+								Namespace:  "prod",
+							},
+							FieldRef: ktypes.FieldSelector{FieldPath: "metadata.name"},
+						},
+						{
+							Name: "SERVICE_MYREL_NAMESPACE",
+							ObjRef: ktypes.Target{
+								APIVersion: "v1",
+								Gvk:        kresid.Gvk{Kind: "Service"},
+								Name:       "myrel",
+								Namespace:  "prod",
+							},
+							FieldRef: ktypes.FieldSelector{FieldPath: "metadata.namespace"},
+						},
+					},
+					Configurations: []string{"kustomizeconfig/releasefields.yaml"},
+				},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResIdWithPrefixNamespace(service, "myrel", "", "prod"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Service",
+								"metadata": map[string]interface{}{
+									"name":      "myrel",
+									"namespace": "prod",
+								},
+								"spec": map[string]interface{}{
+									"ports": []interface{}{
+										map[string]interface{}{
+											"port": 80,
+										},
+									},
+								},
+							}),
+						resid.NewResIdWithPrefixNamespace(service, "myrel-api", "", "prod"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Service",
+								"metadata": map[string]interface{}{
+									"name":      "myrel-api",
+									"namespace": "prod",
+								},
+								"spec": map[string]interface{}{
+									"ports": []interface{}{
+										map[string]interface{}{
+											"port": 8080,
+										},
+									},
+								},
+							}),
+						resid.NewResIdWithPrefixNamespace(configmap, "myrel-config", "", "prod"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "ConfigMap",
+								"metadata": map[string]interface{}{
+									"name":      "myrel-config",
+									"namespace": "prod",
+								},
+								"data": map[string]interface{}{
+									"url": "http://$(SERVICE_MYREL_API_NAME).$(SERVICE_MYREL_NAMESPACE):8080",
+								},
+							}),
+						resid.NewResIdWithPrefixNamespace(deploy, "myrel-web", "", "prod"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name":      "myrel-web",
+									"namespace": "prod",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name":  "web",
+													"image": "web:1.0",
+													"args": []interface{}{
+														"-Dapp.namespace=$(SERVICE_MYREL_NAMESPACE)",
+														"--db=$(SERVICE_MYREL_NAME)",
+														"--url=db.$(SERVICE_MYREL_NAMESPACE)/$(SERVICE_MYREL_NAME)",
+														"--id=my",
+														"--api=$(SERVICE_MYREL_API_NAME):8080",
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					ReleaseFields: fields,
+					SourceFiles: map[string]string{
+						"kustomizeconfig/releasefields.yaml": "varReference:\n- kind: ConfigMap\n  path: data/url\n",
+					},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "releasefields",
+								Kind:        "Deployment",
+								Name:        "myrel-web",
+								Path:        "spec.template.spec.containers[0].args[0]",
+								Message: "derived from the release namespace (-Dapp.namespace={{ .Release.Namespace }}), " +
+									"replaced by the namespace of Service 'myrel'",
+							},
+							{
+								Transformer: "releasefields",
+								Kind:        "Deployment",
+								Name:        "myrel-web",
+								Path:        "spec.template.spec.containers[0].args[1]",
+								Message: "derived from the release name (--db={{ .Release.Name }}), " +
+									"replaced by the name of Service 'myrel'",
+							},
+							{
+								Transformer: "releasefields",
+								Kind:        "Deployment",
+								Name:        "myrel-web",
+								Path:        "spec.template.spec.containers[0].args[2]",
+								Message: "derived from the release name and namespace " +
+									"(--url=db.{{ .Release.Namespace }}/{{ .Release.Name }}), replaced by the namespace " +
+									"of Service 'myrel' and the name of Service 'myrel'",
+							},
+							{
+								Transformer: "releasefields",
+								Kind:        "Deployment",
+								Name:        "myrel-web",
+								Path:        "spec.template.spec.containers[0].args[3]",
+								Message: "derived from the release name (--id={{ .Release.Name | trunc 2 }}), " +
+									"the value isn't copied verbatim (ie: truncated), it must be updated manually",
+							},
+							{
+								Transformer: "releasefields",
+								Kind:        "Deployment",
+								Name:        "myrel-web",
+								Path:        "spec.template.spec.containers[0].args[4]",
+								Message: "derived from the release name (--api={{ .Release.Name }}-api:8080), " +
+									"replaced by the name of Service 'myrel-api'",
+							},
+							{
+								Transformer: "releasefields",
+								Kind:        "ConfigMap",
+								Name:        "myrel-config",
+								Path:        "data.url",
+								Message: "derived from the release name and namespace " +
+									"(http://{{ .Release.Name }}-api.{{ .Release.Namespace }}:8080), replaced by the " +
+									"name of Service 'myrel-api' and the namespace of Service 'myrel'",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "it should report the fields without source and skip the existing replacements",
+			input: &releaseFieldsTransformerArgs{
+				config: &ktypes.Kustomization{
+					Replacements: []ktypes.ReplacementField{
+						{
+							Replacement: ktypes.Replacement{
+								Source: &ktypes.SourceSelector{ResId: deployment, FieldPath: "metadata.namespace"},
+								Targets: []*ktypes.TargetSelector{
+									{
+										Select:     &ktypes.Selector{ResId: deployment},
+										FieldPaths: []string{"spec.template.spec.containers.0.args.0"},
+										Options:    &ktypes.FieldOptions{Delimiter: "=", Index: 1},
+									},
+								},
+							},
+						},
+					},
+				},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResIdWithPrefixNamespace(deploy, "myrel-web", "", "prod"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name":      "myrel-web",
+									"namespace": "prod",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name":  "web",
+													"image": "web:1.0",
+													"args": []interface{}{
+														"-Dapp.namespace=prod",
+														"--db=myrel",
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					ReleaseFields: fields[:2],
+				},
+			},
+			expected: &releaseFieldsTransformerArgs{
+				config: &ktypes.Kustomization{
+					Replacements: []ktypes.ReplacementField{
+						{
+							Replacement: ktypes.Replacement{
+								Source: &ktypes.SourceSelector{ResId: deployment, FieldPath: "metadata.namespace"},
+								Targets: []*ktypes.TargetSelector{
+									{
+										Select:     &ktypes.Selector{ResId: deployment},
+										FieldPaths: []string{"spec.template.spec.containers.0.args.0"},
+										Options:    &ktypes.FieldOptions{Delimiter: "=", Index: 1},
+									},
+								},
+							},
+						},
+					},
+				},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResIdWithPrefixNamespace(deploy, "myrel-web", "", "prod"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name":      "myrel-web",
+									"namespace": "prod",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name":  "web",
+													"image": "web:1.0",
+													"args": []interface{}{
+														"-Dapp.namespace=prod",
+														"--db=myrel",
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					ReleaseFields: fields[:2],
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "releasefields",
+								Kind:        "Deployment",
+								Name:        "myrel-web",
+								Path:        "spec.template.spec.containers[0].args[1]",
+								Message: "derived from the release name (--db={{ .Release.Name }}), " +
+									"no resource holds the release name, it must be updated manually",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "it should use the namespace of the kustomization for the resources without namespace",
+			input: &releaseFieldsTransformerArgs{
+				config: &ktypes.Kustomization{Namespace: "prod"},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(service, "myrel"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Service",
+								"metadata": map[string]interface{}{
+									"name": "myrel",
+								},
+								"spec": map[string]interface{}{
+									"ports": []interface{}{
+										map[string]interface{}{
+											"port": 80,
+										},
+									},
+								},
+							}),
+						resid.NewResId(deploy, "myrel-web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "myrel-web",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name":  "web",
+													"image": "web:1.0",
+													"args": []interface{}{
+														"-Dapp.namespace=prod",
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					ReleaseFields: []types.ReleaseField{
+						{
+							ResourceField: types.ResourceField{
+								Kind: "Deployment",
+								Name: "myrel-web",
+								Path: []string{"spec", "template", "spec", "containers", "[0]", "args", "[0]"},
+							},
+							Value:    "-Dapp.namespace=prod",
+							Template: "-Dapp.namespace={{ .Release.Namespace }}",
+						},
+					},
+				},
+			},
+			expected: &releaseFieldsTransformerArgs{
+				config: &ktypes.Kustomization{
+					Namespace: "prod",
+					Vars: []ktypes.Var{
+						{
+							Name:     "SERVICE_MYREL_NAMESPACE",
+							ObjRef:   ktypes.Target{APIVersion: "v1", Gvk: kresid.Gvk{Kind: "Service"}, Name: "myrel"},
+							FieldRef: ktypes.FieldSelector{FieldPath: "metadata.namespace"},
+						},
+					},
+				},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(service, "myrel"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Service",
+								"metadata": map[string]interface{}{
+									"name": "myrel",
+								},
+								"spec": map[string]interface{}{
+									"ports": []interface{}{
+										map[string]interface{}{
+											"port": 80,
+										},
+									},
+								},
+							}),
+						resid.NewResId(deploy, "myrel-web"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "apps/v1",
+								"kind":       "Deployment",
+								"metadata": map[string]interface{}{
+									"name": "myrel-web",
+								},
+								"spec": map[string]interface{}{
+									"template": map[string]interface{}{
+										"spec": map[string]interface{}{
+											"containers": []interface{}{
+												map[string]interface{}{
+													"name":  "web",
+													"image": "web:1.0",
+													"args": []interface{}{
+														"-Dapp.namespace=$(SERVICE_MYREL_NAMESPACE)",
+													},
+												},
+											},
+										},
+									},
+								},
+							}),
+					},
+					ReleaseFields: []types.ReleaseField{
+						{
+							ResourceField: types.ResourceField{
+								Kind: "Deployment",
+								Name: "myrel-web",
+								Path: []string{"spec", "template", "spec", "containers", "[0]", "args", "[0]"},
+							},
+							Value:    "-Dapp.namespace=prod",
+							Template: "-Dapp.namespace={{ .Release.Namespace }}",
+						},
+					},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "releasefields",
+								Kind:        "Deployment",
+								Name:        "myrel-web",
+								Path:        "spec.template.spec.containers[0].args[0]",
+								Message: "derived from the release namespace (-Dapp.namespace={{ .Release.Namespace }}), " +
+									"replaced by the namespace of Service 'myrel'",
+							},
+						},
+					},
+				},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := NewReleaseFieldsTransformer("myrel", "prod").Transform(test.input.config, test.input.resources)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := pretty.Compare(test.input, test.expected); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+		})
+	}
+}
+
+// TestReleaseFieldsRoundTrip build the converted chart as a base of an
+// overlay changing the namespace and the name prefix
+func TestReleaseFieldsRoundTrip(t *testing.T) {
+	var configmap = gvk.Gvk{Version: "v1", Kind: "ConfigMap"}
+	var deploy = gvk.Gvk{Group: "apps", Version: "v1", Kind: "Deployment"}
+	var service = gvk.Gvk{Version: "v1", Kind: "Service"}
+	var rf = resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
+
+	for _, test := range []struct {
+		name      string
+		namespace string
+		resources resmap.ResMap
+	}{
+		{
+			name:      "chart setting the namespace",
+			namespace: "prod",
+			resources: resmap.ResMap{
+				resid.NewResIdWithPrefixNamespace(service, "myrel", "", "prod"): rf.FromMap(
+					map[string]interface{}{
+						"apiVersion": "v1",
+						"kind":       "Service",
+						"metadata": map[string]interface{}{
+							"name":      "myrel",
+							"namespace": "prod",
+						},
+						"spec": map[string]interface{}{
+							"ports": []interface{}{
+								map[string]interface{}{
+									"port": 80,
+								},
+							},
+						},
+					}),
+				resid.NewResIdWithPrefixNamespace(service, "myrel-api", "", "prod"): rf.FromMap(
+					map[string]interface{}{
+						"apiVersion": "v1",
+						"kind":       "Service",
+						"metadata": map[string]interface{}{
+							"name":      "myrel-api",
+							"namespace": "prod",
+						},
+						"spec": map[string]interface{}{
+							"ports": []interface{}{
+								map[string]interface{}{
+									"port": 8080,
+								},
+							},
+						},
+					}),
+				resid.NewResIdWithPrefixNamespace(configmap, "myrel-config", "", "prod"): rf.FromMap(
+					map[string]interface{}{
+						"apiVersion": "v1",
+						"kind":       "ConfigMap",
+						"metadata": map[string]interface{}{
+							"name":      "myrel-config",
+							"namespace": "prod",
+						},
+						"data": map[string]interface{}{
+							"url": "http://myrel-api.prod:8080",
+						},
+					}),
+				resid.NewResIdWithPrefixNamespace(deploy, "myrel-web", "", "prod"): rf.FromMap(
+					map[string]interface{}{
+						"apiVersion": "apps/v1",
+						"kind":       "Deployment",
+						"metadata": map[string]interface{}{
+							"name":      "myrel-web",
+							"namespace": "prod",
+						},
+						"spec": map[string]interface{}{
+							"template": map[string]interface{}{
+								"spec": map[string]interface{}{
+									"containers": []interface{}{
+										map[string]interface{}{
+											"name":  "web",
+											"image": "web:1.0",
+											"args": []interface{}{
+												"-Dapp.namespace=prod",
+												"--db=myrel",
+											},
+										},
+									},
+								},
+							},
+						},
+					}),
+			},
+		},
+		{
+			name: "chart without namespace",
+			resources: resmap.ResMap{
+				resid.NewResId(service, "myrel"): rf.FromMap(
+					map[string]interface{}{
+						"apiVersion": "v1",
+						"kind":       "Service",
+						"metadata": map[string]interface{}{
+							"name": "myrel",
+						},
+						"spec": map[string]interface{}{
+							"ports": []interface{}{
+								map[string]interface{}{
+									"port": 80,
+								},
+							},
+						},
+					}),
+				resid.NewResId(service, "myrel-api"): rf.FromMap(
+					map[string]interface{}{
+						"apiVersion": "v1",
+						"kind":       "Service",
+						"metadata": map[string]interface{}{
+							"name": "myrel-api",
+						},
+						"spec": map[string]interface{}{
+							"ports": []interface{}{
+								map[string]interface{}{
+									"port": 8080,
+								},
+							},
+						},
+					}),
+				resid.NewResId(configmap, "myrel-config"): rf.FromMap(
+					map[string]interface{}{
+						"apiVersion": "v1",
+						"kind":       "ConfigMap",
+						"metadata": map[string]interface{}{
+							"name": "myrel-config",
+						},
+						"data": map[string]interface{}{
+							"url": "http://myrel-api.prod:8080",
+						},
+					}),
+				resid.NewResId(deploy, "myrel-web"): rf.FromMap(
+					map[string]interface{}{
+						"apiVersion": "apps/v1",
+						"kind":       "Deployment",
+						"metadata": map[string]interface{}{
+							"name": "myrel-web",
+						},
+						"spec": map[string]interface{}{
+							"template": map[string]interface{}{
+								"spec": map[string]interface{}{
+									"containers": []interface{}{
+										map[string]interface{}{
+											"name":  "web",
+											"image": "web:1.0",
+											"args": []interface{}{
+												"-Dapp.namespace=prod",
+												"--db=myrel",
+											},
+										},
+									},
+								},
+							},
+						},
+					}),
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			config := &ktypes.Kustomization{}
+			if test.namespace == "" {
+				config.Namespace = "prod"
+			}
+
+			resources := types.NewResources()
+			resources.ResMap = test.resources
+			resources.ReleaseFields = []types.ReleaseField{
+				{
+					ResourceField: types.ResourceField{
+						Kind:      "Deployment",
+						Name:      "myrel-web",
+						Namespace: test.namespace,
+						Path:      []string{"spec", "template", "spec", "containers", "[0]", "args", "[0]"},
+					},
+					Value:    "-Dapp.namespace=prod",
+					Template: "-Dapp.namespace={{ .Release.Namespace }}",
+				},
+				{
+					ResourceField: types.ResourceField{
+						Kind:      "Deployment",
+						Name:      "myrel-web",
+						Namespace: test.namespace,
+						Path:      []string{"spec", "template", "spec", "containers", "[0]", "args", "[1]"},
+					},
+					Value:    "--db=myrel",
+					Template: "--db={{ .Release.Name }}",
+				},
+				{
+					ResourceField: types.ResourceField{
+						Kind:      "ConfigMap",
+						Name:      "myrel-config",
+						Namespace: test.namespace,
+						Path:      []string{"data", "url"},
+					},
+					Value:    "http://myrel-api.prod:8080",
+					Template: "http://{{ .Release.Name }}-api.{{ .Release.Namespace }}:8080",
+				},
+			}
+
+			if err := NewReleaseFieldsTransformer("myrel", "prod").Transform(config, resources); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, entry := range resources.Report.Entries {
+				if strings.Contains(entry.Message, "manually") {
+					t.Errorf("unexpected manual field %s: %s", entry.Path, entry.Message)
+				}
+			}
+
+			fs := filesys.MakeFsInMemory()
+			for id, res := range resources.ResMap {
+				output, err := yaml.Marshal(res.Map())
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				filename, err := utils.GetResourceFileName(id, res)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				fs.WriteFile(path.Join("/base", filename), []byte(output))
+				config.Resources = append(config.Resources, filename)
+			}
+			for filename, content := range resources.SourceFiles {
+				fs.WriteFile(path.Join("/base", filename), []byte(content))
+			}
+			base, err := yaml.Marshal(config)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			fs.WriteFile("/base/kustomization.yaml", base)
+
+			overlay := &ktypes.Kustomization{NamePrefix: "pre-", Namespace: "staging", Resources: []string{"../base"}}
+			output, err := kustomizeBuild(fs, overlay)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			obj, _ := output["Deployment/pre-myrel-web"].(map[string]interface{})
+			for i, expected := range []string{"-Dapp.namespace=staging", "--db=pre-myrel"} {
+				value, err := getFieldValue(obj, []string{"spec", "template", "spec", "containers", "0", "args", strconv.Itoa(i)})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if value != expected {
+					t.Errorf("args[%d]: expected '%s', got '%s'", i, expected, value)
+				}
+			}
+
+			obj, _ = output["ConfigMap/pre-myrel-config"].(map[string]interface{})
+			value, err := getFieldValue(obj, []string{"data", "url"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if expected := "http://pre-myrel-api.staging:8080"; value != expected {
+				t.Errorf("url: expected '%s', got '%s'", expected, value)
+			}
+		})
+	}
+}
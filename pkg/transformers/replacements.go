@@ -0,0 +1,632 @@
+package transformers
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/layertwo/helm-convert/pkg/types"
+	"github.com/layertwo/helm-convert/pkg/utils"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	kresid "sigs.k8s.io/kustomize/kyaml/resid"
+	"sigs.k8s.io/kustomize/pkg/resid"
+	"sigs.k8s.io/kustomize/pkg/resource"
+)
+
+// referenceDelimiters are the delimiters tried to isolate a name in a string
+// field, a replacement can only replace one part of a delimited string
+var referenceDelimiters = []string{"/", "=", ":", ".", ",", " ", "@", ";"}
+
+// referenceIgnoredFields are the fields never considered as a reference:
+// identifiers of list elements, selectors and external hostnames
+var referenceIgnoredFields = map[string]struct{}{
+	"name":        {},
+	"labels":      {},
+	"matchLabels": {},
+	"selector":    {},
+	"host":        {},
+	"hosts":       {},
+}
+
+// Characters allowed in a resource name, a reference must not be surrounded
+// by one of them. A dot is allowed after the name (ie: svc.ns.svc.cluster.local)
+const referenceNameChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_"
+
+// referenceNameFields are the fields holding only the name of another
+// resource, they are already updated by the name reference transformer of
+// kustomize (ie: volumes[].configMap.name, the "name" key is never walked)
+var referenceNameFields = map[string]struct{}{
+	"claimName":          {},
+	"ingressClassName":   {},
+	"priorityClassName":  {},
+	"runtimeClassName":   {},
+	"secretName":         {},
+	"serviceAccount":     {},
+	"serviceAccountName": {},
+	"serviceName":        {},
+	"storageClassName":   {},
+	"volumeName":         {},
+}
+
+// referenceVarFields are the container fields in which kustomize substitutes
+// vars, env values are matched as env.value
+var referenceVarFields = []string{"args", "command", "env.value"}
+
+type replacementsTransformer struct {
+	vars bool
+}
+
+var _ Transformer = &replacementsTransformer{}
+
+// NewReplacementsTransformer constructs a replacementsTransformer. If vars is
+// true, legacy vars are used instead of replacements.
+func NewReplacementsTransformer(vars bool) Transformer {
+	return &replacementsTransformer{vars: vars}
+}
+
+// referenceSource is a resource whose name may be referenced by others
+type referenceSource struct {
+	id        resid.ResId
+	res       *resource.Resource
+	name      string
+	namespace string
+}
+
+// reference is an occurrence of the name of a resource in a string field
+type reference struct {
+	source *referenceSource
+	target *resource.Resource
+
+	// path of the field from the root of the target, list indexes included
+	path []string
+
+	// value of the field and position of the name in the value
+	value      string
+	start, end int
+
+	// true if the name is followed by the namespace of the source, ie:
+	// svc.ns.svc.cluster.local
+	withNamespace bool
+}
+
+// Transform finds the literal names of other resources in string fields and
+// replace them with kustomize replacements, or vars, so that namePrefix and
+// namespace changes propagate to these fields
+func (t *replacementsTransformer) Transform(config *ktypes.Kustomization, resources *types.Resources) error {
+	sources := t.referenceSources(resources)
+	if len(sources) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	// longest names first, myrel-redis-master is preferred over myrel-redis
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) > len(names[j])
+		}
+		return names[i] < names[j]
+	})
+
+	// fields already targeted by a replacement (ie: added by another
+	// transformer) are left untouched
+	targeted := make(map[string]struct{})
+	for _, replacement := range config.Replacements {
+		for _, target := range replacement.Targets {
+			if target.Select == nil {
+				continue
+			}
+			for _, fieldPath := range target.FieldPaths {
+				targeted[target.Select.ResId.String()+"|"+fieldPath] = struct{}{}
+			}
+		}
+	}
+
+	var references []*reference
+	for _, id := range utils.SortedIds(resources.ResMap) {
+		res := resources.ResMap[id]
+		if res.GetKind() == "Secret" {
+			continue
+		}
+
+		walkStrings(res.Map(), nil, func(path []string, value string) {
+			if _, found := targeted[referenceResId(res).String()+"|"+replacementFieldPath(path)]; found {
+				return
+			}
+			for _, ref := range findReferences(sources, names, res, path, value) {
+				if !t.isNameReference(ref) {
+					references = append(references, ref)
+				}
+			}
+		})
+	}
+
+	if t.vars {
+		return t.addVars(config, references)
+	}
+
+	return t.addReplacements(config, references)
+}
+
+// referenceSources return the resources which can be referenced, indexed by
+// name. Secrets are ignored as they may be replaced by resources of another
+// kind (ie: ExternalSecret).
+func (t *replacementsTransformer) referenceSources(resources *types.Resources) map[string][]*referenceSource {
+	sources := make(map[string][]*referenceSource)
+	for _, id := range utils.SortedIds(resources.ResMap) {
+		res := resources.ResMap[id]
+		if res.GetKind() == "Secret" || res.GetName() == "" {
+			continue
+		}
+		namespace, _ := res.GetFieldValue("metadata.namespace")
+		sources[res.GetName()] = append(sources[res.GetName()], &referenceSource{
+			id:        id,
+			res:       res,
+			name:      res.GetName(),
+			namespace: namespace,
+		})
+	}
+
+	// services are the most likely to be referenced, generated ConfigMaps get
+	// a hash suffix and are the least likely
+	for _, list := range sources {
+		sort.SliceStable(list, func(i, j int) bool {
+			return referencePriority(list[i].res.GetKind()) < referencePriority(list[j].res.GetKind())
+		})
+	}
+
+	return sources
+}
+
+// referencePriority return the priority of a kind when several resources
+// share the same name
+func referencePriority(kind string) int {
+	switch kind {
+	case "Service":
+		return 0
+	case "ConfigMap":
+		return 2
+	}
+	return 1
+}
+
+// findReferences return the references to other resources found in a string
+// field, ordered by position
+func findReferences(sources map[string][]*referenceSource, names []string, target *resource.Resource,
+	path []string, value string) (references []*reference) {
+
+	taken := make([]bool, len(value))
+
+NAMES:
+	for _, name := range names {
+		for _, start := range indexReferences(value, name) {
+			end := start + len(name)
+			for i := start; i < end; i++ {
+				if taken[i] {
+					continue NAMES
+				}
+			}
+
+			for _, source := range sources[name] {
+				if source.res == target {
+					continue
+				}
+
+				ref := &reference{
+					source: source,
+					target: target,
+					path:   path,
+					value:  value,
+					start:  start,
+					end:    end,
+				}
+
+				// name.namespace.svc.cluster.local form
+				if source.res.GetKind() == "Service" && strings.HasPrefix(value[end:], ".") {
+					ns := leadingName(value[end+1:])
+					isService := strings.HasPrefix(value[end+1+len(ns):], ".svc")
+					switch {
+					case source.namespace != "" && ns == source.namespace:
+						ref.withNamespace = true
+					case source.namespace != "" && isService:
+						// service of another namespace
+						continue
+					case isService:
+						glog.Warningf("The namespace '%s' of the service '%s' referenced in %s '%s' "+
+							"won't follow the namespace of the kustomization", ns, name,
+							target.GetKind(), target.GetName())
+					}
+				}
+
+				for i := start; i < end; i++ {
+					taken[i] = true
+				}
+				references = append(references, ref)
+				break
+			}
+		}
+	}
+
+	sort.Slice(references, func(i, j int) bool {
+		return references[i].start < references[j].start
+	})
+
+	return
+}
+
+// indexReferences return the positions of a name in a value, the name must
+// not be part of a larger name
+func indexReferences(value, name string) (positions []int) {
+	offset := 0
+	for {
+		i := strings.Index(value[offset:], name)
+		if i < 0 {
+			return
+		}
+		start := offset + i
+		end := start + len(name)
+
+		before := start == 0 || !strings.ContainsRune(referenceNameChars+".", rune(value[start-1]))
+		after := end == len(value) || !strings.ContainsRune(referenceNameChars, rune(value[end]))
+		if before && after {
+			positions = append(positions, start)
+		}
+		offset = start + 1
+	}
+}
+
+// leadingName return the name found at the beginning of a string
+func leadingName(s string) string {
+	for i, r := range s {
+		if !strings.ContainsRune(referenceNameChars, r) {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// isNameReference return true if the field is already updated by the name
+// reference transformer of kustomize (ie: spec.serviceName)
+func (t *replacementsTransformer) isNameReference(ref *reference) bool {
+	if ref.start != 0 || ref.end != len(ref.value) {
+		return false
+	}
+	_, found := referenceNameFields[ref.path[len(ref.path)-1]]
+	return found
+}
+
+// isVarReference return true if vars are substituted in the field, ie: the
+// args, command or env values of a container
+func (t *replacementsTransformer) isVarReference(ref *reference) bool {
+	return isContainerVarField(ref.path)
+}
+
+// isContainerVarField return true if a field is substituted by the default
+// var references of kustomize, the path contains the list indexes
+func isContainerVarField(path []string) bool {
+	path = withoutIndexes(path)
+	for i, field := range path {
+		if field != "containers" && field != "initContainers" {
+			continue
+		}
+		containerPath := strings.Join(path[i+1:], ".")
+		for _, varField := range referenceVarFields {
+			if containerPath == varField {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addReplacements add a replacement for each referenced resource, the part of
+// the field holding the name is isolated with a delimiter
+func (t *replacementsTransformer) addReplacements(config *ktypes.Kustomization, references []*reference) error {
+	replacements := make(map[string]*ktypes.Replacement)
+
+	add := func(source *referenceSource, fieldPath string, ref *reference, options *ktypes.FieldOptions) {
+		key := source.id.String() + "|" + fieldPath
+		replacement, ok := replacements[key]
+		if !ok {
+			replacement = &ktypes.Replacement{
+				Source: &ktypes.SourceSelector{
+					ResId:     referenceResId(source.res),
+					FieldPath: fieldPath,
+				},
+			}
+			replacements[key] = replacement
+		}
+
+		selector := referenceResId(ref.target)
+		targetPath := replacementFieldPath(ref.path)
+		for _, target := range replacement.Targets {
+			if target.Select.ResId == selector && fieldOptionsEqual(target.Options, options) {
+				target.FieldPaths = append(target.FieldPaths, targetPath)
+				return
+			}
+		}
+		replacement.Targets = append(replacement.Targets, &ktypes.TargetSelector{
+			Select:     &ktypes.Selector{ResId: selector},
+			FieldPaths: []string{targetPath},
+			Options:    options,
+		})
+	}
+
+	for _, ref := range references {
+		if ref.start == 0 && ref.end == len(ref.value) {
+			add(ref.source, "metadata.name", ref, nil)
+			continue
+		}
+
+		delimiter, index := isolateReference(ref)
+		if delimiter == "" {
+			glog.Warningf("The reference to %s '%s' in the field '%s' of %s '%s' can't be expressed as "+
+				"a replacement, it must be updated manually", ref.source.res.GetKind(), ref.source.name,
+				replacementFieldPath(ref.path), ref.target.GetKind(), ref.target.GetName())
+			continue
+		}
+
+		add(ref.source, "metadata.name", ref, &ktypes.FieldOptions{Delimiter: delimiter, Index: index})
+
+		if !ref.withNamespace {
+			continue
+		}
+		if parts := strings.Split(ref.value, delimiter); delimiter == "." && parts[index+1] == ref.source.namespace {
+			add(ref.source, "metadata.namespace", ref, &ktypes.FieldOptions{Delimiter: delimiter, Index: index + 1})
+		} else {
+			glog.Warningf("The namespace of the service '%s' referenced in the field '%s' of %s '%s' can't be "+
+				"expressed as a replacement, it must be updated manually", ref.source.name,
+				replacementFieldPath(ref.path), ref.target.GetKind(), ref.target.GetName())
+		}
+	}
+
+	keys := make([]string, 0, len(replacements))
+	for key := range replacements {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		config.Replacements = append(config.Replacements, ktypes.ReplacementField{
+			Replacement: *replacements[key],
+		})
+	}
+
+	return nil
+}
+
+// addVars replace each reference by a var, vars are only substituted in a
+// limited set of fields
+func (t *replacementsTransformer) addVars(config *ktypes.Kustomization, references []*reference) error {
+	vars := make(map[string]ktypes.Var)
+
+	// references of a field are substituted from the last one so that the
+	// positions of the previous ones remain valid
+	for i := len(references) - 1; i >= 0; i-- {
+		ref := references[i]
+		if !t.isVarReference(ref) {
+			glog.Warningf("The reference to %s '%s' in the field '%s' of %s '%s' can't be expressed as "+
+				"a var, it must be updated manually", ref.source.res.GetKind(), ref.source.name,
+				replacementFieldPath(ref.path), ref.target.GetKind(), ref.target.GetName())
+			continue
+		}
+
+		value, err := getFieldValue(ref.target.Map(), ref.path)
+		if err != nil {
+			return err
+		}
+
+		nameVar := referenceVar(ref.source, "metadata.name")
+		vars[nameVar.Name] = nameVar
+		substitute := "$(" + nameVar.Name + ")"
+		end := ref.end
+
+		if ref.withNamespace {
+			namespaceVar := referenceVar(ref.source, "metadata.namespace")
+			vars[namespaceVar.Name] = namespaceVar
+			substitute += ".$(" + namespaceVar.Name + ")"
+			end += 1 + len(ref.source.namespace)
+		}
+
+		if err := setFieldValue(ref.target.Map(), ref.path, value[:ref.start]+substitute+value[end:]); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		config.Vars = append(config.Vars, vars[name])
+	}
+
+	return nil
+}
+
+// isolateReference return a delimiter and the index of the part holding the
+// referenced name once the value is split
+func isolateReference(ref *reference) (string, int) {
+	for _, delimiter := range referenceDelimiters {
+		if strings.Contains(ref.source.name, delimiter) {
+			continue
+		}
+		before := ref.value[:ref.start]
+		after := ref.value[ref.end:]
+		if (before != "" && !strings.HasSuffix(before, delimiter)) ||
+			(after != "" && !strings.HasPrefix(after, delimiter)) {
+			continue
+		}
+		return delimiter, strings.Count(before, delimiter)
+	}
+	return "", 0
+}
+
+// referenceResId return the kustomize id used to select a resource
+func referenceResId(res *resource.Resource) kresid.ResId {
+	id := kresid.ResId{
+		Gvk:  kresid.Gvk{Kind: res.GetKind()},
+		Name: res.GetName(),
+	}
+	if namespace, err := res.GetFieldValue("metadata.namespace"); err == nil {
+		id.Namespace = namespace
+	}
+	return id
+}
+
+// referenceVar return the var holding a field of a resource, ie:
+// SERVICE_MYREL_REDIS_NAME
+func referenceVar(source *referenceSource, fieldPath string) ktypes.Var {
+	field := strings.TrimPrefix(fieldPath, "metadata.")
+	name := strings.ToUpper(regexpVarName.ReplaceAllString(
+		fmt.Sprintf("%s_%s_%s", source.res.GetKind(), source.name, field), "_"))
+
+	group, version := source.id.Gvk().Group, source.id.Gvk().Version
+	apiVersion := version
+	if group != "" {
+		apiVersion = group + "/" + version
+	}
+
+	return ktypes.Var{
+		Name: name,
+		ObjRef: ktypes.Target{
+			APIVersion: apiVersion,
+			Gvk:        kresid.Gvk{Kind: source.res.GetKind()},
+			Name:       source.name,
+			Namespace:  source.namespace,
+		},
+		FieldRef: ktypes.FieldSelector{FieldPath: fieldPath},
+	}
+}
+
+// Characters not allowed in a var name
+var regexpVarName = regexp.MustCompile("[^A-Za-z0-9_]+")
+
+// replacementFieldPath return a kustomize field path, keys containing a dot
+// are escaped with brackets
+func replacementFieldPath(path []string) string {
+	fields := make([]string, len(path))
+	for i, field := range path {
+		if strings.Contains(field, ".") {
+			field = "[" + field + "]"
+		}
+		fields[i] = field
+	}
+	return strings.Join(fields, ".")
+}
+
+// withoutIndexes return a path without the list indexes
+func withoutIndexes(path []string) (output []string) {
+	for _, field := range path {
+		if _, err := strconv.Atoi(field); err != nil {
+			output = append(output, field)
+		}
+	}
+	return
+}
+
+func fieldOptionsEqual(a, b *ktypes.FieldOptions) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// walkStrings call fn for each single line string field of an object, list
+// indexes are part of the path. Metadata identifying the resource and ignored
+// fields are skipped.
+func walkStrings(value interface{}, path []string, fn func([]string, string)) {
+	switch typedV := value.(type) {
+	case map[string]interface{}:
+		for _, key := range sortedInterfaceKeys(typedV) {
+			if _, ignored := referenceIgnoredFields[key]; ignored {
+				continue
+			}
+			if len(path) == 0 && (key == "apiVersion" || key == "kind" || key == "binaryData") {
+				continue
+			}
+			if len(path) == 1 && path[0] == "metadata" && key == "namespace" {
+				continue
+			}
+			walkStrings(typedV[key], append(append([]string{}, path...), key), fn)
+		}
+	case []interface{}:
+		for i, item := range typedV {
+			walkStrings(item, append(append([]string{}, path...), strconv.Itoa(i)), fn)
+		}
+	case string:
+		if !isMultiline(typedV) {
+			fn(path, typedV)
+		}
+	}
+}
+
+// getFieldValue return the value of a string field found with walkStrings
+func getFieldValue(obj map[string]interface{}, path []string) (string, error) {
+	var current interface{} = obj
+	for _, field := range path {
+		switch typedV := current.(type) {
+		case map[string]interface{}:
+			current = typedV[field]
+		case []interface{}:
+			index, err := strconv.Atoi(field)
+			if err != nil || index >= len(typedV) {
+				return "", fmt.Errorf("invalid path %s", strings.Join(path, "."))
+			}
+			current = typedV[index]
+		default:
+			return "", fmt.Errorf("invalid path %s", strings.Join(path, "."))
+		}
+	}
+	value, ok := current.(string)
+	if !ok {
+		return "", fmt.Errorf("invalid path %s", strings.Join(path, "."))
+	}
+	return value, nil
+}
+
+// setFieldValue set the value of a field found with walkStrings
+func setFieldValue(obj map[string]interface{}, path []string, value string) error {
+	var current interface{} = obj
+	for i, field := range path {
+		last := i == len(path)-1
+		switch typedV := current.(type) {
+		case map[string]interface{}:
+			if last {
+				typedV[field] = value
+				return nil
+			}
+			current = typedV[field]
+		case []interface{}:
+			index, err := strconv.Atoi(field)
+			if err != nil || index >= len(typedV) {
+				return fmt.Errorf("invalid path %s", strings.Join(path, "."))
+			}
+			if last {
+				typedV[index] = value
+				return nil
+			}
+			current = typedV[index]
+		default:
+			return fmt.Errorf("invalid path %s", strings.Join(path, "."))
+		}
+	}
+	return nil
+}
+
+// sortedInterfaceKeys return the keys of a map in alphabetical order
+func sortedInterfaceKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,471 @@
+package transformers
+
+import (
+	"path"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/layertwo/helm-convert/pkg/types"
+	"github.com/layertwo/helm-convert/pkg/utils"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/k8sdeps/kunstruct"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	kresid "sigs.k8s.io/kustomize/kyaml/resid"
+	"sigs.k8s.io/kustomize/pkg/gvk"
+	"sigs.k8s.io/kustomize/pkg/resid"
+	"sigs.k8s.io/kustomize/pkg/resmap"
+	"sigs.k8s.io/kustomize/pkg/resource"
+)
+
+type replacementsTransformerArgs struct {
+	config    *ktypes.Kustomization
+	resources *types.Resources
+}
+
+func TestReplacementsRun(t *testing.T) {
+	var deploy = gvk.Gvk{Group: "apps", Version: "v1", Kind: "Deployment"}
+	var service = gvk.Gvk{Version: "v1", Kind: "Service"}
+	var rf = resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
+
+	newService := func(name, namespace string) *resource.Resource {
+		metadata := map[string]interface{}{"name": name}
+		if namespace != "" {
+			metadata["namespace"] = namespace
+		}
+		return rf.FromMap(map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata":   metadata,
+		})
+	}
+
+	newDeployment := func(annotations map[string]interface{}, env []interface{}, args []interface{}) *resource.Resource {
+		return rf.FromMap(map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name":        "myrel-app",
+				"annotations": annotations,
+			},
+			"spec": map[string]interface{}{
+				"serviceName": "myrel-redis",
+				"template": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"containers": []interface{}{
+							map[string]interface{}{
+								"name": "app",
+								"env":  env,
+								"args": args,
+							},
+						},
+					},
+				},
+			},
+		})
+	}
+
+	for _, test := range []struct {
+		name     string
+		vars     bool
+		input    *replacementsTransformerArgs
+		expected *replacementsTransformerArgs
+	}{
+		{
+			name: "it should add replacements for the names found in string fields",
+			input: &replacementsTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(service, "myrel-redis"): newService("myrel-redis", ""),
+						resid.NewResId(deploy, "myrel-app"): newDeployment(
+							map[string]interface{}{"example.com/backend": "myrel-redis"},
+							[]interface{}{
+								map[string]interface{}{"name": "REDIS_HOST", "value": "myrel-redis"},
+								map[string]interface{}{"name": "REDIS_ADDR", "value": "myrel-redis:6379"},
+								map[string]interface{}{"name": "REDIS_MASTER", "value": "myrel-redis-master"},
+							},
+							[]interface{}{"--cache=/srv/myrel-redis"},
+						),
+					},
+				},
+			},
+			expected: &replacementsTransformerArgs{
+				config: &ktypes.Kustomization{
+					Replacements: []ktypes.ReplacementField{
+						{
+							Replacement: ktypes.Replacement{
+								Source: &ktypes.SourceSelector{
+									ResId:     kresid.ResId{Gvk: kresid.Gvk{Kind: "Service"}, Name: "myrel-redis"},
+									FieldPath: "metadata.name",
+								},
+								Targets: []*ktypes.TargetSelector{
+									{
+										Select: &ktypes.Selector{
+											ResId: kresid.ResId{Gvk: kresid.Gvk{Kind: "Deployment"}, Name: "myrel-app"},
+										},
+										FieldPaths: []string{
+											"metadata.annotations.[example.com/backend]",
+											"spec.template.spec.containers.0.env.0.value",
+										},
+									},
+									{
+										Select: &ktypes.Selector{
+											ResId: kresid.ResId{Gvk: kresid.Gvk{Kind: "Deployment"}, Name: "myrel-app"},
+										},
+										FieldPaths: []string{"spec.template.spec.containers.0.args.0"},
+										Options:    &ktypes.FieldOptions{Delimiter: "/", Index: 2},
+									},
+									{
+										Select: &ktypes.Selector{
+											ResId: kresid.ResId{Gvk: kresid.Gvk{Kind: "Deployment"}, Name: "myrel-app"},
+										},
+										FieldPaths: []string{"spec.template.spec.containers.0.env.1.value"},
+										Options:    &ktypes.FieldOptions{Delimiter: ":", Index: 0},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "it should replace the name and the namespace of fully qualified service names",
+			input: &replacementsTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResIdWithPrefixNamespace(service, "db", "", "data"): newService("db", "data"),
+						resid.NewResId(deploy, "myrel-app"): newDeployment(
+							map[string]interface{}{},
+							[]interface{}{
+								map[string]interface{}{"name": "DB_HOST", "value": "db.data.svc.cluster.local"},
+								map[string]interface{}{"name": "OTHER_HOST", "value": "db.other.svc.cluster.local"},
+							},
+							[]interface{}{},
+						),
+					},
+				},
+			},
+			expected: &replacementsTransformerArgs{
+				config: &ktypes.Kustomization{
+					Replacements: []ktypes.ReplacementField{
+						{
+							Replacement: ktypes.Replacement{
+								Source: &ktypes.SourceSelector{
+									ResId: kresid.ResId{
+										Gvk:       kresid.Gvk{Kind: "Service"},
+										Name:      "db",
+										Namespace: "data",
+									},
+									FieldPath: "metadata.name",
+								},
+								Targets: []*ktypes.TargetSelector{
+									{
+										Select: &ktypes.Selector{
+											ResId: kresid.ResId{Gvk: kresid.Gvk{Kind: "Deployment"}, Name: "myrel-app"},
+										},
+										FieldPaths: []string{"spec.template.spec.containers.0.env.0.value"},
+										Options:    &ktypes.FieldOptions{Delimiter: ".", Index: 0},
+									},
+								},
+							},
+						},
+						{
+							Replacement: ktypes.Replacement{
+								Source: &ktypes.SourceSelector{
+									ResId: kresid.ResId{
+										Gvk:       kresid.Gvk{Kind: "Service"},
+										Name:      "db",
+										Namespace: "data",
+									},
+									FieldPath: "metadata.namespace",
+								},
+								Targets: []*ktypes.TargetSelector{
+									{
+										Select: &ktypes.Selector{
+											ResId: kresid.ResId{Gvk: kresid.Gvk{Kind: "Deployment"}, Name: "myrel-app"},
+										},
+										FieldPaths: []string{"spec.template.spec.containers.0.env.0.value"},
+										Options:    &ktypes.FieldOptions{Delimiter: ".", Index: 1},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "it should replace the names by vars in legacy mode",
+			vars: true,
+			input: &replacementsTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResIdWithPrefixNamespace(service, "db", "", "data"): newService("db", "data"),
+						resid.NewResId(deploy, "myrel-app"): newDeployment(
+							map[string]interface{}{"example.com/backend": "db"},
+							[]interface{}{
+								map[string]interface{}{"name": "DB_URL", "value": "db.data.svc.cluster.local:5432,db:5433"},
+							},
+							[]interface{}{},
+						),
+					},
+				},
+			},
+			expected: &replacementsTransformerArgs{
+				config: &ktypes.Kustomization{
+					Vars: []ktypes.Var{
+						{
+							Name: "SERVICE_DB_NAME",
+							ObjRef: ktypes.Target{
+								APIVersion: "v1",
+								Gvk:        kresid.Gvk{Kind: "Service"},
+								Name:       "db",
+								Namespace:  "data",
+							},
+							FieldRef: ktypes.FieldSelector{FieldPath: "metadata.name"},
+						},
+						{
+							Name: "SERVICE_DB_NAMESPACE",
+							ObjRef: ktypes.Target{
+								APIVersion: "v1",
+								Gvk:        kresid.Gvk{Kind: "Service"},
+								Name:       "db",
+								Namespace:  "data",
+							},
+							FieldRef: ktypes.FieldSelector{FieldPath: "metadata.namespace"},
+						},
+					},
+				},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := NewReplacementsTransformer(test.vars).Transform(test.input.config, test.input.resources)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := pretty.Compare(test.input.config, test.expected.config); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+		})
+	}
+}
+
+func TestReplacementsVarsRun(t *testing.T) {
+	var rf = resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
+
+	resources := types.NewResources()
+	for _, obj := range []map[string]interface{}{
+		{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata":   map[string]interface{}{"name": "db", "namespace": "data"},
+		},
+		{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name":        "app",
+				"annotations": map[string]interface{}{"example.com/backend": "db"},
+			},
+			"spec": map[string]interface{}{
+				"template": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"containers": []interface{}{
+							map[string]interface{}{
+								"name": "app",
+								"env": []interface{}{
+									map[string]interface{}{"name": "DB_URL", "value": "db.data.svc.cluster.local:5432,db:5433"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	} {
+		res := rf.FromMap(obj)
+		resources.ResMap[res.Id()] = res
+	}
+
+	if err := NewReplacementsTransformer(true).Transform(&ktypes.Kustomization{}, resources); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var obj map[string]interface{}
+	for _, res := range resources.ResMap {
+		if res.GetKind() == "Deployment" {
+			obj = res.Map()
+		}
+	}
+
+	for _, test := range []struct {
+		path     []string
+		expected string
+	}{
+		{
+			path:     []string{"spec", "template", "spec", "containers", "0", "env", "0", "value"},
+			expected: "$(SERVICE_DB_NAME).$(SERVICE_DB_NAMESPACE).svc.cluster.local:5432,$(SERVICE_DB_NAME):5433",
+		},
+		{
+			// vars are not substituted in annotations
+			path:     []string{"metadata", "annotations", "example.com/backend"},
+			expected: "db",
+		},
+	} {
+		value, err := getFieldValue(obj, test.path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if value != test.expected {
+			t.Errorf("%v: expected '%s', got '%s'", test.path, test.expected, value)
+		}
+	}
+}
+
+// replacementsTestManifests reference a service by name, with a port, with
+// its fully qualified name and a configmap from a path
+var replacementsTestManifests = []string{`
+apiVersion: v1
+kind: Service
+metadata:
+  name: myrel-redis
+  namespace: data
+spec:
+  ports:
+  - port: 6379
+`, `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: myrel-config
+  namespace: data
+data:
+  redis: myrel-redis.data.svc.cluster.local:6379
+`, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: myrel-app
+  namespace: data
+  annotations:
+    example.com/backend: myrel-redis
+spec:
+  selector:
+    matchLabels:
+      app: myrel-app
+  template:
+    metadata:
+      labels:
+        app: myrel-app
+    spec:
+      containers:
+      - name: app
+        image: app
+        args:
+        - --config=/etc/myrel-config
+        env:
+        - name: REDIS_HOST
+          value: myrel-redis
+        - name: REDIS_ADDR
+          value: myrel-redis:6379
+`}
+
+func TestReplacementsRoundTrip(t *testing.T) {
+	var rf = resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
+
+	var (
+		annotation = []string{"metadata", "annotations", "example.com/backend"}
+		args       = []string{"spec", "template", "spec", "containers", "0", "args", "0"}
+		redisHost  = []string{"spec", "template", "spec", "containers", "0", "env", "0", "value"}
+		redisAddr  = []string{"spec", "template", "spec", "containers", "0", "env", "1", "value"}
+		configData = []string{"data", "redis"}
+	)
+
+	type field struct {
+		resource string
+		path     []string
+		expected string
+	}
+
+	for _, test := range []struct {
+		name   string
+		vars   bool
+		fields []field
+	}{
+		{
+			name: "it should propagate the name prefix and the namespace with replacements",
+			fields: []field{
+				{"ConfigMap/pre-myrel-config", configData, "pre-myrel-redis.prod.svc.cluster.local:6379"},
+				{"Deployment/pre-myrel-app", annotation, "pre-myrel-redis"},
+				{"Deployment/pre-myrel-app", args, "--config=/etc/pre-myrel-config"},
+				{"Deployment/pre-myrel-app", redisHost, "pre-myrel-redis"},
+				{"Deployment/pre-myrel-app", redisAddr, "pre-myrel-redis:6379"},
+			},
+		},
+		{
+			name: "it should propagate the name prefix to containers with vars",
+			vars: true,
+			fields: []field{
+				{"ConfigMap/pre-myrel-config", configData, "myrel-redis.data.svc.cluster.local:6379"},
+				{"Deployment/pre-myrel-app", annotation, "myrel-redis"},
+				{"Deployment/pre-myrel-app", args, "--config=/etc/pre-myrel-config"},
+				{"Deployment/pre-myrel-app", redisHost, "pre-myrel-redis"},
+				{"Deployment/pre-myrel-app", redisAddr, "pre-myrel-redis:6379"},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			resources := types.NewResources()
+			for _, manifest := range replacementsTestManifests {
+				var obj map[string]interface{}
+				if err := yaml.Unmarshal([]byte(manifest), &obj); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				res := rf.FromMap(obj)
+				resources.ResMap[res.Id()] = res
+			}
+
+			config := &ktypes.Kustomization{NamePrefix: "pre-", Namespace: "prod"}
+			if err := NewReplacementsTransformer(test.vars).Transform(config, resources); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			fs := filesys.MakeFsInMemory()
+			for id, res := range resources.ResMap {
+				output, err := yaml.Marshal(res.Map())
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				filename, err := utils.GetResourceFileName(id, res)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				fs.WriteFile(path.Join("/app", filename), output)
+				config.Resources = append(config.Resources, filename)
+			}
+
+			output, err := kustomizeBuild(fs, config)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for _, f := range test.fields {
+				obj, _ := output[f.resource].(map[string]interface{})
+				value, err := getFieldValue(obj, f.path)
+				if err != nil {
+					t.Errorf("%s: %v", f.resource, err)
+					continue
+				}
+				if value != f.expected {
+					t.Errorf("%s %v: expected '%s', got '%s'", f.resource, f.path, f.expected, value)
+				}
+			}
+		})
+	}
+}
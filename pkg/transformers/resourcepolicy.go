@@ -0,0 +1,126 @@
+package transformers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/layertwo/helm-convert/pkg/types"
+	"github.com/layertwo/helm-convert/pkg/utils"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+)
+
+// PruneProtection is the tool whose prune protection replaces the Helm
+// resource policy
+type PruneProtection string
+
+const (
+	// PruneProtectionNone leave the Helm resource policy untouched
+	PruneProtectionNone PruneProtection = "none"
+
+	// PruneProtectionArgoCD disable the pruning of the resource by Argo CD
+	PruneProtectionArgoCD PruneProtection = "argocd"
+
+	// PruneProtectionFlux disable the garbage collection of the resource by
+	// the Flux kustomize-controller
+	PruneProtectionFlux PruneProtection = "flux"
+
+	// PruneProtectionKapp orphan the resource when deleted by kapp
+	PruneProtectionKapp PruneProtection = "kapp"
+
+	// helmResourcePolicyAnnotation prevent Helm from deleting a resource
+	helmResourcePolicyAnnotation = "helm.sh/resource-policy"
+
+	// argoCDSyncOptionsAnnotation is a comma separated list of sync options
+	argoCDSyncOptionsAnnotation = "argocd.argoproj.io/sync-options"
+)
+
+// pruneProtectionAnnotations are the annotation set for each prune protection
+var pruneProtectionAnnotations = map[PruneProtection][2]string{
+	PruneProtectionArgoCD: {argoCDSyncOptionsAnnotation, "Prune=false"},
+	PruneProtectionFlux:   {"kustomize.toolkit.fluxcd.io/prune", "disabled"},
+	PruneProtectionKapp:   {"kapp.k14s.io/delete-strategy", "orphan"},
+}
+
+type resourcePolicyTransformer struct {
+	protection PruneProtection
+}
+
+var _ Transformer = &resourcePolicyTransformer{}
+
+// NewResourcePolicyTransformer constructs a resourcePolicyTransformer.
+func NewResourcePolicyTransformer(protection PruneProtection) Transformer {
+	return &resourcePolicyTransformer{protection}
+}
+
+// Transform replace the helm.sh/resource-policy: keep annotation, which only
+// means something to Helm, by the prune protection annotation of the chosen
+// GitOps tool. Argo CD sync options are merged with the existing ones. The
+// annotation is left untouched without GitOps tool.
+func (t *resourcePolicyTransformer) Transform(config *ktypes.Kustomization, resources *types.Resources) error {
+	if t.protection == PruneProtectionNone {
+		return nil
+	}
+	protection, found := pruneProtectionAnnotations[t.protection]
+	if !found {
+		return fmt.Errorf("unknown prune protection '%s', expected none, argocd, flux or kapp", t.protection)
+	}
+
+	for _, id := range utils.SortedIds(resources.ResMap) {
+		res := resources.ResMap[id]
+		metadata, _ := res.Map()["metadata"].(map[string]interface{})
+		annotations, _ := metadata["annotations"].(map[string]interface{})
+
+		policy, ok := annotations[helmResourcePolicyAnnotation].(string)
+		if !ok {
+			continue
+		}
+
+		report := func(message string) {
+			resources.Report.Add(types.ReportEntry{
+				Transformer: "resourcepolicy",
+				Kind:        res.GetKind(),
+				Name:        res.GetName(),
+				Path:        "metadata.annotations." + helmResourcePolicyAnnotation,
+				Message:     message,
+			})
+		}
+
+		delete(annotations, helmResourcePolicyAnnotation)
+
+		if strings.TrimSpace(policy) != "keep" {
+			report(fmt.Sprintf("removed the unknown resource policy '%s'", policy))
+		} else {
+			key, value := protection[0], protection[1]
+			if existing, ok := annotations[key].(string); ok && key == argoCDSyncOptionsAnnotation {
+				value = mergeSyncOptions(existing, value)
+			}
+			annotations[key] = value
+
+			glog.V(8).Infof("Replaced the resource policy of %s '%s' by %s: %s",
+				res.GetKind(), res.GetName(), key, value)
+			report(fmt.Sprintf("replaced by %s: %s", key, value))
+		}
+
+		if len(annotations) == 0 {
+			delete(metadata, "annotations")
+		}
+	}
+
+	return nil
+}
+
+// mergeSyncOptions add an option to a comma separated list of Argo CD sync
+// options, replacing the option with the same name
+func mergeSyncOptions(existing, option string) string {
+	name := option[:strings.Index(option, "=")+1]
+
+	var options []string
+	for _, o := range strings.Split(existing, ",") {
+		o = strings.TrimSpace(o)
+		if o != "" && !strings.HasPrefix(o, name) {
+			options = append(options, o)
+		}
+	}
+	return strings.Join(append(options, option), ",")
+}
@@ -0,0 +1,366 @@
+package transformers
+
+import (
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/layertwo/helm-convert/pkg/types"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/k8sdeps/kunstruct"
+	"sigs.k8s.io/kustomize/pkg/gvk"
+	"sigs.k8s.io/kustomize/pkg/resid"
+	"sigs.k8s.io/kustomize/pkg/resmap"
+	"sigs.k8s.io/kustomize/pkg/resource"
+)
+
+type resourcePolicyTransformerArgs struct {
+	config    *ktypes.Kustomization
+	resources *types.Resources
+}
+
+func TestResourcePolicyRun(t *testing.T) {
+	var pvc = gvk.Gvk{Version: "v1", Kind: "PersistentVolumeClaim"}
+	var rf = resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
+
+	for _, test := range []struct {
+		name       string
+		protection PruneProtection
+		input      *resourcePolicyTransformerArgs
+		expected   *resourcePolicyTransformerArgs
+	}{
+		{
+			name:       "it should replace the resource policy by the Flux prune protection",
+			protection: PruneProtectionFlux,
+			input: &resourcePolicyTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(pvc, "data"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "PersistentVolumeClaim",
+								"metadata": map[string]interface{}{
+									"name": "data",
+									"annotations": map[string]interface{}{
+										"helm.sh/resource-policy": "keep",
+									},
+								},
+								"spec": map[string]interface{}{
+									"accessModes": []interface{}{
+										"ReadWriteOnce",
+									},
+								},
+							}),
+					},
+				},
+			},
+			expected: &resourcePolicyTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(pvc, "data"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "PersistentVolumeClaim",
+								"metadata": map[string]interface{}{
+									"name": "data",
+									"annotations": map[string]interface{}{
+										"kustomize.toolkit.fluxcd.io/prune": "disabled",
+									},
+								},
+								"spec": map[string]interface{}{
+									"accessModes": []interface{}{
+										"ReadWriteOnce",
+									},
+								},
+							}),
+					},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "resourcepolicy",
+								Kind:        "PersistentVolumeClaim",
+								Name:        "data",
+								Path:        "metadata.annotations.helm.sh/resource-policy",
+								Message:     "replaced by kustomize.toolkit.fluxcd.io/prune: disabled",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:       "it should replace the resource policy by the kapp delete strategy",
+			protection: PruneProtectionKapp,
+			input: &resourcePolicyTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(pvc, "data"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "PersistentVolumeClaim",
+								"metadata": map[string]interface{}{
+									"name": "data",
+									"annotations": map[string]interface{}{
+										"helm.sh/resource-policy": "keep",
+									},
+								},
+								"spec": map[string]interface{}{
+									"accessModes": []interface{}{
+										"ReadWriteOnce",
+									},
+								},
+							}),
+					},
+				},
+			},
+			expected: &resourcePolicyTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(pvc, "data"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "PersistentVolumeClaim",
+								"metadata": map[string]interface{}{
+									"name": "data",
+									"annotations": map[string]interface{}{
+										"kapp.k14s.io/delete-strategy": "orphan",
+									},
+								},
+								"spec": map[string]interface{}{
+									"accessModes": []interface{}{
+										"ReadWriteOnce",
+									},
+								},
+							}),
+					},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "resourcepolicy",
+								Kind:        "PersistentVolumeClaim",
+								Name:        "data",
+								Path:        "metadata.annotations.helm.sh/resource-policy",
+								Message:     "replaced by kapp.k14s.io/delete-strategy: orphan",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:       "it should merge the Argo CD sync options",
+			protection: PruneProtectionArgoCD,
+			input: &resourcePolicyTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(pvc, "data"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "PersistentVolumeClaim",
+								"metadata": map[string]interface{}{
+									"name": "data",
+									"annotations": map[string]interface{}{
+										"argocd.argoproj.io/sync-options": "Prune=true, ServerSideApply=true",
+										"helm.sh/resource-policy":         "keep",
+									},
+								},
+								"spec": map[string]interface{}{
+									"accessModes": []interface{}{
+										"ReadWriteOnce",
+									},
+								},
+							}),
+					},
+				},
+			},
+			expected: &resourcePolicyTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(pvc, "data"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "PersistentVolumeClaim",
+								"metadata": map[string]interface{}{
+									"name": "data",
+									"annotations": map[string]interface{}{
+										"argocd.argoproj.io/sync-options": "ServerSideApply=true,Prune=false",
+									},
+								},
+								"spec": map[string]interface{}{
+									"accessModes": []interface{}{
+										"ReadWriteOnce",
+									},
+								},
+							}),
+					},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "resourcepolicy",
+								Kind:        "PersistentVolumeClaim",
+								Name:        "data",
+								Path:        "metadata.annotations.helm.sh/resource-policy",
+								Message:     "replaced by argocd.argoproj.io/sync-options: ServerSideApply=true,Prune=false",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:       "it should leave the resource policy untouched without prune protection",
+			protection: PruneProtectionNone,
+			input: &resourcePolicyTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(pvc, "data"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "PersistentVolumeClaim",
+								"metadata": map[string]interface{}{
+									"name": "data",
+									"annotations": map[string]interface{}{
+										"helm.sh/resource-policy": "keep",
+									},
+								},
+								"spec": map[string]interface{}{
+									"accessModes": []interface{}{
+										"ReadWriteOnce",
+									},
+								},
+							}),
+					},
+				},
+			},
+			expected: &resourcePolicyTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(pvc, "data"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "PersistentVolumeClaim",
+								"metadata": map[string]interface{}{
+									"name": "data",
+									"annotations": map[string]interface{}{
+										"helm.sh/resource-policy": "keep",
+									},
+								},
+								"spec": map[string]interface{}{
+									"accessModes": []interface{}{
+										"ReadWriteOnce",
+									},
+								},
+							}),
+					},
+				},
+			},
+		},
+		{
+			name:       "it should not protect resources with another policy",
+			protection: PruneProtectionFlux,
+			input: &resourcePolicyTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(pvc, "data"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "PersistentVolumeClaim",
+								"metadata": map[string]interface{}{
+									"name": "data",
+									"annotations": map[string]interface{}{
+										"helm.sh/resource-policy": "delete",
+										"example.com/owner":       "team",
+									},
+								},
+								"spec": map[string]interface{}{
+									"accessModes": []interface{}{
+										"ReadWriteOnce",
+									},
+								},
+							}),
+					},
+				},
+			},
+			expected: &resourcePolicyTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(pvc, "data"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "PersistentVolumeClaim",
+								"metadata": map[string]interface{}{
+									"name": "data",
+									"annotations": map[string]interface{}{
+										"example.com/owner": "team",
+									},
+								},
+								"spec": map[string]interface{}{
+									"accessModes": []interface{}{
+										"ReadWriteOnce",
+									},
+								},
+							}),
+					},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "resourcepolicy",
+								Kind:        "PersistentVolumeClaim",
+								Name:        "data",
+								Path:        "metadata.annotations.helm.sh/resource-policy",
+								Message:     "removed the unknown resource policy 'delete'",
+							},
+						},
+					},
+				},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := NewResourcePolicyTransformer(test.protection).Transform(test.input.config, test.input.resources)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := pretty.Compare(test.input, test.expected); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+		})
+	}
+}
+
+func TestResourcePolicyUnknownProtection(t *testing.T) {
+	var pvc = gvk.Gvk{Version: "v1", Kind: "PersistentVolumeClaim"}
+	var rf = resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
+
+	resources := &types.Resources{
+		ResMap: resmap.ResMap{
+			resid.NewResId(pvc, "data"): rf.FromMap(
+				map[string]interface{}{
+					"apiVersion": "v1",
+					"kind":       "PersistentVolumeClaim",
+					"metadata": map[string]interface{}{
+						"name": "data",
+					},
+					"spec": map[string]interface{}{
+						"accessModes": []interface{}{
+							"ReadWriteOnce",
+						},
+					},
+				}),
+		},
+	}
+	err := NewResourcePolicyTransformer("helm").Transform(&ktypes.Kustomization{}, resources)
+	if err == nil {
+		t.Errorf("expected an error for an unknown prune protection")
+	}
+}
@@ -5,7 +5,7 @@ import (
 
 	"github.com/layertwo/helm-convert/pkg/types"
 	"github.com/layertwo/helm-convert/pkg/utils"
-	ktypes "sigs.k8s.io/kustomize/pkg/types"
+	ktypes "sigs.k8s.io/kustomize/api/types"
 )
 
 type resourcesTransformer struct{}
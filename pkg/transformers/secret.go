@@ -1,26 +1,178 @@
 package transformers
 
 import (
+	"bytes"
 	"encoding/base64"
 	"fmt"
 	"sort"
+	"strings"
+	"text/template"
 
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
 	"github.com/layertwo/helm-convert/pkg/types"
-	ktypes "sigs.k8s.io/kustomize/pkg/types"
+	"github.com/layertwo/helm-convert/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/pkg/resource"
 )
 
-type secretTransformer struct{}
+// secretTypeRequiredKeys list the keys Kubernetes requires for each well-known
+// secret type. Secrets of these types have their keys written as file sources
+// so that the generated secret keeps the exact key names.
+var secretTypeRequiredKeys = map[string][]string{
+	string(corev1.SecretTypeTLS):              {corev1.TLSCertKey, corev1.TLSPrivateKeyKey},
+	string(corev1.SecretTypeDockerConfigJson): {corev1.DockerConfigJsonKey},
+	string(corev1.SecretTypeDockercfg):        {corev1.DockerConfigKey},
+	string(corev1.SecretTypeSSHAuth):          {corev1.SSHAuthPrivateKey},
+	string(corev1.SecretTypeBasicAuth):        {},
+}
+
+// secretTypeOneOfKeys list the keys of which Kubernetes requires at least one
+// for each well-known secret type
+var secretTypeOneOfKeys = map[string][]string{
+	string(corev1.SecretTypeBasicAuth): {corev1.BasicAuthUsernameKey, corev1.BasicAuthPasswordKey},
+}
+
+// SecretOutput define how the secret material is written to disk
+type SecretOutput string
+
+const (
+	// SecretOutputPlain write secrets decoded in plaintext files
+	SecretOutputPlain SecretOutput = "plain"
+
+	// SecretOutputSOPS write secrets into files encrypted with SOPS
+	SecretOutputSOPS SecretOutput = "sops"
+
+	// SecretOutputPlaceholder write no value, secretGenerator point to
+	// git-ignored files that must be filled from a template
+	SecretOutputPlaceholder SecretOutput = "placeholder"
+
+	// SecretOutputExternalSecret write no value, secrets are replaced by
+	// ExternalSecret resources
+	SecretOutputExternalSecret SecretOutput = "external-secret"
+)
+
+const (
+	// DefaultExternalSecretKeyTemplate is the default template of the remote
+	// reference key of an ExternalSecret
+	DefaultExternalSecretKeyTemplate = "{{ .Name }}/{{ .Key }}"
+
+	// DefaultExternalSecretStoreKind is the default kind of the store
+	// referenced by an ExternalSecret
+	DefaultExternalSecretStoreKind = "SecretStore"
+
+	// RandomKeysAnnotation list the keys of a secret whose value is
+	// generated on every render of the chart
+	RandomKeysAnnotation = "helm-convert/random-keys"
+
+	// DefaultGitIgnoreFilename is the name of the file listing the placeholder
+	// files that must not be committed
+	DefaultGitIgnoreFilename = ".gitignore"
+)
+
+const (
+	// SOPSLayoutFlux keep the secretGenerator in the kustomization.yaml and
+	// let the Flux kustomize-controller decrypt the source files
+	SOPSLayoutFlux = "flux"
+
+	// SOPSLayoutKSOPS replace the secretGenerator by a KSOPS generator
+	SOPSLayoutKSOPS = "ksops"
+
+	// DefaultKSOPSGeneratorFilename is the name of the KSOPS generator file
+	DefaultKSOPSGeneratorFilename = "secret-generator.yaml"
+)
+
+// SecretOptions define the secretTransformer configuration
+type SecretOptions struct {
+	// Output is the way secret material is written, default to plain
+	Output SecretOutput
+
+	// SOPSLayout is the layout of the kustomization when secrets are
+	// encrypted with SOPS, default to flux
+	SOPSLayout string
+
+	// ExternalSecretStore is the name of the store referenced by the
+	// generated ExternalSecret resources
+	ExternalSecretStore string
+
+	// ExternalSecretStoreKind is the kind of the store, SecretStore or
+	// ClusterSecretStore
+	ExternalSecretStoreKind string
+
+	// ExternalSecretKeyTemplate is a Go template building the remote
+	// reference key of each secret key, .Name, .Namespace, .Type and .Key are
+	// available
+	ExternalSecretKeyTemplate string
+}
+
+// externalSecretKeyData is the data given to the ExternalSecretKeyTemplate
+type externalSecretKeyData struct {
+	Name      string
+	Namespace string
+	Type      string
+	Key       string
+}
+
+// ksopsGenerator is a KSOPS exec KRM function generating secrets from SOPS
+// encrypted files
+type ksopsGenerator struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   ksopsMetadata     `json:"metadata"`
+	SecretFrom []ksopsSecretFrom `json:"secretFrom"`
+}
+
+type ksopsMetadata struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ksopsSecretFrom struct {
+	Metadata ksopsMetadata `json:"metadata"`
+	Type     string        `json:"type,omitempty"`
+	Files    []string      `json:"files,omitempty"`
+	Envs     []string      `json:"envs,omitempty"`
+}
+
+type secretTransformer struct {
+	options SecretOptions
+}
 
 var _ Transformer = &secretTransformer{}
 
 // NewSecretTransformer constructs a secretTransformer.
-func NewSecretTransformer() Transformer {
-	return &secretTransformer{}
+func NewSecretTransformer(options SecretOptions) Transformer {
+	if options.Output == "" {
+		options.Output = SecretOutputPlain
+	}
+	if options.SOPSLayout == "" {
+		options.SOPSLayout = SOPSLayoutFlux
+	}
+	if options.ExternalSecretStoreKind == "" {
+		options.ExternalSecretStoreKind = DefaultExternalSecretStoreKind
+	}
+	if options.ExternalSecretKeyTemplate == "" {
+		options.ExternalSecretKeyTemplate = DefaultExternalSecretKeyTemplate
+	}
+	return &secretTransformer{options}
 }
 
 // Transform retrieve secrets from manifests and store them as secretGenerator in the kustomization.yaml
 func (t *secretTransformer) Transform(config *ktypes.Kustomization, resources *types.Resources) error {
-	for id, res := range resources.ResMap {
+	var secretArgs []ktypes.SecretArgs
+	var externalSecrets []*resource.Resource
+	var ignoredFiles []string
+
+	keyTemplate, err := template.New("key").Parse(t.options.ExternalSecretKeyTemplate)
+	if err != nil {
+		return fmt.Errorf("invalid external secret key template: %v", err)
+	}
+
+	for _, id := range utils.SortedIds(resources.ResMap) {
+		res := resources.ResMap[id]
 		kind, err := res.GetFieldValue("kind")
 		if err != nil {
 			continue
@@ -37,42 +189,476 @@ func (t *secretTransformer) Transform(config *ktypes.Kustomization, resources *t
 
 		secretType, err := res.GetFieldValue("type")
 		if err != nil {
-			secretType = "Opaque"
+			secretType = string(corev1.SecretTypeOpaque)
+		}
+
+		// service account tokens are populated by the cluster, there is
+		// nothing to generate
+		if secretType == string(corev1.SecretTypeServiceAccountToken) {
+			glog.V(8).Infof("Keeping secret '%s' of type '%s' as a resource", name, secretType)
+			continue
 		}
 
 		obj := resources.ResMap[id].Map()
 
-		var data map[string]interface{}
-		if _, found := obj["data"]; found && obj["data"] != nil {
-			data = obj["data"].(map[string]interface{})
+		dataDecoded, err := secretData(obj)
+		if err != nil {
+			return fmt.Errorf("secret '%s': %v", name, err)
 		}
 
-		secretArg := ktypes.SecretArgs{
-			GeneratorArgs: ktypes.GeneratorArgs{
-				Name: name,
-			},
-			Type: secretType,
+		if requiredKeys, ok := secretTypeRequiredKeys[secretType]; ok {
+			for _, key := range requiredKeys {
+				if _, found := dataDecoded[key]; !found {
+					return fmt.Errorf("secret '%s' of type '%s' is missing the required key '%s'",
+						name, secretType, key)
+				}
+			}
+		}
+		if oneOfKeys, ok := secretTypeOneOfKeys[secretType]; ok {
+			found := false
+			for _, key := range oneOfKeys {
+				if _, ok := dataDecoded[key]; ok {
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("secret '%s' of type '%s' requires at least one of the keys '%s'",
+					name, secretType, strings.Join(oneOfKeys, "', '"))
+			}
+		}
+
+		// keys generated on every render are supplied by the user
+		namespace, _ := res.GetFieldValue("metadata.namespace")
+		requiredKeys := resources.RequiredSecretKeys[types.ResourceKey(kind, namespace, name)]
+		randomKeys := mergeKeys(nil, requiredKeys)
+
+		// a high entropy alone doesn't tell if a value is generated, the
+		// value is the same on every render so it is only reported
+		for _, key := range randomSecretKeys(dataDecoded) {
+			if containsKey(randomKeys, key) {
+				continue
+			}
+			glog.V(8).Infof("Value of the key '%s' from secret '%s' looks random but is the same on every render",
+				key, name)
+			resources.Report.Add(types.ReportEntry{
+				Transformer: "secret",
+				Kind:        kind,
+				Name:        name,
+				Path:        "data." + key,
+				Message:     "the value looks randomly generated but is the same on every render, check that it isn't a default credential shipped with the chart",
+			})
+		}
+
+		args, err := generatorArgs(name, obj)
+		if err != nil {
+			return fmt.Errorf("secret '%s': %v", name, err)
 		}
 
-		dataDecoded := make(map[string]string, len(data))
-		for key, value := range data {
-			decoded, err := base64.StdEncoding.DecodeString(value.(string))
+		if t.options.Output == SecretOutputExternalSecret {
+			externalSecret, err := t.externalSecret(keyTemplate, args, secretType, dataDecoded, randomKeys)
 			if err != nil {
-				return fmt.Errorf("couldn't base64 decode the secret key '%s' with value '%v'", key, value)
+				return fmt.Errorf("secret '%s': %v", name, err)
 			}
-			dataDecoded[key] = string(decoded)
+			externalSecrets = append(externalSecrets, externalSecret)
+			delete(resources.ResMap, res.Id())
+			continue
 		}
 
-		secretArg.GeneratorArgs.DataSources = TransformDataSource(name, dataDecoded, resources.SourceFiles)
+		secretArg := ktypes.SecretArgs{
+			GeneratorArgs: args,
+			Type:          secretType,
+		}
+
+		if t.options.Output == SecretOutputPlaceholder {
+			var files []string
+			secretArg.GeneratorArgs.KvPairSources, files = t.placeholder(name, secretType, dataDecoded,
+				randomKeys, false, resources.SourceFiles)
+			ignoredFiles = append(ignoredFiles, files...)
+
+			for _, filename := range dataSourceFiles(secretArg.GeneratorArgs.KvPairSources) {
+				resources.SecretFiles[filename] = struct{}{}
+			}
+		} else {
+			required := make(map[string]string, len(requiredKeys))
+			for _, key := range requiredKeys {
+				if value, found := dataDecoded[key]; found {
+					required[key] = value
+					delete(dataDecoded, key)
+				}
+			}
+
+			if _, ok := secretTypeRequiredKeys[secretType]; ok {
+				secretArg.GeneratorArgs.KvPairSources = TransformKeyedFileDataSource(kind, name, dataDecoded, resources.SourceFiles)
+			} else if t.options.Output == SecretOutputSOPS && !isEnvFile(dataDecoded) {
+				// literals would end up in plaintext in the kustomization.yaml
+				secretArg.GeneratorArgs.KvPairSources = TransformKeyedFileDataSource(kind, name, dataDecoded, resources.SourceFiles)
+			} else if len(dataDecoded) > 0 {
+				secretArg.GeneratorArgs.KvPairSources = TransformDataSource(kind, name, dataDecoded, resources.SourceFiles)
+			}
+
+			for _, filename := range dataSourceFiles(secretArg.GeneratorArgs.KvPairSources) {
+				resources.SecretFiles[filename] = struct{}{}
+			}
+
+			// with sops, the values supplied by the user are decrypted
+			// with the other sources and must be encrypted too
+			if len(required) > 0 {
+				placeholderSources, files := t.placeholder(name, secretType, required, requiredKeys,
+					t.options.Output == SecretOutputSOPS, resources.SourceFiles)
+				secretArg.GeneratorArgs.KvPairSources = mergeDataSources(secretArg.GeneratorArgs.KvPairSources,
+					placeholderSources)
+				ignoredFiles = append(ignoredFiles, files...)
+			}
+		}
 
-		config.SecretGenerator = append(config.SecretGenerator, secretArg)
+		secretArgs = append(secretArgs, secretArg)
 		delete(resources.ResMap, res.Id())
 	}
 
+	for _, externalSecret := range externalSecrets {
+		resources.ResMap[externalSecret.Id()] = externalSecret
+	}
+
+	if len(ignoredFiles) > 0 {
+		sort.Strings(ignoredFiles)
+		gitignore := resources.SourceFiles[DefaultGitIgnoreFilename]
+		resources.SourceFiles[DefaultGitIgnoreFilename] = gitignore + strings.Join(ignoredFiles, "\n") + "\n"
+	}
+
 	// sort by name
+	sort.Slice(secretArgs, func(i, j int) bool {
+		return secretArgs[i].Name < secretArgs[j].Name
+	})
+
+	if t.options.Output == SecretOutputSOPS && t.options.SOPSLayout == SOPSLayoutKSOPS {
+		return t.ksopsGenerator(config, resources, secretArgs)
+	}
+
+	config.SecretGenerator = append(config.SecretGenerator, secretArgs...)
+
 	sort.Slice(config.SecretGenerator, func(i, j int) bool {
 		return config.SecretGenerator[i].Name < config.SecretGenerator[j].Name
 	})
 
 	return nil
 }
+
+// ksopsGenerator store secrets as a KSOPS generator referenced from the
+// generators field of the kustomization.yaml
+func (t *secretTransformer) ksopsGenerator(config *ktypes.Kustomization, resources *types.Resources,
+	secretArgs []ktypes.SecretArgs) error {
+
+	if len(secretArgs) == 0 {
+		return nil
+	}
+
+	generator := ksopsGenerator{
+		APIVersion: "viaduct.ai/v1",
+		Kind:       "ksops",
+		Metadata: ksopsMetadata{
+			Name: "secret-generator",
+			Annotations: map[string]string{
+				"config.kubernetes.io/function": "exec:\n  path: ksops\n",
+			},
+		},
+	}
+
+	for _, secretArg := range secretArgs {
+		secretFrom := ksopsSecretFrom{
+			Metadata: ksopsMetadata{
+				Name:      secretArg.Name,
+				Namespace: secretArg.Namespace,
+			},
+			Type:  secretArg.Type,
+			Files: secretArg.FileSources,
+		}
+
+		// KSOPS only read encrypted files, literals are written to files
+		// encrypted like the other sources
+		if len(secretArg.LiteralSources) > 0 {
+			literals := make(map[string]string, len(secretArg.LiteralSources))
+			for _, literal := range secretArg.LiteralSources {
+				key, value, _ := strings.Cut(literal, "=")
+				literals[key] = value
+			}
+			sources := TransformKeyedFileDataSource("Secret", secretArg.Name, literals, resources.SourceFiles)
+			for _, filename := range dataSourceFiles(sources) {
+				resources.SecretFiles[filename] = struct{}{}
+			}
+			secretFrom.Files = append(secretFrom.Files, sources.FileSources...)
+		}
+
+		if secretArg.Options != nil {
+			secretFrom.Metadata.Labels = secretArg.Options.Labels
+			secretFrom.Metadata.Annotations = secretArg.Options.Annotations
+			if secretArg.Options.Immutable {
+				glog.Warningf("KSOPS can't generate immutable secrets, secret '%s' will be mutable", secretArg.Name)
+			}
+		}
+		if secretArg.EnvSource != "" {
+			secretFrom.Envs = []string{secretArg.EnvSource}
+		}
+		secretFrom.Envs = append(secretFrom.Envs, secretArg.EnvSources...)
+		generator.SecretFrom = append(generator.SecretFrom, secretFrom)
+	}
+
+	output, err := yaml.Marshal(generator)
+	if err != nil {
+		return err
+	}
+
+	resources.SourceFiles[DefaultKSOPSGeneratorFilename] = string(output)
+	config.Generators = append(config.Generators, DefaultKSOPSGeneratorFilename)
+
+	return nil
+}
+
+// secretData return the decoded content of the data field merged with the
+// stringData field, stringData taking precedence like the API server does
+func secretData(obj map[string]interface{}) (map[string]string, error) {
+	data, _ := obj["data"].(map[string]interface{})
+	stringData, _ := obj["stringData"].(map[string]interface{})
+
+	output := make(map[string]string, len(data)+len(stringData))
+	for key, value := range data {
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("value of the key '%s' is not a string but %T", key, value)
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(str)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't base64 decode the secret key '%s' with value '%v'", key, value)
+		}
+		output[key] = string(decoded)
+	}
+
+	for key, value := range stringData {
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("value of the key '%s' is not a string but %T", key, value)
+		}
+		output[key] = str
+	}
+
+	return output, nil
+}
+
+// placeholder return a Kustomize DataSource pointing to files that must be
+// supplied by the user, and write a template listing the keys to fill in.
+// Values are never written. Single line values are expected in an env file,
+// multiline values and well-known secret types in their own file. The files
+// are ignored by git unless encrypted is true, the user is then asked to
+// encrypt them with sops.
+func (t *secretTransformer) placeholder(name, secretType string, data map[string]string,
+	randomKeys []string, encrypted bool, sourceFiles map[string]string) (dataSources ktypes.KvPairSources,
+	ignoredFiles []string) {
+
+	random := make(map[string]struct{}, len(randomKeys))
+	for _, key := range randomKeys {
+		random[key] = struct{}{}
+	}
+
+	// placeholder files are never written, keep track of their paths to avoid
+	// collisions
+	placeholders := make(map[string]struct{})
+	taken := func(p string) bool {
+		_, inSourceFiles := sourceFiles[p]
+		_, inPlaceholders := placeholders[p]
+		return inSourceFiles || inPlaceholders
+	}
+
+	dir := SourceFileDir("Secret", name)
+	envFilename := sourceFilePath(taken, dir, name+".env")
+	placeholders[envFilename] = struct{}{}
+	templateFilename := sourceFilePath(taken, dir, name+".env.example")
+	placeholders[templateFilename] = struct{}{}
+
+	_, keyedFiles := secretTypeRequiredKeys[secretType]
+
+	lines := []string{
+		fmt.Sprintf("# Keys of the secret '%s', copy this file to %s and fill in the values", name, envFilename),
+	}
+	if encrypted {
+		lines = append(lines, "# Encrypt the files with sops --encrypt --in-place before committing them")
+	}
+	var envKeys int
+	for _, key := range sortedKeys(data) {
+		if _, ok := random[key]; ok {
+			lines = append(lines, fmt.Sprintf("# %s was randomly generated by the chart, a value must be supplied", key))
+		}
+
+		if keyedFiles || isMultiline(data[key]) || isBinary(data[key]) {
+			filename := sourceFilePath(taken, dir, key)
+			placeholders[filename] = struct{}{}
+			dataSources.FileSources = append(dataSources.FileSources, fmt.Sprintf("%s=%s", key, filename))
+			ignoredFiles = append(ignoredFiles, filename)
+			lines = append(lines, fmt.Sprintf("# %s: content of the file %s", key, filename))
+			continue
+		}
+
+		lines = append(lines, fmt.Sprintf("%s=", key))
+		envKeys++
+	}
+
+	if envKeys > 0 {
+		dataSources.EnvSource = envFilename
+		ignoredFiles = append(ignoredFiles, envFilename)
+	}
+	if encrypted {
+		ignoredFiles = nil
+	}
+
+	sourceFiles[templateFilename] = strings.Join(lines, "\n") + "\n"
+
+	glog.V(8).Infof("Converting secret '%s' as placeholder with template '%s'", name, templateFilename)
+
+	return
+}
+
+// externalSecret return an ExternalSecret resource generating the given secret
+// from a secret store
+func (t *secretTransformer) externalSecret(keyTemplate *template.Template, args ktypes.GeneratorArgs,
+	secretType string, data map[string]string, randomKeys []string) (*resource.Resource, error) {
+
+	name, namespace := args.Name, args.Namespace
+
+	var remoteData []interface{}
+	for _, key := range sortedKeys(data) {
+		var remoteKey bytes.Buffer
+		err := keyTemplate.Execute(&remoteKey, externalSecretKeyData{
+			Name:      name,
+			Namespace: namespace,
+			Type:      secretType,
+			Key:       key,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		remoteData = append(remoteData, map[string]interface{}{
+			"secretKey": key,
+			"remoteRef": map[string]interface{}{
+				"key": remoteKey.String(),
+			},
+		})
+	}
+
+	metadata := map[string]interface{}{
+		"name": name,
+	}
+	if namespace != "" {
+		metadata["namespace"] = namespace
+	}
+	if len(randomKeys) > 0 {
+		metadata["annotations"] = map[string]interface{}{
+			RandomKeysAnnotation: strings.Join(randomKeys, ","),
+		}
+	}
+
+	// labels and annotations of the secret are set on the generated secret
+	// rather than the ExternalSecret
+	secretTemplate := map[string]interface{}{
+		"type": secretType,
+	}
+	target := map[string]interface{}{
+		"name":     name,
+		"template": secretTemplate,
+	}
+	if args.Options != nil {
+		templateMetadata := make(map[string]interface{})
+		if len(args.Options.Labels) > 0 {
+			templateMetadata["labels"] = stringMapToInterface(args.Options.Labels)
+		}
+		if len(args.Options.Annotations) > 0 {
+			templateMetadata["annotations"] = stringMapToInterface(args.Options.Annotations)
+		}
+		if len(templateMetadata) > 0 {
+			secretTemplate["metadata"] = templateMetadata
+		}
+		if args.Options.Immutable {
+			target["immutable"] = true
+		}
+	}
+
+	spec := map[string]interface{}{
+		"secretStoreRef": map[string]interface{}{
+			"name": t.options.ExternalSecretStore,
+			"kind": t.options.ExternalSecretStoreKind,
+		},
+		"target": target,
+	}
+	if len(remoteData) > 0 {
+		spec["data"] = remoteData
+	}
+
+	glog.V(8).Infof("Converting secret '%s' as ExternalSecret", name)
+
+	return resourceFactory.FromMap(map[string]interface{}{
+		"apiVersion": "external-secrets.io/v1beta1",
+		"kind":       "ExternalSecret",
+		"metadata":   metadata,
+		"spec":       spec,
+	}), nil
+}
+
+// randomSecretKeys return the sorted keys whose value looks randomly generated
+func randomSecretKeys(data map[string]string) (keys []string) {
+	for _, key := range sortedKeys(data) {
+		if utils.LooksRandom(data[key]) {
+			keys = append(keys, key)
+		}
+	}
+	return
+}
+
+// containsKey return true if a list of keys contains the given key
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeKeys return the sorted union of two lists of keys
+func mergeKeys(a, b []string) []string {
+	if len(b) == 0 {
+		return a
+	}
+	merged := make(map[string]string, len(a)+len(b))
+	for _, key := range append(a[:len(a):len(a)], b...) {
+		merged[key] = key
+	}
+	return sortedKeys(merged)
+}
+
+// mergeDataSources return the data sources of both arguments, env files are
+// moved to EnvSources when both have one
+func mergeDataSources(a, b ktypes.KvPairSources) ktypes.KvPairSources {
+	merged := ktypes.KvPairSources{
+		LiteralSources: append(a.LiteralSources, b.LiteralSources...),
+		FileSources:    append(a.FileSources, b.FileSources...),
+		EnvSources:     append(a.EnvSources, b.EnvSources...),
+	}
+	switch {
+	case a.EnvSource != "" && b.EnvSource != "":
+		merged.EnvSources = append(merged.EnvSources, a.EnvSource, b.EnvSource)
+	case a.EnvSource != "":
+		merged.EnvSource = a.EnvSource
+	default:
+		merged.EnvSource = b.EnvSource
+	}
+	return merged
+}
+
+// stringMapToInterface convert a map of string to the generic form used by
+// unstructured resources
+func stringMapToInterface(m map[string]string) map[string]interface{} {
+	output := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		output[key] = value
+	}
+	return output
+}
@@ -8,12 +8,12 @@ import (
 	"github.com/kylelemons/godebug/pretty"
 	"github.com/layertwo/helm-convert/pkg/types"
 	corev1 "k8s.io/api/core/v1"
+	ktypes "sigs.k8s.io/kustomize/api/types"
 	"sigs.k8s.io/kustomize/k8sdeps/kunstruct"
 	"sigs.k8s.io/kustomize/pkg/gvk"
 	"sigs.k8s.io/kustomize/pkg/resid"
 	"sigs.k8s.io/kustomize/pkg/resmap"
 	"sigs.k8s.io/kustomize/pkg/resource"
-	ktypes "sigs.k8s.io/kustomize/pkg/types"
 )
 
 var rf = resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
@@ -36,9 +36,11 @@ func TestSecretRun(t *testing.T) {
 	}
 
 	for _, test := range []struct {
-		name     string
-		input    *secretTransformerArgs
-		expected *secretTransformerArgs
+		name        string
+		options     SecretOptions
+		input       *secretTransformerArgs
+		expected    *secretTransformerArgs
+		expectedErr string
 	}{
 		{
 			name: "it should retrieve secrets",
@@ -68,8 +70,8 @@ func TestSecretRun(t *testing.T) {
 								},
 								"type": string(corev1.SecretTypeTLS),
 								"data": map[string]interface{}{
-									"tls.cert": base64.StdEncoding.EncodeToString(cert),
-									"tls.key":  base64.StdEncoding.EncodeToString(key),
+									"tls.crt": base64.StdEncoding.EncodeToString(cert),
+									"tls.key": base64.StdEncoding.EncodeToString(key),
 								},
 							}),
 						resid.NewResId(secret, "secret3"): rf.FromMap(
@@ -91,8 +93,8 @@ func TestSecretRun(t *testing.T) {
 						{
 							GeneratorArgs: ktypes.GeneratorArgs{
 								Name: "secret1",
-								DataSources: ktypes.DataSources{
-									EnvSource: "secret1.env",
+								KvPairSources: ktypes.KvPairSources{
+									EnvSource: "files/secret/secret1/secret1.env",
 								},
 							},
 							Type: string(corev1.SecretTypeOpaque),
@@ -100,10 +102,10 @@ func TestSecretRun(t *testing.T) {
 						{
 							GeneratorArgs: ktypes.GeneratorArgs{
 								Name: "secret2",
-								DataSources: ktypes.DataSources{
+								KvPairSources: ktypes.KvPairSources{
 									FileSources: []string{
-										"secret2-tls.cert",
-										"secret2-tls.key",
+										"tls.crt=files/secret/secret2/tls.crt",
+										"tls.key=files/secret/secret2/tls.key",
 									},
 								},
 							},
@@ -112,7 +114,7 @@ func TestSecretRun(t *testing.T) {
 						{
 							GeneratorArgs: ktypes.GeneratorArgs{
 								Name: "secret3",
-								DataSources: ktypes.DataSources{
+								KvPairSources: ktypes.KvPairSources{
 									LiteralSources: []string{},
 								},
 							},
@@ -123,20 +125,626 @@ func TestSecretRun(t *testing.T) {
 				resources: &types.Resources{
 					ResMap: resmap.ResMap{},
 					SourceFiles: map[string]string{
-						"secret1.env":      "DB_PASSWORD=password\nDB_USERNAME=admin",
-						"secret2-tls.cert": string(cert),
-						"secret2-tls.key":  string(key),
+						"files/secret/secret1/secret1.env": "DB_PASSWORD=password\nDB_USERNAME=admin",
+						"files/secret/secret2/tls.crt":     string(cert),
+						"files/secret/secret2/tls.key":     string(key),
+					},
+					SecretFiles: map[string]struct{}{
+						"files/secret/secret1/secret1.env": {},
+						"files/secret/secret2/tls.crt":     {},
+						"files/secret/secret2/tls.key":     {},
 					},
 				},
 			},
 		},
+		{
+			name: "it should handle stringData and well-known secret types",
+			input: &secretTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(secret, "secret1"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name": "secret1",
+								},
+								"data": map[string]interface{}{
+									"DB_USERNAME": base64.StdEncoding.EncodeToString([]byte("admin")),
+									"DB_PASSWORD": base64.StdEncoding.EncodeToString([]byte("password")),
+								},
+								"stringData": map[string]interface{}{
+									"DB_PASSWORD": "overridden",
+									"DB_HOST":     "localhost",
+								},
+							}),
+						resid.NewResId(secret, "secret2"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name": "secret2",
+								},
+								"type": string(corev1.SecretTypeDockerConfigJson),
+								"data": map[string]interface{}{
+									".dockerconfigjson": base64.StdEncoding.EncodeToString([]byte(`{"auths":{}}`)),
+								},
+							}),
+						resid.NewResId(secret, "secret3"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name": "secret3",
+								},
+								"type": string(corev1.SecretTypeSSHAuth),
+								"stringData": map[string]interface{}{
+									"ssh-privatekey": "-----BEGIN KEY-----\nabc\n-----END KEY-----",
+								},
+							}),
+						resid.NewResId(secret, "secret4"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name": "secret4",
+								},
+								"type": string(corev1.SecretTypeServiceAccountToken),
+							}),
+					},
+				},
+			},
+			expected: &secretTransformerArgs{
+				config: &ktypes.Kustomization{
+					SecretGenerator: []ktypes.SecretArgs{
+						{
+							GeneratorArgs: ktypes.GeneratorArgs{
+								Name: "secret1",
+								KvPairSources: ktypes.KvPairSources{
+									EnvSource: "files/secret/secret1/secret1.env",
+								},
+							},
+							Type: string(corev1.SecretTypeOpaque),
+						},
+						{
+							GeneratorArgs: ktypes.GeneratorArgs{
+								Name: "secret2",
+								KvPairSources: ktypes.KvPairSources{
+									FileSources: []string{
+										".dockerconfigjson=files/secret/secret2/dockerconfigjson",
+									},
+								},
+							},
+							Type: string(corev1.SecretTypeDockerConfigJson),
+						},
+						{
+							GeneratorArgs: ktypes.GeneratorArgs{
+								Name: "secret3",
+								KvPairSources: ktypes.KvPairSources{
+									FileSources: []string{
+										"ssh-privatekey=files/secret/secret3/ssh-privatekey",
+									},
+								},
+							},
+							Type: string(corev1.SecretTypeSSHAuth),
+						},
+					},
+				},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(secret, "secret4"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name": "secret4",
+								},
+								"type": string(corev1.SecretTypeServiceAccountToken),
+							}),
+					},
+					SourceFiles: map[string]string{
+						"files/secret/secret1/secret1.env":      "DB_HOST=localhost\nDB_PASSWORD=overridden\nDB_USERNAME=admin",
+						"files/secret/secret2/dockerconfigjson": `{"auths":{}}`,
+						"files/secret/secret3/ssh-privatekey":   "-----BEGIN KEY-----\nabc\n-----END KEY-----",
+					},
+					SecretFiles: map[string]struct{}{
+						"files/secret/secret1/secret1.env":      {},
+						"files/secret/secret2/dockerconfigjson": {},
+						"files/secret/secret3/ssh-privatekey":   {},
+					},
+				},
+			},
+		},
+		{
+			name: "it should not use literals when secrets are encrypted with sops",
+			options: SecretOptions{
+				Output: SecretOutputSOPS,
+			},
+			input: &secretTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(secret, "secret1"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name": "secret1",
+								},
+								"stringData": map[string]interface{}{
+									"password": "secret",
+								},
+							}),
+					},
+				},
+			},
+			expected: &secretTransformerArgs{
+				config: &ktypes.Kustomization{
+					SecretGenerator: []ktypes.SecretArgs{
+						{
+							GeneratorArgs: ktypes.GeneratorArgs{
+								Name: "secret1",
+								KvPairSources: ktypes.KvPairSources{
+									FileSources: []string{
+										"password=files/secret/secret1/password",
+									},
+								},
+							},
+							Type: string(corev1.SecretTypeOpaque),
+						},
+					},
+				},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{},
+					SourceFiles: map[string]string{
+						"files/secret/secret1/password": "secret",
+					},
+					SecretFiles: map[string]struct{}{
+						"files/secret/secret1/password": {},
+					},
+				},
+			},
+		},
+		{
+			name: "it should generate a ksops generator",
+			options: SecretOptions{
+				Output:     SecretOutputSOPS,
+				SOPSLayout: SOPSLayoutKSOPS,
+			},
+			input: &secretTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(secret, "secret1"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name": "secret1",
+								},
+								"stringData": map[string]interface{}{
+									"DB_PASSWORD": "secret",
+								},
+							}),
+					},
+				},
+			},
+			expected: &secretTransformerArgs{
+				config: &ktypes.Kustomization{
+					Generators: []string{
+						"secret-generator.yaml",
+					},
+				},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{},
+					SourceFiles: map[string]string{
+						"files/secret/secret1/secret1.env": "DB_PASSWORD=secret",
+						"secret-generator.yaml": `apiVersion: viaduct.ai/v1
+kind: ksops
+metadata:
+  annotations:
+    config.kubernetes.io/function: |
+      exec:
+        path: ksops
+  name: secret-generator
+secretFrom:
+- envs:
+  - files/secret/secret1/secret1.env
+  metadata:
+    name: secret1
+  type: Opaque
+`,
+					},
+					SecretFiles: map[string]struct{}{
+						"files/secret/secret1/secret1.env": {},
+					},
+				},
+			},
+		},
+		{
+			name: "it should write placeholders instead of values",
+			options: SecretOptions{
+				Output: SecretOutputPlaceholder,
+			},
+			input: &secretTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(secret, "secret1"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name": "secret1",
+								},
+								"stringData": map[string]interface{}{
+									"DB_USERNAME": "admin",
+									"DB_PASSWORD": "x7KqP2mZr9",
+									"ca.pem":      "-----BEGIN CERTIFICATE-----\nabc\n-----END CERTIFICATE-----",
+								},
+							}),
+					},
+				},
+			},
+			expected: &secretTransformerArgs{
+				config: &ktypes.Kustomization{
+					SecretGenerator: []ktypes.SecretArgs{
+						{
+							GeneratorArgs: ktypes.GeneratorArgs{
+								Name: "secret1",
+								KvPairSources: ktypes.KvPairSources{
+									EnvSource: "files/secret/secret1/secret1.env",
+									FileSources: []string{
+										"ca.pem=files/secret/secret1/ca.pem",
+									},
+								},
+							},
+							Type: string(corev1.SecretTypeOpaque),
+						},
+					},
+				},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{},
+					SourceFiles: map[string]string{
+						".gitignore": "files/secret/secret1/ca.pem\nfiles/secret/secret1/secret1.env\n",
+						"files/secret/secret1/secret1.env.example": "# Keys of the secret 'secret1', copy this file to files/secret/secret1/secret1.env and fill in the values\n" +
+							"DB_PASSWORD=\n" +
+							"DB_USERNAME=\n" +
+							"# ca.pem: content of the file files/secret/secret1/ca.pem\n",
+					},
+					SecretFiles: map[string]struct{}{
+						"files/secret/secret1/secret1.env": {},
+						"files/secret/secret1/ca.pem":      {},
+					},
+					Report: types.Report{
+						Entries: []types.ReportEntry{
+							{
+								Transformer: "secret",
+								Kind:        "Secret",
+								Name:        "secret1",
+								Path:        "data.DB_PASSWORD",
+								Message: "the value looks randomly generated but is the same on every render, " +
+									"check that it isn't a default credential shipped with the chart",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "it should replace secrets by external secrets",
+			options: SecretOptions{
+				Output:                    SecretOutputExternalSecret,
+				ExternalSecretStore:       "vault",
+				ExternalSecretStoreKind:   "ClusterSecretStore",
+				ExternalSecretKeyTemplate: "{{ .Namespace }}/{{ .Name }}/{{ .Key }}",
+			},
+			input: &secretTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResIdWithPrefixNamespace(secret, "secret1", "", "db"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name":      "secret1",
+									"namespace": "db",
+									"labels": map[string]interface{}{
+										"app": "db",
+									},
+								},
+								"type":      string(corev1.SecretTypeBasicAuth),
+								"immutable": true,
+								"data": map[string]interface{}{
+									"username": base64.StdEncoding.EncodeToString([]byte("admin")),
+									"password": base64.StdEncoding.EncodeToString([]byte("x7KqP2mZr9")),
+								},
+							}),
+					},
+					RequiredSecretKeys: map[string][]string{
+						"Secret/db/secret1": {"password"},
+					},
+				},
+			},
+			expected: &secretTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResIdWithPrefixNamespace(
+							gvk.Gvk{Group: "external-secrets.io", Version: "v1beta1", Kind: "ExternalSecret"},
+							"secret1", "", "db"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "external-secrets.io/v1beta1",
+								"kind":       "ExternalSecret",
+								"metadata": map[string]interface{}{
+									"name":      "secret1",
+									"namespace": "db",
+									"annotations": map[string]interface{}{
+										RandomKeysAnnotation: "password",
+									},
+								},
+								"spec": map[string]interface{}{
+									"secretStoreRef": map[string]interface{}{
+										"name": "vault",
+										"kind": "ClusterSecretStore",
+									},
+									"target": map[string]interface{}{
+										"name":      "secret1",
+										"immutable": true,
+										"template": map[string]interface{}{
+											"type": string(corev1.SecretTypeBasicAuth),
+											"metadata": map[string]interface{}{
+												"labels": map[string]interface{}{
+													"app": "db",
+												},
+											},
+										},
+									},
+									"data": []interface{}{
+										map[string]interface{}{
+											"secretKey": "password",
+											"remoteRef": map[string]interface{}{
+												"key": "db/secret1/password",
+											},
+										},
+										map[string]interface{}{
+											"secretKey": "username",
+											"remoteRef": map[string]interface{}{
+												"key": "db/secret1/username",
+											},
+										},
+									},
+								},
+							}),
+					},
+					RequiredSecretKeys: map[string][]string{
+						"Secret/db/secret1": {"password"},
+					},
+				},
+			},
+		},
+		{
+			name: "it should store binary secrets as raw files",
+			input: &secretTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(secret, "secret1"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name": "secret1",
+								},
+								"data": map[string]interface{}{
+									"KEYSTORE": base64.StdEncoding.EncodeToString([]byte("\xfe\xed\xfe\xed")),
+									"USERNAME": base64.StdEncoding.EncodeToString([]byte("admin")),
+								},
+							}),
+					},
+				},
+			},
+			expected: &secretTransformerArgs{
+				config: &ktypes.Kustomization{
+					SecretGenerator: []ktypes.SecretArgs{
+						{
+							GeneratorArgs: ktypes.GeneratorArgs{
+								Name: "secret1",
+								KvPairSources: ktypes.KvPairSources{
+									LiteralSources: []string{
+										"USERNAME=admin",
+									},
+									FileSources: []string{
+										"KEYSTORE=files/secret/secret1/KEYSTORE",
+									},
+								},
+							},
+							Type: string(corev1.SecretTypeOpaque),
+						},
+					},
+				},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{},
+					SourceFiles: map[string]string{
+						"files/secret/secret1/KEYSTORE": "\xfe\xed\xfe\xed",
+					},
+					SecretFiles: map[string]struct{}{
+						"files/secret/secret1/KEYSTORE": {},
+					},
+				},
+			},
+		},
+		{
+			name: "it should write placeholders for the keys generated on every render",
+			input: &secretTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(secret, "secret1"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name": "secret1",
+								},
+								"data": map[string]interface{}{
+									"PASSWORD": base64.StdEncoding.EncodeToString([]byte("hunter2")),
+									"USERNAME": base64.StdEncoding.EncodeToString([]byte("admin")),
+								},
+							}),
+					},
+					RequiredSecretKeys: map[string][]string{
+						"Secret//secret1": {"PASSWORD"},
+					},
+				},
+			},
+			expected: &secretTransformerArgs{
+				config: &ktypes.Kustomization{
+					SecretGenerator: []ktypes.SecretArgs{
+						{
+							GeneratorArgs: ktypes.GeneratorArgs{
+								Name: "secret1",
+								KvPairSources: ktypes.KvPairSources{
+									EnvSources: []string{
+										"files/secret/secret1/secret1.env",
+										"files/secret/secret1/secret1-1.env",
+									},
+								},
+							},
+							Type: string(corev1.SecretTypeOpaque),
+						},
+					},
+				},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{},
+					SourceFiles: map[string]string{
+						".gitignore":                       "files/secret/secret1/secret1-1.env\n",
+						"files/secret/secret1/secret1.env": "USERNAME=admin",
+						"files/secret/secret1/secret1.env.example": "# Keys of the secret 'secret1', copy this file to " +
+							"files/secret/secret1/secret1-1.env and fill in the values\n" +
+							"# PASSWORD was randomly generated by the chart, a value must be supplied\n" +
+							"PASSWORD=\n",
+					},
+					SecretFiles: map[string]struct{}{
+						"files/secret/secret1/secret1.env": {},
+					},
+					RequiredSecretKeys: map[string][]string{
+						"Secret//secret1": {"PASSWORD"},
+					},
+				},
+			},
+		},
+		{
+			name: "it should ask for the keys generated on every render to be encrypted with sops",
+			options: SecretOptions{
+				Output:     SecretOutputSOPS,
+				SOPSLayout: SOPSLayoutKSOPS,
+			},
+			input: &secretTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(secret, "secret1"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name": "secret1",
+								},
+								"data": map[string]interface{}{
+									"PASSWORD": base64.StdEncoding.EncodeToString([]byte("hunter2")),
+									"USERNAME": base64.StdEncoding.EncodeToString([]byte("admin")),
+								},
+							}),
+					},
+					RequiredSecretKeys: map[string][]string{
+						"Secret//secret1": {"PASSWORD"},
+					},
+				},
+			},
+			expected: &secretTransformerArgs{
+				config: &ktypes.Kustomization{
+					Generators: []string{
+						"secret-generator.yaml",
+					},
+				},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{},
+					SourceFiles: map[string]string{
+						"files/secret/secret1/secret1.env": "USERNAME=admin",
+						"files/secret/secret1/secret1.env.example": "# Keys of the secret 'secret1', copy this file to " +
+							"files/secret/secret1/secret1-1.env and fill in the values\n" +
+							"# Encrypt the files with sops --encrypt --in-place before committing them\n" +
+							"# PASSWORD was randomly generated by the chart, a value must be supplied\n" +
+							"PASSWORD=\n",
+						"secret-generator.yaml": `apiVersion: viaduct.ai/v1
+kind: ksops
+metadata:
+  annotations:
+    config.kubernetes.io/function: |
+      exec:
+        path: ksops
+  name: secret-generator
+secretFrom:
+- envs:
+  - files/secret/secret1/secret1.env
+  - files/secret/secret1/secret1-1.env
+  metadata:
+    name: secret1
+  type: Opaque
+`,
+					},
+					SecretFiles: map[string]struct{}{
+						"files/secret/secret1/secret1.env": {},
+					},
+					RequiredSecretKeys: map[string][]string{
+						"Secret//secret1": {"PASSWORD"},
+					},
+				},
+			},
+		},
+		{
+			name: "it should require a username or a password in basic-auth secrets",
+			input: &secretTransformerArgs{
+				config: &ktypes.Kustomization{},
+				resources: &types.Resources{
+					ResMap: resmap.ResMap{
+						resid.NewResId(secret, "secret1"): rf.FromMap(
+							map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name": "secret1",
+								},
+								"type": string(corev1.SecretTypeBasicAuth),
+								"stringData": map[string]interface{}{
+									"token": "abc",
+								},
+							}),
+					},
+				},
+			},
+			expectedErr: "secret 'secret1' of type 'kubernetes.io/basic-auth' requires at least one of the keys " +
+				"'username', 'password'",
+		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
 			res := types.NewResources()
 			res.ResMap = test.input.resources.ResMap
+			if test.input.resources.RequiredSecretKeys != nil {
+				res.RequiredSecretKeys = test.input.resources.RequiredSecretKeys
+			}
 
-			lt := NewSecretTransformer()
+			lt := NewSecretTransformer(test.options)
 			err := lt.Transform(test.input.config, res)
+			if test.expectedErr != "" {
+				if err == nil || err.Error() != test.expectedErr {
+					t.Fatalf("expected error '%s', got: %v", test.expectedErr, err)
+				}
+				return
+			}
 
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
@@ -152,3 +760,74 @@ func TestSecretRun(t *testing.T) {
 		})
 	}
 }
+
+func TestKSOPSGenerator(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		secretArgs []ktypes.SecretArgs
+		expected   *types.Resources
+	}{
+		{
+			name: "it should write the literals to encrypted files",
+			secretArgs: []ktypes.SecretArgs{
+				{
+					GeneratorArgs: ktypes.GeneratorArgs{
+						Name: "secret1",
+						KvPairSources: ktypes.KvPairSources{
+							LiteralSources: []string{"password=secret", "username=admin"},
+							FileSources:    []string{"tls.key=files/secret/secret1/tls.key"},
+						},
+					},
+					Type: string(corev1.SecretTypeOpaque),
+				},
+			},
+			expected: &types.Resources{
+				SourceFiles: map[string]string{
+					"files/secret/secret1/password": "secret",
+					"files/secret/secret1/username": "admin",
+					"secret-generator.yaml": `apiVersion: viaduct.ai/v1
+kind: ksops
+metadata:
+  annotations:
+    config.kubernetes.io/function: |
+      exec:
+        path: ksops
+  name: secret-generator
+secretFrom:
+- files:
+  - tls.key=files/secret/secret1/tls.key
+  - password=files/secret/secret1/password
+  - username=files/secret/secret1/username
+  metadata:
+    name: secret1
+  type: Opaque
+`,
+				},
+				SecretFiles: map[string]struct{}{
+					"files/secret/secret1/password": {},
+					"files/secret/secret1/username": {},
+				},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			config := &ktypes.Kustomization{}
+			res := &types.Resources{
+				SourceFiles: make(map[string]string),
+				SecretFiles: make(map[string]struct{}),
+			}
+
+			lt := &secretTransformer{SecretOptions{Output: SecretOutputSOPS, SOPSLayout: SOPSLayoutKSOPS}}
+			if err := lt.ksopsGenerator(config, res, test.secretArgs); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := pretty.Compare(res, test.expected); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+			if diff := pretty.Compare(config.Generators, []string{DefaultKSOPSGeneratorFilename}); diff != "" {
+				t.Errorf("%s, diff: (-got +want)\n%s", test.name, diff)
+			}
+		})
+	}
+}
@@ -2,13 +2,30 @@
 package transformers
 
 import (
+	"sort"
+
 	"github.com/layertwo/helm-convert/pkg/types"
-	ktypes "sigs.k8s.io/kustomize/pkg/types"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/k8sdeps/kunstruct"
+	"sigs.k8s.io/kustomize/pkg/resource"
 )
 
+// resourceFactory create the resources added by transformers
+var resourceFactory = resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl())
+
 // A Transformer modifies an instance of resources.
 type Transformer interface {
 	// Transform modifies data in the argument, e.g. gathering common labels to
 	// resources that can be labelled.
 	Transform(*ktypes.Kustomization, *types.Resources) error
 }
+
+// sortedKeys return the keys of a map in alphabetical order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,92 @@
+package types
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ResourceField identify a field of a rendered resource
+type ResourceField struct {
+	Kind      string
+	Name      string
+	Namespace string
+
+	// Path is the path of the field, list elements are written as [index]
+	// (ie: spec, containers, [0], image)
+	Path []string
+}
+
+// String return the path of the field, list elements are appended to the
+// field holding the list (ie: spec.containers[0].image)
+func (f ResourceField) String() string {
+	var b strings.Builder
+	for i, field := range f.Path {
+		if i > 0 && !strings.HasPrefix(field, "[") {
+			b.WriteString(".")
+		}
+		b.WriteString(field)
+	}
+	return b.String()
+}
+
+// ResourceKey return the key identifying a resource in the comments
+func ResourceKey(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// ValueMap contains the fields of the rendered resources controlled by each
+// value of the chart, indexed by the path of the value (ie: image.tag,
+// tolerations[0].key)
+type ValueMap map[string][]ResourceField
+
+// valueMapField is a field of a ValueMap as written in the value map file
+type valueMapField struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Path      string `json:"path"`
+}
+
+// MarshalJSON write the fields of a ValueMap with their path as a string
+func (m ValueMap) MarshalJSON() ([]byte, error) {
+	output := make(map[string][]valueMapField, len(m))
+	for value, fields := range m {
+		for _, field := range fields {
+			output[value] = append(output[value], valueMapField{field.Kind, field.Name, field.Namespace, field.String()})
+		}
+	}
+	return json.Marshal(output)
+}
+
+const (
+	// ReleaseNameMarker replace the release name in ReleaseField.Template
+	ReleaseNameMarker = "{{ .Release.Name }}"
+
+	// ReleaseNamespaceMarker replace the release namespace in
+	// ReleaseField.Template
+	ReleaseNamespaceMarker = "{{ .Release.Namespace }}"
+)
+
+// ReleaseField is a string field whose value is derived from the release name
+// or namespace
+type ReleaseField struct {
+	ResourceField
+
+	// Value is the rendered value of the field
+	Value string
+
+	// Template is the value of the field where the release name and namespace
+	// are replaced by ReleaseNameMarker and ReleaseNamespaceMarker (ie:
+	// http://{{ .Release.Name }}-api.{{ .Release.Namespace }}:8080)
+	Template string
+}
+
+// HasName return true if the field contains the release name
+func (f ReleaseField) HasName() bool {
+	return strings.Contains(f.Template, ReleaseNameMarker)
+}
+
+// HasNamespace return true if the field contains the release namespace
+func (f ReleaseField) HasNamespace() bool {
+	return strings.Contains(f.Template, ReleaseNamespaceMarker)
+}
@@ -0,0 +1,53 @@
+package types
+
+import (
+	"sort"
+)
+
+// ReportEntry is a change made, or a problem found, by a transformer on a
+// resource
+type ReportEntry struct {
+	// Transformer is the name of the transformer adding the entry, or values
+	// for the entries about the values supplied by the user
+	Transformer string `json:"transformer"`
+
+	// Kind and Name identify the resource, they are empty for entries about
+	// the whole package
+	Kind string `json:"kind,omitempty"`
+	Name string `json:"name,omitempty"`
+
+	// Path is the path of the field the entry is about, if any
+	Path string `json:"path,omitempty"`
+
+	// Message describe the change or the problem
+	Message string `json:"message"`
+}
+
+// Report contains the entries gathered during a conversion, it is written
+// next to the kustomization.yaml file so that changes which can't be
+// expressed by kustomize can be reviewed
+type Report struct {
+	Entries []ReportEntry `json:"entries"`
+}
+
+// Add append an entry to the report
+func (r *Report) Add(entry ReportEntry) {
+	r.Entries = append(r.Entries, entry)
+}
+
+// Sort order the entries by transformer, kind, name and path
+func (r *Report) Sort() {
+	sort.SliceStable(r.Entries, func(i, j int) bool {
+		a, b := r.Entries[i], r.Entries[j]
+		if a.Transformer != b.Transformer {
+			return a.Transformer < b.Transformer
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Path < b.Path
+	})
+}
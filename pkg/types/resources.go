@@ -12,12 +12,50 @@ type Resources struct {
 	// SourceFiles contains a list of file retrieved from either configmaps or
 	// secret resources. The key being the filename, and the value its content
 	SourceFiles map[string]string
+
+	// SecretFiles contains the filename of the source files holding secret
+	// material
+	SecretFiles map[string]struct{}
+
+	// Report contains the changes and problems recorded by the transformers
+	Report Report
+
+	// NonDeterministic contains the fields whose value differ between two
+	// renders of the chart (ie: randAlphaNum, uuidv4, now)
+	NonDeterministic []ResourceField
+
+	// ReleaseFields contains the string fields derived from the release name
+	// or namespace
+	ReleaseFields []ReleaseField
+
+	// ValueMap contains the fields controlled by each value of the chart
+	ValueMap ValueMap
+
+	// RequiredSecretKeys contains the keys of secrets whose value must be
+	// supplied by the user, indexed by ResourceKey
+	RequiredSecretKeys map[string][]string
+
+	// Comments contains the comments written at the top of resource files,
+	// indexed by ResourceKey
+	Comments map[string][]string
 }
 
 // NewResources constructs a new Resources
 func NewResources() *Resources {
 	return &Resources{
-		ResMap:      resmap.ResMap{},
-		SourceFiles: make(map[string]string),
+		ResMap:             resmap.ResMap{},
+		SourceFiles:        make(map[string]string),
+		SecretFiles:        make(map[string]struct{}),
+		RequiredSecretKeys: make(map[string][]string),
+		Comments:           make(map[string][]string),
+	}
+}
+
+// AddComment add a comment to the file of a resource
+func (r *Resources) AddComment(kind, namespace, name, comment string) {
+	if r.Comments == nil {
+		r.Comments = make(map[string][]string)
 	}
+	key := ResourceKey(kind, namespace, name)
+	r.Comments[key] = append(r.Comments[key], comment)
 }
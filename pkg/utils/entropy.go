@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"math"
+	"regexp"
+	"unicode"
+)
+
+// Characters produced by the sprig rand* and uuid functions
+var randomCharsetPattern = regexp.MustCompile(`^[A-Za-z0-9+/=_-]+$`)
+
+// Minimal length of a value to be considered as randomly generated
+const randomMinLength = 8
+
+// Minimal entropy, in bit per character, of a value to be considered as
+// randomly generated
+const randomMinEntropy = 3.0
+
+// ShannonEntropy return the entropy in bit per character of a given string
+func ShannonEntropy(s string) float64 {
+	if len(s) == 0 {
+		return 0
+	}
+
+	frequencies := make(map[rune]float64)
+	length := 0
+	for _, r := range s {
+		frequencies[r]++
+		length++
+	}
+
+	var entropy float64
+	for _, count := range frequencies {
+		p := count / float64(length)
+		entropy -= p * math.Log2(p)
+	}
+
+	return entropy
+}
+
+// LooksRandom return true if a value looks like it was randomly generated by
+// a template function like randAlphaNum or uuidv4: a single word of mixed
+// character classes with a high entropy
+func LooksRandom(s string) bool {
+	if len(s) < randomMinLength || !randomCharsetPattern.MatchString(s) {
+		return false
+	}
+
+	var lower, upper, digit bool
+	for _, r := range s {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		}
+	}
+
+	classes := 0
+	for _, c := range []bool{lower, upper, digit} {
+		if c {
+			classes++
+		}
+	}
+
+	return classes >= 2 && ShannonEntropy(s) >= randomMinEntropy
+}
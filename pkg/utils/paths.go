@@ -1,6 +1,13 @@
 package utils
 
-import "os"
+import (
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Characters which are not allowed in a generated filename
+var unsafeFilenamePattern = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
 
 // PathExists check if a path exist
 func PathExists(path string) (bool, error) {
@@ -13,3 +20,18 @@ func PathExists(path string) (bool, error) {
 	}
 	return true, err
 }
+
+// SanitizeFilename return a single path element derived from a given string,
+// which can't be a hidden file, a reference to the current or the parent
+// directory, nor contain a path separator
+func SanitizeFilename(s string) string {
+	s = unsafeFilenamePattern.ReplaceAllString(s, "_")
+	for strings.Contains(s, "..") {
+		s = strings.ReplaceAll(s, "..", ".")
+	}
+	s = strings.TrimLeft(s, ".")
+	if s == "" {
+		return "_"
+	}
+	return s
+}
@@ -1,5 +1,12 @@
 package utils
 
+import (
+	"sort"
+
+	"sigs.k8s.io/kustomize/pkg/resid"
+	"sigs.k8s.io/kustomize/pkg/resmap"
+)
+
 type byLength []string
 
 func (s byLength) Len() int {
@@ -11,3 +18,15 @@ func (s byLength) Swap(i, j int) {
 func (s byLength) Less(i, j int) bool {
 	return len(s[i]) < len(s[j])
 }
+
+// SortedIds return the ids of a ResMap in a deterministic order
+func SortedIds(m resmap.ResMap) []resid.ResId {
+	ids := make([]resid.ResId, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].String() < ids[j].String()
+	})
+	return ids
+}
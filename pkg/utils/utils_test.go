@@ -195,3 +195,88 @@ func TestRecursivelyRemoveKey(t *testing.T) {
 		})
 	}
 }
+
+func TestLooksRandom(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		input    string
+		expected bool
+	}{
+		{
+			name:     "it should detect randAlphaNum values",
+			input:    "x7KqP2mZr9",
+			expected: true,
+		},
+		{
+			name:     "it should detect uuids",
+			input:    "0b5f1c3e-8d2a-4f6b-9c1e-7a3d5e2f4b6c",
+			expected: true,
+		},
+		{
+			name:     "it should ignore words",
+			input:    "production",
+			expected: false,
+		},
+		{
+			name:     "it should ignore short values",
+			input:    "aB3",
+			expected: false,
+		},
+		{
+			name:     "it should ignore sentences",
+			input:    "Hello World 2024",
+			expected: false,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			output := LooksRandom(test.input)
+			if output != test.expected {
+				t.Fatalf(
+					"expected: \n %v\ngot:\n %v",
+					test.expected,
+					output,
+				)
+			}
+		})
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "it should keep safe filenames",
+			input:    "application.properties",
+			expected: "application.properties",
+		},
+		{
+			name:     "it should remove path separators and parent references",
+			input:    "../../etc/passwd",
+			expected: "_._etc_passwd",
+		},
+		{
+			name:     "it should not return hidden files",
+			input:    ".dockerconfigjson",
+			expected: "dockerconfigjson",
+		},
+		{
+			name:     "it should not return an empty filename",
+			input:    "..",
+			expected: "_",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			output := SanitizeFilename(test.input)
+			if output != test.expected {
+				t.Fatalf(
+					"expected: \n %v\ngot:\n %v",
+					test.expected,
+					output,
+				)
+			}
+		})
+	}
+}